@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// assertFailedExitCode is the exit status when a response fails an -assert
+const assertFailedExitCode = 5
+
+// assertions are the -assert expressions checked against the response
+type assertions []string
+
+func (a *assertions) String() string {
+	return strings.Join(*a, "; ")
+}
+
+func (a *assertions) Set(expr string) error {
+	if _, err := parseAssertion(expr); err != nil {
+		return err
+	}
+	*a = append(*a, expr)
+	return nil
+}
+
+// comparisons are the operators of an assertion, longest first so >= is not read as >
+var comparisons = []string{"==", "!=", ">=", "<=", ">", "<"}
+
+// assertion is a parsed expression in a small subset of jq: a path such as
+// .data.sellers[0].name, optionally piped into length, optionally compared
+// with a JSON literal, e.g. `.data.sellers | length >= 1`. Without a comparison
+// the value must be neither null nor false
+type assertion struct {
+	path     []interface{}
+	length   bool
+	operator string
+	operand  interface{}
+}
+
+func parseAssertion(expr string) (*assertion, error) {
+	a := &assertion{}
+	rest := strings.TrimSpace(expr)
+	// The first operator splits the expression, so the literal may contain operators
+	at := -1
+	for _, op := range comparisons {
+		if i := strings.Index(rest, op); i >= 0 && (at < 0 || i < at) {
+			at, a.operator = i, op
+		}
+	}
+	if at >= 0 {
+		right := strings.TrimSpace(rest[at+len(a.operator):])
+		if err := json.Unmarshal([]byte(right), &a.operand); err != nil {
+			return nil, fmt.Errorf("assertion %q: expected a JSON value after %s", expr, a.operator)
+		}
+		rest = strings.TrimSpace(rest[:at])
+	}
+
+	path, fn, piped := strings.Cut(rest, "|")
+	if piped {
+		if strings.TrimSpace(fn) != "length" {
+			return nil, fmt.Errorf("assertion %q: only length may follow a pipe", expr)
+		}
+		a.length = true
+	}
+
+	var err error
+	if a.path, err = parsePath(strings.TrimSpace(path)); err != nil {
+		return nil, fmt.Errorf("assertion %q: %w", expr, err)
+	}
+	return a, nil
+}
+
+// parsePath splits a path like .data.sellers[0].name into keys and indexes
+func parsePath(path string) ([]interface{}, error) {
+	if !strings.HasPrefix(path, ".") {
+		return nil, fmt.Errorf("path must start with .")
+	}
+	var steps []interface{}
+	for _, part := range strings.Split(path[1:], ".") {
+		key, index, _ := strings.Cut(part, "[")
+		if key != "" {
+			steps = append(steps, key)
+		}
+		for index != "" {
+			n, rest, ok := strings.Cut(index, "]")
+			i, err := strconv.Atoi(n)
+			if !ok || err != nil {
+				return nil, fmt.Errorf("invalid index in %q", part)
+			}
+			steps = append(steps, i)
+			index = strings.TrimPrefix(rest, "[")
+		}
+	}
+	return steps, nil
+}
+
+// check evaluates the assertion against the response, returning the value it
+// compared and whether the assertion holds
+func (a *assertion) check(response map[string]interface{}) (interface{}, bool) {
+	var value interface{} = response
+	for _, step := range a.path {
+		switch step := step.(type) {
+		case string:
+			object, _ := value.(map[string]interface{})
+			value = object[step]
+		case int:
+			list, _ := value.([]interface{})
+			value = nil
+			if step >= 0 && step < len(list) {
+				value = list[step]
+			}
+		}
+	}
+	if a.length {
+		switch v := value.(type) {
+		case []interface{}:
+			value = float64(len(v))
+		case map[string]interface{}:
+			value = float64(len(v))
+		case string:
+			value = float64(len([]rune(v)))
+		case nil:
+			value = float64(0)
+		}
+	}
+
+	switch a.operator {
+	case "":
+		return value, value != nil && value != false
+	case "==":
+		return value, reflect.DeepEqual(value, a.operand)
+	case "!=":
+		return value, !reflect.DeepEqual(value, a.operand)
+	}
+	left, ok := value.(float64)
+	right, isNumber := a.operand.(float64)
+	if !ok || !isNumber {
+		return value, false
+	}
+	switch a.operator {
+	case ">=":
+		return value, left >= right
+	case "<=":
+		return value, left <= right
+	case ">":
+		return value, left > right
+	default:
+		return value, left < right
+	}
+}
+
+// checkAssertions prints the outcome of every assertion and reports whether all held
+func checkAssertions(exprs []string, response map[string]interface{}) bool {
+	passed := true
+	for _, expr := range exprs {
+		a, _ := parseAssertion(expr)
+		value, ok := a.check(response)
+		if ok {
+			fmt.Printf("Assertion passed: %s\n", expr)
+			continue
+		}
+		got, _ := json.Marshal(value)
+		fmt.Printf("Assertion failed: %s (got %s)\n", expr, got)
+		passed = false
+	}
+	return passed
+}
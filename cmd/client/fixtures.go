@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// fixture is a recorded response, stored as one JSON file per request
+type fixture struct {
+	Method      string          `json:"method"`
+	Path        string          `json:"path"`
+	Role        string          `json:"role,omitempty"`
+	Request     json.RawMessage `json:"request,omitempty"`
+	Status      int             `json:"status"`
+	ContentType string          `json:"contentType,omitempty"`
+	Body        string          `json:"body"`
+}
+
+// fixtureTransport records responses into a directory, or replays them from it
+// without contacting the server. Requests are matched by method, path, role
+// and body, so the server URL may differ between recording and replaying
+type fixtureTransport struct {
+	dir    string
+	replay bool
+	next   http.RoundTripper
+}
+
+func (t *fixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	path := filepath.Join(t.dir, fixtureName(req, body))
+
+	if t.replay {
+		return t.load(req, path)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	f := fixture{
+		Method:      req.Method,
+		Path:        req.URL.Path,
+		Role:        req.Header.Get("X-User-Role"),
+		Status:      resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		Body:        string(respBody),
+	}
+	if json.Valid(body) {
+		f.Request = body
+	}
+	if err := saveFixture(path, f); err != nil {
+		return nil, err
+	}
+	if verbose {
+		log.Printf("Recorded response in %s", path)
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	return resp, nil
+}
+
+// load returns the response recorded at path
+func (t *fixtureTransport) load(req *http.Request, path string) (*http.Response, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no fixture for this request in %s; record it with -record", t.dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f fixture
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid fixture %s: %w", path, err)
+	}
+	if verbose {
+		log.Printf("Replaying response from %s", path)
+	}
+
+	header := http.Header{}
+	if f.ContentType != "" {
+		header.Set("Content-Type", f.ContentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", f.Status, http.StatusText(f.Status)),
+		StatusCode:    f.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(f.Body)),
+		ContentLength: int64(len(f.Body)),
+		Request:       req,
+	}, nil
+}
+
+// fixtureName returns the file name of the fixture for a request
+func fixtureName(req *http.Request, body []byte) string {
+	hash := sha256.New()
+	for _, part := range []string{req.Method, req.URL.Path, req.Header.Get("X-User-Role")} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil)[:8]) + ".json"
+}
+
+// saveFixture writes a fixture, indented so recordings can be reviewed in diffs
+func saveFixture(path string, f fixture) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create fixture directory: %w", err)
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write fixture: %w", err)
+	}
+	return nil
+}
+
+// newHTTPClient returns the client for requests to the server, recording or
+// replaying responses if -record or -replay is set
+func newHTTPClient() *http.Client {
+	client := &http.Client{Timeout: 10 * time.Second}
+	switch {
+	case replayDir != "":
+		client.Transport = &fixtureTransport{dir: replayDir, replay: true}
+	case recordDir != "":
+		client.Transport = &fixtureTransport{dir: recordDir, next: http.DefaultTransport}
+	}
+	return client
+}
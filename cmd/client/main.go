@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -10,7 +11,6 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
 	"strings"
 	"time"
 
@@ -25,6 +25,41 @@ type graphQLRequest struct {
 	OperationName string                 `json:"operationName,omitempty"`
 }
 
+// GraphQL error of a response; the server classifies errors in extensions.code
+type graphQLError struct {
+	Message    string `json:"message"`
+	Extensions struct {
+		Code string `json:"code"`
+	} `json:"extensions"`
+}
+
+// queryErrors are the errors of a failed GraphQL response
+type queryErrors []graphQLError
+
+func (e queryErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = fmt.Sprintf("[%s] %s", err.Extensions.Code, err.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// exitCodes are the exit statuses for error codes, so scripts can tell failure
+// classes apart; other failures exit with status 1
+var exitCodes = map[string]int{
+	"INVALID_INPUT": 2,
+	"NOT_FOUND":     3,
+	"CONFLICT":      4,
+}
+
+// exitCode returns the exit status for the first error
+func (e queryErrors) exitCode() int {
+	if code, ok := exitCodes[e[0].Extensions.Code]; ok {
+		return code
+	}
+	return 1
+}
+
 // WebSocket message
 type wsMessage struct {
 	Type    string      `json:"type"`
@@ -36,9 +71,9 @@ type wsMessage struct {
 var (
 	serverURL       string
 	queryType       string
-	id              int
-	sellerId        int
-	listingId       int
+	id              string
+	sellerId        string
+	listingId       string
 	minPrice        float64
 	maxPrice        float64
 	price           float64
@@ -46,11 +81,22 @@ var (
 	description     string
 	bankTxId        string
 	deliveryAddress string
+	pickupPointId   string
 	statusFilter    string
 	status          string
 	fromDate        string
 	toDate          string
 	verbose         bool
+	clientName      string
+	clientVersion   string
+	role            string
+	authToken       string
+	asserts         assertions
+	recordDir       string
+	replayDir       string
+	queryFile       string
+	variablesFile   string
+	vars            = templateVars{}
 )
 
 func main() {
@@ -65,10 +111,10 @@ func main() {
 	}
 
 	flag.StringVar(&serverURL, "server", serverURLEnv, "GraphQL server URL")
-	flag.StringVar(&queryType, "query", "", "Query/mutation type (sellers, seller, listings, listing, purchases, purchase, deliveries, delivery, create-listing, create-purchase, create-delivery, subscribe)")
-	flag.IntVar(&id, "id", 0, "ID for specific item queries")
-	flag.IntVar(&sellerId, "seller-id", 0, "Filter listings by seller ID or use as seller ID for creating listings")
-	flag.IntVar(&listingId, "listing-id", 0, "Filter purchases by listing ID or use as listing ID for creating purchases")
+	flag.StringVar(&queryType, "query", "", "Query/mutation type (sellers, seller, listings, listing, listing-price-stats, purchases, purchase, purchases-by-status, deliveries, delivery, latest-delivery, delivery-timeline, create-listing, create-purchase, create-delivery, subscribe, schema)")
+	flag.StringVar(&id, "id", "", "ID for specific item queries")
+	flag.StringVar(&sellerId, "seller-id", "", "Filter listings by seller ID or use as seller ID for creating listings")
+	flag.StringVar(&listingId, "listing-id", "", "Filter purchases by listing ID or use as listing ID for creating purchases")
 	flag.Float64Var(&minPrice, "min-price", 0, "Filter listings by minimum price")
 	flag.Float64Var(&maxPrice, "max-price", 0, "Filter listings by maximum price")
 	flag.Float64Var(&price, "price", 0, "Price for creating listings or purchases")
@@ -76,19 +122,41 @@ func main() {
 	flag.StringVar(&description, "description", "", "Description for creating listings")
 	flag.StringVar(&bankTxId, "bank-tx-id", "", "Bank transaction ID for creating purchases")
 	flag.StringVar(&deliveryAddress, "delivery-address", "", "Delivery address for creating purchases")
+	flag.StringVar(&pickupPointId, "pickup-point-id", "", "Pickup point ID for creating purchases instead of a delivery address")
 	flag.StringVar(&statusFilter, "status", "", "Filter deliveries by status (PACKED, OUT_FOR_DELIVERY, DELIVERED, RESCHEDULED, CANCELED)")
 	flag.StringVar(&status, "delivery-status", "", "Status for creating deliveries")
-	flag.StringVar(&fromDate, "from", "", "Filter by start date (format: 2025-04-01T00:00:00Z)")
-	flag.StringVar(&toDate, "to", "", "Filter by end date (format: 2025-04-01T00:00:00Z)")
+	flag.StringVar(&fromDate, "from", "", "Filter by start date (format: 2025-04-01T00:00:00Z, or today, yesterday, thisWeek, thisMonth, thisYear, last7d, last24h)")
+	flag.StringVar(&toDate, "to", "", "Filter by end date (format: 2025-04-01T00:00:00Z, or today, yesterday, thisWeek, thisMonth, thisYear, last7d, last24h)")
 	flag.BoolVar(&verbose, "v", false, "Verbose output")
+	flag.StringVar(&clientName, "client-name", "graphql-tiny-client", "Client name sent in the apollographql-client-name header")
+	flag.StringVar(&clientVersion, "client-version", "dev", "Client version sent in the apollographql-client-version header")
+	flag.StringVar(&role, "role", "", "Role sent in the X-User-Role header")
+	flag.StringVar(&authToken, "auth-token", os.Getenv("AUTH_TOKEN"), "Auth token sent when opening subscriptions, instead of relying on the -role header")
+	flag.Var(&asserts, "assert", "Check the response with an expression like '.data.sellers | length >= 1' and exit with status 5 if it fails; may be repeated")
+	flag.StringVar(&recordDir, "record", "", "Record server responses as fixtures in this directory")
+	flag.StringVar(&replayDir, "replay", "", "Serve responses from fixtures recorded in this directory instead of contacting the server")
+	flag.StringVar(&queryFile, "query-file", "", "Run the operation in this file instead of a built-in query; ${ENV_VAR} and {{name}} placeholders are expanded")
+	flag.StringVar(&variablesFile, "variables-file", "", "JSON variables for the operation of -query-file, with placeholders expanded")
+	flag.Var(vars, "var", "Value of a {{name}} placeholder in query files, as name=value; may be repeated")
 	flag.Parse()
 
+	if recordDir != "" && replayDir != "" {
+		log.Fatalf("Use either -record or -replay, not both")
+	}
+
+	if variablesFile != "" && queryFile == "" {
+		log.Fatalf("-variables-file requires -query-file")
+	}
+	if queryFile != "" {
+		queryType = "file"
+	}
+
 	log.Println("GraphQL client started")
 	log.Printf("Server URL: %s", serverURL)
 
 	// Check if query type is provided
 	if queryType == "" {
-		log.Println("No query type specified. Use -query flag with one of: sellers, seller, listings, listing, purchases, purchase, deliveries, delivery, create-listing, create-purchase, create-delivery, subscribe")
+		log.Println("No query type specified. Use -query flag with one of: sellers, seller, listings, listing, listing-price-stats, purchases, purchase, purchases-by-status, deliveries, delivery, latest-delivery, delivery-timeline, create-listing, create-purchase, create-delivery, subscribe, schema")
 		flag.Usage()
 		os.Exit(1)
 	}
@@ -110,7 +178,7 @@ func main() {
 		}
 		`
 	case "seller":
-		if id == 0 {
+		if id == "" {
 			log.Fatalf("Seller ID is required for seller query. Use -id flag.")
 		}
 
@@ -129,7 +197,7 @@ func main() {
 		}
 		`
 		variables = map[string]interface{}{
-			"id": strconv.Itoa(id),
+			"id": id,
 		}
 	case "listings":
 		query = `
@@ -147,8 +215,26 @@ func main() {
 		}
 		`
 		variables = buildListingFilter()
+	case "listing-price-stats":
+		query = `
+		query($filter: ListingFilter) {
+			listingPriceStats(filter: $filter) {
+				count
+				min
+				max
+				avg
+				median
+				histogram {
+					min
+					max
+					count
+				}
+			}
+		}
+		`
+		variables = buildListingFilter()
 	case "listing":
-		if id == 0 {
+		if id == "" {
 			log.Fatalf("Listing ID is required for listing query. Use -id flag.")
 		}
 
@@ -173,15 +259,15 @@ func main() {
 		}
 		`
 		variables = map[string]interface{}{
-			"id": strconv.Itoa(id),
+			"id": id,
 		}
 	case "purchases":
 		query = `
-		query($filter: PurchaseFilter) {
+		query($filter: PurchaseFilter, $admin: Boolean = false) {
 			purchases(filter: $filter) {
 				id
 				price
-				bankTxId
+				bankTxId @include(if: $admin)
 				deliveryAddress
 				createdAt
 				listing {
@@ -194,17 +280,21 @@ func main() {
 		`
 		variables = buildPurchaseFilter()
 	case "purchase":
-		if id == 0 {
+		if id == "" {
 			log.Fatalf("Purchase ID is required for purchase query. Use -id flag.")
 		}
 
 		query = `
-		query($id: ID!) {
+		query($id: ID!, $admin: Boolean = false) {
 			purchase(id: $id) {
 				id
 				price
-				bankTxId
+				bankTxId @include(if: $admin)
 				deliveryAddress
+				pickupPoint {
+					id
+					name
+				}
 				createdAt
 				listing {
 					id
@@ -223,18 +313,39 @@ func main() {
 		}
 		`
 		variables = map[string]interface{}{
-			"id": strconv.Itoa(id),
+			"id": id,
+		}
+	case "purchases-by-status":
+		if statusFilter == "" {
+			log.Fatalf("Status is required for purchases-by-status query. Use -status flag.")
+		}
+
+		query = `
+		query($status: DeliveryStatus!) {
+			purchasesByDeliveryStatus(status: $status) {
+				id
+				deliveryAddress
+				createdAt
+				listing {
+					id
+					title
+				}
+			}
+		}
+		`
+		variables = map[string]interface{}{
+			"status": strings.ToUpper(statusFilter),
 		}
 	case "deliveries":
 		query = `
-		query($filter: DeliveryFilter) {
+		query($filter: DeliveryFilter, $admin: Boolean = false) {
 			deliveries(filter: $filter) {
 				id
 				timestamp
 				status
 				purchase {
 					id
-					bankTxId
+					bankTxId @include(if: $admin)
 					listing {
 						id
 						title
@@ -246,19 +357,19 @@ func main() {
 		variables = buildDeliveryFilter()
 
 	case "delivery":
-		if id == 0 {
+		if id == "" {
 			log.Fatalf("Delivery ID is required for delivery query. Use -id flag.")
 		}
 
 		query = `
-		query($id: ID!) {
+		query($id: ID!, $admin: Boolean = false) {
 			delivery(id: $id) {
 				id
 				timestamp
 				status
 				purchase {
 					id
-					bankTxId
+					bankTxId @include(if: $admin)
 					deliveryAddress
 					listing {
 						id
@@ -273,32 +384,78 @@ func main() {
 		}
 		`
 		variables = map[string]interface{}{
-			"id": strconv.Itoa(id),
+			"id": id,
+		}
+
+	case "latest-delivery":
+		if id == "" {
+			log.Fatalf("Purchase ID is required for latest-delivery query. Use -id flag.")
+		}
+
+		query = `
+		query($purchaseId: ID!) {
+			latestDelivery(purchaseId: $purchaseId) {
+				id
+				timestamp
+				status
+			}
+		}
+		`
+		variables = map[string]interface{}{
+			"purchaseId": id,
+		}
+
+	case "delivery-timeline":
+		if id == "" {
+			log.Fatalf("Purchase ID is required for delivery-timeline query. Use -id flag.")
+		}
+
+		query = `
+		query($purchaseId: ID!) {
+			deliveryTimeline(purchaseId: $purchaseId) {
+				date
+				total
+				statusCounts {
+					status
+					count
+				}
+			}
+		}
+		`
+		variables = map[string]interface{}{
+			"purchaseId": id,
 		}
 
 	// New mutation cases
 	case "create-listing":
-		if sellerId == 0 || title == "" || price == 0 {
+		if sellerId == "" || title == "" || price == 0 {
 			log.Fatalf("To create a listing, you must provide: -seller-id, -title, -price, and optionally -description")
 		}
 
 		query = `
 		mutation($input: CreateListingInput!) {
 			createListing(input: $input) {
-				id
-				title
-				description
-				price
-				seller {
+				listing {
 					id
-					name
+					title
+					description
+					price
+					seller {
+						id
+						name
+					}
+				}
+				userErrors {
+					field
+					message
+					code
 				}
 			}
 		}
 		`
 		variables = map[string]interface{}{
 			"input": map[string]interface{}{
-				"sellerId":    strconv.Itoa(sellerId),
+				"sellerId":    sellerId,
 				"title":       title,
 				"description": description,
 				"price":       price,
@@ -306,87 +463,106 @@ func main() {
 		}
 
 	case "create-purchase":
-		if listingId == 0 || price == 0 || bankTxId == "" || deliveryAddress == "" {
-			log.Fatalf("To create a purchase, you must provide: -listing-id, -price, -bank-tx-id, -delivery-address")
+		if listingId == "" || price == 0 || bankTxId == "" || (deliveryAddress == "") == (pickupPointId == "") {
+			log.Fatalf("To create a purchase, you must provide: -listing-id, -price, -bank-tx-id, and one of -delivery-address or -pickup-point-id")
 		}
 
 		query = `
-		mutation($input: CreatePurchaseInput!) {
+		mutation($input: CreatePurchaseInput!, $admin: Boolean = false) {
 			createPurchase(input: $input) {
-				id
-				price
-				bankTxId
-				deliveryAddress
-				createdAt
-				listing {
+				purchase {
 					id
-					title
-					seller {
+					price
+					bankTxId @include(if: $admin)
+					deliveryAddress
+					createdAt
+					listing {
 						id
-						name
+						title
+						seller {
+							id
+							name
+						}
 					}
 				}
+				userErrors {
+					field
+					message
+					code
+				}
 			}
 		}
 		`
+		input := map[string]interface{}{
+			"listingId": listingId,
+			"price":     price,
+			"bankTxId":  bankTxId,
+		}
+		if pickupPointId != "" {
+			input["pickupPointId"] = pickupPointId
+		} else {
+			input["deliveryAddress"] = deliveryAddress
+		}
 		variables = map[string]interface{}{
-			"input": map[string]interface{}{
-				"listingId":       strconv.Itoa(listingId),
-				"price":           price,
-				"bankTxId":        bankTxId,
-				"deliveryAddress": deliveryAddress,
-			},
+			"input": input,
 		}
 
 	case "create-delivery":
-		if id == 0 || status == "" {
+		if id == "" || status == "" {
 			log.Fatalf("To create a delivery, you must provide: -id (purchase ID), -delivery-status")
 		}
 
 		query = `
-		mutation($input: CreateDeliveryInput!) {
+		mutation($input: CreateDeliveryInput!, $admin: Boolean = false) {
 			createDelivery(input: $input) {
-				id
-				timestamp
-				status
-				purchase {
+				delivery {
 					id
-					bankTxId
-					listing {
+					timestamp
+					status
+					purchase {
 						id
-						title
+						bankTxId @include(if: $admin)
+						listing {
+							id
+							title
+						}
 					}
 				}
+				userErrors {
+					field
+					message
+					code
+				}
 			}
 		}
 		`
 		variables = map[string]interface{}{
 			"input": map[string]interface{}{
-				"purchaseId": strconv.Itoa(id),
+				"purchaseId": id,
 				"status":     strings.ToUpper(status),
 			},
 		}
 
 	case "subscribe":
-		if id == 0 {
+		if id == "" {
 			log.Fatalf("Purchase ID is required for delivery subscription. Use -id flag.")
 		}
 
 		query = `
-		subscription($purchaseId: ID!) {
+		subscription($purchaseId: ID!, $admin: Boolean = false) {
 			deliveryUpdated(purchaseId: $purchaseId) {
 				id
 				timestamp
 				status
 				purchase {
 					id
-					bankTxId
+					bankTxId @include(if: $admin)
 				}
 			}
 		}
 		`
 		variables = map[string]interface{}{
-			"purchaseId": strconv.Itoa(id),
+			"purchaseId": id,
 		}
 
 		err := executeSubscription(query, variables)
@@ -395,6 +571,33 @@ func main() {
 		}
 		return
 
+	case "file":
+		if queryFile == "" {
+			log.Fatalf("Query file is required for file query. Use -query-file flag.")
+		}
+
+		var err error
+		query, variables, err = loadOperation(queryFile, variablesFile, vars)
+		if err != nil {
+			log.Fatalf("Failed to load query file: %v", err)
+		}
+
+		if strings.HasPrefix(strings.TrimSpace(query), "subscription") {
+			if err := executeSubscription(query, variables); err != nil {
+				log.Fatalf("Failed to execute subscription: %v", err)
+			}
+			return
+		}
+
+	case "schema":
+		sdl, err := fetchSchema()
+		if err != nil {
+			log.Fatalf("Failed to fetch schema: %v", err)
+		}
+		// Print only the SDL so it can be redirected to a file
+		fmt.Print(sdl)
+		return
+
 	default:
 		log.Fatalf("Unknown query type: %s", queryType)
 	}
@@ -408,6 +611,11 @@ func main() {
 	}
 
 	result, err := executeQuery(query, variables)
+	var errs queryErrors
+	if errors.As(err, &errs) {
+		log.Printf("Query failed: %v", errs)
+		os.Exit(errs.exitCode())
+	}
 	if err != nil {
 		log.Fatalf("Failed to execute query: %v", err)
 	}
@@ -424,6 +632,15 @@ func main() {
 	fmt.Println(string(prettyJSON))
 	fmt.Println("=============")
 	fmt.Printf("Executed in: %s\n", elapsed)
+	if extensions, ok := result["extensions"].(map[string]interface{}); ok {
+		if duration, ok := extensions["durationMs"].(float64); ok {
+			fmt.Printf("Server time: %.3fms\n", duration)
+		}
+	}
+
+	if !checkAssertions(asserts, result) {
+		os.Exit(assertFailedExitCode)
+	}
 }
 
 // buildListingFilter builds a filter for listings query based on command line flags
@@ -431,8 +648,8 @@ func buildListingFilter() map[string]interface{} {
 	filter := make(map[string]interface{})
 	filterVars := make(map[string]interface{})
 
-	if sellerId > 0 {
-		filterVars["sellerId"] = strconv.Itoa(sellerId)
+	if sellerId != "" {
+		filterVars["sellerId"] = sellerId
 	}
 
 	if minPrice > 0 {
@@ -459,8 +676,8 @@ func buildPurchaseFilter() map[string]interface{} {
 	filter := make(map[string]interface{})
 	filterVars := make(map[string]interface{})
 
-	if listingId > 0 {
-		filterVars["listingId"] = strconv.Itoa(listingId)
+	if listingId != "" {
+		filterVars["listingId"] = listingId
 	}
 
 	if bankTxId != "" {
@@ -487,8 +704,8 @@ func buildDeliveryFilter() map[string]interface{} {
 	filter := make(map[string]interface{})
 	filterVars := make(map[string]interface{})
 
-	if id > 0 {
-		filterVars["purchaseId"] = strconv.Itoa(id)
+	if id != "" {
+		filterVars["purchaseId"] = id
 	}
 
 	if statusFilter != "" {
@@ -510,12 +727,64 @@ func buildDeliveryFilter() map[string]interface{} {
 	return filter
 }
 
+// setClientHeaders identifies this client to the server for per-client metrics and
+// rate limits, along with the role it acts as
+func setClientHeaders(header http.Header) {
+	if clientName != "" {
+		header.Set("apollographql-client-name", clientName)
+	}
+	if clientVersion != "" {
+		header.Set("apollographql-client-version", clientVersion)
+	}
+	if role != "" {
+		header.Set("X-User-Role", role)
+	}
+}
+
+// withRoleVariables sets the admin variable, which queries use to select
+// admin-only fields such as bankTxId only when acting as an admin
+func withRoleVariables(variables map[string]interface{}) map[string]interface{} {
+	if role != "admin" {
+		return variables
+	}
+	if variables == nil {
+		variables = make(map[string]interface{})
+	}
+	variables["admin"] = true
+	return variables
+}
+
+// fetchSchema downloads the schema SDL, served next to the GraphQL endpoint
+func fetchSchema() (string, error) {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(serverURL, "/")+"/schema", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	setClientHeaders(req.Header)
+
+	client := newHTTPClient()
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return string(body), nil
+}
+
 // executeQuery sends a GraphQL query to the server and returns the response
 func executeQuery(query string, variables map[string]interface{}) (map[string]interface{}, error) {
 	// Prepare the request
 	reqBody, err := json.Marshal(graphQLRequest{
 		Query:     query,
-		Variables: variables,
+		Variables: withRoleVariables(variables),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
@@ -530,6 +799,7 @@ func executeQuery(query string, variables map[string]interface{}) (map[string]in
 	// Set headers
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
+	setClientHeaders(req.Header)
 
 	// Log the request details in verbose mode
 	if verbose {
@@ -538,7 +808,7 @@ func executeQuery(query string, variables map[string]interface{}) (map[string]in
 	}
 
 	// Send the request
-	client := &http.Client{Timeout: 10 * time.Second}
+	client := newHTTPClient()
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
@@ -566,8 +836,14 @@ func executeQuery(query string, variables map[string]interface{}) (map[string]in
 	}
 
 	// Check for GraphQL errors
-	if errors, ok := result["errors"]; ok {
-		return nil, fmt.Errorf("GraphQL error: %v", errors)
+	if _, ok := result["errors"]; ok {
+		var response struct {
+			Errors queryErrors `json:"errors"`
+		}
+		if err := json.Unmarshal(body, &response); err != nil || len(response.Errors) == 0 {
+			return nil, fmt.Errorf("GraphQL error: %v", result["errors"])
+		}
+		return nil, response.Errors
 	}
 
 	return result, nil
@@ -575,6 +851,10 @@ func executeQuery(query string, variables map[string]interface{}) (map[string]in
 
 // executeSubscription handles GraphQL subscriptions over WebSocket
 func executeSubscription(query string, variables map[string]interface{}) error {
+	if recordDir != "" || replayDir != "" {
+		return errors.New("subscriptions can't be recorded or replayed")
+	}
+
 	// Convert HTTP URL to WebSocket URL
 	wsURL := strings.Replace(serverURL, "http://", "ws://", 1)
 	wsURL = strings.Replace(wsURL, "https://", "wss://", 1)
@@ -583,7 +863,9 @@ func executeSubscription(query string, variables map[string]interface{}) error {
 	log.Printf("Connecting to WebSocket endpoint: %s", wsURL)
 
 	// Connect to WebSocket
-	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	headers := http.Header{}
+	setClientHeaders(headers)
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, headers)
 	if err != nil {
 		return fmt.Errorf("failed to connect to WebSocket: %w", err)
 	}
@@ -593,6 +875,9 @@ func executeSubscription(query string, variables map[string]interface{}) error {
 
 	// Send connection init message
 	initMessage := wsMessage{Type: "connection_init"}
+	if authToken != "" {
+		initMessage.Payload = map[string]string{"authToken": authToken}
+	}
 	if err := conn.WriteJSON(initMessage); err != nil {
 		return fmt.Errorf("failed to send connection init: %w", err)
 	}
@@ -603,6 +888,12 @@ func executeSubscription(query string, variables map[string]interface{}) error {
 		return fmt.Errorf("failed to receive connection ack: %w", err)
 	}
 
+	if ackMessage.Type == "connection_error" {
+		if payload, ok := ackMessage.Payload.(map[string]interface{}); ok {
+			return fmt.Errorf("connection rejected: %v", payload["message"])
+		}
+		return errors.New("connection rejected")
+	}
 	if ackMessage.Type != "connection_ack" {
 		return fmt.Errorf("expected connection_ack, got %s", ackMessage.Type)
 	}
@@ -615,7 +906,7 @@ func executeSubscription(query string, variables map[string]interface{}) error {
 		ID:   subscriptionID,
 		Payload: graphQLRequest{
 			Query:     query,
-			Variables: variables,
+			Variables: withRoleVariables(variables),
 		},
 	}
 	if err := conn.WriteJSON(startMessage); err != nil {
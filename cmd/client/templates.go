@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// templateVars holds the values of {{name}} placeholders, given as -var name=value
+type templateVars map[string]string
+
+func (v templateVars) String() string {
+	var pairs []string
+	for name, value := range v {
+		pairs = append(pairs, name+"="+value)
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (v templateVars) Set(pair string) error {
+	name, value, ok := strings.Cut(pair, "=")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("expected name=value, got %q", pair)
+	}
+	v[strings.TrimSpace(name)] = value
+	return nil
+}
+
+// placeholder matches ${ENV_VAR} and {{name}}
+var placeholder = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}|\{\{\s*([A-Za-z_][A-Za-z0-9_.-]*)\s*\}\}`)
+
+// expandTemplate replaces ${ENV_VAR} with the environment variable and {{name}}
+// with the value given by -var. Values are inserted as they are, so a value
+// going into a JSON string must be quoted in the file. Placeholders without a
+// value are an error rather than silently left empty
+func expandTemplate(text string, vars templateVars) (string, error) {
+	var missing []string
+	expanded := placeholder.ReplaceAllStringFunc(text, func(match string) string {
+		groups := placeholder.FindStringSubmatch(match)
+		if name := groups[1]; name != "" {
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				missing = append(missing, "${"+name+"}")
+			}
+			return value
+		}
+		value, ok := vars[groups[2]]
+		if !ok {
+			missing = append(missing, "{{"+groups[2]+"}}")
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("no value for %s", strings.Join(slices.Compact(missing), ", "))
+	}
+	return expanded, nil
+}
+
+// loadOperation reads an operation and optionally its JSON variables from
+// files, expanding the placeholders in both
+func loadOperation(queryPath, variablesPath string, vars templateVars) (string, map[string]interface{}, error) {
+	data, err := os.ReadFile(queryPath)
+	if err != nil {
+		return "", nil, err
+	}
+	query, err := expandTemplate(string(data), vars)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", queryPath, err)
+	}
+	if variablesPath == "" {
+		return query, nil, nil
+	}
+
+	if data, err = os.ReadFile(variablesPath); err != nil {
+		return "", nil, err
+	}
+	expanded, err := expandTemplate(string(data), vars)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", variablesPath, err)
+	}
+	var variables map[string]interface{}
+	if err := json.Unmarshal([]byte(expanded), &variables); err != nil {
+		return "", nil, fmt.Errorf("%s: invalid JSON variables: %w", variablesPath, err)
+	}
+	return query, variables, nil
+}
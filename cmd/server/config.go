@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/graphql"
+	"github.com/korjavin/graphqlTinyExample/pkg/ratelimit"
+)
+
+// reloadableKeys are the settings applied again when the config file is
+// reloaded; changes to any other setting take effect on the next start
+var reloadableKeys = map[string]bool{
+	"CLIENT_RATE_LIMIT":    true,
+	"CLIENT_RATE_BURST":    true,
+	"PAGE_SIZE_DEFAULT":    true,
+	"PAGE_SIZE_MAX":        true,
+	"MAX_PAGE_SIZE":        true,
+	"PAGE_SIZE_OVERRIDES":  true,
+	"CORS_ALLOWED_ORIGINS": true,
+}
+
+// envValue is an environment variable as it was before the config file set it
+type envValue struct {
+	value string
+	set   bool
+}
+
+// configFile holds settings as KEY=VALUE lines, named like the environment
+// variables they override. Blank lines and lines starting with # are ignored
+type configFile struct {
+	path   string
+	values map[string]string
+	// environ keeps the variables the file overrides, restored once a key is
+	// removed from the file
+	environ map[string]envValue
+}
+
+// load reads the file and sets its values in the environment
+func (c *configFile) load() error {
+	values, _, err := c.read()
+	if err != nil {
+		return err
+	}
+	c.set(values)
+	return nil
+}
+
+// read parses the file without touching the environment, returning its values
+// and the keys whose value changed since they were last set
+func (c *configFile) read() (map[string]string, []string, error) {
+	values, err := parseConfigFile(c.path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var changed []string
+	for key, value := range values {
+		if old, ok := c.values[key]; !ok || old != value {
+			changed = append(changed, key)
+		}
+	}
+	for key := range c.values {
+		if _, ok := values[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	slices.Sort(changed)
+	return values, changed, nil
+}
+
+// getenv looks up variables in the environment as it will be once values are
+// set, so settings can be validated before they replace the current ones
+func (c *configFile) getenv(values map[string]string) func(string) string {
+	return func(key string) string {
+		if value, ok := values[key]; ok {
+			return value
+		}
+		if original, ok := c.environ[key]; ok {
+			return original.value
+		}
+		return os.Getenv(key)
+	}
+}
+
+// set sets values in the environment, restoring the variables of keys removed
+// from the file since the last call
+func (c *configFile) set(values map[string]string) {
+	if c.environ == nil {
+		c.environ = make(map[string]envValue)
+	}
+	for key, value := range values {
+		if _, ok := c.environ[key]; !ok {
+			original, set := os.LookupEnv(key)
+			c.environ[key] = envValue{value: original, set: set}
+		}
+		os.Setenv(key, value)
+	}
+	for key := range c.values {
+		if _, ok := values[key]; ok {
+			continue
+		}
+		if original := c.environ[key]; original.set {
+			os.Setenv(key, original.value)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+	c.values = values
+}
+
+// parseConfigFile reads the KEY=VALUE lines of a config file
+func parseConfigFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, n)
+		}
+		values[key] = strings.TrimSpace(value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// watch reloads the file on SIGHUP and applies the reloadable settings to the
+// running server. The settings are validated before the environment is
+// changed, so an invalid file leaves both untouched. Connections, including
+// WebSocket subscriptions, are kept
+func (c *configFile) watch(t *tunables) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			values, changed, err := c.read()
+			if err != nil {
+				log.Printf("Failed to reload config file, keeping the current settings: %v", err)
+				continue
+			}
+			settings, err := readSettings(c.getenv(values))
+			if err != nil {
+				log.Printf("Invalid config file, keeping the current settings: %v", err)
+				continue
+			}
+			c.set(values)
+			t.apply(settings)
+			for _, key := range changed {
+				if !reloadableKeys[key] {
+					log.Printf("Config setting %s changed and takes effect after a restart", key)
+				}
+			}
+			log.Printf("Reloaded config file %s", c.path)
+		}
+	}()
+}
+
+// tunables are the parts of the running server the reloadable settings apply to
+type tunables struct {
+	limiter    *ratelimit.Limiter
+	resolver   *graphql.Resolver
+	complexity *graphql.Complexity
+	cors       *corsOrigins
+}
+
+// settings are the values of the reloadable settings
+type settings struct {
+	pageLimits  graphql.PageLimits
+	rate        float64
+	burst       int
+	corsOrigins string
+}
+
+// readSettings reads the reloadable settings with getenv, failing if any of
+// them is invalid
+func readSettings(getenv func(string) string) (*settings, error) {
+	pageLimits, err := pageLimitsFromEnv(getenv)
+	if err != nil {
+		return nil, err
+	}
+	rate, burst, err := rateLimitFromEnv(getenv)
+	if err != nil {
+		return nil, err
+	}
+	return &settings{pageLimits: pageLimits, rate: rate, burst: burst, corsOrigins: getenv("CORS_ALLOWED_ORIGINS")}, nil
+}
+
+// apply applies validated settings to the running server
+func (t *tunables) apply(s *settings) {
+	t.limiter.SetRate(s.rate, s.burst)
+	t.resolver.SetPageLimits(s.pageLimits)
+	t.complexity.SetPageLimits(s.pageLimits)
+	t.cors.set(s.corsOrigins)
+}
+
+// pageLimitsFromEnv returns the page sizes clients may request; MAX_PAGE_SIZE
+// is the former name of PAGE_SIZE_MAX
+func pageLimitsFromEnv(getenv func(string) string) (graphql.PageLimits, error) {
+	pageLimits := graphql.DefaultPageLimits()
+	pageLimits.Default = int(envFloat(getenv, "PAGE_SIZE_DEFAULT", float64(pageLimits.Default)))
+	pageLimits.Max = int(envFloat(getenv, "PAGE_SIZE_MAX", envFloat(getenv, "MAX_PAGE_SIZE", float64(pageLimits.Max))))
+	if pageLimits.Default < 1 || pageLimits.Max < 1 {
+		return pageLimits, fmt.Errorf("PAGE_SIZE_DEFAULT and PAGE_SIZE_MAX must be positive")
+	}
+	if overrides := getenv("PAGE_SIZE_OVERRIDES"); overrides != "" {
+		fields, err := graphql.ParsePageLimitOverrides(overrides)
+		if err != nil {
+			return pageLimits, fmt.Errorf("invalid PAGE_SIZE_OVERRIDES: %w", err)
+		}
+		for field, limit := range fields {
+			pageLimits.Fields[field] = limit
+		}
+	}
+	return pageLimits, nil
+}
+
+// rateLimitFromEnv returns the per-client rate limit; a rate of zero disables
+// it. The burst defaults to the rate rounded up, so rates below one request per
+// second still let a request through
+func rateLimitFromEnv(getenv func(string) string) (float64, int, error) {
+	rate := envFloat(getenv, "CLIENT_RATE_LIMIT", 0)
+	burst := int(envFloat(getenv, "CLIENT_RATE_BURST", max(1, math.Ceil(rate))))
+	if burst < 1 {
+		return 0, 0, fmt.Errorf("CLIENT_RATE_BURST must be at least 1, got %d", burst)
+	}
+	return rate, burst, nil
+}
+
+// corsOrigins are the origins allowed to call the API from a browser, which
+// may be replaced while requests are served
+type corsOrigins struct {
+	origins atomic.Pointer[[]string]
+}
+
+// set replaces the allowed origins with a comma-separated list; an empty list
+// or * allows any origin
+func (c *corsOrigins) set(list string) {
+	var origins []string
+	for _, origin := range strings.Split(list, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	c.origins.Store(&origins)
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for a request
+// from origin, or "" if the origin is not allowed
+func (c *corsOrigins) allowOrigin(origin string) string {
+	origins := *c.origins.Load()
+	if len(origins) == 0 || slices.Contains(origins, "*") {
+		return "*"
+	}
+	if slices.Contains(origins, origin) {
+		return origin
+	}
+	return ""
+}
@@ -1,77 +1,392 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
-	"github.com/gorilla/websocket"
-	graphqlgo "github.com/graph-gophers/graphql-go"
-	"github.com/graph-gophers/graphql-go/relay"
 	_ "github.com/lib/pq"
 
+	"github.com/korjavin/graphqlTinyExample/pkg/digest"
+	"github.com/korjavin/graphqlTinyExample/pkg/export"
+	"github.com/korjavin/graphqlTinyExample/pkg/fraud"
 	"github.com/korjavin/graphqlTinyExample/pkg/graphql"
+	"github.com/korjavin/graphqlTinyExample/pkg/id"
+	"github.com/korjavin/graphqlTinyExample/pkg/jwt"
+	"github.com/korjavin/graphqlTinyExample/pkg/metrics"
 	"github.com/korjavin/graphqlTinyExample/pkg/models"
+	"github.com/korjavin/graphqlTinyExample/pkg/moderation"
+	"github.com/korjavin/graphqlTinyExample/pkg/mqttbridge"
+	"github.com/korjavin/graphqlTinyExample/pkg/ratelimit"
+	"github.com/korjavin/graphqlTinyExample/pkg/rates"
+	"github.com/korjavin/graphqlTinyExample/pkg/receipt"
+	"github.com/korjavin/graphqlTinyExample/pkg/recommend"
 	"github.com/korjavin/graphqlTinyExample/pkg/repository"
+	"github.com/korjavin/graphqlTinyExample/pkg/search"
+	"github.com/korjavin/graphqlTinyExample/pkg/tax"
+	"github.com/korjavin/graphqlTinyExample/pkg/ws"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
-}
+// Headers identifying the calling application, following the Apollo convention
+const (
+	clientNameHeader    = "apollographql-client-name"
+	clientVersionHeader = "apollographql-client-version"
+	// roleHeader carries the caller's role; it must be set by a trusted gateway
+	roleHeader = "X-User-Role"
+	// Admins set these to run an operation as another caller, e.g. seller 3
+	impersonateRoleHeader    = "X-Impersonate-Role"
+	impersonateSubjectHeader = "X-Impersonate-Subject"
+)
 
 func main() {
+	checkSchema := flag.String("check-schema", "", "Compare the compiled schema against a baseline SDL file and exit non-zero on breaking changes")
+	selfTest := flag.Bool("selftest", false, "Smoke-test the configured database with a query, a rolled-back mutation and a subscription, then exit")
+	flag.Parse()
+
+	if *checkSchema != "" {
+		os.Exit(runSchemaCheck(*checkSchema))
+	}
+
 	log.Println("Starting GraphQL server...")
 
-	// Get database configuration from environment variables
-	dbHost := getEnv("DB_HOST", "localhost")
-	dbPort := getEnv("DB_PORT", "5432")
-	dbUser := getEnv("DB_USER", "postgres")
-	dbPassword := getEnv("DB_PASSWORD", "postgres")
-	dbName := getEnv("DB_NAME", "graphql_example")
+	// Optionally read settings from a file overriding the environment; SIGHUP
+	// reloads it once the server runs
+	var config *configFile
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		config = &configFile{path: path}
+		if err := config.load(); err != nil {
+			log.Fatalf("Failed to load config file: %v", err)
+		}
+		log.Printf("Loaded config file %s", path)
+	}
+
+	// Get database configuration from environment variables, preferring a
+	// single DATABASE_URL as provided by most hosting platforms
+	dataSource := os.Getenv("DATABASE_URL")
+	if dataSource == "" {
+		dataSource = models.DSN(
+			getEnv("DB_HOST", "localhost"),
+			getEnv("DB_PORT", "5432"),
+			getEnv("DB_USER", "postgres"),
+			getEnv("DB_PASSWORD", "postgres"),
+			getEnv("DB_NAME", "graphql_example"),
+			models.SSLConfig{
+				Mode:     getEnv("DB_SSLMODE", "disable"),
+				RootCert: os.Getenv("DB_SSLROOTCERT"),
+				Cert:     os.Getenv("DB_SSLCERT"),
+				Key:      os.Getenv("DB_SSLKEY"),
+			},
+		)
+	}
+
+	// Wait for the database to come up, e.g. when started together with docker-compose
+	retry := models.DefaultConnectRetry
+	retry.MaxWait = time.Duration(getEnvFloat("DB_CONNECT_MAX_WAIT_SECONDS", retry.MaxWait.Seconds()) * float64(time.Second))
+	retry.MaxBackoff = time.Duration(getEnvFloat("DB_CONNECT_MAX_BACKOFF_SECONDS", retry.MaxBackoff.Seconds()) * float64(time.Second))
 
 	// Connect to the database
-	db, err := models.NewDB(dbHost, dbPort, dbUser, dbPassword, dbName)
+	db, err := models.NewDB(dataSource, retry)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 	defer db.Close()
 
+	// Export connection pool statistics to catch pool exhaustion early
+	if err := metrics.RegisterDBStats(db, "graphql"); err != nil {
+		log.Printf("Failed to register database pool metrics: %v", err)
+	}
+
+	if *selfTest {
+		os.Exit(runSelfTest(db, dataSource))
+	}
+
 	// Create repository and resolver
 	repo := repository.NewRepository(db)
+	// Fail fast while the database is unreachable instead of waiting for timeouts
+	repo.SetBreaker(repository.NewBreaker(
+		int(getEnvFloat("DB_BREAKER_THRESHOLD", 5)),
+		time.Duration(getEnvFloat("DB_BREAKER_COOLDOWN_SECONDS", 10)*float64(time.Second))))
 	resolver := graphql.NewResolver(repo)
 
-	// Create GraphQL schema
-	schema, err := graphql.GetSchema(resolver)
+	// Charge tax through an external tax service if configured, otherwise at a flat rate
+	if taxServiceURL := os.Getenv("TAX_SERVICE_URL"); taxServiceURL != "" {
+		resolver.SetTaxCalculator(tax.NewHTTPCalculator(taxServiceURL, os.Getenv("TAX_SERVICE_API_KEY")))
+		log.Printf("Using tax service at %s", taxServiceURL)
+	} else {
+		resolver.SetTaxCalculator(tax.FlatRate{Rate: getEnvFloat("TAX_RATE", 0)})
+	}
+
+	// Keep exchange rates cached in memory, refreshed in the background
+	if ratesURL := os.Getenv("EXCHANGE_RATES_URL"); ratesURL != "" {
+		cache := rates.NewCache(rates.NewHTTPProvider(ratesURL))
+		resolver.SetExchangeRates(cache)
+		refresh := time.Duration(getEnvFloat("EXCHANGE_RATES_REFRESH_MINUTES", 60) * float64(time.Minute))
+		if refresh <= 0 {
+			log.Fatalf("EXCHANGE_RATES_REFRESH_MINUTES must be positive")
+		}
+		go cache.Run(refresh, nil)
+		log.Printf("Refreshing exchange rates from %s every %s", ratesURL, refresh)
+	}
+
+	// Serve searchListings from a search engine kept in sync in the background
+	if searchURL := os.Getenv("SEARCH_URL"); searchURL != "" {
+		var backend search.Backend
+		index := getEnv("SEARCH_INDEX", "listings")
+		switch kind := getEnv("SEARCH_BACKEND", "meilisearch"); kind {
+		case "meilisearch":
+			backend = search.NewMeilisearch(searchURL, os.Getenv("SEARCH_API_KEY"), index)
+		case "elasticsearch":
+			backend = search.NewElasticsearch(searchURL, os.Getenv("SEARCH_API_KEY"), index)
+		default:
+			log.Fatalf("Unknown search backend: %s", kind)
+		}
+		indexer := search.NewIndexer(backend, repo)
+		resolver.SetSearchIndexer(indexer)
+		go indexer.Run(nil)
+		log.Printf("Indexing listings in %s", searchURL)
+	}
+
+	// Use an external recommendation engine instead of the built-in heuristic
+	if recommenderURL := os.Getenv("RECOMMENDER_URL"); recommenderURL != "" {
+		resolver.SetRecommender(recommend.NewHTTPRecommender(recommenderURL))
+		log.Printf("Using recommendation engine at %s", recommenderURL)
+	}
+
+	// Screen listing text against a custom wordlist instead of the built-in spam terms
+	if wordlistFile := os.Getenv("MODERATION_WORDLIST_FILE"); wordlistFile != "" {
+		action := moderation.Action(getEnv("MODERATION_ACTION", string(moderation.Reject)))
+		if action != moderation.Reject && action != moderation.Flag {
+			log.Fatalf("Unknown moderation action: %s", action)
+		}
+		wordlist, err := moderation.LoadWordlist(wordlistFile, action)
+		if err != nil {
+			log.Fatalf("Failed to load moderation wordlist: %v", err)
+		}
+		resolver.SetModerationChecker(wordlist)
+		log.Printf("Moderating listings with %s (%s)", wordlistFile, action)
+	}
+
+	// Bound the listings of each seller; admins can override it per seller with setSellerQuota
+	resolver.SetMaxListingsPerSeller(int(getEnvFloat("SELLER_MAX_LISTINGS", 0)))
+
+	// Screen new purchases for fraud in the background
+	if maxAmount := getEnvFloat("FRAUD_MAX_AMOUNT", 0); maxAmount > 0 {
+		resolver.SetFraudChecker(fraud.MaxAmount{Limit: models.MoneyFromFloat(maxAmount)})
+		log.Printf("Rejecting purchases above %.2f", maxAmount)
+	}
+	go resolver.FraudReviewer().Run(time.Minute, nil)
+
+	// Notify sellers of purchases of their listings
+	if source := os.Getenv("CLOUDEVENTS_SOURCE"); source != "" {
+		resolver.WebhookDispatcher().SetSource(source)
+	}
+	go resolver.WebhookDispatcher().Run(nil)
+
+	// Send opted-in sellers a digest of the previous day through their webhook
+	digestHour := int(getEnvFloat("SELLER_DIGEST_HOUR", 6))
+	go digest.NewJob(repo, resolver.WebhookDispatcher(), digestHour).Run(10*time.Minute, nil)
+
+	// Protect the event bus from hot purchase IDs
+	maxSubscribers := int(getEnvFloat("MAX_SUBSCRIBERS_PER_PURCHASE", 100))
+	resolver.SetMaxSubscribersPerPurchase(maxSubscribers)
+
+	// Bound the events buffered for slow subscribers and choose what happens to lagging ones
+	backpressure := graphql.DefaultBackpressure()
+	backpressure.Buffer = int(getEnvFloat("SUBSCRIPTION_BUFFER", float64(backpressure.Buffer)))
+	backpressure.Replay = int(getEnvFloat("SUBSCRIPTION_MAX_REPLAY", float64(backpressure.Replay)))
+	if backpressure.Buffer < 1 || backpressure.Replay < 1 {
+		log.Fatalf("SUBSCRIPTION_BUFFER and SUBSCRIPTION_MAX_REPLAY must be positive")
+	}
+	if policy := os.Getenv("SUBSCRIPTION_LAG_POLICY"); policy != "" {
+		var err error
+		if backpressure.Policy, err = graphql.ParseLagPolicy(policy); err != nil {
+			log.Fatalf("Invalid SUBSCRIPTION_LAG_POLICY: %v", err)
+		}
+	}
+	resolver.SetBackpressure(backpressure)
+
+	// Bound the page sizes clients may request
+	pageLimits, err := pageLimitsFromEnv(os.Getenv)
+	if err != nil {
+		log.Fatalf("Invalid page limits: %v", err)
+	}
+	resolver.SetPageLimits(pageLimits)
+
+	// Share the cursor signing key between replicas and restarts
+	if secret := os.Getenv("CURSOR_SECRET"); secret != "" {
+		resolver.SetCursorKey([]byte(secret))
+	}
+
+	// Expose obfuscated IDs so clients can't enumerate rows by incrementing IDs
+	if secret := os.Getenv("ID_SECRET"); secret != "" {
+		id.SetCodec(id.NewObfuscator([]byte(secret)))
+		log.Printf("Obfuscating IDs exposed by the API")
+	}
+
+	// Flush buffered listing views in batches, and once more on shutdown
+	stopViews := make(chan struct{})
+	viewsFlushed := make(chan struct{})
+	go func() {
+		resolver.ViewCounter().Run(10*time.Second, stopViews)
+		close(viewsFlushed)
+	}()
+
+	// Recompute the statistics behind sellerStats and topSellers in the background
+	statsRefresh := time.Duration(getEnvFloat("STATS_REFRESH_SECONDS", 60) * float64(time.Second))
+	if statsRefresh <= 0 {
+		log.Fatalf("STATS_REFRESH_SECONDS must be positive")
+	}
+	go resolver.StatsCache().Run(statsRefresh, nil)
+
+	// Copy new purchases and deliveries to the analytics warehouse
+	if exportDir := os.Getenv("EXPORT_DIR"); exportDir != "" {
+		exporter := export.NewExporter(repo, export.FileSink{Dir: exportDir}, int(getEnvFloat("EXPORT_BATCH_SIZE", 1000)))
+		interval := time.Duration(getEnvFloat("EXPORT_INTERVAL_MINUTES", 60) * float64(time.Minute))
+		if interval <= 0 {
+			log.Fatalf("EXPORT_INTERVAL_MINUTES must be positive")
+		}
+		go exporter.Run(interval, nil)
+		log.Printf("Exporting purchases and deliveries to %s every %s", exportDir, interval)
+	}
+
+	// Create GraphQL schema. MAX_PARALLEL_RESOLVERS bounds the concurrent resolvers
+	// of a single request to protect the database pool
+	schemaConfig := graphql.DefaultSchemaConfig()
+	schemaConfig.SubscribeResolverTimeout = time.Duration(getEnvFloat("SUBSCRIBE_RESOLVER_TIMEOUT_SECONDS", schemaConfig.SubscribeResolverTimeout.Seconds()) * float64(time.Second))
+	schemaConfig.MaxParallelism = int(getEnvFloat("MAX_PARALLEL_RESOLVERS", float64(schemaConfig.MaxParallelism)))
+	schemaConfig.MaxDepth = int(getEnvFloat("MAX_QUERY_DEPTH", float64(schemaConfig.MaxDepth)))
+	// Publicly exposed servers hide the schema from introspection; admins can still fetch the SDL
+	disableIntrospection := os.Getenv("DISABLE_INTROSPECTION") == "true"
+	schemaConfig.DisableIntrospection = disableIntrospection
+	// Client headers are chosen by callers, so only listed clients get their own
+	// metrics and rate limit buckets
+	knownClients := graphql.ParseKnownClients(os.Getenv("KNOWN_CLIENTS"))
+	schemaConfig.KnownClients = knownClients
+	schema, err := graphql.NewSchema(resolver, schemaConfig)
 	if err != nil {
 		log.Fatalf("Failed to create GraphQL schema: %v", err)
 	}
 
+	// Optionally bridge delivery scanners speaking MQTT
+	if broker := os.Getenv("MQTT_BROKER_URL"); broker != "" {
+		bridge := mqttbridge.NewBridge(schema, mqttbridge.Config{
+			Broker:       broker,
+			ClientID:     getEnv("MQTT_CLIENT_ID", "graphql-server"),
+			Username:     os.Getenv("MQTT_USERNAME"),
+			Password:     os.Getenv("MQTT_PASSWORD"),
+			CommandTopic: getEnv("MQTT_COMMAND_TOPIC", "deliveries/create"),
+			EventTopic:   getEnv("MQTT_EVENT_TOPIC", "deliveries/updated"),
+			QoS:          byte(getEnvFloat("MQTT_QOS", 1)),
+			CloudEvents:  os.Getenv("MQTT_EVENT_FORMAT") == "cloudevents",
+			Source:       os.Getenv("CLOUDEVENTS_SOURCE"),
+		})
+		go func() {
+			if err := bridge.Run(context.Background()); err != nil {
+				log.Printf("MQTT bridge stopped: %v", err)
+			}
+		}()
+		log.Printf("Bridging deliveries over MQTT broker %s", broker)
+	}
+
+	// Optionally publish the schema SDL to a schema registry
+	if registryURL := os.Getenv("SCHEMA_REGISTRY_URL"); registryURL != "" {
+		err := graphql.PublishSchema(graphql.RegistryConfig{
+			Kind:     getEnv("SCHEMA_REGISTRY_KIND", "hive"),
+			Endpoint: registryURL,
+			APIKey:   os.Getenv("SCHEMA_REGISTRY_API_KEY"),
+			Graph:    os.Getenv("SCHEMA_REGISTRY_GRAPH"),
+			Variant:  getEnv("SCHEMA_REGISTRY_VARIANT", "current"),
+			Commit:   os.Getenv("GIT_COMMIT"),
+		})
+		if err != nil {
+			log.Printf("Failed to publish schema to registry: %v", err)
+		}
+	}
+
 	// Set up HTTP handler for regular GraphQL queries and mutations
-	http.Handle("/graphql", corsMiddleware(&relay.Handler{Schema: schema}))
+	rate, burst, err := rateLimitFromEnv(os.Getenv)
+	if err != nil {
+		log.Fatalf("Invalid rate limit: %v", err)
+	}
+	limiter := ratelimit.NewLimiter(rate, burst)
+	if rate > 0 {
+		log.Printf("Per-client rate limit: %.2f req/s, burst %d", rate, burst)
+	}
+	cors := &corsOrigins{}
+	cors.set(os.Getenv("CORS_ALLOWED_ORIGINS"))
+
+	// Optionally restrict the root fields each role may use
+	var whitelist graphql.RoleWhitelist
+	if path := os.Getenv("ROLE_WHITELIST_FILE"); path != "" {
+		whitelist, err = graphql.LoadRoleWhitelist(path)
+		if err != nil {
+			log.Fatalf("Failed to load role whitelist: %v", err)
+		}
+		log.Printf("Role whitelist loaded for %d roles from %s", len(whitelist), path)
+	}
+
+	// Estimate the cost of every operation from the page sizes it asks for;
+	// MAX_QUERY_COMPLEXITY rejects operations costing more
+	complexity := graphql.NewComplexity(schema, pageLimits)
+	complexity.Budget = int(getEnvFloat("MAX_QUERY_COMPLEXITY", 0))
+
+	http.Handle("/graphql", corsMiddleware(cors, clientInfoMiddleware(limiter, knownClients,
+		impersonationMiddleware(repo, roleWhitelistMiddleware(whitelist, &graphql.Handler{Schema: schema, DisableIntrospection: disableIntrospection, Complexity: complexity})))))
 
 	// Set up WebSocket handler for GraphQL subscriptions
+	wsHandler := &ws.Handler{
+		Schema:    schema,
+		Whitelist: whitelist,
+		// Bound what a single client may hold open; behind a proxy every
+		// client shares its IP, so the per-IP limit is off by default
+		MaxSubscriptions:    int(getEnvFloat("WS_MAX_SUBSCRIPTIONS_PER_CONNECTION", 100)),
+		MaxMessageSize:      int64(getEnvFloat("WS_MAX_MESSAGE_SIZE", 64*1024)),
+		MaxConnectionsPerIP: int(getEnvFloat("WS_MAX_CONNECTIONS_PER_IP", 0)),
+		Context: func(r *http.Request) context.Context {
+			ctx := graphql.WithClientInfo(context.Background(), clientInfoFromRequest(r))
+			return graphql.WithRole(ctx, r.Header.Get(roleHeader))
+		},
+	}
+	// Browsers can't set headers on WebSocket connections, so clients may
+	// instead authenticate with a token signed with WS_AUTH_SECRET in the
+	// connection_init payload; its role replaces the one from the gateway
+	if secret := os.Getenv("WS_AUTH_SECRET"); secret != "" {
+		wsHandler.Authenticate = tokenAuthenticator(jwt.NewHS256([]byte(secret)))
+		log.Println("WebSocket connections may authenticate with an auth token")
+	}
 	http.HandleFunc("/graphql/ws", func(w http.ResponseWriter, r *http.Request) {
-		// Upgrade HTTP connection to WebSocket
-		conn, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			log.Printf("Failed to upgrade connection to WebSocket: %v", err)
+		// Impersonation is audited per operation, which only the HTTP endpoint does
+		if r.Header.Get(impersonateRoleHeader) != "" || r.Header.Get(impersonateSubjectHeader) != "" {
+			http.Error(w, "Impersonation is not supported for subscriptions", http.StatusForbidden)
 			return
 		}
-		defer conn.Close()
+		wsHandler.ServeHTTP(w, r)
+	})
 
-		// Log the new WebSocket connection
-		log.Printf("[WS] New WebSocket connection from %s", r.RemoteAddr)
+	// Serve the schema SDL for codegen and linters, which then need no introspection
+	http.Handle("GET /graphql/schema", corsMiddleware(cors, schemaHandler(disableIntrospection)))
 
-		// Handle subscription protocol
-		handleGraphQLSubscription(conn, schema)
-	})
+	// Render purchase receipts as PDF
+	http.HandleFunc("GET /receipts/{purchaseId}/pdf", receiptPDFHandler(repo))
+
+	// Report readiness so load balancers route away while the database is down
+	http.HandleFunc("GET /ready", readyHandler(repo.Breaker()))
+
+	// Expose Prometheus metrics
+	http.Handle("/metrics", metrics.Handler())
 
 	// Serve GraphQL Playground for interactive API exploration
 	http.HandleFunc("/", playgroundHandler)
@@ -82,6 +397,15 @@ func main() {
 	log.Printf("GraphQL HTTP endpoint: http://localhost:%s/graphql", port)
 	log.Printf("GraphQL WebSocket endpoint: http://localhost:%s/graphql/ws", port)
 	log.Printf("GraphQL Playground: http://localhost:%s/", port)
+	log.Printf("Metrics endpoint: http://localhost:%s/metrics", port)
+	log.Printf("Receipt PDFs: http://localhost:%s/receipts/{purchaseId}/pdf", port)
+
+	// Apply changes to the rate limit, page sizes and CORS origins on SIGHUP
+	// without dropping connections
+	if config != nil {
+		config.watch(&tunables{limiter: limiter, resolver: resolver, complexity: complexity, cors: cors})
+		log.Printf("Send SIGHUP to reload %s", config.path)
+	}
 
 	server := &http.Server{
 		Addr:         ":" + port,
@@ -89,156 +413,55 @@ func main() {
 		WriteTimeout: 30 * time.Second,
 	}
 
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
-	}
-}
-
-// handleGraphQLSubscription manages the WebSocket connection for GraphQL subscriptions
-func handleGraphQLSubscription(conn *websocket.Conn, schema *graphqlgo.Schema) {
-	// Map of active subscriptions, keyed by subscription ID
-	subscriptions := make(map[string]context.CancelFunc)
-	defer func() {
-		// Clean up all subscriptions when connection closes
-		for id, cancel := range subscriptions {
-			cancel()
-			log.Printf("[WS] Closing subscription %s", id)
+	// On SIGINT or SIGTERM, stop accepting connections and let requests in
+	// flight finish, so the views they record are flushed below
+	shutdownDone := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		log.Println("Shutting down...")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Failed to finish requests before shutting down: %v", err)
 		}
+		close(shutdownDone)
 	}()
 
-	// Process WebSocket messages
-	for {
-		// Read message from WebSocket
-		_, msg, err := conn.ReadMessage()
-		if err != nil {
-			log.Printf("[WS] Error reading message: %v", err)
-			break
-		}
-
-		// Parse the message
-		var message struct {
-			Type    string          `json:"type"`
-			ID      string          `json:"id,omitempty"`
-			Payload json.RawMessage `json:"payload,omitempty"`
-		}
-
-		if err := json.Unmarshal(msg, &message); err != nil {
-			log.Printf("[WS] Error parsing message: %v", err)
-			sendErrorMessage(conn, "", "Invalid message format")
-			continue
-		}
-
-		// Handle message based on type
-		switch message.Type {
-		case "connection_init":
-			// Connection initialization
-			log.Printf("[WS] Connection initialized")
-			sendMessage(conn, "connection_ack", "", nil)
-
-		case "start":
-			// Start subscription
-			var payload struct {
-				Query         string                 `json:"query"`
-				Variables     map[string]interface{} `json:"variables,omitempty"`
-				OperationName string                 `json:"operationName,omitempty"`
-			}
-
-			if err := json.Unmarshal(message.Payload, &payload); err != nil {
-				log.Printf("[WS] Error parsing subscription payload: %v", err)
-				sendErrorMessage(conn, message.ID, "Invalid subscription payload")
-				continue
-			}
-
-			log.Printf("[WS] Starting subscription %s: %s", message.ID, payload.Query)
-
-			// Create context with cancel function for this subscription
-			ctx, cancel := context.WithCancel(context.Background())
-			subscriptions[message.ID] = cancel
-
-			// Start the subscription
-			go func(id string, ctx context.Context) {
-				// Execute the subscription query
-				responseChannel, err := schema.Subscribe(ctx, payload.Query, payload.OperationName, payload.Variables)
-
-				if err != nil {
-					log.Printf("[WS] Subscription error: %v", err)
-					sendErrorMessage(conn, id, err.Error())
-					return
-				}
-
-				// Process subscription events from the channel
-				for response := range responseChannel {
-					// Type assert to get the actual response type
-					if graphqlResponse, ok := response.(*graphqlgo.Response); ok {
-						if graphqlResponse.Errors != nil && len(graphqlResponse.Errors) > 0 {
-							// If there's an error, send it to the client
-							sendErrorMessage(conn, id, graphqlResponse.Errors[0].Error())
-							continue
-						}
-
-						// Send data to the client
-						sendMessage(conn, "data", id, map[string]interface{}{
-							"data": graphqlResponse.Data,
-						})
-					}
-				}
-			}(message.ID, ctx)
-
-		case "stop":
-			// Stop subscription
-			if cancel, ok := subscriptions[message.ID]; ok {
-				cancel()
-				delete(subscriptions, message.ID)
-				log.Printf("[WS] Stopped subscription %s", message.ID)
-			}
-			sendMessage(conn, "complete", message.ID, nil)
-
-		case "connection_terminate":
-			// Connection termination requested by client
-			log.Printf("[WS] Connection termination requested")
-			return
-
-		default:
-			log.Printf("[WS] Unknown message type: %s", message.Type)
-		}
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("Failed to start server: %v", err)
 	}
+	<-shutdownDone
+	close(stopViews)
+	<-viewsFlushed
+	log.Println("Server stopped")
 }
 
-// sendMessage sends a message to the WebSocket client
-func sendMessage(conn *websocket.Conn, messageType, id string, payload interface{}) {
-	msg := map[string]interface{}{
-		"type": messageType,
-	}
-
-	if id != "" {
-		msg["id"] = id
-	}
-
-	if payload != nil {
-		msg["payload"] = payload
-	}
-
-	if err := conn.WriteJSON(msg); err != nil {
-		log.Printf("[WS] Error sending message: %v", err)
+// runSchemaCheck diffs the compiled schema against the baseline file and returns the process exit code
+func runSchemaCheck(baselinePath string) int {
+	baseline, err := os.ReadFile(baselinePath)
+	if err != nil {
+		log.Printf("Failed to read baseline schema: %v", err)
+		return 2
 	}
-}
 
-// sendErrorMessage sends an error message to the WebSocket client
-func sendErrorMessage(conn *websocket.Conn, id string, errorMessage string) {
-	msg := map[string]interface{}{
-		"type": "error",
-		"payload": map[string]interface{}{
-			"message": errorMessage,
-		},
+	changes, err := graphql.CheckCompatibility(string(baseline), graphql.Schema)
+	if err != nil {
+		log.Printf("Failed to compare schemas: %v", err)
+		return 2
 	}
 
-	if id != "" {
-		msg["id"] = id
+	if len(changes) == 0 {
+		fmt.Println("No breaking changes detected")
+		return 0
 	}
 
-	if err := conn.WriteJSON(msg); err != nil {
-		log.Printf("[WS] Error sending error message: %v", err)
+	fmt.Printf("Found %d breaking change(s):\n", len(changes))
+	for _, change := range changes {
+		fmt.Printf("  - %s\n", change)
 	}
+	return 1
 }
 
 // getEnv gets an environment variable or returns a default value
@@ -250,13 +473,35 @@ func getEnv(key, defaultValue string) string {
 	return value
 }
 
-// corsMiddleware adds CORS headers to responses
-func corsMiddleware(next http.Handler) http.Handler {
+// getEnvFloat gets a numeric environment variable or returns a default value
+func getEnvFloat(key string, defaultValue float64) float64 {
+	return envFloat(os.Getenv, key, defaultValue)
+}
+
+// envFloat gets a numeric variable looked up with getenv or returns a default value
+func envFloat(getenv func(string) string, key string, defaultValue float64) float64 {
+	value := getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Invalid value for %s: %v, using default %v", key, err, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+// corsMiddleware adds CORS headers to responses, allowing the configured origins
+func corsMiddleware(cors *corsOrigins, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Add CORS headers
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+		if origin := cors.allowOrigin(r.Header.Get("Origin")); origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		w.Header().Add("Vary", "Origin")
 		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, Authorization, apollographql-client-name, apollographql-client-version, X-User-Role, X-Impersonate-Role, X-Impersonate-Subject")
 
 		// Handle OPTIONS requests
 		if r.Method == http.MethodOptions {
@@ -272,6 +517,214 @@ func corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// tokenAuthenticator validates the auth token of WebSocket connections and
+// attaches its principal. Connections sending no token keep the gateway's role
+func tokenAuthenticator(tokens *jwt.HS256) func(ctx context.Context, token string) (context.Context, error) {
+	return func(ctx context.Context, token string) (context.Context, error) {
+		if token == "" {
+			return ctx, nil
+		}
+		claims, err := tokens.Verify(token)
+		if err != nil {
+			return nil, err
+		}
+		return graphql.WithPrincipal(ctx, &graphql.Principal{Subject: claims.Subject, Role: claims.Role}), nil
+	}
+}
+
+// clientInfoFromRequest reads the client identification headers
+func clientInfoFromRequest(r *http.Request) graphql.ClientInfo {
+	return graphql.ClientInfo{
+		Name:    r.Header.Get(clientNameHeader),
+		Version: r.Header.Get(clientVersionHeader),
+	}
+}
+
+// clientInfoMiddleware attaches the calling client and a per-request cache to
+// the request context and applies the per-client rate limit when a limiter is configured
+func clientInfoMiddleware(limiter *ratelimit.Limiter, known graphql.KnownClients, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := graphql.WithClientInfo(r.Context(), clientInfoFromRequest(r))
+		ctx = graphql.WithRequestCache(ctx)
+		client := graphql.ClientInfoFromContext(ctx)
+
+		if limiter != nil && !limiter.Allow(rateLimitKey(r, client, known)) {
+			log.Printf("[HTTP] Rate limit exceeded for client %s from %s", client.Name, r.RemoteAddr)
+			name, _ := known.Labels(client)
+			metrics.RateLimitedTotal.WithLabelValues(name).Inc()
+			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// rateLimitKey returns the rate limit bucket of a request. Known clients share
+// a bucket per name; requests naming any other client, or none, are limited per
+// remote address, so inventing a name doesn't escape the limit. Behind a proxy
+// the remote address is the proxy's, so the proxy must then set the client
+// headers itself
+func rateLimitKey(r *http.Request, client graphql.ClientInfo, known graphql.KnownClients) string {
+	if known.Known(client.Name) {
+		return "client:" + client.Name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// impersonationMiddleware lets admins run operations as another caller. The
+// impersonated role replaces the caller's role for the whitelist and resolvers,
+// and every impersonated operation is recorded in the audit log before it runs;
+// operations that can't be audited are refused
+func impersonationMiddleware(repo *repository.Repository, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role := r.Header.Get(impersonateRoleHeader)
+		subject := r.Header.Get(impersonateSubjectHeader)
+		if role == "" && subject == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		impersonation, err := graphql.NewImpersonation(r.Header.Get(roleHeader), role, subject)
+		if err != nil {
+			log.Printf("[HTTP] Impersonation rejected: %v", err)
+			writeGraphQLError(w, http.StatusForbidden, err)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+
+		var params struct {
+			Query         string `json:"query"`
+			OperationName string `json:"operationName"`
+		}
+		if err := json.Unmarshal(body, &params); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		client := graphql.ClientInfoFromContext(r.Context())
+		if err := repo.RecordImpersonation(role, subject, client.Name, r.RemoteAddr, params.OperationName, params.Query); err != nil {
+			log.Printf("[HTTP] Failed to audit impersonation: %v", err)
+			http.Error(w, "Failed to audit impersonation", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("[HTTP] Client %s is running an operation as %s %s", client.Name, role, subject)
+
+		// Later handlers see the impersonated role as the caller's role
+		r.Header.Set(roleHeader, role)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r.WithContext(graphql.WithImpersonation(r.Context(), impersonation)))
+	})
+}
+
+// roleWhitelistMiddleware rejects operations selecting root fields the caller's
+// role may not use, attaching the role to the context for the field checks
+func roleWhitelistMiddleware(whitelist graphql.RoleWhitelist, next http.Handler) http.Handler {
+	if whitelist == nil {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(graphql.WithRole(r.Context(), r.Header.Get(roleHeader))))
+		})
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+
+		var params struct {
+			Query         string `json:"query"`
+			OperationName string `json:"operationName"`
+		}
+		if err := json.Unmarshal(body, &params); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		role := r.Header.Get(roleHeader)
+		if err := whitelist.Check(role, params.Query, params.OperationName); err != nil {
+			log.Printf("[HTTP] Operation rejected by role whitelist: %v", err)
+			writeGraphQLError(w, http.StatusForbidden, err)
+			return
+		}
+
+		// Restore the body for the GraphQL handler
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r.WithContext(graphql.WithRole(r.Context(), role)))
+	})
+}
+
+// writeGraphQLError responds with a GraphQL error response carrying err
+func writeGraphQLError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]string{{"message": err.Error()}},
+	})
+}
+
+// receiptPDFHandler renders the receipt of a purchase as a PDF document
+func receiptPDFHandler(store receipt.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		purchaseID, err := id.ParsePurchaseID(r.PathValue("purchaseId"))
+		if err != nil {
+			http.Error(w, "Invalid purchase ID", http.StatusBadRequest)
+			return
+		}
+
+		rec, err := receipt.Load(store, purchaseID)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Purchase not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to generate receipt for purchase %d: %v", purchaseID, err)
+			http.Error(w, "Failed to generate receipt", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", rec.Number+".pdf"))
+		w.Write(rec.PDF())
+	}
+}
+
+// schemaHandler serves the schema SDL the server executes. With introspection
+// disabled it is served to admins only
+func schemaHandler(adminOnly bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminOnly && r.Header.Get(roleHeader) != graphql.AdminRole {
+			http.Error(w, "The schema is only available to admins", http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(graphql.Schema))
+	}
+}
+
+// readyHandler answers 200 while the server can serve requests and 503 while
+// the circuit breaker in front of the database is open
+func readyHandler(breaker *repository.Breaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if breaker.Open() {
+			http.Error(w, "Database unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("OK\n"))
+	}
+}
+
 // playgroundHandler serves the GraphQL Playground UI
 func playgroundHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	graphqlgo "github.com/graph-gophers/graphql-go"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/graphql"
+	"github.com/korjavin/graphqlTinyExample/pkg/id"
+	"github.com/korjavin/graphqlTinyExample/pkg/repository"
+)
+
+// selfTestTimeout bounds the whole self-test, so a hanging database fails the
+// deploy instead of stalling it
+const selfTestTimeout = 30 * time.Second
+
+// runSelfTest smoke-tests the server against the configured database and
+// returns the exit code. It runs a representative query, creates a seller,
+// listing and purchase in a transaction that is rolled back, and checks that a
+// delivery created in the same transaction reaches a deliveryUpdated
+// subscription through the event bus. External integrations such as search or
+// the tax service aren't exercised
+func runSelfTest(db *sql.DB, dataSource string) int {
+	ctx, cancel := context.WithTimeout(context.Background(), selfTestTimeout)
+	defer cancel()
+
+	schema, err := graphql.GetSchema(graphql.NewResolver(repository.NewRepository(db)))
+	if err != nil {
+		log.Printf("[SelfTest] FAIL schema: %v", err)
+		return 1
+	}
+
+	// Writes go through a pool of a single connection, so every statement runs
+	// in the transaction opened on it
+	txDB, err := sql.Open("postgres", dataSource)
+	if err != nil {
+		log.Printf("[SelfTest] FAIL connect: %v", err)
+		return 1
+	}
+	defer txDB.Close()
+	txDB.SetMaxOpenConns(1)
+	txDB.SetMaxIdleConns(1)
+
+	txSchema, err := graphql.GetSchema(graphql.NewResolver(repository.NewRepository(txDB)))
+	if err != nil {
+		log.Printf("[SelfTest] FAIL schema: %v", err)
+		return 1
+	}
+
+	test := &selfTest{db: db, schema: schema, txSchema: txSchema}
+	checks := []struct {
+		name string
+		run  func(context.Context) error
+	}{
+		{"query", test.query},
+		{"begin transaction", func(ctx context.Context) error {
+			_, err := txDB.ExecContext(ctx, "BEGIN")
+			return err
+		}},
+		{"mutation", test.mutation},
+		{"subscription", test.subscription},
+		{"rollback", func(ctx context.Context) error {
+			if _, err := txDB.ExecContext(ctx, "ROLLBACK"); err != nil {
+				return err
+			}
+			return test.rolledBack(ctx)
+		}},
+	}
+
+	for _, check := range checks {
+		start := time.Now()
+		if err := check.run(ctx); err != nil {
+			log.Printf("[SelfTest] FAIL %s after %s: %v", check.name, time.Since(start), err)
+			// Don't leave the transaction open if a later check failed
+			txDB.Exec("ROLLBACK")
+			return 1
+		}
+		log.Printf("[SelfTest] PASS %s in %s", check.name, time.Since(start))
+	}
+	log.Printf("[SelfTest] All checks passed")
+	return 0
+}
+
+// selfTest holds the schemas the checks run against and the IDs of the rows
+// created in the transaction
+type selfTest struct {
+	db     *sql.DB
+	schema *graphqlgo.Schema
+	// txSchema runs operations in the transaction that is rolled back
+	txSchema *graphqlgo.Schema
+
+	sellerID   string
+	purchaseID string
+}
+
+// query runs a query touching sellers, listings, purchases and paging
+func (t *selfTest) query(ctx context.Context) error {
+	var data struct {
+		Sellers []struct {
+			ID string `json:"id"`
+		} `json:"sellers"`
+	}
+	return execSelfTest(ctx, t.schema, `
+		{
+		  sellers { id name listings(limit: 1) { id title price } }
+		  listingsConnection(first: 1) { edges { node { id } } pageInfo { hasNextPage } }
+		  purchases(limit: 1) { id status latestDelivery { status } }
+		}`, nil, &data)
+}
+
+// mutation creates a seller, a listing and a purchase in the transaction
+func (t *selfTest) mutation(ctx context.Context) error {
+	var seller struct {
+		CreateSeller struct {
+			Seller     *struct{ ID string }
+			UserErrors []struct{ Message string }
+		}
+	}
+	err := execSelfTest(ctx, t.txSchema, `
+		mutation { createSeller(input: {name: "Self-test seller", address: "Self-test address"}) {
+		  seller { id } userErrors { message } } }`, nil, &seller)
+	if err := payloadError(err, seller.CreateSeller.Seller == nil, seller.CreateSeller.UserErrors); err != nil {
+		return fmt.Errorf("createSeller: %w", err)
+	}
+	t.sellerID = seller.CreateSeller.Seller.ID
+
+	var listing struct {
+		CreateListing struct {
+			Listing    *struct{ ID string }
+			UserErrors []struct{ Message string }
+		}
+	}
+	err = execSelfTest(ctx, t.txSchema, `
+		mutation($sellerId: ID!) { createListing(input: {sellerId: $sellerId, title: "Self-test listing", description: "Rolled back", price: 1}) {
+		  listing { id } userErrors { message } } }`, map[string]interface{}{"sellerId": t.sellerID}, &listing)
+	if err := payloadError(err, listing.CreateListing.Listing == nil, listing.CreateListing.UserErrors); err != nil {
+		return fmt.Errorf("createListing: %w", err)
+	}
+
+	var purchase struct {
+		CreatePurchase struct {
+			Purchase   *struct{ ID string }
+			UserErrors []struct{ Message string }
+		}
+	}
+	err = execSelfTest(ctx, t.txSchema, `
+		mutation($listingId: ID!) { createPurchase(input: {listingId: $listingId, price: 1, bankTxId: "SELFTEST", deliveryAddress: "Self-test address"}) {
+		  purchase { id } userErrors { message } } }`, map[string]interface{}{"listingId": listing.CreateListing.Listing.ID}, &purchase)
+	if err := payloadError(err, purchase.CreatePurchase.Purchase == nil, purchase.CreatePurchase.UserErrors); err != nil {
+		return fmt.Errorf("createPurchase: %w", err)
+	}
+	t.purchaseID = purchase.CreatePurchase.Purchase.ID
+	return nil
+}
+
+// subscription subscribes to the deliveries of the purchase, creates one and
+// waits for it to arrive through the event bus
+func (t *selfTest) subscription(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	updates, err := t.txSchema.Subscribe(ctx, `subscription($purchaseId: ID!) { deliveryUpdated(purchaseId: $purchaseId) { id status } }`,
+		"", map[string]interface{}{"purchaseId": t.purchaseID})
+	if err != nil {
+		return err
+	}
+
+	var delivery struct {
+		CreateDelivery struct {
+			Delivery   *struct{ ID string }
+			UserErrors []struct{ Message string }
+		}
+	}
+	err = execSelfTest(ctx, t.txSchema, `
+		mutation($purchaseId: ID!) { createDelivery(input: {purchaseId: $purchaseId, status: PACKED}) {
+		  delivery { id } userErrors { message } } }`, map[string]interface{}{"purchaseId": t.purchaseID}, &delivery)
+	if err := payloadError(err, delivery.CreateDelivery.Delivery == nil, delivery.CreateDelivery.UserErrors); err != nil {
+		return fmt.Errorf("createDelivery: %w", err)
+	}
+
+	select {
+	case update, ok := <-updates:
+		if !ok {
+			return errors.New("subscription closed before the delivery arrived")
+		}
+		response := update.(*graphqlgo.Response)
+		if len(response.Errors) > 0 {
+			return response.Errors[0]
+		}
+		var data struct {
+			DeliveryUpdated struct{ ID string }
+		}
+		if err := json.Unmarshal(response.Data, &data); err != nil {
+			return err
+		}
+		if data.DeliveryUpdated.ID != delivery.CreateDelivery.Delivery.ID {
+			return fmt.Errorf("expected delivery %s, got %s", delivery.CreateDelivery.Delivery.ID, data.DeliveryUpdated.ID)
+		}
+		return nil
+	case <-ctx.Done():
+		return errors.New("timed out waiting for the delivery")
+	}
+}
+
+// rolledBack checks that the seller created in the transaction is gone
+func (t *selfTest) rolledBack(ctx context.Context) error {
+	sellerID, err := id.ParseSellerID(t.sellerID)
+	if err != nil {
+		return err
+	}
+	var exists bool
+	if err := t.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM sellers WHERE id = $1)", sellerID).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("seller %d survived the rollback", sellerID)
+	}
+	return nil
+}
+
+// execSelfTest runs an operation and decodes its data into out, failing on
+// GraphQL errors
+func execSelfTest(ctx context.Context, schema *graphqlgo.Schema, query string, variables map[string]interface{}, out interface{}) error {
+	response := schema.Exec(ctx, query, "", variables)
+	if len(response.Errors) > 0 {
+		return response.Errors[0]
+	}
+	return json.Unmarshal(response.Data, out)
+}
+
+// payloadError returns the error of a mutation: the GraphQL error, the first
+// user error, or an error if the payload holds no object
+func payloadError(err error, missing bool, userErrors []struct{ Message string }) error {
+	switch {
+	case err != nil:
+		return err
+	case len(userErrors) > 0:
+		return errors.New(userErrors[0].Message)
+	case missing:
+		return errors.New("payload holds no object")
+	}
+	return nil
+}
@@ -16,6 +16,14 @@ CREATE TABLE IF NOT EXISTS listings (
     price NUMERIC(10, 2) NOT NULL
 );
 
+-- Creation time of listings, used to sort by newest
+ALTER TABLE listings ADD COLUMN IF NOT EXISTS created_at TIMESTAMP NOT NULL DEFAULT NOW();
+
+-- Weighted full-text document of listings: title matches rank above description matches
+ALTER TABLE listings ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('english', title), 'A') || setweight(to_tsvector('english', description), 'B')
+) STORED;
+
 -- Purchases table
 CREATE TABLE IF NOT EXISTS purchases (
     id SERIAL PRIMARY KEY,
@@ -26,6 +34,29 @@ CREATE TABLE IF NOT EXISTS purchases (
     created_at TIMESTAMP NOT NULL DEFAULT NOW()
 );
 
+-- Pickup points where buyers can collect purchases instead of home delivery
+CREATE TABLE IF NOT EXISTS pickup_points (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    address TEXT NOT NULL,
+    latitude DOUBLE PRECISION NOT NULL,
+    longitude DOUBLE PRECISION NOT NULL
+);
+
+ALTER TABLE purchases ADD COLUMN IF NOT EXISTS pickup_point_id INTEGER REFERENCES pickup_points(id);
+
+-- Tax charged on top of the purchase price, calculated at purchase time
+ALTER TABLE purchases ADD COLUMN IF NOT EXISTS tax_amount NUMERIC(10, 2) NOT NULL DEFAULT 0;
+
+-- Review status set by the fraud screening worker; new purchases start in pending_review
+ALTER TABLE purchases ADD COLUMN IF NOT EXISTS status VARCHAR(50) NOT NULL DEFAULT 'approved' 
+    CHECK (status IN ('pending_review', 'approved', 'rejected', 'canceled'));
+
+-- Purchases canceled by cancelPurchase
+ALTER TABLE purchases DROP CONSTRAINT IF EXISTS purchases_status_check;
+ALTER TABLE purchases ADD CONSTRAINT purchases_status_check 
+    CHECK (status IN ('pending_review', 'approved', 'rejected', 'canceled'));
+
 -- Deliveries table
 CREATE TABLE IF NOT EXISTS deliveries (
     id SERIAL PRIMARY KEY,
@@ -34,8 +65,140 @@ CREATE TABLE IF NOT EXISTS deliveries (
     status VARCHAR(50) NOT NULL CHECK (status IN ('packed', 'out_for_delivery', 'delivered', 'rescheduled', 'canceled'))
 );
 
+-- Delivery attempts: rescheduled updates start a new attempt for a future time
+ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS scheduled_for TIMESTAMP;
+ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS attempt_number INTEGER NOT NULL DEFAULT 1;
+
+-- Order status maintained as purchases are paid and delivered. Purchases
+-- inserted without one predate payment tracking and count as paid; existing
+-- purchases are backfilled from their review status and latest delivery once,
+-- when the column is added
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns 
+                   WHERE table_name = 'purchases' AND column_name = 'order_status') THEN
+        ALTER TABLE purchases ADD COLUMN order_status VARCHAR(50) NOT NULL DEFAULT 'paid' 
+            CHECK (order_status IN ('pending', 'paid', 'shipped', 'completed', 'canceled', 'refunded'));
+        UPDATE purchases p SET order_status = CASE 
+            WHEN p.status IN ('rejected', 'canceled') THEN 'canceled' 
+            WHEN p.status = 'pending_review' THEN 'pending' 
+            ELSE COALESCE(( 
+                SELECT CASE d.status WHEN 'delivered' THEN 'completed' WHEN 'out_for_delivery' THEN 'shipped' END 
+                FROM deliveries d WHERE d.purchase_id = p.id ORDER BY d.id DESC LIMIT 1 
+            ), 'paid') END;
+    END IF;
+END $$;
+
+-- Listing view counters, incremented in batches by the server
+CREATE TABLE IF NOT EXISTS listing_views (
+    listing_id INTEGER PRIMARY KEY REFERENCES listings(id),
+    views BIGINT NOT NULL DEFAULT 0
+);
+
+-- Listing price changes recorded by updateListing
+CREATE TABLE IF NOT EXISTS listing_price_history (
+    id SERIAL PRIMARY KEY,
+    listing_id INTEGER NOT NULL REFERENCES listings(id),
+    price NUMERIC(10, 2) NOT NULL,
+    changed_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Last row IDs copied by the analytics exporter, so exports are incremental
+CREATE TABLE IF NOT EXISTS export_bookmarks (
+    name VARCHAR(255) PRIMARY KEY,
+    last_id INTEGER NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Listings rejected or flagged by content moderation; rejected content has no listing
+CREATE TABLE IF NOT EXISTS moderation_decisions (
+    id SERIAL PRIMARY KEY,
+    content_type VARCHAR(50) NOT NULL,
+    content_id INTEGER,
+    content TEXT NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    reason TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Sellers receiving the daily sales digest through their webhook
+ALTER TABLE sellers ADD COLUMN IF NOT EXISTS digest_opt_in BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Seller callback URLs notified of new purchases, signed with a per-seller secret
+CREATE TABLE IF NOT EXISTS seller_webhooks (
+    seller_id INTEGER PRIMARY KEY REFERENCES sellers(id),
+    url TEXT NOT NULL,
+    secret VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Listing limits admins set for single sellers, overriding SELLER_MAX_LISTINGS
+CREATE TABLE IF NOT EXISTS seller_quotas (
+    seller_id INTEGER PRIMARY KEY REFERENCES sellers(id),
+    max_listings INTEGER NOT NULL CHECK (max_listings >= 0),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Why and when purchases were canceled through cancelPurchase
+CREATE TABLE IF NOT EXISTS purchase_cancellations (
+    purchase_id INTEGER PRIMARY KEY REFERENCES purchases(id),
+    reason TEXT NOT NULL DEFAULT '',
+    canceled_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Operations admins ran while impersonating another role, for support audits
+CREATE TABLE IF NOT EXISTS impersonation_audit (
+    id SERIAL PRIMARY KEY,
+    role VARCHAR(50) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    client_name VARCHAR(255) NOT NULL,
+    remote_addr VARCHAR(255) NOT NULL,
+    operation_name VARCHAR(255) NOT NULL DEFAULT '',
+    query TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Review and order status of purchases over time, for as-of queries. A trigger
+-- records every change; purchases that existed before the history start with
+-- their status at the time it was added
+CREATE TABLE IF NOT EXISTS purchase_status_history (
+    id SERIAL PRIMARY KEY,
+    purchase_id INTEGER NOT NULL REFERENCES purchases(id),
+    status VARCHAR(50) NOT NULL,
+    order_status VARCHAR(50) NOT NULL,
+    changed_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE OR REPLACE FUNCTION record_purchase_status() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND OLD.status = NEW.status AND OLD.order_status = NEW.order_status THEN
+        RETURN NEW;
+    END IF;
+    INSERT INTO purchase_status_history (purchase_id, status, order_status, changed_at)
+    VALUES (NEW.id, NEW.status, NEW.order_status, CASE WHEN TG_OP = 'INSERT' THEN NEW.created_at ELSE NOW() END);
+    RETURN NEW;
+END $$ LANGUAGE plpgsql;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'purchases_status_history') THEN
+        INSERT INTO purchase_status_history (purchase_id, status, order_status, changed_at)
+            SELECT id, status, order_status, created_at FROM purchases;
+        CREATE TRIGGER purchases_status_history AFTER INSERT OR UPDATE OF status, order_status ON purchases
+            FOR EACH ROW EXECUTE FUNCTION record_purchase_status();
+    END IF;
+END $$;
+
 -- Indexes
 CREATE INDEX IF NOT EXISTS idx_listings_seller_id ON listings(seller_id);
 CREATE INDEX IF NOT EXISTS idx_purchases_listing_id ON purchases(listing_id);
 CREATE INDEX IF NOT EXISTS idx_deliveries_purchase_id ON deliveries(purchase_id);
-CREATE INDEX IF NOT EXISTS idx_deliveries_status ON deliveries(status);
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS idx_deliveries_status ON deliveries(status);
+CREATE INDEX IF NOT EXISTS idx_listing_price_history_listing_id ON listing_price_history(listing_id, changed_at);
+CREATE INDEX IF NOT EXISTS idx_deliveries_scheduled_for ON deliveries(scheduled_for);
+CREATE INDEX IF NOT EXISTS idx_purchases_status ON purchases(status);
+CREATE INDEX IF NOT EXISTS idx_listings_price ON listings(price);
+CREATE INDEX IF NOT EXISTS idx_listings_created_at ON listings(created_at);
+CREATE INDEX IF NOT EXISTS idx_listings_search_vector ON listings USING GIN(search_vector);
+CREATE INDEX IF NOT EXISTS idx_moderation_decisions_content ON moderation_decisions(content_type, content_id);
+CREATE INDEX IF NOT EXISTS idx_purchase_status_history_purchase_id ON purchase_status_history(purchase_id, changed_at);
@@ -18,12 +18,18 @@ INSERT INTO listings (seller_id, title, description, price) VALUES
   (4, 'Wireless Earbuds', 'True wireless earbuds with great sound', 129.99),
   (4, 'Smart Watch', 'Fitness tracking smart watch', 249.99);
 
+-- Insert sample pickup points
+INSERT INTO pickup_points (name, address, latitude, longitude) VALUES
+  ('Back Bay Locker', '500 Boylston St, Boston, MA 02116', 42.3505, -71.0760),
+  ('Downtown Austin Hub', '200 Congress Ave, Austin, TX 78701', 30.2651, -97.7437),
+  ('Pearl District Pickup', '1000 NW Lovejoy St, Portland, OR 97209', 45.5302, -122.6812);
+
 -- Insert sample purchases
-INSERT INTO purchases (listing_id, price, bank_tx_id, delivery_address) VALUES
-  (1, 799.99, 'TX123456789', '42 Park Avenue, Boston, MA 02215'),
-  (3, 49.99, 'TX223456789', '77 Oak Street, Austin, TX 78701'),
-  (5, 89.99, 'TX323456789', '15 Pine Road, Portland, OR 97205'),
-  (7, 129.99, 'TX423456789', '33 Lake Drive, Miami, FL 33101');
+INSERT INTO purchases (listing_id, price, bank_tx_id, delivery_address, order_status) VALUES
+  (1, 799.99, 'TX123456789', '42 Park Avenue, Boston, MA 02215', 'completed'),
+  (3, 49.99, 'TX223456789', '77 Oak Street, Austin, TX 78701', 'completed'),
+  (5, 89.99, 'TX323456789', '15 Pine Road, Portland, OR 97205', 'shipped'),
+  (7, 129.99, 'TX423456789', '33 Lake Drive, Miami, FL 33101', 'paid');
 
 -- Insert sample deliveries
 INSERT INTO deliveries (purchase_id, timestamp, status) VALUES
@@ -0,0 +1,109 @@
+package cursor
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrInvalidCursor is returned for malformed or tampered cursors
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// ErrStaleCursor is returned when a cursor was issued for a different sort order
+	ErrStaleCursor = errors.New("cursor was issued for a different sort order")
+)
+
+// payload is the signed content of a cursor
+type payload struct {
+	Sort   string            `json:"s"`
+	Values []json.RawMessage `json:"v"`
+}
+
+// Codec encodes keyset values into opaque HMAC-signed cursors
+type Codec struct {
+	key []byte
+}
+
+// NewCodec creates a codec signing cursors with the given secret key
+func NewCodec(key []byte) *Codec {
+	return &Codec{key: key}
+}
+
+// NewRandomCodec creates a codec with a random key, so cursors do not survive a restart
+func NewRandomCodec() (*Codec, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate cursor key: %w", err)
+	}
+	return NewCodec(key), nil
+}
+
+// Encode builds a cursor from the sort order it belongs to and the keyset values of a row
+func (c *Codec) Encode(sort string, values ...interface{}) (string, error) {
+	p := payload{Sort: sort}
+	for _, v := range values {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal cursor value: %w", err)
+		}
+		p.Values = append(p.Values, raw)
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal cursor: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data) + "." +
+		base64.RawURLEncoding.EncodeToString(c.sign(data)), nil
+}
+
+// Decode verifies the cursor signature and sort order and unmarshals the keyset values into the given pointers
+func (c *Codec) Decode(cursor, sort string, values ...interface{}) error {
+	encodedData, encodedSig, ok := strings.Cut(cursor, ".")
+	if !ok {
+		return ErrInvalidCursor
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(encodedData)
+	if err != nil {
+		return ErrInvalidCursor
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil {
+		return ErrInvalidCursor
+	}
+	if !hmac.Equal(sig, c.sign(data)) {
+		return ErrInvalidCursor
+	}
+
+	var p payload
+	if err := json.Unmarshal(data, &p); err != nil {
+		return ErrInvalidCursor
+	}
+	if p.Sort != sort {
+		return ErrStaleCursor
+	}
+	if len(p.Values) != len(values) {
+		return ErrInvalidCursor
+	}
+
+	for i, raw := range p.Values {
+		if err := json.Unmarshal(raw, values[i]); err != nil {
+			return ErrInvalidCursor
+		}
+	}
+
+	return nil
+}
+
+func (c *Codec) sign(data []byte) []byte {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,52 @@
+package cursor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	codec := NewCodec([]byte("secret"))
+
+	cursor, err := codec.Encode("PRICE_ASC", 12.5, 42)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var price float64
+	var id int
+	if err := codec.Decode(cursor, "PRICE_ASC", &price, &id); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if price != 12.5 || id != 42 {
+		t.Errorf("Expected (12.5, 42), got (%v, %v)", price, id)
+	}
+}
+
+func TestDecodeRejectsTamperedCursor(t *testing.T) {
+	codec := NewCodec([]byte("secret"))
+	cursor, _ := codec.Encode("ID_ASC", 42)
+
+	// Forge a cursor with different values but the original signature
+	forged, _ := NewCodec([]byte("other")).Encode("ID_ASC", 1)
+	_, sig, _ := strings.Cut(cursor, ".")
+	data, _, _ := strings.Cut(forged, ".")
+
+	var id int
+	for _, c := range []string{data + "." + sig, forged, "garbage", ""} {
+		if err := codec.Decode(c, "ID_ASC", &id); err != ErrInvalidCursor {
+			t.Errorf("Expected ErrInvalidCursor for %q, got %v", c, err)
+		}
+	}
+}
+
+func TestDecodeRejectsStaleSortOrder(t *testing.T) {
+	codec := NewCodec([]byte("secret"))
+	cursor, _ := codec.Encode("PRICE_ASC", 12.5, 42)
+
+	var price float64
+	var id int
+	if err := codec.Decode(cursor, "PRICE_DESC", &price, &id); err != ErrStaleCursor {
+		t.Errorf("Expected ErrStaleCursor, got %v", err)
+	}
+}
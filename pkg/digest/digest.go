@@ -0,0 +1,88 @@
+package digest
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+)
+
+// Store aggregates the digests of opted-in sellers
+type Store interface {
+	GetSellerDigests(from, to time.Time) ([]*models.SellerDigest, error)
+}
+
+// Sender delivers a digest to its seller
+type Sender interface {
+	SendDigest(ctx context.Context, digest *models.SellerDigest) error
+}
+
+// Job sends every opted-in seller a digest of the previous UTC day once a day
+type Job struct {
+	store  Store
+	sender Sender
+	hour   int
+	// lastDay is the start of the last day digests were sent for
+	lastDay time.Time
+	now     func() time.Time
+}
+
+// NewJob creates a job sending digests from the given UTC hour onwards
+func NewJob(store Store, sender Sender, hour int) *Job {
+	return &Job{store: store, sender: sender, hour: hour, now: time.Now}
+}
+
+// Send builds and sends the digests of the UTC day starting at day. Failing
+// sellers are logged and skipped so one broken webhook doesn't block the others
+func (j *Job) Send(ctx context.Context, day time.Time) error {
+	digests, err := j.store.GetSellerDigests(day, day.AddDate(0, 0, 1))
+	if err != nil {
+		return err
+	}
+
+	for _, digest := range digests {
+		if err := j.sender.SendDigest(ctx, digest); err != nil {
+			log.Printf("[Digest] %v", err)
+		}
+	}
+
+	log.Printf("[Digest] Sent %d seller digests for %s", len(digests), day.Format("2006-01-02"))
+	return nil
+}
+
+// due returns the day whose digests should be sent now, if any
+func (j *Job) due() (time.Time, bool) {
+	now := j.now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	yesterday := today.AddDate(0, 0, -1)
+	if now.Hour() < j.hour || !yesterday.After(j.lastDay) {
+		return time.Time{}, false
+	}
+	return yesterday, true
+}
+
+// Run checks every interval whether the previous day's digests are due and sends
+// them, until stop is closed. Digests are sent again after a restart; their
+// event IDs let sellers deduplicate them
+func (j *Job) Run(interval time.Duration, stop <-chan struct{}) {
+	ctx := context.Background()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if day, ok := j.due(); ok {
+			if err := j.Send(ctx, day); err != nil {
+				log.Printf("[Digest] Error sending digests for %s: %v", day.Format("2006-01-02"), err)
+			} else {
+				j.lastDay = day
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package digest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+)
+
+type fakeStore struct {
+	from, to time.Time
+}
+
+func (s *fakeStore) GetSellerDigests(from, to time.Time) ([]*models.SellerDigest, error) {
+	s.from, s.to = from, to
+	return []*models.SellerDigest{{SellerID: 1}, {SellerID: 2}}, nil
+}
+
+type fakeSender struct {
+	sent []int
+}
+
+func (s *fakeSender) SendDigest(ctx context.Context, digest *models.SellerDigest) error {
+	if digest.SellerID == 1 {
+		return errors.New("webhook unavailable")
+	}
+	s.sent = append(s.sent, digest.SellerID)
+	return nil
+}
+
+func TestSend(t *testing.T) {
+	store := &fakeStore{}
+	sender := &fakeSender{}
+	job := NewJob(store, sender, 6)
+
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	if err := job.Send(context.Background(), day); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !store.from.Equal(day) || !store.to.Equal(day.AddDate(0, 0, 1)) {
+		t.Errorf("Unexpected range %s - %s", store.from, store.to)
+	}
+	if len(sender.sent) != 1 || sender.sent[0] != 2 {
+		t.Errorf("Expected the digest of seller 2 to be sent despite seller 1 failing, got %v", sender.sent)
+	}
+}
+
+func TestDue(t *testing.T) {
+	job := NewJob(&fakeStore{}, &fakeSender{}, 6)
+
+	job.now = func() time.Time { return time.Date(2024, 5, 2, 5, 0, 0, 0, time.UTC) }
+	if _, ok := job.due(); ok {
+		t.Errorf("Expected digests not to be due before the configured hour")
+	}
+
+	job.now = func() time.Time { return time.Date(2024, 5, 2, 7, 0, 0, 0, time.UTC) }
+	day, ok := job.due()
+	if !ok || !day.Equal(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Expected digests of 2024-05-01 to be due, got %s %v", day, ok)
+	}
+
+	job.lastDay = day
+	if _, ok := job.due(); ok {
+		t.Errorf("Expected digests not to be sent twice for the same day")
+	}
+}
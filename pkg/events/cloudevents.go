@@ -0,0 +1,52 @@
+package events
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// CloudEvents attributes of outbound events
+const (
+	CloudEventsSpecVersion = "1.0"
+	// DefaultSource identifies this service when no source is configured
+	DefaultSource = "/graphqlTinyExample"
+	// DeliveryUpdatedType is the type of delivery status update events
+	DeliveryUpdatedType = "io.github.korjavin.graphqltinyexample.delivery.updated"
+	// PurchaseCreatedType is the type of new purchase events sent to sellers
+	PurchaseCreatedType = "io.github.korjavin.graphqltinyexample.purchase.created"
+	// PurchaseCanceledType is the type of purchase cancellation events sent to sellers
+	PurchaseCanceledType = "io.github.korjavin.graphqltinyexample.purchase.canceled"
+	// SellerDigestType is the type of daily seller digest events
+	SellerDigestType = "io.github.korjavin.graphqltinyexample.seller.digest"
+)
+
+// CloudEvent is an event in the CloudEvents 1.0 JSON format, so consumers can
+// route events by type and source without knowing their payload
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	Type            string          `json:"type"`
+	Source          string          `json:"source"`
+	ID              string          `json:"id"`
+	Time            time.Time       `json:"time"`
+	Subject         string          `json:"subject,omitempty"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// NewCloudEvent wraps a JSON payload in a CloudEvent. The ID must be unique per
+// source, and stable so consumers can deduplicate redelivered events
+func NewCloudEvent(eventType, source, id, subject string, t time.Time, data json.RawMessage) CloudEvent {
+	if source == "" {
+		source = DefaultSource
+	}
+	return CloudEvent{
+		SpecVersion:     CloudEventsSpecVersion,
+		Type:            eventType,
+		Source:          source,
+		ID:              id,
+		Time:            t.UTC(),
+		Subject:         subject,
+		DataContentType: "application/json",
+		Data:            data,
+	}
+}
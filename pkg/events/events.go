@@ -1,9 +1,12 @@
 package events
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/korjavin/graphqlTinyExample/pkg/models"
 )
@@ -11,28 +14,69 @@ import (
 // DeliveryEvent represents a delivery status update event
 type DeliveryEvent struct {
 	Delivery *models.Delivery
+	// Published is when the event was published, to measure subscriber lag
+	Published time.Time
+}
+
+// ErrTooManySubscribers is returned when a purchase already has the maximum number of subscribers
+var ErrTooManySubscribers = errors.New("too many subscribers")
+
+// PurchaseEvent represents the outcome of a purchase review
+type PurchaseEvent struct {
+	Purchase *models.Purchase
+}
+
+// ListingEvent represents a newly created listing
+type ListingEvent struct {
+	Listing *models.Listing
 }
 
 // EventBus manages subscription events
 type EventBus struct {
-	mu          sync.RWMutex
-	subscribers map[string]map[chan DeliveryEvent]bool
-	nextID      int
+	mu                  sync.RWMutex
+	subscribers         map[string]map[chan DeliveryEvent]bool
+	purchaseSubscribers map[string]map[chan PurchaseEvent]bool
+	listingSubscribers  map[string]map[chan ListingEvent]bool
+	maxPerPurchase      int
+	nextID              int
 }
 
 // NewEventBus creates a new event bus
 func NewEventBus() *EventBus {
 	return &EventBus{
-		subscribers: make(map[string]map[chan DeliveryEvent]bool),
+		subscribers:         make(map[string]map[chan DeliveryEvent]bool),
+		purchaseSubscribers: make(map[string]map[chan PurchaseEvent]bool),
+		listingSubscribers:  make(map[string]map[chan ListingEvent]bool),
+	}
+}
+
+// SetMaxSubscribersPerPurchase limits the concurrent subscribers watching a single
+// purchase ID, per event type. Zero means unlimited; subscribers to all purchases are not limited
+func (b *EventBus) SetMaxSubscribersPerPurchase(max int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.maxPerPurchase = max
+}
+
+// checkLimit returns an error if a purchase ID already has the maximum number of subscribers
+func (b *EventBus) checkLimit(purchaseID string, current int) error {
+	if purchaseID == "" || b.maxPerPurchase <= 0 || current < b.maxPerPurchase {
+		return nil
 	}
+	log.Printf("[EventBus] Rejecting subscriber for purchaseID=%s, limit of %d reached", purchaseID, b.maxPerPurchase)
+	return fmt.Errorf("%w for purchase %s (limit %d)", ErrTooManySubscribers, purchaseID, b.maxPerPurchase)
 }
 
 // Subscribe registers a channel to receive delivery events for a specific purchase ID
 // If purchaseID is empty, subscribe to all delivery events
-func (b *EventBus) SubscribeToDeliveries(purchaseID string) chan DeliveryEvent {
+func (b *EventBus) SubscribeToDeliveries(purchaseID string) (chan DeliveryEvent, error) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
+	if err := b.checkLimit(purchaseID, len(b.subscribers[purchaseID])); err != nil {
+		return nil, err
+	}
+
 	ch := make(chan DeliveryEvent, 1) // Buffered channel to prevent blocking
 
 	// Initialize map for this purchaseID if it doesn't exist
@@ -45,7 +89,7 @@ func (b *EventBus) SubscribeToDeliveries(purchaseID string) chan DeliveryEvent {
 	log.Printf("[EventBus] New subscriber for purchaseID=%s, total subscribers: %d",
 		purchaseID, len(b.subscribers[purchaseID]))
 
-	return ch
+	return ch, nil
 }
 
 // Unsubscribe removes a channel from receiving events
@@ -69,7 +113,7 @@ func (b *EventBus) PublishDelivery(delivery *models.Delivery) {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 
-	event := DeliveryEvent{Delivery: delivery}
+	event := DeliveryEvent{Delivery: delivery, Published: time.Now()}
 
 	// Send to subscribers for the specific purchase ID
 	purchaseID := strconv.Itoa(delivery.PurchaseID)
@@ -97,3 +141,117 @@ func (b *EventBus) PublishDelivery(delivery *models.Delivery) {
 		}
 	}
 }
+
+// SubscribeToPurchaseReviews registers a channel to receive review events for a specific purchase ID
+// If purchaseID is empty, subscribe to all purchase review events
+func (b *EventBus) SubscribeToPurchaseReviews(purchaseID string) (chan PurchaseEvent, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.checkLimit(purchaseID, len(b.purchaseSubscribers[purchaseID])); err != nil {
+		return nil, err
+	}
+
+	ch := make(chan PurchaseEvent, 1)
+
+	if _, ok := b.purchaseSubscribers[purchaseID]; !ok {
+		b.purchaseSubscribers[purchaseID] = make(map[chan PurchaseEvent]bool)
+	}
+
+	b.purchaseSubscribers[purchaseID][ch] = true
+	log.Printf("[EventBus] New purchase review subscriber for purchaseID=%s, total subscribers: %d",
+		purchaseID, len(b.purchaseSubscribers[purchaseID]))
+
+	return ch, nil
+}
+
+// UnsubscribePurchaseReviews removes a channel from receiving purchase review events
+func (b *EventBus) UnsubscribePurchaseReviews(purchaseID string, ch chan PurchaseEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.purchaseSubscribers[purchaseID]; ok {
+		delete(b.purchaseSubscribers[purchaseID], ch)
+		log.Printf("[EventBus] Unsubscribed from purchase reviews for purchaseID=%s, remaining subscribers: %d",
+			purchaseID, len(b.purchaseSubscribers[purchaseID]))
+
+		if len(b.purchaseSubscribers[purchaseID]) == 0 {
+			delete(b.purchaseSubscribers, purchaseID)
+		}
+	}
+}
+
+// PublishPurchaseReviewed publishes a purchase review event to all relevant subscribers
+func (b *EventBus) PublishPurchaseReviewed(purchase *models.Purchase) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	event := PurchaseEvent{Purchase: purchase}
+
+	for _, key := range []string{strconv.Itoa(purchase.ID), ""} {
+		for ch := range b.purchaseSubscribers[key] {
+			// Use non-blocking send to prevent deadlocks
+			select {
+			case ch <- event:
+				log.Printf("[EventBus] Delivered purchase review event for purchaseID=%d", purchase.ID)
+			default:
+				log.Printf("[EventBus] Purchase review subscriber channel is full, skipping")
+			}
+		}
+	}
+}
+
+// SubscribeToListings registers a channel to receive events for listings created by a specific seller ID
+// If sellerID is empty, subscribe to all new listings
+func (b *EventBus) SubscribeToListings(sellerID string) chan ListingEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan ListingEvent, 1)
+
+	if _, ok := b.listingSubscribers[sellerID]; !ok {
+		b.listingSubscribers[sellerID] = make(map[chan ListingEvent]bool)
+	}
+
+	b.listingSubscribers[sellerID][ch] = true
+	log.Printf("[EventBus] New listing subscriber for sellerID=%s, total subscribers: %d",
+		sellerID, len(b.listingSubscribers[sellerID]))
+
+	return ch
+}
+
+// UnsubscribeListings removes a channel from receiving listing events
+func (b *EventBus) UnsubscribeListings(sellerID string, ch chan ListingEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.listingSubscribers[sellerID]; ok {
+		delete(b.listingSubscribers[sellerID], ch)
+		log.Printf("[EventBus] Unsubscribed from listings for sellerID=%s, remaining subscribers: %d",
+			sellerID, len(b.listingSubscribers[sellerID]))
+
+		if len(b.listingSubscribers[sellerID]) == 0 {
+			delete(b.listingSubscribers, sellerID)
+		}
+	}
+}
+
+// PublishListingCreated publishes a new listing to the subscribers of its seller and of all listings
+func (b *EventBus) PublishListingCreated(listing *models.Listing) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	event := ListingEvent{Listing: listing}
+
+	for _, key := range []string{strconv.Itoa(listing.SellerID), ""} {
+		for ch := range b.listingSubscribers[key] {
+			// Use non-blocking send to prevent deadlocks
+			select {
+			case ch <- event:
+				log.Printf("[EventBus] Delivered new listing event for listingID=%d", listing.ID)
+			default:
+				log.Printf("[EventBus] Listing subscriber channel is full, skipping")
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+)
+
+func TestSubscriberLimitPerPurchase(t *testing.T) {
+	bus := NewEventBus()
+	bus.SetMaxSubscribersPerPurchase(2)
+
+	first, err := bus.SubscribeToDeliveries("1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := bus.SubscribeToDeliveries("1"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, err = bus.SubscribeToDeliveries("1")
+	if !errors.Is(err, ErrTooManySubscribers) {
+		t.Errorf("Expected ErrTooManySubscribers, got %v", err)
+	}
+
+	// Other purchases and global subscribers are not affected
+	if _, err := bus.SubscribeToDeliveries("2"); err != nil {
+		t.Errorf("Unexpected error for another purchase: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := bus.SubscribeToDeliveries(""); err != nil {
+			t.Errorf("Unexpected error for global subscriber: %v", err)
+		}
+	}
+
+	// Unsubscribing frees a slot
+	bus.Unsubscribe("1", first)
+	if _, err := bus.SubscribeToDeliveries("1"); err != nil {
+		t.Errorf("Expected a free slot after unsubscribe, got %v", err)
+	}
+}
+
+func TestSubscriberLimitDisabled(t *testing.T) {
+	bus := NewEventBus()
+
+	for i := 0; i < 10; i++ {
+		if _, err := bus.SubscribeToPurchaseReviews("1"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+}
+
+func TestNewCloudEvent(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	event := NewCloudEvent(DeliveryUpdatedType, "", "delivery-7", "purchases/3", at, json.RawMessage(`{"id":"7"}`))
+
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := `{"specversion":"1.0","type":"io.github.korjavin.graphqltinyexample.delivery.updated","source":"/graphqlTinyExample",` +
+		`"id":"delivery-7","time":"2024-05-01T10:00:00Z","subject":"purchases/3","datacontenttype":"application/json","data":{"id":"7"}}`
+	if string(encoded) != expected {
+		t.Errorf("Expected %s, got %s", expected, encoded)
+	}
+}
+
+func TestPublishListingCreated(t *testing.T) {
+	bus := NewEventBus()
+	seller := bus.SubscribeToListings("1")
+	other := bus.SubscribeToListings("2")
+	all := bus.SubscribeToListings("")
+
+	listing := &models.Listing{ID: 7, SellerID: 1}
+	bus.PublishListingCreated(listing)
+
+	for name, ch := range map[string]chan ListingEvent{"seller": seller, "global": all} {
+		select {
+		case event := <-ch:
+			if event.Listing != listing {
+				t.Errorf("Expected listing 7 for the %s subscriber, got %+v", name, event.Listing)
+			}
+		default:
+			t.Errorf("Expected the %s subscriber to receive the listing", name)
+		}
+	}
+	select {
+	case event := <-other:
+		t.Errorf("Expected no event for another seller, got %+v", event.Listing)
+	default:
+	}
+
+	bus.UnsubscribeListings("1", seller)
+	bus.PublishListingCreated(listing)
+	select {
+	case <-seller:
+		t.Errorf("Expected no event after unsubscribing")
+	default:
+	}
+}
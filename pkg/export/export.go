@@ -0,0 +1,172 @@
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+)
+
+// Exported tables, also used as bookmark names
+const (
+	PurchasesTable  = "purchases"
+	DeliveriesTable = "deliveries"
+)
+
+// Store reads rows created since the last export and tracks the export bookmarks
+type Store interface {
+	GetPurchasesSince(afterID, limit int) ([]*models.Purchase, error)
+	GetDeliveriesSince(purchaseID *int, afterID, limit int) ([]*models.Delivery, error)
+	GetExportBookmark(name string) (int, error)
+	SetExportBookmark(name string, lastID int) error
+}
+
+// Batch is a set of consecutive rows of one table, identified by their ID range
+type Batch struct {
+	Table   string
+	FirstID int
+	LastID  int
+	Rows    []interface{}
+}
+
+// Sink writes exported batches to a warehouse. Batches may be written again
+// after a failure, so sinks should overwrite a batch with the same ID range
+type Sink interface {
+	Write(ctx context.Context, batch Batch) error
+}
+
+// FileSink writes each batch as newline-delimited JSON to
+// <Dir>/<table>/<table>-<firstID>-<lastID>.ndjson, a format both S3-based
+// lakes and BigQuery load jobs ingest directly
+type FileSink struct {
+	Dir string
+}
+
+// Write stores the batch, replacing a previous file for the same ID range
+func (s FileSink) Write(ctx context.Context, batch Batch) error {
+	dir := filepath.Join(s.Dir, batch.Table)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create export directory: %w", err)
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%s-%d-%d.ndjson", batch.Table, batch.FirstID, batch.LastID))
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %w", err)
+	}
+
+	encoder := json.NewEncoder(file)
+	for _, row := range batch.Rows {
+		if err := encoder.Encode(row); err != nil {
+			file.Close()
+			os.Remove(tmp)
+			return fmt.Errorf("failed to encode %s row: %w", batch.Table, err)
+		}
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write export file: %w", err)
+	}
+
+	// Rename so readers never see a partially written batch
+	return os.Rename(tmp, path)
+}
+
+// Exporter copies new purchases and deliveries to a sink in batches. Each table
+// has a bookmark of the last exported ID, advanced only after the sink accepted
+// the batch, so exports are incremental and rows are delivered at least once
+type Exporter struct {
+	store     Store
+	sink      Sink
+	batchSize int
+}
+
+// NewExporter creates an exporter writing batches of up to batchSize rows
+func NewExporter(store Store, sink Sink, batchSize int) *Exporter {
+	return &Exporter{store: store, sink: sink, batchSize: batchSize}
+}
+
+// Export copies all rows created since the previous export
+func (e *Exporter) Export(ctx context.Context) error {
+	err := e.exportTable(ctx, PurchasesTable, func(afterID int) ([]interface{}, int, error) {
+		purchases, err := e.store.GetPurchasesSince(afterID, e.batchSize)
+		if err != nil || len(purchases) == 0 {
+			return nil, afterID, err
+		}
+		rows := make([]interface{}, len(purchases))
+		for i, purchase := range purchases {
+			rows[i] = purchase
+		}
+		return rows, purchases[len(purchases)-1].ID, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return e.exportTable(ctx, DeliveriesTable, func(afterID int) ([]interface{}, int, error) {
+		deliveries, err := e.store.GetDeliveriesSince(nil, afterID, e.batchSize)
+		if err != nil || len(deliveries) == 0 {
+			return nil, afterID, err
+		}
+		rows := make([]interface{}, len(deliveries))
+		for i, delivery := range deliveries {
+			rows[i] = delivery
+		}
+		return rows, deliveries[len(deliveries)-1].ID, nil
+	})
+}
+
+// exportTable writes batches returned by fetch until a batch comes back short
+func (e *Exporter) exportTable(ctx context.Context, table string, fetch func(afterID int) ([]interface{}, int, error)) error {
+	afterID, err := e.store.GetExportBookmark(table)
+	if err != nil {
+		return fmt.Errorf("failed to read %s bookmark: %w", table, err)
+	}
+
+	for {
+		rows, lastID, err := fetch(afterID)
+		if err != nil {
+			return fmt.Errorf("failed to fetch %s: %w", table, err)
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+
+		if err := e.sink.Write(ctx, Batch{Table: table, FirstID: afterID + 1, LastID: lastID, Rows: rows}); err != nil {
+			return fmt.Errorf("failed to write %s batch: %w", table, err)
+		}
+		if err := e.store.SetExportBookmark(table, lastID); err != nil {
+			return fmt.Errorf("failed to save %s bookmark: %w", table, err)
+		}
+		log.Printf("[Export] Exported %d %s up to ID %d", len(rows), table, lastID)
+
+		if len(rows) < e.batchSize {
+			return nil
+		}
+		afterID = lastID
+	}
+}
+
+// Run exports new rows immediately and then every interval until stop is closed
+func (e *Exporter) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := e.Export(context.Background()); err != nil {
+			log.Printf("[Export] Error exporting: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+)
+
+type fakeStore struct {
+	purchases  []*models.Purchase
+	deliveries []*models.Delivery
+	bookmarks  map[string]int
+}
+
+func (s *fakeStore) GetPurchasesSince(afterID, limit int) ([]*models.Purchase, error) {
+	var result []*models.Purchase
+	for _, p := range s.purchases {
+		if p.ID > afterID && len(result) < limit {
+			result = append(result, p)
+		}
+	}
+	return result, nil
+}
+
+func (s *fakeStore) GetDeliveriesSince(purchaseID *int, afterID, limit int) ([]*models.Delivery, error) {
+	var result []*models.Delivery
+	for _, d := range s.deliveries {
+		if d.ID > afterID && len(result) < limit {
+			result = append(result, d)
+		}
+	}
+	return result, nil
+}
+
+func (s *fakeStore) GetExportBookmark(name string) (int, error) {
+	return s.bookmarks[name], nil
+}
+
+func (s *fakeStore) SetExportBookmark(name string, lastID int) error {
+	s.bookmarks[name] = lastID
+	return nil
+}
+
+type recordingSink struct {
+	batches []Batch
+	err     error
+}
+
+func (s *recordingSink) Write(ctx context.Context, batch Batch) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.batches = append(s.batches, batch)
+	return nil
+}
+
+func TestExportIsIncremental(t *testing.T) {
+	store := &fakeStore{
+		purchases:  []*models.Purchase{{ID: 1}, {ID: 2}, {ID: 3}},
+		deliveries: []*models.Delivery{{ID: 5}},
+		bookmarks:  map[string]int{},
+	}
+	sink := &recordingSink{}
+	exporter := NewExporter(store, sink, 2)
+
+	if err := exporter.Export(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Purchases are split into batches of two
+	if len(sink.batches) != 3 {
+		t.Fatalf("Expected 3 batches, got %d", len(sink.batches))
+	}
+	if b := sink.batches[1]; b.Table != PurchasesTable || b.FirstID != 3 || b.LastID != 3 || len(b.Rows) != 1 {
+		t.Errorf("Unexpected second batch: %+v", b)
+	}
+	if store.bookmarks[PurchasesTable] != 3 || store.bookmarks[DeliveriesTable] != 5 {
+		t.Errorf("Unexpected bookmarks: %v", store.bookmarks)
+	}
+
+	// Only rows created since the last run are exported
+	store.purchases = append(store.purchases, &models.Purchase{ID: 4})
+	sink.batches = nil
+	if err := exporter.Export(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(sink.batches) != 1 || sink.batches[0].LastID != 4 {
+		t.Errorf("Expected a single batch with purchase 4, got %+v", sink.batches)
+	}
+}
+
+func TestExportKeepsBookmarkOnSinkFailure(t *testing.T) {
+	store := &fakeStore{
+		purchases: []*models.Purchase{{ID: 1}},
+		bookmarks: map[string]int{},
+	}
+	exporter := NewExporter(store, &recordingSink{err: errors.New("unavailable")}, 10)
+
+	if err := exporter.Export(context.Background()); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if store.bookmarks[PurchasesTable] != 0 {
+		t.Errorf("Expected the bookmark to stay at 0, got %d", store.bookmarks[PurchasesTable])
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir := t.TempDir()
+	sink := FileSink{Dir: dir}
+
+	batch := Batch{
+		Table:   DeliveriesTable,
+		FirstID: 1,
+		LastID:  2,
+		Rows:    []interface{}{&models.Delivery{ID: 1, Status: "packed"}, &models.Delivery{ID: 2, Status: "delivered"}},
+	}
+	if err := sink.Write(context.Background(), batch); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "deliveries", "deliveries-1-2.ndjson"))
+	if err != nil {
+		t.Fatalf("Failed to read export file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], `"status":"delivered"`) {
+		t.Errorf("Unexpected export file contents: %s", data)
+	}
+}
@@ -0,0 +1,153 @@
+package fraud
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+)
+
+// queueSize bounds the number of purchases waiting for review in memory;
+// overflow is picked up by the next sweep
+const queueSize = 100
+
+// Decision is the outcome of a fraud check
+type Decision struct {
+	Approved bool
+	Reason   string
+}
+
+// Checker screens a purchase for fraud
+type Checker interface {
+	Check(ctx context.Context, purchase *models.Purchase) (Decision, error)
+}
+
+// ApproveAll approves every purchase; it is the default when no screening is configured
+type ApproveAll struct{}
+
+// Check approves the purchase
+func (ApproveAll) Check(ctx context.Context, purchase *models.Purchase) (Decision, error) {
+	return Decision{Approved: true}, nil
+}
+
+// MaxAmount rejects purchases whose price exceeds a limit
+type MaxAmount struct {
+	Limit models.Money
+}
+
+// Check rejects the purchase if its price is above the limit
+func (m MaxAmount) Check(ctx context.Context, purchase *models.Purchase) (Decision, error) {
+	if purchase.Price > m.Limit {
+		return Decision{Reason: fmt.Sprintf("price %s exceeds limit %s", purchase.Price, m.Limit)}, nil
+	}
+	return Decision{Approved: true}, nil
+}
+
+// Store loads pending purchases and records review outcomes
+type Store interface {
+	GetPurchases(filter *models.PurchaseFilter) ([]*models.Purchase, error)
+	UpdatePurchaseStatus(id int, from, to string) (*models.Purchase, error)
+}
+
+// Reviewer screens purchases pending review in the background
+type Reviewer struct {
+	checker    Checker
+	store      Store
+	queue      chan *models.Purchase
+	onReviewed func(*models.Purchase)
+}
+
+// NewReviewer creates a reviewer; onReviewed is called with every purchase whose status changed
+func NewReviewer(checker Checker, store Store, onReviewed func(*models.Purchase)) *Reviewer {
+	return &Reviewer{
+		checker:    checker,
+		store:      store,
+		queue:      make(chan *models.Purchase, queueSize),
+		onReviewed: onReviewed,
+	}
+}
+
+// Submit queues a purchase for review without blocking the caller
+func (r *Reviewer) Submit(purchase *models.Purchase) {
+	select {
+	case r.queue <- purchase:
+	default:
+		log.Printf("[Fraud] Review queue is full, purchase ID %d will be picked up by the next sweep", purchase.ID)
+	}
+}
+
+// Review checks a single purchase and records the outcome. A failed check
+// leaves the purchase pending so it is retried by the next sweep
+func (r *Reviewer) Review(ctx context.Context, purchase *models.Purchase) error {
+	decision, err := r.checker.Check(ctx, purchase)
+	if err != nil {
+		return fmt.Errorf("fraud check failed for purchase %d: %w", purchase.ID, err)
+	}
+
+	status := models.PurchaseStatusApproved
+	if !decision.Approved {
+		status = models.PurchaseStatusRejected
+	}
+
+	reviewed, err := r.store.UpdatePurchaseStatus(purchase.ID, models.PurchaseStatusPendingReview, status)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Already reviewed, e.g. by a sweep racing the queue
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[Fraud] Purchase ID %d %s %s", purchase.ID, status, decision.Reason)
+	if r.onReviewed != nil {
+		r.onReviewed(reviewed)
+	}
+	return nil
+}
+
+// Sweep reviews every purchase still pending review
+func (r *Reviewer) Sweep(ctx context.Context) error {
+	status := models.PurchaseStatusPendingReview
+	pending, err := r.store.GetPurchases(&models.PurchaseFilter{Status: &status})
+	if err != nil {
+		return err
+	}
+
+	for _, purchase := range pending {
+		if err := r.Review(ctx, purchase); err != nil {
+			log.Printf("[Fraud] %v", err)
+		}
+	}
+	return nil
+}
+
+// Run reviews queued purchases as they arrive and sweeps for missed ones every
+// interval, starting with an initial sweep, until stop is closed
+func (r *Reviewer) Run(sweepInterval time.Duration, stop <-chan struct{}) {
+	ctx := context.Background()
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	if err := r.Sweep(ctx); err != nil {
+		log.Printf("[Fraud] Error sweeping pending purchases: %v", err)
+	}
+
+	for {
+		select {
+		case purchase := <-r.queue:
+			if err := r.Review(ctx, purchase); err != nil {
+				log.Printf("[Fraud] %v", err)
+			}
+		case <-ticker.C:
+			if err := r.Sweep(ctx); err != nil {
+				log.Printf("[Fraud] Error sweeping pending purchases: %v", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
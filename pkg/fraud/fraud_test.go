@@ -0,0 +1,94 @@
+package fraud
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+)
+
+type fakeStore struct {
+	purchases map[int]*models.Purchase
+}
+
+func (s *fakeStore) GetPurchases(filter *models.PurchaseFilter) ([]*models.Purchase, error) {
+	var result []*models.Purchase
+	for _, p := range s.purchases {
+		if filter.Status == nil || p.Status == *filter.Status {
+			result = append(result, p)
+		}
+	}
+	return result, nil
+}
+
+func (s *fakeStore) UpdatePurchaseStatus(id int, from, to string) (*models.Purchase, error) {
+	p, ok := s.purchases[id]
+	if !ok || p.Status != from {
+		return nil, sql.ErrNoRows
+	}
+	p.Status = to
+	return p, nil
+}
+
+type failingChecker struct{}
+
+func (failingChecker) Check(ctx context.Context, purchase *models.Purchase) (Decision, error) {
+	return Decision{}, errors.New("screening service unavailable")
+}
+
+func newStore() *fakeStore {
+	return &fakeStore{purchases: map[int]*models.Purchase{
+		1: {ID: 1, Price: 50, Status: models.PurchaseStatusPendingReview},
+		2: {ID: 2, Price: 5000, Status: models.PurchaseStatusPendingReview},
+		3: {ID: 3, Price: 20, Status: models.PurchaseStatusApproved},
+	}}
+}
+
+func TestSweep(t *testing.T) {
+	store := newStore()
+	var reviewed []int
+	reviewer := NewReviewer(MaxAmount{Limit: 1000}, store, func(p *models.Purchase) {
+		reviewed = append(reviewed, p.ID)
+	})
+
+	if err := reviewer.Sweep(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if store.purchases[1].Status != models.PurchaseStatusApproved {
+		t.Errorf("Expected purchase 1 to be %s, got %s", models.PurchaseStatusApproved, store.purchases[1].Status)
+	}
+	if store.purchases[2].Status != models.PurchaseStatusRejected {
+		t.Errorf("Expected purchase 2 to be %s, got %s", models.PurchaseStatusRejected, store.purchases[2].Status)
+	}
+	if len(reviewed) != 2 {
+		t.Errorf("Expected 2 reviewed events, got %d", len(reviewed))
+	}
+}
+
+func TestReviewAlreadyReviewed(t *testing.T) {
+	store := newStore()
+	called := false
+	reviewer := NewReviewer(ApproveAll{}, store, func(p *models.Purchase) { called = true })
+
+	if err := reviewer.Review(context.Background(), store.purchases[3]); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if called {
+		t.Errorf("Expected no event for a purchase that was not pending review")
+	}
+}
+
+func TestReviewCheckerFailureKeepsPending(t *testing.T) {
+	store := newStore()
+	reviewer := NewReviewer(failingChecker{}, store, nil)
+
+	if err := reviewer.Review(context.Background(), store.purchases[1]); err == nil {
+		t.Errorf("Expected error from failing checker")
+	}
+	if store.purchases[1].Status != models.PurchaseStatusPendingReview {
+		t.Errorf("Expected purchase to stay %s, got %s", models.PurchaseStatusPendingReview, store.purchases[1].Status)
+	}
+}
@@ -0,0 +1,127 @@
+package graphql
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/metrics"
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+)
+
+// LagPolicy decides what happens to a subscriber whose buffer is full
+type LagPolicy string
+
+const (
+	// LagPolicyDrop drops the oldest buffered event to make room for the new one
+	LagPolicyDrop LagPolicy = "drop"
+	// LagPolicyDisconnect ends the subscription; the client may resume it with
+	// lastEventId and have the missed events replayed from the database
+	LagPolicyDisconnect LagPolicy = "disconnect"
+)
+
+// ParseLagPolicy parses "drop" or "disconnect"
+func ParseLagPolicy(s string) (LagPolicy, error) {
+	switch policy := LagPolicy(s); policy {
+	case LagPolicyDrop, LagPolicyDisconnect:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown lag policy %q, expected %q or %q", s, LagPolicyDrop, LagPolicyDisconnect)
+	}
+}
+
+// Backpressure bounds the live events buffered for a subscriber that reads
+// slower than they are published, and the missed events replayed to a
+// subscriber resuming with lastEventId
+type Backpressure struct {
+	Buffer int
+	Policy LagPolicy
+	// Replay is the most events replayed on resumption; a subscriber that
+	// missed more must resync from queries
+	Replay int
+}
+
+// DefaultBackpressure buffers 64 events per subscriber and drops the oldest
+// ones beyond that, and replays at most 1000 missed events
+func DefaultBackpressure() Backpressure {
+	return Backpressure{Buffer: 64, Policy: LagPolicyDrop, Replay: 1000}
+}
+
+// queuedDelivery is a delivery waiting to be sent to a subscriber. Replayed
+// deliveries don't count towards the buffer
+type queuedDelivery struct {
+	delivery  *models.Delivery
+	published time.Time
+	replayed  bool
+}
+
+// deliveryQueue holds the deliveries a subscriber hasn't read yet
+type deliveryQueue struct {
+	subscription string
+	backpressure Backpressure
+	pending      []queuedDelivery
+	live         int
+}
+
+// push queues a live delivery, applying the lag policy when the buffer is
+// full. It returns false once the subscriber should be disconnected
+func (q *deliveryQueue) push(delivery *models.Delivery, published time.Time) bool {
+	if q.live >= q.backpressure.Buffer {
+		if q.backpressure.Policy == LagPolicyDisconnect {
+			log.Printf("[GraphQL] Disconnecting %s subscriber lagging %d events behind", q.subscription, q.live)
+			metrics.SubscriptionLagDisconnects.WithLabelValues(q.subscription).Inc()
+			return false
+		}
+		q.dropOldest()
+	}
+
+	q.pending = append(q.pending, queuedDelivery{delivery: delivery, published: published})
+	q.live++
+	metrics.SubscriptionBufferedEvents.WithLabelValues(q.subscription).Inc()
+	return true
+}
+
+// dropOldest drops the oldest live delivery; replayed ones are kept as the
+// client asked for them explicitly
+func (q *deliveryQueue) dropOldest() {
+	for i, queued := range q.pending {
+		if !queued.replayed {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			q.live--
+			metrics.SubscriptionBufferedEvents.WithLabelValues(q.subscription).Dec()
+			metrics.SubscriptionDroppedEvents.WithLabelValues(q.subscription).Inc()
+			log.Printf("[GraphQL] Dropped delivery %d for lagging %s subscriber", queued.delivery.ID, q.subscription)
+			return
+		}
+	}
+}
+
+// replay queues a delivery missed by a resuming subscriber
+func (q *deliveryQueue) replay(delivery *models.Delivery) {
+	q.pending = append(q.pending, queuedDelivery{delivery: delivery, replayed: true})
+}
+
+// next returns the delivery to send next, if any
+func (q *deliveryQueue) next() (*models.Delivery, bool) {
+	if len(q.pending) == 0 {
+		return nil, false
+	}
+	return q.pending[0].delivery, true
+}
+
+// sent removes the delivery returned by next, recording how long it waited
+func (q *deliveryQueue) sent() {
+	queued := q.pending[0]
+	q.pending = q.pending[1:]
+	if !queued.replayed {
+		q.live--
+		metrics.SubscriptionBufferedEvents.WithLabelValues(q.subscription).Dec()
+		metrics.SubscriptionLag.WithLabelValues(q.subscription).Observe(time.Since(queued.published).Seconds())
+	}
+}
+
+// discard forgets the deliveries never sent when the subscription ends
+func (q *deliveryQueue) discard() {
+	metrics.SubscriptionBufferedEvents.WithLabelValues(q.subscription).Sub(float64(q.live))
+	q.pending, q.live = nil, 0
+}
@@ -0,0 +1,209 @@
+package graphql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/graph-gophers/graphql-go"
+	"github.com/korjavin/graphqlTinyExample/pkg/events"
+	"github.com/korjavin/graphqlTinyExample/pkg/id"
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+	"github.com/korjavin/graphqlTinyExample/pkg/repository"
+)
+
+// publishDeliveries hands deliveries with the given IDs to the forwarder,
+// waiting until it has read each of them
+func publishDeliveries(updates chan events.DeliveryEvent, ids ...int) {
+	for _, id := range ids {
+		updates <- events.DeliveryEvent{Delivery: &models.Delivery{ID: id}, Published: time.Now()}
+	}
+}
+
+// receiveDeliveries reads deliveries until the subscription ends or none
+// arrives for a while, returning their IDs and whether the subscription ended
+func receiveDeliveries(c <-chan *DeliveryResolver) ([]int, bool) {
+	var ids []int
+	for {
+		select {
+		case delivery, ok := <-c:
+			if !ok {
+				return ids, true
+			}
+			ids = append(ids, delivery.delivery.ID)
+		case <-time.After(50 * time.Millisecond):
+			return ids, false
+		}
+	}
+}
+
+func TestForwardDeliveriesDropsOldest(t *testing.T) {
+	r := NewResolver(nil)
+	r.SetBackpressure(Backpressure{Buffer: 2, Policy: LagPolicyDrop})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates := make(chan events.DeliveryEvent)
+	missed := []*models.Delivery{{ID: 1}, {ID: 2}, {ID: 3}}
+	c := r.forwardDeliveries(ctx, "", updates, missed, nil)
+
+	// A subscriber that doesn't read doesn't stall the events behind it
+	publishDeliveries(updates, 4, 5, 6, 7)
+
+	ids, ended := receiveDeliveries(c)
+	if ended {
+		t.Fatalf("Expected the subscription to stay open")
+	}
+	// Replayed deliveries are kept, the oldest live ones dropped
+	want := []int{1, 2, 3, 6, 7}
+	if len(ids) != len(want) {
+		t.Fatalf("Expected deliveries %v, got %v", want, ids)
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Fatalf("Expected deliveries %v, got %v", want, ids)
+		}
+	}
+
+	// Once caught up, the buffer is available again
+	publishDeliveries(updates, 8)
+	if ids, _ := receiveDeliveries(c); len(ids) != 1 || ids[0] != 8 {
+		t.Errorf("Expected delivery 8, got %v", ids)
+	}
+}
+
+func TestForwardDeliveriesDisconnectsLaggingSubscriber(t *testing.T) {
+	r := NewResolver(nil)
+	r.SetBackpressure(Backpressure{Buffer: 2, Policy: LagPolicyDisconnect})
+
+	updates := make(chan events.DeliveryEvent)
+	c := r.forwardDeliveries(context.Background(), "", updates, nil, nil)
+
+	publishDeliveries(updates, 1, 2, 3)
+
+	ids, ended := receiveDeliveries(c)
+	if !ended {
+		t.Fatalf("Expected the lagging subscription to end")
+	}
+	if len(ids) != 0 {
+		t.Errorf("Expected buffered deliveries to be discarded, got %v", ids)
+	}
+}
+
+func TestForwardDeliveriesFiltersStatuses(t *testing.T) {
+	r := NewResolver(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates := make(chan events.DeliveryEvent)
+	missed := []*models.Delivery{{ID: 1, Status: "packed"}, {ID: 2, Status: "delivered"}}
+	c := r.forwardDeliveries(ctx, "", updates, missed, statusFilter{"delivered": true, "canceled": true})
+
+	for _, delivery := range []*models.Delivery{{ID: 3, Status: "out_for_delivery"}, {ID: 4, Status: "canceled"}} {
+		updates <- events.DeliveryEvent{Delivery: delivery, Published: time.Now()}
+	}
+
+	ids, _ := receiveDeliveries(c)
+	if len(ids) != 2 || ids[0] != 2 || ids[1] != 4 {
+		t.Errorf("Expected only the terminal deliveries 2 and 4, got %v", ids)
+	}
+}
+
+func TestForwardDeliveriesOutOfOrder(t *testing.T) {
+	r := NewResolver(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates := make(chan events.DeliveryEvent)
+	missed := []*models.Delivery{{ID: 2}, {ID: 3}}
+	c := r.forwardDeliveries(ctx, "", updates, missed, nil)
+
+	// Delivery 3 was committed after the subscription started and is also replayed;
+	// 5 and 4 come from concurrent transactions committing out of order
+	publishDeliveries(updates, 3, 5, 4)
+
+	ids, _ := receiveDeliveries(c)
+	want := []int{2, 3, 5, 4}
+	if len(ids) != len(want) {
+		t.Fatalf("Expected deliveries %v, got %v", want, ids)
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Fatalf("Expected deliveries %v, got %v", want, ids)
+		}
+	}
+}
+
+func TestParseLagPolicy(t *testing.T) {
+	for _, s := range []string{"drop", "disconnect"} {
+		if policy, err := ParseLagPolicy(s); err != nil || string(policy) != s {
+			t.Errorf("Expected %q to parse, got %q, %v", s, policy, err)
+		}
+	}
+	if _, err := ParseLagPolicy("block"); err == nil {
+		t.Errorf("Expected an unknown policy to be rejected")
+	}
+}
+
+func TestDeliveryUpdatedWithObfuscatedPurchaseID(t *testing.T) {
+	id.SetCodec(id.NewObfuscator([]byte("secret")))
+	defer id.SetCodec(id.Decimal{})
+
+	r := NewResolver(nil)
+	purchaseID := graphql.ID(id.FormatPurchaseID(7))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := r.DeliveryUpdated(ctx, struct {
+		PurchaseID  *graphql.ID
+		LastEventID *graphql.ID
+		Statuses    *[]string
+	}{PurchaseID: &purchaseID})
+	if err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+
+	// The bus publishes by database ID, whatever form the subscriber used
+	r.eventBus.PublishDelivery(&models.Delivery{ID: 1, PurchaseID: 7})
+	select {
+	case delivery := <-c:
+		if delivery.delivery.ID != 1 {
+			t.Errorf("Expected delivery 1, got %d", delivery.delivery.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the delivery of purchase 7 to reach the subscriber")
+	}
+}
+
+func TestDeliveryUpdatedReplayLimit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock database: %v", err)
+	}
+	defer db.Close()
+
+	r := NewResolver(repository.NewRepository(db))
+	r.SetBackpressure(Backpressure{Buffer: 64, Policy: LagPolicyDrop, Replay: 2})
+	lastEventID := graphql.ID("4")
+	args := struct {
+		PurchaseID  *graphql.ID
+		LastEventID *graphql.ID
+		Statuses    *[]string
+	}{LastEventID: &lastEventID}
+
+	deliveryColumns := []string{"id", "purchase_id", "timestamp", "status", "scheduled_for", "attempt_number"}
+	rows := sqlmock.NewRows(deliveryColumns)
+	for id := 5; id <= 7; id++ {
+		rows.AddRow(id, 1, time.Now(), "packed", nil, 1)
+	}
+	mock.ExpectQuery("FROM deliveries WHERE id > \\$1 ORDER BY id LIMIT \\$2").WithArgs(4, 3).WillReturnRows(rows)
+
+	// Missing more than can be replayed asks the client to resync
+	if _, err := r.DeliveryUpdated(context.Background(), args); err == nil || errorCode(err) != CodeInvalidInput {
+		t.Errorf("Expected the replay to be refused as invalid input, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package graphql
+
+import (
+	"database/sql"
+	"sync"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+	"github.com/korjavin/graphqlTinyExample/pkg/rates"
+	"github.com/korjavin/graphqlTinyExample/pkg/repository"
+)
+
+// listingBatch is shared by the resolvers of listings returned together, so
+// their sellers, purchases and view counts are loaded with one query for all
+// of them instead of one per listing
+type listingBatch struct {
+	repo     *repository.Repository
+	listings []*models.Listing
+
+	sellersOnce sync.Once
+	sellers     map[int]*models.Seller
+	sellersErr  error
+
+	viewsOnce sync.Once
+	views     map[int]int64
+	viewsErr  error
+
+	mu        sync.Mutex
+	purchases map[purchasePage]*purchaseBatch
+}
+
+// purchasePage identifies a page of the purchases of each listing; aliases
+// may select different pages of the same field
+type purchasePage struct {
+	limit, offset int
+}
+
+// purchaseBatch holds a page of the purchases of every listing of a batch
+type purchaseBatch struct {
+	once      sync.Once
+	byListing map[int][]*models.Purchase
+	err       error
+}
+
+// newListingResolvers creates the resolvers of listings returned together,
+// sharing a batch between them
+func newListingResolvers(listings []*models.Listing, repo *repository.Repository, rates *rates.Cache, pageLimits PageLimits) []*ListingResolver {
+	batch := &listingBatch{
+		repo:      repo,
+		listings:  listings,
+		purchases: make(map[purchasePage]*purchaseBatch),
+	}
+
+	resolvers := make([]*ListingResolver, 0, len(listings))
+	for _, listing := range listings {
+		resolvers = append(resolvers, &ListingResolver{listing: listing, repo: repo, rates: rates, pageLimits: pageLimits, batch: batch})
+	}
+	return resolvers
+}
+
+// seller returns a seller of the batch's listings, loading all of them on the
+// first call
+func (b *listingBatch) seller(id int) (*models.Seller, error) {
+	b.sellersOnce.Do(func() {
+		seen := make(map[int]bool, len(b.listings))
+		ids := make([]int, 0, len(b.listings))
+		for _, listing := range b.listings {
+			if !seen[listing.SellerID] {
+				seen[listing.SellerID] = true
+				ids = append(ids, listing.SellerID)
+			}
+		}
+
+		sellers, err := b.repo.GetSellersByIDs(ids)
+		if err != nil {
+			b.sellersErr = err
+			return
+		}
+		b.sellers = make(map[int]*models.Seller, len(sellers))
+		for _, seller := range sellers {
+			b.sellers[seller.ID] = seller
+		}
+	})
+
+	if b.sellersErr != nil {
+		return nil, b.sellersErr
+	}
+	seller, ok := b.sellers[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return seller, nil
+}
+
+// viewsOf returns the view count of a listing of the batch, loading the counts
+// of all of them on the first call
+func (b *listingBatch) viewsOf(listingID int) (int64, error) {
+	b.viewsOnce.Do(func() {
+		ids := make([]int, 0, len(b.listings))
+		for _, listing := range b.listings {
+			ids = append(ids, listing.ID)
+		}
+		b.views, b.viewsErr = b.repo.GetListingViewsByIDs(ids)
+	})
+
+	return b.views[listingID], b.viewsErr
+}
+
+// purchasesOf returns a page of the purchases of a listing of the batch,
+// loading the page for all of them on the first call
+func (b *listingBatch) purchasesOf(listingID, limit, offset int) ([]*models.Purchase, error) {
+	key := purchasePage{limit: limit, offset: offset}
+	b.mu.Lock()
+	page, ok := b.purchases[key]
+	if !ok {
+		page = &purchaseBatch{}
+		b.purchases[key] = page
+	}
+	b.mu.Unlock()
+
+	page.once.Do(func() {
+		ids := make([]int, 0, len(b.listings))
+		for _, listing := range b.listings {
+			ids = append(ids, listing.ID)
+		}
+
+		purchases, err := b.repo.GetPurchasesByListingIDs(ids, limit, offset)
+		if err != nil {
+			page.err = err
+			return
+		}
+		page.byListing = make(map[int][]*models.Purchase, len(ids))
+		for _, purchase := range purchases {
+			page.byListing[purchase.ListingID] = append(page.byListing[purchase.ListingID], purchase)
+		}
+	})
+
+	return page.byListing[listingID], page.err
+}
@@ -0,0 +1,136 @@
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/metrics"
+	"github.com/korjavin/graphqlTinyExample/pkg/repository"
+)
+
+// nestedListingsQuery selects the seller, purchases and views of every listing,
+// which without batching costs one query per listing and field
+const nestedListingsQuery = `{ listings { id views seller { name } purchases { id } } }`
+
+// repositoryCalls returns how often repository methods have been called in
+// total, as recorded by their duration metric
+func repositoryCalls(tb testing.TB) uint64 {
+	ch := make(chan prometheus.Metric, 64)
+	go func() {
+		metrics.RepositoryDuration.Collect(ch)
+		close(ch)
+	}()
+
+	var calls uint64
+	for metric := range ch {
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			tb.Fatalf("Failed to read repository metrics: %v", err)
+		}
+		calls += m.GetHistogram().GetSampleCount()
+	}
+	return calls
+}
+
+// expectNestedListings sets up a database holding the given number of
+// listings of three sellers with two purchases each, the first of them viewed
+func expectNestedListings(mock sqlmock.Sqlmock, listings int) {
+	listingRows := sqlmock.NewRows([]string{"id", "seller_id", "title", "description", "price"})
+	purchaseRows := sqlmock.NewRows([]string{"id", "listing_id", "price", "tax_amount", "bank_tx_id", "delivery_address", "pickup_point_id", "status", "order_status", "created_at"})
+	for i := 1; i <= listings; i++ {
+		listingRows.AddRow(i, (i-1)%3+1, fmt.Sprintf("Listing %d", i), "Description", 10.0)
+		for j := 0; j < 2; j++ {
+			id := 2*i + j
+			purchaseRows.AddRow(id, i, 10.0, 0.0, fmt.Sprintf("TX%d", id), "Address", nil, "approved", "paid", time.Now())
+		}
+	}
+	sellerRows := sqlmock.NewRows([]string{"id", "name", "address", "digest_opt_in"})
+	for i := 1; i <= 3 && i <= listings; i++ {
+		sellerRows.AddRow(i, fmt.Sprintf("Seller %d", i), "Address", false)
+	}
+
+	viewRows := sqlmock.NewRows([]string{"listing_id", "views"}).AddRow(1, 42)
+
+	mock.ExpectQuery("FROM listings").WillReturnRows(listingRows)
+	mock.ExpectQuery("FROM sellers").WillReturnRows(sellerRows)
+	mock.ExpectQuery("FROM purchases").WillReturnRows(purchaseRows)
+	mock.ExpectQuery("FROM listing_views").WillReturnRows(viewRows)
+}
+
+func newNestedListingsSchema(tb testing.TB) (sqlmock.Sqlmock, func(), func() []string) {
+	tb.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		tb.Fatalf("Failed to create mock database: %v", err)
+	}
+	// Sellers and purchases are resolved concurrently
+	mock.MatchExpectationsInOrder(false)
+
+	schema, err := GetSchema(NewResolver(repository.NewRepository(db)))
+	if err != nil {
+		tb.Fatalf("Failed to parse schema: %v", err)
+	}
+
+	exec := func() []string {
+		resp := schema.Exec(context.Background(), nestedListingsQuery, "", nil)
+		errs := make([]string, 0, len(resp.Errors))
+		for _, err := range resp.Errors {
+			errs = append(errs, err.Error())
+		}
+		return errs
+	}
+	return mock, func() { db.Close() }, exec
+}
+
+func TestNestedListingsQueryCountIsConstant(t *testing.T) {
+	for _, listings := range []int{1, 10, 100} {
+		mock, closeDB, exec := newNestedListingsSchema(t)
+		expectNestedListings(mock, listings)
+
+		before := repositoryCalls(t)
+		if errs := exec(); len(errs) > 0 {
+			t.Fatalf("%d listings: unexpected errors: %v", listings, errs)
+		}
+
+		// One query per level of the query, however many listings it returns
+		if calls := repositoryCalls(t) - before; calls != 4 {
+			t.Errorf("%d listings: expected 4 repository calls, got %d", listings, calls)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%d listings: there were unfulfilled expectations: %s", listings, err)
+		}
+		closeDB()
+	}
+}
+
+func BenchmarkNestedListingsQuery(b *testing.B) {
+	for _, listings := range []int{10, 100} {
+		b.Run(fmt.Sprintf("listings=%d", listings), func(b *testing.B) {
+			mock, closeDB, exec := newNestedListingsSchema(b)
+			defer closeDB()
+
+			var calls uint64
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				expectNestedListings(mock, listings)
+				before := repositoryCalls(b)
+				b.StartTimer()
+
+				if errs := exec(); len(errs) > 0 {
+					b.Fatalf("Unexpected errors: %v", errs)
+				}
+
+				b.StopTimer()
+				calls += repositoryCalls(b) - before
+				b.StartTimer()
+			}
+			b.ReportMetric(float64(calls)/float64(b.N), "queries/op")
+		})
+	}
+}
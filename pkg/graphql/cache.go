@@ -0,0 +1,51 @@
+package graphql
+
+import (
+	"context"
+	"sync"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+)
+
+type requestCacheKey struct{}
+
+// requestCache memoizes lookups for the lifetime of a single request, so
+// resolving the seller of many listings of the same seller hits the database once
+type requestCache struct {
+	mu      sync.Mutex
+	sellers map[int]*sellerEntry
+}
+
+// sellerEntry lets concurrently resolved fields share a single lookup
+type sellerEntry struct {
+	once   sync.Once
+	seller *models.Seller
+	err    error
+}
+
+// WithRequestCache attaches an empty per-request cache to the context
+func WithRequestCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, requestCacheKey{}, &requestCache{sellers: make(map[int]*sellerEntry)})
+}
+
+// loadSeller returns the seller from the request cache, calling fetch on the
+// first lookup. Without a cache in the context every call goes to fetch
+func loadSeller(ctx context.Context, id int, fetch func(int) (*models.Seller, error)) (*models.Seller, error) {
+	cache, ok := ctx.Value(requestCacheKey{}).(*requestCache)
+	if !ok {
+		return fetch(id)
+	}
+
+	cache.mu.Lock()
+	entry, ok := cache.sellers[id]
+	if !ok {
+		entry = &sellerEntry{}
+		cache.sellers[id] = entry
+	}
+	cache.mu.Unlock()
+
+	entry.once.Do(func() {
+		entry.seller, entry.err = fetch(id)
+	})
+	return entry.seller, entry.err
+}
@@ -0,0 +1,52 @@
+package graphql
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+)
+
+func TestLoadSeller(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	fetch := func(id int) (*models.Seller, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		return &models.Seller{ID: id, Name: "Test Seller"}, nil
+	}
+
+	// Resolve the seller of many listings concurrently within one request
+	ctx := WithRequestCache(context.Background())
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if seller, err := loadSeller(ctx, 1, fetch); err != nil || seller.ID != 1 {
+				t.Errorf("Expected seller 1, got %v (%v)", seller, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("Expected 1 fetch, got %d", calls)
+	}
+
+	// A different seller and a different request are fetched again
+	loadSeller(ctx, 2, fetch)
+	loadSeller(WithRequestCache(context.Background()), 1, fetch)
+	if calls != 3 {
+		t.Errorf("Expected 3 fetches, got %d", calls)
+	}
+
+	// Without a cache every lookup is fetched
+	loadSeller(context.Background(), 1, fetch)
+	loadSeller(context.Background(), 1, fetch)
+	if calls != 5 {
+		t.Errorf("Expected 5 fetches, got %d", calls)
+	}
+}
@@ -0,0 +1,88 @@
+package graphql
+
+import (
+	"strings"
+	"sync"
+)
+
+// OtherLabel replaces client names, client versions and operation names in
+// metric labels that aren't known, so callers can't create unbounded series
+const OtherLabel = "other"
+
+// KnownClients are the clients metrics are broken down by. The client headers
+// are chosen by the caller, so any other name or version is labeled "other"
+type KnownClients struct {
+	// versions maps known client names to their known versions
+	versions map[string]map[string]bool
+}
+
+// ParseKnownClients parses a comma-separated list of client names, each
+// optionally followed by @version, e.g. "storefront@1.2.0,storefront@1.3.0,cli".
+// Versions of a name listed without one are labeled "other"
+func ParseKnownClients(list string) KnownClients {
+	known := KnownClients{versions: make(map[string]map[string]bool)}
+	for _, entry := range strings.Split(list, ",") {
+		name, version, _ := strings.Cut(strings.TrimSpace(entry), "@")
+		if name == "" {
+			continue
+		}
+		if known.versions[name] == nil {
+			known.versions[name] = make(map[string]bool)
+		}
+		if version != "" {
+			known.versions[name][version] = true
+		}
+	}
+	return known
+}
+
+// Known reports whether a client name is known
+func (k KnownClients) Known(name string) bool {
+	_, ok := k.versions[name]
+	return ok
+}
+
+// Labels returns the client name and version to label metrics of the client with
+func (k KnownClients) Labels(client ClientInfo) (string, string) {
+	versions, ok := k.versions[client.Name]
+	if !ok {
+		return OtherLabel, OtherLabel
+	}
+	if !versions[client.Version] {
+		return client.Name, OtherLabel
+	}
+	return client.Name, client.Version
+}
+
+const (
+	// maxOperationLabels bounds the distinct operation names labeling metrics
+	maxOperationLabels = 500
+	// maxOperationLabelLength is the longest operation name labeling metrics
+	maxOperationLabelLength = 100
+)
+
+// operationLabels admits the first maxOperationLabels operation names seen as
+// metric labels, labeling later and overly long names "other"
+type operationLabels struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func (o *operationLabels) label(name string) string {
+	if len(name) > maxOperationLabelLength {
+		return OtherLabel
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.seen[name] {
+		return name
+	}
+	if len(o.seen) >= maxOperationLabels {
+		return OtherLabel
+	}
+	if o.seen == nil {
+		o.seen = make(map[string]bool)
+	}
+	o.seen[name] = true
+	return name
+}
@@ -0,0 +1,161 @@
+package graphql
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/ast"
+)
+
+// CheckCompatibility compares a baseline schema with a new one and returns
+// the list of breaking changes clients relying on the baseline would hit
+func CheckCompatibility(oldSDL, newSDL string) ([]string, error) {
+	oldSchema, err := graphql.ParseSchema(oldSDL, nil, graphql.UseStringDescriptions())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse baseline schema: %w", err)
+	}
+	newSchema, err := graphql.ParseSchema(newSDL, nil, graphql.UseStringDescriptions())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse new schema: %w", err)
+	}
+
+	return diffSchemas(oldSchema.AST(), newSchema.AST()), nil
+}
+
+func diffSchemas(oldSchema, newSchema *ast.Schema) []string {
+	var changes []string
+
+	for _, op := range []string{"query", "mutation", "subscription"} {
+		oldRoot, newRoot := oldSchema.RootOperationTypes[op], newSchema.RootOperationTypes[op]
+		if oldRoot != nil && (newRoot == nil || newRoot.TypeName() != oldRoot.TypeName()) {
+			changes = append(changes, fmt.Sprintf("root %s type %s was removed or changed", op, oldRoot.TypeName()))
+		}
+	}
+
+	// Iterate in a stable order so the report is deterministic
+	names := make([]string, 0, len(oldSchema.Types))
+	for name := range oldSchema.Types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		oldType := oldSchema.Types[name]
+		newType, ok := newSchema.Types[name]
+		if !ok {
+			changes = append(changes, fmt.Sprintf("type %s was removed", name))
+			continue
+		}
+		if oldType.Kind() != newType.Kind() {
+			changes = append(changes, fmt.Sprintf("type %s changed kind from %s to %s", name, oldType.Kind(), newType.Kind()))
+			continue
+		}
+
+		switch oldT := oldType.(type) {
+		case *ast.ObjectTypeDefinition:
+			changes = append(changes, diffFields(name, oldT.Fields, newType.(*ast.ObjectTypeDefinition).Fields)...)
+		case *ast.InterfaceTypeDefinition:
+			changes = append(changes, diffFields(name, oldT.Fields, newType.(*ast.InterfaceTypeDefinition).Fields)...)
+		case *ast.InputObject:
+			changes = append(changes, diffInputValues(name, "input field", oldT.Values, newType.(*ast.InputObject).Values)...)
+		case *ast.EnumTypeDefinition:
+			newValues := make(map[string]bool)
+			for _, v := range newType.(*ast.EnumTypeDefinition).EnumValuesDefinition {
+				newValues[v.EnumValue] = true
+			}
+			for _, v := range oldT.EnumValuesDefinition {
+				if !newValues[v.EnumValue] {
+					changes = append(changes, fmt.Sprintf("enum value %s.%s was removed", name, v.EnumValue))
+				}
+			}
+		case *ast.Union:
+			newMembers := make(map[string]bool)
+			for _, m := range newType.(*ast.Union).UnionMemberTypes {
+				newMembers[m.Name] = true
+			}
+			for _, m := range oldT.UnionMemberTypes {
+				if !newMembers[m.Name] {
+					changes = append(changes, fmt.Sprintf("union member %s was removed from %s", m.Name, name))
+				}
+			}
+		}
+	}
+
+	return changes
+}
+
+// diffFields reports removed output fields, incompatible type changes and breaking argument changes
+func diffFields(typeName string, oldFields, newFields ast.FieldsDefinition) []string {
+	var changes []string
+
+	for _, oldField := range oldFields {
+		path := typeName + "." + oldField.Name
+		newField := newFields.Get(oldField.Name)
+		if newField == nil {
+			changes = append(changes, fmt.Sprintf("field %s was removed", path))
+			continue
+		}
+
+		// Output types may only become stricter (nullable -> non-null)
+		if !isStricterOrEqual(newField.Type, oldField.Type) {
+			changes = append(changes, fmt.Sprintf("field %s changed type from %s to %s", path, oldField.Type, newField.Type))
+		}
+
+		changes = append(changes, diffInputValues(path, "argument", oldField.Arguments, newField.Arguments)...)
+	}
+
+	return changes
+}
+
+// diffInputValues reports breaking changes for arguments and input object fields
+func diffInputValues(path, kind string, oldValues, newValues ast.ArgumentsDefinition) []string {
+	var changes []string
+
+	for _, oldValue := range oldValues {
+		newValue := newValues.Get(oldValue.Name.Name)
+		if newValue == nil {
+			changes = append(changes, fmt.Sprintf("%s %s.%s was removed", kind, path, oldValue.Name.Name))
+			continue
+		}
+
+		// Input types may only become looser (non-null -> nullable)
+		if !isStricterOrEqual(oldValue.Type, newValue.Type) {
+			changes = append(changes, fmt.Sprintf("%s %s.%s changed type from %s to %s",
+				kind, path, oldValue.Name.Name, oldValue.Type, newValue.Type))
+		}
+	}
+
+	for _, newValue := range newValues {
+		if oldValues.Get(newValue.Name.Name) != nil {
+			continue
+		}
+		if _, required := newValue.Type.(*ast.NonNull); required && newValue.Default == nil {
+			changes = append(changes, fmt.Sprintf("required %s %s.%s was added", kind, path, newValue.Name.Name))
+		}
+	}
+
+	return changes
+}
+
+// isStricterOrEqual reports whether t is the same type as base, optionally
+// with additional non-null wrappers at any level
+func isStricterOrEqual(t, base ast.Type) bool {
+	if t.String() == base.String() {
+		return true
+	}
+
+	switch tt := t.(type) {
+	case *ast.NonNull:
+		if bt, ok := base.(*ast.NonNull); ok {
+			return isStricterOrEqual(tt.OfType, bt.OfType)
+		}
+		return isStricterOrEqual(tt.OfType, base)
+	case *ast.List:
+		if bt, ok := base.(*ast.List); ok {
+			return isStricterOrEqual(tt.OfType, bt.OfType)
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,95 @@
+package graphql
+
+import (
+	"strings"
+	"testing"
+)
+
+const baselineSchema = `
+schema {
+  query: Query
+}
+
+type Query {
+  item(id: ID!): Item
+  items(filter: ItemFilter): [Item!]!
+}
+
+type Item {
+  id: ID!
+  name: String
+  status: Status!
+}
+
+enum Status {
+  NEW
+  DONE
+}
+
+input ItemFilter {
+  name: String
+  status: Status
+}
+`
+
+func TestCheckCompatibilityIdentical(t *testing.T) {
+	changes, err := CheckCompatibility(Schema, Schema)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("Expected no breaking changes, got %v", changes)
+	}
+}
+
+func TestCheckCompatibilitySafeChanges(t *testing.T) {
+	newSchema := strings.NewReplacer(
+		"name: String\n  status: Status!", "name: String!\n  status: Status!\n  price: Float",
+		"item(id: ID!)", "item(id: ID, withDeleted: Boolean)",
+		"DONE", "DONE\n  CANCELED",
+	).Replace(baselineSchema)
+
+	changes, err := CheckCompatibility(baselineSchema, newSchema)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("Expected no breaking changes, got %v", changes)
+	}
+}
+
+func TestCheckCompatibilityBreakingChanges(t *testing.T) {
+	newSchema := strings.NewReplacer(
+		"  name: String\n  status: Status!", "  status: Status",
+		"item(id: ID!)", "item(id: ID!, version: Int!)",
+		"  DONE\n", "",
+		"  name: String\n  status: Status\n", "  name: String!\n  status: Status\n",
+	).Replace(baselineSchema)
+
+	changes, err := CheckCompatibility(baselineSchema, newSchema)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []string{
+		"field Item.name was removed",
+		"field Item.status changed type from Status! to Status",
+		"input field ItemFilter.name changed type from String to String!",
+		"required argument Query.item.version was added",
+		"enum value Status.DONE was removed",
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %d breaking changes, got %d: %v", len(expected), len(changes), changes)
+	}
+	for _, want := range expected {
+		found := false
+		for _, change := range changes {
+			if change == want {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected breaking change %q in %v", want, changes)
+		}
+	}
+}
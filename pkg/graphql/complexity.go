@@ -0,0 +1,216 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/types"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+// Complexity estimates the cost of operations before they run. Fields
+// returning objects cost 1 and leaf fields cost nothing unless Costs says
+// otherwise, and the cost of a list field, its selections included, is
+// multiplied by the number of items it may return: the requested limit, first
+// or last, or else the default page size of the field
+type Complexity struct {
+	schema     *types.Schema
+	pageLimits atomic.Pointer[PageLimits]
+	// Costs overrides the cost of fields by "Type.field"
+	Costs map[string]int
+	// Budget is the highest cost an operation may have; zero allows any cost
+	Budget int
+}
+
+// NewComplexity returns a calculator for operations of the schema, taking list
+// sizes from the page limits and the field costs from DefaultFieldCosts
+func NewComplexity(schema *graphql.Schema, pageLimits PageLimits) *Complexity {
+	c := &Complexity{
+		schema: schema.AST(),
+		Costs:  DefaultFieldCosts(),
+	}
+	c.SetPageLimits(pageLimits)
+	return c
+}
+
+// SetPageLimits changes the page limits sizing lists, e.g. when the resolver's
+// limits are reloaded. It may be called while operations are estimated
+func (c *Complexity) SetPageLimits(pageLimits PageLimits) {
+	c.pageLimits.Store(&pageLimits)
+}
+
+// DefaultFieldCosts returns higher costs for the fields querying the search
+// index or aggregating over many rows
+func DefaultFieldCosts() map[string]int {
+	return map[string]int{
+		"Query.search":              5,
+		"Query.searchListings":      5,
+		"Query.recommendedListings": 5,
+		"Query.listingPriceStats":   5,
+		"Query.dashboard":           5,
+	}
+}
+
+// sizeArguments are the arguments bounding the number of items a field returns
+var sizeArguments = []string{"limit", "first", "last"}
+
+// Cost returns the estimated cost of the operation. Without an operation name
+// the document must hold a single operation. Fields unknown to the schema cost
+// nothing and are rejected by validation later
+func (c *Complexity) Cost(query, operationName string, variables map[string]interface{}) (int, error) {
+	doc, err := parser.ParseQuery(&ast.Source{Input: query})
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse query: %w", err)
+	}
+
+	var op *ast.OperationDefinition
+	if operationName != "" {
+		op = doc.Operations.ForName(operationName)
+	} else if len(doc.Operations) == 1 {
+		op = doc.Operations[0]
+	}
+	if op == nil {
+		return 0, fmt.Errorf("unknown operation %q", operationName)
+	}
+
+	root := c.schema.RootOperationTypes[string(op.Operation)]
+	if root == nil {
+		return 0, nil
+	}
+	e := &estimator{Complexity: c, pageLimits: *c.pageLimits.Load(), fragments: doc.Fragments, variables: variables}
+	return e.selections(op.SelectionSet, root.TypeName(), map[string]bool{}), nil
+}
+
+// estimator sums the costs of the selections of an operation
+type estimator struct {
+	*Complexity
+	pageLimits PageLimits
+	fragments  ast.FragmentDefinitionList
+	variables  map[string]interface{}
+}
+
+// selections returns the cost of the selections on the named type. Fragments
+// on different types of a union are all counted, bounding the cost from above
+func (e *estimator) selections(set ast.SelectionSet, typeName string, visiting map[string]bool) int {
+	total := 0
+	for _, selection := range set {
+		switch sel := selection.(type) {
+		case *ast.Field:
+			total += e.field(sel, typeName, visiting)
+		case *ast.InlineFragment:
+			total += e.selections(sel.SelectionSet, fragmentType(sel.TypeCondition, typeName), visiting)
+		case *ast.FragmentSpread:
+			// Guard against fragment cycles, which validation rejects later
+			fragment := e.fragments.ForName(sel.Name)
+			if fragment == nil || visiting[sel.Name] {
+				continue
+			}
+			visiting[sel.Name] = true
+			total += e.selections(fragment.SelectionSet, fragmentType(fragment.TypeCondition, typeName), visiting)
+			delete(visiting, sel.Name)
+		}
+	}
+	return total
+}
+
+// field returns the cost of a field of the named type, its selections included
+func (e *estimator) field(field *ast.Field, typeName string, visiting map[string]bool) int {
+	definition := fieldDefinition(e.schema, typeName, field.Name)
+	if definition == nil {
+		return 0
+	}
+
+	named, list := unwrapType(definition.Type)
+	cost, ok := e.Costs[typeName+"."+field.Name]
+	if !ok && len(field.SelectionSet) > 0 {
+		cost = 1
+	}
+	cost += e.selections(field.SelectionSet, named, visiting)
+
+	size, requested := e.requestedSize(field)
+	switch {
+	case requested:
+		return size * cost
+	case list && !strings.HasSuffix(typeName, "Connection"):
+		// Lists of a connection are sized by the arguments of the connection field
+		return e.pageLimits.For(e.pageLimitField(typeName, field.Name)).Default * cost
+	}
+	return cost
+}
+
+// fieldDefinition returns the definition of a field of an object or interface type
+func fieldDefinition(schema *types.Schema, typeName, fieldName string) *types.FieldDefinition {
+	switch t := schema.Types[typeName].(type) {
+	case *types.ObjectTypeDefinition:
+		return t.Fields.Get(fieldName)
+	case *types.InterfaceTypeDefinition:
+		return t.Fields.Get(fieldName)
+	}
+	return nil
+}
+
+// pageLimitField returns the name page limits use for a field: the plain name
+// for root fields and "Type.field" for nested ones
+func (e *estimator) pageLimitField(typeName, fieldName string) string {
+	if query := e.schema.RootOperationTypes["query"]; query != nil && query.TypeName() == typeName {
+		return fieldName
+	}
+	return typeName + "." + fieldName
+}
+
+// requestedSize returns the number of items a field was asked for, if any
+func (e *estimator) requestedSize(field *ast.Field) (int, bool) {
+	for _, name := range sizeArguments {
+		arg := field.Arguments.ForName(name)
+		if arg == nil {
+			continue
+		}
+		value, err := arg.Value.Value(e.variables)
+		if err != nil {
+			continue
+		}
+		switch n := value.(type) {
+		case int:
+			return n, true
+		case int64:
+			return int(n), true
+		case float64:
+			return int(n), true
+		case json.Number:
+			if i, err := n.Int64(); err == nil {
+				return int(i), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// unwrapType returns the name of the type of a field and whether it is a list
+func unwrapType(t types.Type) (string, bool) {
+	list := false
+	for {
+		switch wrapped := t.(type) {
+		case *types.NonNull:
+			t = wrapped.OfType
+		case *types.List:
+			list = true
+			t = wrapped.OfType
+		case types.NamedType:
+			return wrapped.TypeName(), list
+		default:
+			return "", list
+		}
+	}
+}
+
+// fragmentType returns the type a fragment applies to
+func fragmentType(typeCondition, typeName string) string {
+	if typeCondition != "" {
+		return typeCondition
+	}
+	return typeName
+}
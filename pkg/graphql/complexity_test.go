@@ -0,0 +1,81 @@
+package graphql
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestComplexityCost(t *testing.T) {
+	schema, err := GetSchema(NewResolver(nil))
+	if err != nil {
+		t.Fatalf("Failed to parse schema: %v", err)
+	}
+	complexity := NewComplexity(schema, DefaultPageLimits())
+
+	tests := []struct {
+		name      string
+		query     string
+		variables map[string]interface{}
+		want      int
+	}{
+		{"leaf fields are free", `{ views }`, nil, 0},
+		{"unsized lists use the default page size", `{ sellers { id name } }`, nil, 25},
+		{"nested lists multiply", `{ sellers { listings(limit: 2) { title } } }`, nil, 75},
+		{"fragments count like fields", `{ sellers { ...Listings } } fragment Listings on Seller { listings(limit: 2) { title } }`, nil, 75},
+		{"connections are sized by first", `{ listingsConnection(first: 3) { edges { node { id } } pageInfo { hasNextPage } } }`, nil, 12},
+		{"sizes from variables", `query Search($n: Int) { searchListings(query: "lamp", limit: $n) { id } }`, map[string]interface{}{"n": 4}, 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cost, err := complexity.Cost(tt.query, "", tt.variables)
+			if err != nil {
+				t.Fatalf("Cost failed: %v", err)
+			}
+			if cost != tt.want {
+				t.Errorf("Expected cost %d, got %d", tt.want, cost)
+			}
+		})
+	}
+
+	if _, err := complexity.Cost(`{ views }`, "Missing", nil); err == nil {
+		t.Errorf("Expected an error for an unknown operation")
+	}
+}
+
+func TestHandlerComplexityBudget(t *testing.T) {
+	schema, err := GetSchema(NewResolver(nil))
+	if err != nil {
+		t.Fatalf("Failed to parse schema: %v", err)
+	}
+	complexity := NewComplexity(schema, DefaultPageLimits())
+	complexity.Budget = 50
+	handler := &Handler{Schema: schema, Complexity: complexity}
+
+	req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query": "{ sellers { listings { title } } }"}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"code":"TOO_COMPLEX"`) {
+		t.Errorf("Expected the operation to be rejected, got %d %s", rec.Code, rec.Body)
+	}
+
+	var response struct {
+		Extensions map[string]interface{} `json:"extensions"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response %s: %v", rec.Body, err)
+	}
+	if cost, _ := response.Extensions["cost"].(float64); cost != 650 {
+		t.Errorf("Expected the rejected cost of 650 to be reported, got %v", response.Extensions)
+	}
+
+	// Operations within the budget run and report their cost as well
+	req = httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query": "{ __typename }"}`))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"cost":0`) {
+		t.Errorf("Expected the operation to run with its cost, got %d %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,158 @@
+package graphql
+
+import (
+	"strings"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/cursor"
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+)
+
+// idCursorSort names the sort order of cursors over rows ordered by ID
+const idCursorSort = "id"
+
+// cursorSort names the sort order of cursors over rows in the given order.
+// Cursors of one order are rejected as stale in another
+func cursorSort(orderBy string) string {
+	if orderBy == "" {
+		return idCursorSort
+	}
+	return strings.ToLower(orderBy)
+}
+
+// encodeCursor builds the cursor of a row in the page's order. Price cursors
+// carry the price as well as the ID of the row
+func encodeCursor(codec *cursor.Codec, page *models.Page, id int, price models.Money) (string, error) {
+	if page.SortsByPrice() {
+		return codec.Encode(cursorSort(page.OrderBy), price, id)
+	}
+	return codec.Encode(idCursorSort, id)
+}
+
+// decodeCursor reads the keyset values of a cursor in the page's order
+func decodeCursor(codec *cursor.Codec, page *models.Page, c string) (int, *models.Money, error) {
+	var id int
+	if page.SortsByPrice() {
+		var price models.Money
+		if err := codec.Decode(c, cursorSort(page.OrderBy), &price, &id); err != nil {
+			return 0, nil, err
+		}
+		return id, &price, nil
+	}
+	if err := codec.Decode(c, idCursorSort, &id); err != nil {
+		return 0, nil, err
+	}
+	return id, nil, nil
+}
+
+// connectionPage is a validated request for one page of a Relay connection
+type connectionPage struct {
+	size      int
+	fromEnd   bool
+	hasAfter  bool
+	hasBefore bool
+}
+
+// resolvePage validates the Relay pagination arguments of a field against its
+// page limits and translates them into a keyset page in the given order. One
+// row beyond the page size is fetched so trim can tell whether more rows exist
+// in the paging direction
+func resolvePage(codec *cursor.Codec, field string, limit PageLimit, orderBy string, first *int32, after *string, last *int32, before *string) (*models.Page, connectionPage, error) {
+	if first != nil && last != nil {
+		return nil, connectionPage{}, invalidInput("first and last cannot be used together")
+	}
+
+	req := connectionPage{size: limit.Default, hasAfter: after != nil, hasBefore: before != nil}
+	if first != nil {
+		req.size = int(*first)
+	}
+	if last != nil {
+		req.size = int(*last)
+		req.fromEnd = true
+	}
+	if req.size < 0 {
+		return nil, connectionPage{}, invalidInput("%s: page size must not be negative", field)
+	}
+	if err := limit.check(field, req.size); err != nil {
+		return nil, connectionPage{}, err
+	}
+
+	page := &models.Page{OrderBy: orderBy, Limit: req.size + 1, FromEnd: req.fromEnd}
+	if after != nil {
+		id, price, err := decodeCursor(codec, page, *after)
+		if err != nil {
+			return nil, connectionPage{}, invalidInput("invalid after cursor: %w", err)
+		}
+		page.AfterID, page.AfterPrice = &id, price
+	}
+	if before != nil {
+		id, price, err := decodeCursor(codec, page, *before)
+		if err != nil {
+			return nil, connectionPage{}, invalidInput("invalid before cursor: %w", err)
+		}
+		page.BeforeID, page.BeforePrice = &id, price
+	}
+
+	return page, req, nil
+}
+
+// trim returns the bounds of the fetched rows belonging to the page and the
+// resulting page info flags. Rows on the far side of a cursor are only known to
+// exist, so the flag facing a cursor is set whenever the cursor is given
+func (p connectionPage) trim(fetched int) (start, end int, hasPrevious, hasNext bool) {
+	start, end = 0, fetched
+	hasMore := fetched > p.size
+	if p.fromEnd {
+		if hasMore {
+			start = fetched - p.size
+		}
+		return start, end, hasMore, p.hasBefore
+	}
+
+	if hasMore {
+		end = p.size
+	}
+	return start, end, p.hasAfter, hasMore
+}
+
+// limitOffset validates offset pagination arguments of a field against its
+// page limits, applying the default page size when no limit is given
+func (l PageLimits) limitOffset(field string, limit, offset *int32) (int, int, error) {
+	size, err := l.size(field, limit)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var skip int
+	if offset != nil {
+		skip = int(*offset)
+		if skip < 0 {
+			return 0, 0, invalidInput("offset must not be negative")
+		}
+	}
+
+	return size, skip, nil
+}
+
+// PageInfoResolver describes the page of a Relay connection
+type PageInfoResolver struct {
+	hasPreviousPage bool
+	hasNextPage     bool
+	startCursor     *string
+	endCursor       *string
+}
+
+func (r *PageInfoResolver) HasPreviousPage() bool {
+	return r.hasPreviousPage
+}
+
+func (r *PageInfoResolver) HasNextPage() bool {
+	return r.hasNextPage
+}
+
+func (r *PageInfoResolver) StartCursor() *string {
+	return r.startCursor
+}
+
+func (r *PageInfoResolver) EndCursor() *string {
+	return r.endCursor
+}
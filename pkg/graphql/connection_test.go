@@ -0,0 +1,169 @@
+package graphql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/korjavin/graphqlTinyExample/pkg/cursor"
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+	"github.com/korjavin/graphqlTinyExample/pkg/repository"
+)
+
+var testPageLimit = PageLimit{Default: 20, Max: 100}
+
+func TestResolvePage(t *testing.T) {
+	codec := cursor.NewCodec([]byte("secret"))
+	after, _ := codec.Encode(idCursorSort, 42)
+	first := int32(10)
+
+	page, req, err := resolvePage(codec, "listingsConnection", testPageLimit, "", &first, &after, nil, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if page.AfterID == nil || *page.AfterID != 42 {
+		t.Errorf("Expected page after ID 42, got %+v", page.AfterID)
+	}
+	if page.Limit != 11 || page.FromEnd {
+		t.Errorf("Expected a forward page fetching 11 rows, got %+v", page)
+	}
+	if req.size != 10 {
+		t.Errorf("Expected page size 10, got %d", req.size)
+	}
+
+	// Defaults to the first page
+	page, _, err = resolvePage(codec, "listingsConnection", testPageLimit, "", nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if page.Limit != testPageLimit.Default+1 || page.AfterID != nil || page.BeforeID != nil {
+		t.Errorf("Expected the default first page, got %+v", page)
+	}
+}
+
+func TestResolvePagePriceOrder(t *testing.T) {
+	codec := cursor.NewCodec([]byte("secret"))
+	first := int32(10)
+
+	// Price cursors carry the price and ID of the last row
+	after, err := encodeCursor(codec, &models.Page{OrderBy: models.OrderByPriceDesc}, 42, models.Money(1990))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	page, _, err := resolvePage(codec, "listingsConnection", testPageLimit, models.OrderByPriceDesc, &first, &after, nil, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if page.OrderBy != models.OrderByPriceDesc || page.AfterID == nil || *page.AfterID != 42 || page.AfterPrice == nil || *page.AfterPrice != 1990 {
+		t.Errorf("Expected a page after 19.90/42 by descending price, got %+v", page)
+	}
+
+	// Cursors don't carry over to another order
+	idCursor, _ := codec.Encode(idCursorSort, 42)
+	if _, _, err := resolvePage(codec, "listingsConnection", testPageLimit, models.OrderByPriceDesc, &first, &idCursor, nil, nil); err == nil {
+		t.Errorf("Expected an ID cursor to be rejected for a price order")
+	}
+	if _, _, err := resolvePage(codec, "listingsConnection", testPageLimit, models.OrderByPriceAsc, &first, &after, nil, nil); err == nil {
+		t.Errorf("Expected a descending price cursor to be rejected for an ascending order")
+	}
+}
+
+func TestResolvePageErrors(t *testing.T) {
+	codec := cursor.NewCodec([]byte("secret"))
+	forged, _ := cursor.NewCodec([]byte("other")).Encode(idCursorSort, 1)
+	size := int32(5)
+	tooLarge := int32(testPageLimit.Max + 1)
+
+	tests := []struct {
+		name   string
+		first  *int32
+		after  *string
+		last   *int32
+		before *string
+	}{
+		{"first and last", &size, nil, &size, nil},
+		{"page too large", &tooLarge, nil, nil, nil},
+		{"forged cursor", nil, nil, &size, &forged},
+	}
+
+	for _, tt := range tests {
+		if _, _, err := resolvePage(codec, "listingsConnection", testPageLimit, "", tt.first, tt.after, tt.last, tt.before); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestConnectionPageTrim(t *testing.T) {
+	tests := []struct {
+		name                 string
+		req                  connectionPage
+		fetched              int
+		start, end           int
+		hasPrevious, hasNext bool
+	}{
+		{"forward with more", connectionPage{size: 3}, 4, 0, 3, false, true},
+		{"forward last page after cursor", connectionPage{size: 3, hasAfter: true}, 2, 0, 2, true, false},
+		{"backward with more", connectionPage{size: 3, fromEnd: true}, 4, 1, 4, true, false},
+		{"backward before cursor", connectionPage{size: 3, fromEnd: true, hasBefore: true}, 3, 0, 3, false, true},
+	}
+
+	for _, tt := range tests {
+		start, end, hasPrevious, hasNext := tt.req.trim(tt.fetched)
+		if start != tt.start || end != tt.end || hasPrevious != tt.hasPrevious || hasNext != tt.hasNext {
+			t.Errorf("%s: got [%d:%d] previous=%v next=%v", tt.name, start, end, hasPrevious, hasNext)
+		}
+	}
+}
+
+func TestPageLimitsLimitOffset(t *testing.T) {
+	limits := PageLimits{PageLimit: PageLimit{Default: 25, Max: 50}}
+	limit, offset := int32(20), int32(40)
+
+	size, skip, err := limits.limitOffset("purchases", &limit, &offset)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if size != 20 || skip != 40 {
+		t.Errorf("Expected limit 20 offset 40, got %d %d", size, skip)
+	}
+
+	// The default applies when no limit is given
+	if size, _, _ := limits.limitOffset("purchases", nil, nil); size != 25 {
+		t.Errorf("Expected the default page size 25, got %d", size)
+	}
+
+	tooLarge, negative := int32(51), int32(-1)
+	if _, _, err := limits.limitOffset("purchases", &tooLarge, nil); err == nil {
+		t.Error("Expected an error for a limit above the maximum")
+	}
+	if _, _, err := limits.limitOffset("purchases", nil, &negative); err == nil {
+		t.Error("Expected an error for a negative offset")
+	}
+}
+
+func TestListingsConnectionRejectsUnsupportedOrder(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock database: %v", err)
+	}
+	defer db.Close()
+	r := NewResolver(repository.NewRepository(db))
+
+	// Only ID and price orders have cursors; others are refused, not paged by ID
+	for _, orderBy := range []string{models.OrderByPopularity, models.OrderByTitleAsc, models.OrderByCreatedAtDesc} {
+		_, err := r.ListingsConnection(context.Background(), struct {
+			Filter  *ListingFilterInput
+			OrderBy *string
+			First   *int32
+			After   *string
+			Last    *int32
+			Before  *string
+		}{OrderBy: &orderBy})
+		if errorCode(err) != CodeInvalidInput {
+			t.Errorf("%s: expected %s, got %v", orderBy, CodeInvalidInput, err)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package graphql
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var relativePeriodPattern = regexp.MustCompile(`^last(\d+)([hdw])$`)
+
+// parseDateFilter parses a date filter value, which is either an RFC3339 timestamp
+// or a relative shorthand ("today", "yesterday", "thisWeek", "thisMonth", "thisYear",
+// "last24h", "last7d", "last2w"). Shorthands resolve to the start of the period,
+// or to its end when used as the upper bound of a range.
+func parseDateFilter(value string, upperBound bool, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	var start, end time.Time
+	switch value {
+	case "today":
+		start, end = startOfDay, startOfDay.AddDate(0, 0, 1)
+	case "yesterday":
+		start, end = startOfDay.AddDate(0, 0, -1), startOfDay
+	case "thisWeek":
+		// Weeks start on Monday
+		offset := (int(now.Weekday()) + 6) % 7
+		start = startOfDay.AddDate(0, 0, -offset)
+		end = start.AddDate(0, 0, 7)
+	case "thisMonth":
+		start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		end = start.AddDate(0, 1, 0)
+	case "thisYear":
+		start = time.Date(now.Year(), 1, 1, 0, 0, 0, 0, now.Location())
+		end = start.AddDate(1, 0, 0)
+	default:
+		match := relativePeriodPattern.FindStringSubmatch(value)
+		if match == nil {
+			return time.Time{}, invalidInput("invalid date %q: expected RFC3339 or a relative period", value)
+		}
+		n, err := strconv.Atoi(match[1])
+		unit := map[string]time.Duration{"h": time.Hour, "d": 24 * time.Hour, "w": 7 * 24 * time.Hour}[match[2]]
+		// Longer periods overflow time.Duration
+		if err != nil || n > math.MaxInt64/int(unit) {
+			return time.Time{}, invalidInput("invalid date %q: relative period is too long", value)
+		}
+		start, end = now.Add(-time.Duration(n)*unit), now
+	}
+
+	if upperBound {
+		// Period ends are exclusive, filters compare inclusively
+		if end.Equal(now) {
+			return now, nil
+		}
+		return end.Add(-time.Nanosecond), nil
+	}
+	return start, nil
+}
+
+// parseAsOf parses the asOf argument of time-travel queries like a date filter.
+// Relative periods resolve to their end, so "yesterday" means the state at the
+// end of yesterday. It returns nil when no time is given
+func parseAsOf(value *string, now time.Time) (*time.Time, error) {
+	if value == nil {
+		return nil, nil
+	}
+	asOf, err := parseDateFilter(*value, true, now)
+	if err != nil {
+		return nil, err
+	}
+	return &asOf, nil
+}
@@ -0,0 +1,76 @@
+package graphql
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDateFilter(t *testing.T) {
+	// Wednesday
+	now := time.Date(2025, 4, 16, 15, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		value      string
+		upperBound bool
+		expected   time.Time
+	}{
+		{"2025-04-01T00:00:00Z", false, time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"today", false, time.Date(2025, 4, 16, 0, 0, 0, 0, time.UTC)},
+		{"today", true, time.Date(2025, 4, 17, 0, 0, 0, -1, time.UTC)},
+		{"yesterday", false, time.Date(2025, 4, 15, 0, 0, 0, 0, time.UTC)},
+		{"thisWeek", false, time.Date(2025, 4, 14, 0, 0, 0, 0, time.UTC)},
+		{"thisMonth", false, time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"thisMonth", true, time.Date(2025, 5, 1, 0, 0, 0, -1, time.UTC)},
+		{"thisYear", false, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"last7d", false, now.AddDate(0, 0, -7)},
+		{"last7d", true, now},
+		{"last24h", false, now.Add(-24 * time.Hour)},
+		{"last2w", false, now.AddDate(0, 0, -14)},
+	}
+
+	for _, tt := range tests {
+		got, err := parseDateFilter(tt.value, tt.upperBound, now)
+		if err != nil {
+			t.Errorf("parseDateFilter(%q) returned error: %v", tt.value, err)
+			continue
+		}
+		if !got.Equal(tt.expected) {
+			t.Errorf("parseDateFilter(%q, %v) = %s, expected %s", tt.value, tt.upperBound, got, tt.expected)
+		}
+	}
+
+	for _, value := range []string{"", "lastweek", "last7x", "2025-04-01"} {
+		if _, err := parseDateFilter(value, false, now); err == nil {
+			t.Errorf("Expected error for %q", value)
+		}
+	}
+}
+
+func FuzzParseDateFilter(f *testing.F) {
+	for _, value := range []string{"today", "thisWeek", "last24h", "last7d", "last2w", "2025-04-01T00:00:00Z", "last9999999999999999999h", "last0d", ""} {
+		f.Add(value)
+	}
+	now := time.Date(2025, 4, 16, 15, 30, 0, 0, time.UTC)
+
+	f.Fuzz(func(t *testing.T, value string) {
+		lower, lowerErr := parseDateFilter(value, false, now)
+		upper, upperErr := parseDateFilter(value, true, now)
+		if (lowerErr == nil) != (upperErr == nil) {
+			t.Fatalf("%q: bounds disagree on validity: %v, %v", value, lowerErr, upperErr)
+		}
+		if lowerErr != nil {
+			return
+		}
+
+		// Timestamps are taken as given, periods must contain now or lie before it
+		if _, err := time.Parse(time.RFC3339, value); err == nil {
+			return
+		}
+		if lower.After(now) {
+			t.Errorf("%q: period starts after now: %s", value, lower)
+		}
+		if upper.Before(lower) {
+			t.Errorf("%q: period ends before it starts: %s < %s", value, upper, lower)
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package graphql
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/id"
+	"github.com/korjavin/graphqlTinyExample/pkg/rates"
+	"github.com/korjavin/graphqlTinyExample/pkg/repository"
+	"github.com/korjavin/graphqlTinyExample/pkg/service"
+)
+
+// Codes reported in the "code" extension of GraphQL errors
+const (
+	CodeNotFound           = "NOT_FOUND"
+	CodeInvalidInput       = "INVALID_INPUT"
+	CodeConflict           = "CONFLICT"
+	CodeQuotaExceeded      = "QUOTA_EXCEEDED"
+	CodeForbidden          = "FORBIDDEN"
+	CodeTooComplex         = "TOO_COMPLEX"
+	CodeInternal           = "INTERNAL"
+	CodeServiceUnavailable = "SERVICE_UNAVAILABLE"
+)
+
+// Error is a resolver error with a machine-readable code, which graphql-go
+// reports as extensions.code of the GraphQL error
+type Error struct {
+	Code string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Extensions implements the interface graphql-go uses to fill in extensions
+func (e *Error) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": e.Code}
+}
+
+// invalidInput returns an INVALID_INPUT error formatted like fmt.Errorf
+func invalidInput(format string, args ...interface{}) error {
+	return &Error{Code: CodeInvalidInput, Err: fmt.Errorf(format, args...)}
+}
+
+// forbidden returns a FORBIDDEN error formatted like fmt.Errorf
+func forbidden(format string, args ...interface{}) error {
+	return &Error{Code: CodeForbidden, Err: fmt.Errorf(format, args...)}
+}
+
+// notFound returns a NOT_FOUND error formatted like fmt.Errorf
+func notFound(format string, args ...interface{}) error {
+	return &Error{Code: CodeNotFound, Err: fmt.Errorf(format, args...)}
+}
+
+// errorCode classifies an error returned by a resolver. Errors the resolvers
+// didn't attach a code to are classified by what they wrap, falling back to
+// INTERNAL
+func errorCode(err error) string {
+	var coded *Error
+	var inputErr *service.InputError
+	var idErr *id.FormatError
+	switch {
+	case errors.As(err, &coded):
+		return coded.Code
+	case errors.As(err, &inputErr):
+		return inputErr.Code
+	case errors.As(err, &idErr), errors.Is(err, rates.ErrUnsupportedCurrency):
+		return CodeInvalidInput
+	case errors.Is(err, sql.ErrNoRows):
+		return CodeNotFound
+	case errors.Is(err, repository.ErrUnavailable):
+		return CodeServiceUnavailable
+	default:
+		return CodeInternal
+	}
+}
@@ -0,0 +1,51 @@
+package graphql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/id"
+	"github.com/korjavin/graphqlTinyExample/pkg/repository"
+	"github.com/korjavin/graphqlTinyExample/pkg/service"
+)
+
+func TestErrorCode(t *testing.T) {
+	_, idErr := id.ParseListingID("abc")
+
+	tests := []struct {
+		name string
+		err  error
+		code string
+	}{
+		{"coded", notFound("seller not found: %d", 1), CodeNotFound},
+		{"service input error", &service.InputError{Code: service.CodeConflict, Message: "conflict"}, CodeConflict},
+		{"malformed ID", idErr, CodeInvalidInput},
+		{"missing row", fmt.Errorf("failed to load: %w", sql.ErrNoRows), CodeNotFound},
+		{"database down", fmt.Errorf("%w: dial tcp: connection refused", repository.ErrUnavailable), CodeServiceUnavailable},
+		{"anything else", errors.New("connection refused"), CodeInternal},
+	}
+
+	for _, tt := range tests {
+		if code := errorCode(tt.err); code != tt.code {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.code, code)
+		}
+	}
+}
+
+func TestErrorsCarryCodeExtension(t *testing.T) {
+	schema, err := GetSchema(NewResolver(nil))
+	if err != nil {
+		t.Fatalf("Failed to parse schema: %v", err)
+	}
+
+	resp := schema.Exec(context.Background(), `{ listing(id: "abc") { id } }`, "", nil)
+	if len(resp.Errors) != 1 {
+		t.Fatalf("Expected one error, got %v", resp.Errors)
+	}
+	if code := resp.Errors[0].Extensions["code"]; code != CodeInvalidInput {
+		t.Errorf("Expected code %s, got %v", CodeInvalidInput, code)
+	}
+}
@@ -0,0 +1,112 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/errors"
+)
+
+// Handler serves GraphQL operations over HTTP like relay.Handler, adding the
+// time the server spent on each operation to the response extensions. Fields
+// are checked against the role directives of the schema before running. Queries
+// using @defer or @stream are answered with a multipart/mixed response if the
+// client accepts one
+type Handler struct {
+	Schema *graphql.Schema
+	// DisableIntrospection rejects operations selecting __schema or __type
+	DisableIntrospection bool
+	// Complexity, if set, rejects operations over its budget and reports the
+	// cost of every operation in the cost extension
+	Complexity *Complexity
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	var params struct {
+		Query         string                 `json:"query"`
+		OperationName string                 `json:"operationName"`
+		Variables     map[string]interface{} `json:"variables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cost := -1
+	if h.Complexity != nil {
+		// Unparseable queries are left to execution to report
+		if c, err := h.Complexity.Cost(params.Query, params.OperationName, params.Variables); err == nil {
+			cost = c
+		}
+	}
+	finish := func(response *graphql.Response) {
+		SetDuration(response, start)
+		if cost >= 0 {
+			response.Extensions["cost"] = cost
+		}
+	}
+
+	var response *graphql.Response
+	status := http.StatusOK
+	if h.Complexity != nil && h.Complexity.Budget > 0 && cost > h.Complexity.Budget {
+		response = &graphql.Response{Errors: []*errors.QueryError{{
+			Message:    fmt.Sprintf("operation cost %d exceeds the budget of %d", cost, h.Complexity.Budget),
+			Extensions: map[string]interface{}{"code": CodeTooComplex},
+		}}}
+		status = http.StatusBadRequest
+	} else if err := CheckFieldRoles(h.Schema, RoleFromContext(r.Context()), params.Query, params.OperationName, params.Variables); err != nil {
+		response = &graphql.Response{Errors: []*errors.QueryError{{
+			Message:    err.Error(),
+			Extensions: map[string]interface{}{"code": CodeForbidden},
+		}}}
+		status = http.StatusForbidden
+	} else if h.DisableIntrospection && SelectsIntrospection(params.Query, params.OperationName) {
+		response = &graphql.Response{Errors: []*errors.QueryError{{
+			Message:    "introspection is disabled",
+			Extensions: map[string]interface{}{"code": CodeForbidden},
+		}}}
+		status = http.StatusForbidden
+	} else if plan := planIncremental(params.Query, params.OperationName, params.Variables); plan != nil && acceptsMultipart(r) {
+		h.serveIncremental(w, r, plan, params.OperationName, params.Variables, finish)
+		return
+	} else {
+		response = h.Schema.Exec(r.Context(), params.Query, params.OperationName, params.Variables)
+	}
+	finish(response)
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(responseJSON)
+}
+
+// acceptsMultipart reports whether the client accepts multipart/mixed
+// responses, as clients supporting incremental delivery announce
+func acceptsMultipart(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		if strings.Contains(accept, "multipart/mixed") {
+			return true
+		}
+	}
+	return false
+}
+
+// SetDuration sets the durationMs extension of a response to the milliseconds
+// passed since start, with microsecond precision
+func SetDuration(response *graphql.Response, start time.Time) {
+	if response.Extensions == nil {
+		response.Extensions = make(map[string]interface{})
+	}
+	response.Extensions["durationMs"] = float64(time.Since(start).Microseconds()) / 1000
+}
@@ -0,0 +1,75 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandlerReportsDuration(t *testing.T) {
+	schema, err := GetSchema(NewResolver(nil))
+	if err != nil {
+		t.Fatalf("Failed to parse schema: %v", err)
+	}
+	handler := &Handler{Schema: schema}
+
+	// Failed operations report their duration too
+	req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query": "{ listing(id: \"abc\") { id } }"}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	var response struct {
+		Errors     []json.RawMessage      `json:"errors"`
+		Extensions map[string]interface{} `json:"extensions"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response %s: %v", rec.Body, err)
+	}
+	if len(response.Errors) != 1 {
+		t.Errorf("Expected the resolver error, got %s", rec.Body)
+	}
+	if duration, ok := response.Extensions["durationMs"].(float64); !ok || duration < 0 {
+		t.Errorf("Expected a durationMs extension, got %v", response.Extensions)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`not json`))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid body, got %d", rec.Code)
+	}
+}
+
+func TestHandlerDisableIntrospection(t *testing.T) {
+	config := DefaultSchemaConfig()
+	config.DisableIntrospection = true
+	schema, err := NewSchema(NewResolver(nil), config)
+	if err != nil {
+		t.Fatalf("Failed to parse schema: %v", err)
+	}
+	handler := &Handler{Schema: schema, DisableIntrospection: true}
+
+	req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query": "{ __type(name: \"Seller\") { name } }"}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), `"code":"FORBIDDEN"`) {
+		t.Errorf("Expected introspection to be rejected, got %d %s", rec.Code, rec.Body)
+	}
+
+	// __typename is not introspection of the schema
+	req = httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query": "{ __typename }"}`))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"__typename":"Query"`) {
+		t.Errorf("Expected __typename to be resolved, got %d %s", rec.Code, rec.Body)
+	}
+
+	// Paths other than the HTTP handler don't see the schema either
+	resp := schema.Exec(context.Background(), `{ __schema { queryType { name } } }`, "", nil)
+	if string(resp.Data) != `{}` {
+		t.Errorf("Expected __schema to be hidden, got %s", resp.Data)
+	}
+}
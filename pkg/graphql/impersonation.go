@@ -0,0 +1,49 @@
+package graphql
+
+import (
+	"context"
+	"fmt"
+)
+
+// AdminRole is the role allowed to impersonate other roles
+const AdminRole = "admin"
+
+// Impersonation describes an admin running operations as another caller for
+// support debugging, e.g. as seller 3. Role is the impersonated role, which
+// replaces the admin's role for the whitelist and resolvers, and Subject
+// identifies the impersonated seller or buyer
+type Impersonation struct {
+	Role    string
+	Subject string
+}
+
+type impersonationKey struct{}
+
+// NewImpersonation validates that a caller with actorRole may run operations as
+// subject in role. Only admins may impersonate, and never as another admin
+func NewImpersonation(actorRole, role, subject string) (*Impersonation, error) {
+	if actorRole != AdminRole {
+		return nil, fmt.Errorf("role %q is not permitted to impersonate", RoleOrAnonymous(actorRole))
+	}
+	if role == "" || subject == "" {
+		return nil, fmt.Errorf("impersonation requires both a role and a subject")
+	}
+	if role == AdminRole {
+		return nil, fmt.Errorf("cannot impersonate role %q", AdminRole)
+	}
+	return &Impersonation{Role: role, Subject: subject}, nil
+}
+
+// WithImpersonation attaches an impersonation to the context and makes its role
+// the caller's role
+func WithImpersonation(ctx context.Context, impersonation *Impersonation) context.Context {
+	ctx = context.WithValue(ctx, impersonationKey{}, impersonation)
+	return WithRole(ctx, impersonation.Role)
+}
+
+// ImpersonationFromContext returns the impersonation of the request, or nil if
+// the caller acts as themselves
+func ImpersonationFromContext(ctx context.Context) *Impersonation {
+	impersonation, _ := ctx.Value(impersonationKey{}).(*Impersonation)
+	return impersonation
+}
@@ -0,0 +1,37 @@
+package graphql
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNewImpersonation(t *testing.T) {
+	if _, err := NewImpersonation("seller", "buyer", "4"); err == nil {
+		t.Errorf("Expected a non-admin to be denied")
+	}
+	if _, err := NewImpersonation("", "seller", "3"); err == nil {
+		t.Errorf("Expected an anonymous caller to be denied")
+	}
+	if _, err := NewImpersonation(AdminRole, "seller", ""); err == nil {
+		t.Errorf("Expected a missing subject to be rejected")
+	}
+	if _, err := NewImpersonation(AdminRole, AdminRole, "1"); err == nil {
+		t.Errorf("Expected impersonating another admin to be rejected")
+	}
+
+	impersonation, err := NewImpersonation(AdminRole, "seller", "3")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	ctx := WithImpersonation(WithRole(context.Background(), AdminRole), impersonation)
+	if role := RoleFromContext(ctx); role != "seller" {
+		t.Errorf("Expected the impersonated role, got %s", role)
+	}
+	if got := ImpersonationFromContext(ctx); got == nil || got.Subject != "3" {
+		t.Errorf("Expected the impersonation in the context, got %+v", got)
+	}
+	if got := ImpersonationFromContext(context.Background()); got != nil {
+		t.Errorf("Expected no impersonation, got %+v", got)
+	}
+}
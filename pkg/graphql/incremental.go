@@ -0,0 +1,516 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/errors"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/formatter"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+// graphql-go ignores @defer and @stream, so the handler plans incremental
+// delivery itself: deferred fragments are cut out of the initial operation and
+// resolved afterwards by operations of their own, selecting the same path down
+// to the fragment. Lists are resolved in one go, so @stream splits the
+// response of a list rather than its resolution
+
+// multipartBoundary is the boundary of incremental responses, giving the
+// "---" delimiters clients expect
+const multipartBoundary = "-"
+
+// deferredFragment is a fragment marked with @defer and the operation
+// resolving it
+type deferredFragment struct {
+	label string
+	// path holds the response keys leading to the objects the fragment applies to
+	path  []string
+	query string
+}
+
+// streamedField is a list field marked with @stream
+type streamedField struct {
+	label string
+	// path holds the response keys leading to the list, its own key included
+	path         []string
+	initialCount int
+}
+
+// incrementalPlan splits an operation into an initial operation and the parts
+// delivered after it
+type incrementalPlan struct {
+	initial  string
+	deferred []deferredFragment
+	streamed []streamedField
+}
+
+// planIncremental plans the incremental delivery of a query. It returns nil if
+// the query uses neither @defer nor @stream, or can't be parsed, leaving it to
+// regular execution. Nested @defer within a deferred fragment is delivered
+// with the enclosing fragment
+func planIncremental(query, operationName string, variables map[string]interface{}) *incrementalPlan {
+	doc, err := parser.ParseQuery(&ast.Source{Input: query})
+	if err != nil {
+		return nil
+	}
+
+	var op *ast.OperationDefinition
+	if operationName != "" {
+		op = doc.Operations.ForName(operationName)
+	} else if len(doc.Operations) == 1 {
+		op = doc.Operations[0]
+	}
+	if op == nil || op.Operation != ast.Query {
+		return nil
+	}
+
+	p := &planner{op: op, variables: variables, plan: &incrementalPlan{}}
+	selections := p.split(inlineFragments(op.SelectionSet, doc.Fragments, map[string]bool{}), nil, nil)
+	if len(p.plan.deferred) == 0 && len(p.plan.streamed) == 0 {
+		return nil
+	}
+	if len(selections) == 0 {
+		selections = ast.SelectionSet{&ast.Field{Name: "__typename"}}
+	}
+	p.plan.initial = p.format(selections)
+	return p.plan
+}
+
+// planner collects the deferred fragments and streamed fields of an operation
+type planner struct {
+	op        *ast.OperationDefinition
+	variables map[string]interface{}
+	plan      *incrementalPlan
+}
+
+// split returns the selections of the initial operation, recording the
+// deferred fragments and streamed fields below them. ancestors holds the
+// fields and inline fragments leading to the selections
+func (p *planner) split(selections ast.SelectionSet, path []string, ancestors []ast.Selection) ast.SelectionSet {
+	initial := make(ast.SelectionSet, 0, len(selections))
+	for _, selection := range selections {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			field := *selection
+			key := responseKey(selection)
+			if directive := field.Directives.ForName("stream"); directive != nil {
+				field.Directives = withoutDirective(field.Directives, "stream")
+				if p.enabled(directive) {
+					p.plan.streamed = append(p.plan.streamed, streamedField{
+						label:        p.stringArgument(directive, "label"),
+						path:         appendKey(path, key),
+						initialCount: p.intArgument(directive, "initialCount"),
+					})
+				}
+			}
+			if len(field.SelectionSet) > 0 {
+				field.SelectionSet = p.split(field.SelectionSet, appendKey(path, key), append(ancestors, &field))
+				if len(field.SelectionSet) == 0 {
+					field.SelectionSet = ast.SelectionSet{&ast.Field{Name: "__typename"}}
+				}
+			}
+			initial = append(initial, &field)
+
+		case *ast.InlineFragment:
+			fragment := *selection
+			if directive := fragment.Directives.ForName("defer"); directive != nil {
+				fragment.Directives = withoutDirective(fragment.Directives, "defer")
+				if p.enabled(directive) {
+					fragment.SelectionSet = stripIncremental(fragment.SelectionSet)
+					p.plan.deferred = append(p.plan.deferred, deferredFragment{
+						label: p.stringArgument(directive, "label"),
+						path:  path,
+						query: p.format(nest(ancestors, &fragment)),
+					})
+					continue
+				}
+			}
+			fragment.SelectionSet = p.split(fragment.SelectionSet, path, append(ancestors, &fragment))
+			if len(fragment.SelectionSet) > 0 {
+				initial = append(initial, &fragment)
+			}
+		}
+	}
+	return initial
+}
+
+// enabled evaluates the if argument of @defer or @stream
+func (p *planner) enabled(directive *ast.Directive) bool {
+	arg := directive.Arguments.ForName("if")
+	if arg == nil {
+		return true
+	}
+	value, err := arg.Value.Value(p.variables)
+	if err != nil {
+		return true
+	}
+	enabled, ok := value.(bool)
+	return !ok || enabled
+}
+
+func (p *planner) stringArgument(directive *ast.Directive, name string) string {
+	if arg := directive.Arguments.ForName(name); arg != nil {
+		if value, err := arg.Value.Value(p.variables); err == nil {
+			s, _ := value.(string)
+			return s
+		}
+	}
+	return ""
+}
+
+func (p *planner) intArgument(directive *ast.Directive, name string) int {
+	if arg := directive.Arguments.ForName(name); arg != nil {
+		if value, err := arg.Value.Value(p.variables); err == nil {
+			switch n := value.(type) {
+			case int64:
+				return int(n)
+			case float64:
+				return int(n)
+			case json.Number:
+				i, _ := n.Int64()
+				return int(i)
+			}
+		}
+	}
+	return 0
+}
+
+// format prints an operation with the selections, declaring only the
+// variables they use
+func (p *planner) format(selections ast.SelectionSet) string {
+	used := make(map[string]bool)
+	collectVariables(selections, used)
+
+	op := *p.op
+	op.SelectionSet = selections
+	op.VariableDefinitions = nil
+	for _, definition := range p.op.VariableDefinitions {
+		if used[definition.Variable] {
+			op.VariableDefinitions = append(op.VariableDefinitions, definition)
+		}
+	}
+
+	var b strings.Builder
+	formatter.NewFormatter(&b).FormatQueryDocument(&ast.QueryDocument{Operations: ast.OperationList{&op}})
+	return b.String()
+}
+
+// inlineFragments replaces fragment spreads with equivalent inline fragments,
+// so split operations don't carry fragment definitions they no longer use
+func inlineFragments(selections ast.SelectionSet, fragments ast.FragmentDefinitionList, visiting map[string]bool) ast.SelectionSet {
+	inlined := make(ast.SelectionSet, 0, len(selections))
+	for _, selection := range selections {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			field := *selection
+			field.SelectionSet = inlineFragments(field.SelectionSet, fragments, visiting)
+			inlined = append(inlined, &field)
+		case *ast.InlineFragment:
+			fragment := *selection
+			fragment.SelectionSet = inlineFragments(fragment.SelectionSet, fragments, visiting)
+			inlined = append(inlined, &fragment)
+		case *ast.FragmentSpread:
+			definition := fragments.ForName(selection.Name)
+			// Unknown and cyclic fragments are left to validation
+			if definition == nil || visiting[selection.Name] {
+				inlined = append(inlined, selection)
+				continue
+			}
+			visiting[selection.Name] = true
+			inlined = append(inlined, &ast.InlineFragment{
+				TypeCondition: definition.TypeCondition,
+				Directives:    selection.Directives,
+				SelectionSet:  inlineFragments(definition.SelectionSet, fragments, visiting),
+			})
+			delete(visiting, selection.Name)
+		}
+	}
+	return inlined
+}
+
+// stripIncremental removes @defer and @stream from the selections, delivering
+// them with the enclosing part
+func stripIncremental(selections ast.SelectionSet) ast.SelectionSet {
+	stripped := make(ast.SelectionSet, 0, len(selections))
+	for _, selection := range selections {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			field := *selection
+			field.Directives = withoutDirective(field.Directives, "stream")
+			field.SelectionSet = stripIncremental(field.SelectionSet)
+			stripped = append(stripped, &field)
+		case *ast.InlineFragment:
+			fragment := *selection
+			fragment.Directives = withoutDirective(fragment.Directives, "defer")
+			fragment.SelectionSet = stripIncremental(fragment.SelectionSet)
+			stripped = append(stripped, &fragment)
+		default:
+			stripped = append(stripped, selection)
+		}
+	}
+	return stripped
+}
+
+// nest wraps the selection into copies of its ancestors selecting nothing else
+func nest(ancestors []ast.Selection, selection ast.Selection) ast.SelectionSet {
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		switch ancestor := ancestors[i].(type) {
+		case *ast.Field:
+			field := *ancestor
+			field.SelectionSet = ast.SelectionSet{selection}
+			selection = &field
+		case *ast.InlineFragment:
+			fragment := *ancestor
+			fragment.SelectionSet = ast.SelectionSet{selection}
+			selection = &fragment
+		}
+	}
+	return ast.SelectionSet{selection}
+}
+
+func withoutDirective(directives ast.DirectiveList, name string) ast.DirectiveList {
+	var kept ast.DirectiveList
+	for _, directive := range directives {
+		if directive.Name != name {
+			kept = append(kept, directive)
+		}
+	}
+	return kept
+}
+
+// collectVariables records the names of the variables used by the selections
+func collectVariables(selections ast.SelectionSet, used map[string]bool) {
+	for _, selection := range selections {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			for _, arg := range selection.Arguments {
+				collectValueVariables(arg.Value, used)
+			}
+			collectDirectiveVariables(selection.Directives, used)
+			collectVariables(selection.SelectionSet, used)
+		case *ast.InlineFragment:
+			collectDirectiveVariables(selection.Directives, used)
+			collectVariables(selection.SelectionSet, used)
+		case *ast.FragmentSpread:
+			collectDirectiveVariables(selection.Directives, used)
+		}
+	}
+}
+
+func collectDirectiveVariables(directives ast.DirectiveList, used map[string]bool) {
+	for _, directive := range directives {
+		for _, arg := range directive.Arguments {
+			collectValueVariables(arg.Value, used)
+		}
+	}
+}
+
+func collectValueVariables(value *ast.Value, used map[string]bool) {
+	if value == nil {
+		return
+	}
+	if value.Kind == ast.Variable {
+		used[value.Raw] = true
+	}
+	for _, child := range value.Children {
+		collectValueVariables(child.Value, used)
+	}
+}
+
+func responseKey(field *ast.Field) string {
+	if field.Alias != "" {
+		return field.Alias
+	}
+	return field.Name
+}
+
+func appendKey(path []string, key string) []string {
+	return append(append(make([]string, 0, len(path)+1), path...), key)
+}
+
+// incrementalPayload is a part of an incremental response. The initial
+// payload carries data, the later ones incremental results
+type incrementalPayload struct {
+	Data        json.RawMessage        `json:"data,omitempty"`
+	Errors      []*errors.QueryError   `json:"errors,omitempty"`
+	Incremental []incrementalResult    `json:"incremental,omitempty"`
+	HasNext     bool                   `json:"hasNext"`
+	Extensions  map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// incrementalResult holds the fields of a deferred fragment for the object at
+// path, or further items of the list at path starting at the index ending it
+type incrementalResult struct {
+	Data   map[string]interface{} `json:"data,omitempty"`
+	Items  []interface{}          `json:"items,omitempty"`
+	Path   []interface{}          `json:"path"`
+	Label  string                 `json:"label,omitempty"`
+	Errors []*errors.QueryError   `json:"errors,omitempty"`
+}
+
+// serveIncremental executes the plan, writing the initial payload and each
+// deferred fragment as a part of a multipart/mixed response as soon as it is
+// resolved. finish fills in the extensions of the initial payload
+func (h *Handler) serveIncremental(w http.ResponseWriter, r *http.Request, plan *incrementalPlan, operationName string, variables map[string]interface{}, finish func(*graphql.Response)) {
+	ctx := r.Context()
+	response := h.Schema.Exec(ctx, plan.initial, operationName, variables)
+	finish(response)
+
+	data, err := decodeData(response.Data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Items of streamed lists beyond initialCount follow the initial payload
+	var streamed []incrementalPayload
+	for _, field := range plan.streamed {
+		var results []incrementalResult
+		for _, list := range locate(data, field.path[:len(field.path)-1], nil) {
+			object, ok := list.value.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			items, ok := object[field.path[len(field.path)-1]].([]interface{})
+			if !ok || len(items) <= field.initialCount {
+				continue
+			}
+			object[field.path[len(field.path)-1]] = items[:field.initialCount]
+			results = append(results, incrementalResult{
+				Items: items[field.initialCount:],
+				Path:  append(append(list.path, field.path[len(field.path)-1]), field.initialCount),
+				Label: field.label,
+			})
+		}
+		if len(results) > 0 {
+			streamed = append(streamed, incrementalPayload{Incremental: results})
+		}
+	}
+	pending := len(streamed) + len(plan.deferred)
+
+	// Data is only re-encoded if streaming changed it, keeping the field order otherwise
+	initialData := response.Data
+	if len(streamed) > 0 {
+		if initialData, err = json.Marshal(data); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	mw := multipart.NewWriter(w)
+	mw.SetBoundary(multipartBoundary)
+	w.Header().Set("Content-Type", `multipart/mixed; boundary="`+multipartBoundary+`"; deferSpec=20220824`)
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	write := func(payload incrementalPayload) {
+		part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json; charset=utf-8"}})
+		if err != nil {
+			return
+		}
+		json.NewEncoder(part).Encode(payload)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	defer mw.Close()
+
+	// Deferred fragments aren't resolved if the initial operation failed as a whole
+	if data == nil {
+		write(incrementalPayload{Errors: response.Errors, Extensions: response.Extensions})
+		return
+	}
+	write(incrementalPayload{Data: initialData, Errors: response.Errors, HasNext: pending > 0, Extensions: response.Extensions})
+
+	for _, payload := range streamed {
+		pending--
+		payload.HasNext = pending > 0
+		write(payload)
+	}
+
+	results := make(chan []incrementalResult)
+	for _, fragment := range plan.deferred {
+		go func() {
+			results <- h.resolveDeferred(ctx, fragment, operationName, variables)
+		}()
+	}
+	for range plan.deferred {
+		pending--
+		write(incrementalPayload{Incremental: <-results, HasNext: pending > 0})
+	}
+}
+
+// resolveDeferred runs the operation of a deferred fragment, returning its
+// fields for every object the fragment applies to
+func (h *Handler) resolveDeferred(ctx context.Context, fragment deferredFragment, operationName string, variables map[string]interface{}) []incrementalResult {
+	response := h.Schema.Exec(ctx, fragment.query, operationName, variables)
+	data, err := decodeData(response.Data)
+	if err != nil || data == nil {
+		path := make([]interface{}, len(fragment.path))
+		for i, key := range fragment.path {
+			path[i] = key
+		}
+		return []incrementalResult{{Path: path, Label: fragment.label, Errors: response.Errors}}
+	}
+
+	var results []incrementalResult
+	for _, object := range locate(data, fragment.path, nil) {
+		fields, ok := object.value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		// The fragment's fields sit at the end of its path
+		results = append(results, incrementalResult{Data: fields, Path: object.path, Label: fragment.label})
+	}
+	if len(results) > 0 {
+		results[0].Errors = response.Errors
+	}
+	return results
+}
+
+// located is a value found in response data and its path, list indexes included
+type located struct {
+	value interface{}
+	path  []interface{}
+}
+
+// locate returns the values at the response keys of path, descending into
+// every item of the lists on the way
+func locate(value interface{}, path []string, at []interface{}) []located {
+	switch value := value.(type) {
+	case []interface{}:
+		var found []located
+		for i, item := range value {
+			found = append(found, locate(item, path, append(append([]interface{}{}, at...), i))...)
+		}
+		return found
+	case map[string]interface{}:
+		if len(path) == 0 {
+			return []located{{value: value, path: append([]interface{}{}, at...)}}
+		}
+		return locate(value[path[0]], path[1:], append(append([]interface{}{}, at...), path[0]))
+	default:
+		return nil
+	}
+}
+
+// decodeData decodes response data keeping numbers as they were written
+func decodeData(raw json.RawMessage) (map[string]interface{}, error) {
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return nil, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var data map[string]interface{}
+	if err := decoder.Decode(&data); err != nil {
+		return nil, fmt.Errorf("failed to decode response data: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,160 @@
+package graphql
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/graph-gophers/graphql-go"
+)
+
+type incrementalTestQuery struct{}
+
+func (*incrementalTestQuery) Shops() []*incrementalTestShop {
+	return []*incrementalTestShop{{name: "a", items: []int32{1, 2, 3}}, {name: "b", items: []int32{4}}}
+}
+
+type incrementalTestShop struct {
+	name  string
+	items []int32
+}
+
+func (s *incrementalTestShop) Name() string     { return s.name }
+func (s *incrementalTestShop) Items() []int32   { return s.items }
+func (s *incrementalTestShop) Revenue() float64 { return float64(len(s.items)) * 10 }
+
+func newIncrementalTestHandler(t *testing.T) *Handler {
+	schema, err := graphql.ParseSchema(`
+directive @defer(label: String, if: Boolean = true) on FRAGMENT_SPREAD | INLINE_FRAGMENT
+directive @stream(label: String, if: Boolean = true, initialCount: Int = 0) on FIELD
+
+schema {
+  query: Query
+}
+
+type Query {
+  shops: [Shop!]!
+}
+
+type Shop {
+  name: String!
+  items: [Int!]!
+  revenue: Float!
+}
+`, &incrementalTestQuery{})
+	if err != nil {
+		t.Fatalf("Failed to parse schema: %v", err)
+	}
+	return &Handler{Schema: schema}
+}
+
+// readParts reads the JSON parts of a multipart/mixed response
+func readParts(t *testing.T, rec *httptest.ResponseRecorder) []string {
+	t.Helper()
+	mediaType, params, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("Expected a multipart/mixed response, got %q: %s", rec.Header().Get("Content-Type"), rec.Body)
+	}
+
+	var parts []string
+	reader := multipart.NewReader(rec.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return parts
+		}
+		if err != nil {
+			t.Fatalf("Failed to read part: %v", err)
+		}
+		body, _ := io.ReadAll(part)
+		parts = append(parts, strings.TrimSpace(string(body)))
+	}
+}
+
+func TestPlanIncremental(t *testing.T) {
+	plan := planIncremental(`
+query Shops($withRevenue: Boolean!, $unused: Int) {
+  shops {
+    name
+    ...Revenue @defer(label: "revenue", if: $withRevenue)
+  }
+}
+
+fragment Revenue on Shop {
+  revenue
+}`, "Shops", map[string]interface{}{"withRevenue": true})
+	if plan == nil {
+		t.Fatalf("Expected a plan for a deferred fragment")
+	}
+	if strings.Contains(plan.initial, "revenue") || strings.Contains(plan.initial, "$") {
+		t.Errorf("Expected the deferred fragment and its variables to be cut out, got:\n%s", plan.initial)
+	}
+	if len(plan.deferred) != 1 {
+		t.Fatalf("Expected 1 deferred fragment, got %d", len(plan.deferred))
+	}
+	deferred := plan.deferred[0]
+	if deferred.label != "revenue" || len(deferred.path) != 1 || deferred.path[0] != "shops" {
+		t.Errorf("Expected the revenue fragment at shops, got %q at %v", deferred.label, deferred.path)
+	}
+	if strings.Contains(deferred.query, "name") || !strings.Contains(deferred.query, "... on Shop") || strings.Contains(deferred.query, "$") {
+		t.Errorf("Expected the deferred operation to select only the fragment, got:\n%s", deferred.query)
+	}
+
+	// Disabled directives and queries without them execute normally
+	if plan := planIncremental(`query Shops($withRevenue: Boolean!) { shops { ... @defer(if: $withRevenue) { revenue } } }`, "", map[string]interface{}{"withRevenue": false}); plan != nil {
+		t.Errorf("Expected no plan for a disabled @defer, got %+v", plan)
+	}
+	if plan := planIncremental(`{ shops { name } }`, "", nil); plan != nil {
+		t.Errorf("Expected no plan without @defer or @stream, got %+v", plan)
+	}
+}
+
+func TestHandlerIncrementalDelivery(t *testing.T) {
+	handler := newIncrementalTestHandler(t)
+	body := `{"query": "{ shops { name items @stream(initialCount: 1) ... @defer(label: \"revenue\") { revenue } } }"}`
+
+	req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+	req.Header.Set("Accept", "multipart/mixed; deferSpec=20220824, application/json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	parts := readParts(t, rec)
+	if len(parts) != 3 {
+		t.Fatalf("Expected the initial payload, streamed items and the deferred fragment, got %d parts:\n%s", len(parts), strings.Join(parts, "\n"))
+	}
+
+	var initial struct {
+		Data       json.RawMessage        `json:"data"`
+		HasNext    bool                   `json:"hasNext"`
+		Extensions map[string]interface{} `json:"extensions"`
+	}
+	if err := json.Unmarshal([]byte(parts[0]), &initial); err != nil {
+		t.Fatalf("Failed to decode initial payload %s: %v", parts[0], err)
+	}
+	if string(initial.Data) != `{"shops":[{"items":[1],"name":"a"},{"items":[4],"name":"b"}]}` || !initial.HasNext {
+		t.Errorf("Unexpected initial payload %s", parts[0])
+	}
+	if _, ok := initial.Extensions["durationMs"]; !ok {
+		t.Errorf("Expected the initial payload to report its duration, got %s", parts[0])
+	}
+
+	if want := `{"incremental":[{"items":[2,3],"path":["shops",0,"items",1]}],"hasNext":true}`; parts[1] != want {
+		t.Errorf("Expected streamed items %s, got %s", want, parts[1])
+	}
+	if want := `{"incremental":[{"data":{"revenue":30},"path":["shops",0],"label":"revenue"},{"data":{"revenue":10},"path":["shops",1],"label":"revenue"}],"hasNext":false}`; parts[2] != want {
+		t.Errorf("Expected deferred fields %s, got %s", want, parts[2])
+	}
+
+	// Clients not accepting multipart responses get everything at once
+	req = httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if !strings.Contains(rec.Body.String(), `"data":{"shops":[{"name":"a","items":[1,2,3],"revenue":30}`) {
+		t.Errorf("Expected a complete JSON response, got %s", rec.Body)
+	}
+}
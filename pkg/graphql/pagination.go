@@ -0,0 +1,93 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// PageLimit bounds the page size of a list field: Default applies when the
+// client doesn't ask for a size, and Max is the largest size it may ask for
+type PageLimit struct {
+	Default int `json:"default"`
+	Max     int `json:"max"`
+}
+
+// PageLimits holds the page size limits of all paginated fields. Fields
+// overrides them per field name, e.g. "deliveries"; zero values of an override
+// fall back to the global limits
+type PageLimits struct {
+	PageLimit
+	Fields map[string]PageLimit
+}
+
+// DefaultPageLimits returns 25 items per page by default and at most 200.
+// Searches, recommendations, top sellers and the nearest pickup points keep
+// smaller defaults
+func DefaultPageLimits() PageLimits {
+	return PageLimits{
+		PageLimit: PageLimit{Default: 25, Max: 200},
+		Fields: map[string]PageLimit{
+			"search":              {Default: 20},
+			"searchListings":      {Default: 20},
+			"recommendedListings": {Default: 5},
+			"topSellers":          {Default: 10},
+			"nearestPickupPoints": {Default: 5, Max: 50},
+		},
+	}
+}
+
+// ParsePageLimitOverrides parses per-field overrides given as JSON, e.g.
+// {"deliveries": {"default": 50, "max": 500}}
+func ParsePageLimitOverrides(data string) (map[string]PageLimit, error) {
+	var fields map[string]PageLimit
+	if err := json.Unmarshal([]byte(data), &fields); err != nil {
+		return nil, fmt.Errorf("failed to parse page size overrides: %w", err)
+	}
+	for field, limit := range fields {
+		if limit.Default < 0 || limit.Max < 0 {
+			return nil, fmt.Errorf("page size overrides of %s must not be negative", field)
+		}
+	}
+	return fields, nil
+}
+
+// For returns the limits of a field, applying its override
+func (l PageLimits) For(field string) PageLimit {
+	limit := l.PageLimit
+	if override, ok := l.Fields[field]; ok {
+		if override.Default > 0 {
+			limit.Default = override.Default
+		}
+		if override.Max > 0 {
+			limit.Max = override.Max
+		}
+	}
+	if limit.Default > limit.Max {
+		limit.Default = limit.Max
+	}
+	return limit
+}
+
+// size returns the page size of a field for the requested size, or its default
+// if none was requested, rejecting sizes below one or above the maximum
+func (l PageLimits) size(field string, requested *int32) (int, error) {
+	limit := l.For(field)
+	if requested == nil {
+		return limit.Default, nil
+	}
+	if *requested < 1 {
+		return 0, invalidInput("%s: limit must be positive", field)
+	}
+	if err := limit.check(field, int(*requested)); err != nil {
+		return 0, err
+	}
+	return int(*requested), nil
+}
+
+// check returns an error if size exceeds the maximum page size
+func (l PageLimit) check(field string, size int) error {
+	if size > l.Max {
+		return invalidInput("%s: requested %d items but at most %d may be requested per page", field, size, l.Max)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package graphql
+
+import "testing"
+
+func TestPageLimitsFor(t *testing.T) {
+	limits := PageLimits{
+		PageLimit: PageLimit{Default: 25, Max: 200},
+		Fields: map[string]PageLimit{
+			"deliveries":          {Default: 50, Max: 500},
+			"nearestPickupPoints": {Max: 10},
+		},
+	}
+
+	tests := []struct {
+		field    string
+		expected PageLimit
+	}{
+		{"purchases", PageLimit{Default: 25, Max: 200}},
+		{"deliveries", PageLimit{Default: 50, Max: 500}},
+		// The default never exceeds the maximum
+		{"nearestPickupPoints", PageLimit{Default: 10, Max: 10}},
+	}
+
+	for _, tt := range tests {
+		if got := limits.For(tt.field); got != tt.expected {
+			t.Errorf("%s: expected %+v, got %+v", tt.field, tt.expected, got)
+		}
+	}
+}
+
+func TestPageLimitsSize(t *testing.T) {
+	limits := DefaultPageLimits()
+
+	if size, err := limits.size("searchListings", nil); err != nil || size != 20 {
+		t.Errorf("Expected the searchListings default of 20, got %d (%v)", size, err)
+	}
+
+	tooLarge := int32(201)
+	_, err := limits.size("purchases", &tooLarge)
+	if err == nil || err.Error() != "purchases: requested 201 items but at most 200 may be requested per page" {
+		t.Errorf("Expected a page size error naming the maximum, got %v", err)
+	}
+
+	zero := int32(0)
+	if _, err := limits.size("purchases", &zero); err == nil {
+		t.Errorf("Expected a zero limit to be rejected")
+	}
+}
+
+func TestParsePageLimitOverrides(t *testing.T) {
+	fields, err := ParsePageLimitOverrides(`{"deliveries": {"default": 50, "max": 500}}`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if fields["deliveries"] != (PageLimit{Default: 50, Max: 500}) {
+		t.Errorf("Unexpected overrides: %+v", fields)
+	}
+
+	for _, data := range []string{`{"deliveries": 50}`, `{"deliveries": {"max": -1}}`} {
+		if _, err := ParsePageLimitOverrides(data); err == nil {
+			t.Errorf("Expected %s to be rejected", data)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package graphql
+
+import "context"
+
+// Principal is an authenticated caller, e.g. from the auth token of a
+// WebSocket connection. Subject identifies the caller and Role replaces the
+// role set by the gateway
+type Principal struct {
+	Subject string
+	Role    string
+}
+
+type principalKey struct{}
+
+// WithPrincipal attaches an authenticated caller to the context and makes its
+// role the caller's role
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	ctx = context.WithValue(ctx, principalKey{}, principal)
+	return WithRole(ctx, principal.Role)
+}
+
+// PrincipalFromContext returns the authenticated caller, or nil if the request
+// wasn't authenticated
+func PrincipalFromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
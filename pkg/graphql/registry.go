@@ -0,0 +1,132 @@
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// RegistryConfig describes where and how to publish the schema SDL
+type RegistryConfig struct {
+	// Kind selects the registry API: "hive" or "apollo"
+	Kind     string
+	Endpoint string
+	APIKey   string
+	// Graph is the Apollo graph ID (ignored by Hive)
+	Graph   string
+	Variant string
+	Commit  string
+}
+
+const hivePublishMutation = `
+mutation schemaPublish($input: SchemaPublishInput!) {
+  schemaPublish(input: $input) {
+    __typename
+  }
+}
+`
+
+const apolloUploadMutation = `
+mutation UploadSchema($id: ID!, $schemaDocument: String!, $tag: String!, $gitContext: GitContextInput) {
+  service(id: $id) {
+    uploadSchema(schemaDocument: $schemaDocument, tag: $tag, gitContext: $gitContext) {
+      code
+      success
+      message
+    }
+  }
+}
+`
+
+// PublishSchema uploads the schema SDL to the configured registry
+func PublishSchema(cfg RegistryConfig) error {
+	if cfg.Endpoint == "" {
+		return fmt.Errorf("schema registry endpoint is not configured")
+	}
+
+	var payload map[string]interface{}
+	headers := make(map[string]string)
+
+	switch cfg.Kind {
+	case "hive":
+		payload = map[string]interface{}{
+			"query": hivePublishMutation,
+			"variables": map[string]interface{}{
+				"input": map[string]interface{}{
+					"sdl":    Schema,
+					"author": "graphqlTinyExample",
+					"commit": cfg.Commit,
+				},
+			},
+		}
+		headers["Authorization"] = "Bearer " + cfg.APIKey
+	case "apollo":
+		payload = map[string]interface{}{
+			"query": apolloUploadMutation,
+			"variables": map[string]interface{}{
+				"id":             cfg.Graph,
+				"schemaDocument": Schema,
+				"tag":            cfg.Variant,
+				"gitContext": map[string]interface{}{
+					"commit": cfg.Commit,
+				},
+			},
+		}
+		headers["x-api-key"] = cfg.APIKey
+		headers["apollographql-client-name"] = "graphqlTinyExample"
+	default:
+		return fmt.Errorf("unknown schema registry kind: %s", cfg.Kind)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, cfg.Endpoint, bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	log.Printf("[Registry] Publishing schema to %s (%s, variant=%s, commit=%s)",
+		cfg.Endpoint, cfg.Kind, cfg.Variant, cfg.Commit)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("registry responded with %s: %s", resp.Status, string(respBody))
+	}
+
+	var result struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("registry error: %s", result.Errors[0].Message)
+	}
+
+	log.Printf("[Registry] Schema published successfully")
+	return nil
+}
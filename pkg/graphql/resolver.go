@@ -2,52 +2,272 @@ package graphql
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"net/url"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/trace/tracer"
+	"github.com/korjavin/graphqlTinyExample/pkg/cursor"
 	"github.com/korjavin/graphqlTinyExample/pkg/events"
+	"github.com/korjavin/graphqlTinyExample/pkg/fraud"
+	"github.com/korjavin/graphqlTinyExample/pkg/id"
 	"github.com/korjavin/graphqlTinyExample/pkg/models"
+	"github.com/korjavin/graphqlTinyExample/pkg/moderation"
+	"github.com/korjavin/graphqlTinyExample/pkg/rates"
+	"github.com/korjavin/graphqlTinyExample/pkg/receipt"
+	"github.com/korjavin/graphqlTinyExample/pkg/recommend"
 	"github.com/korjavin/graphqlTinyExample/pkg/repository"
+	"github.com/korjavin/graphqlTinyExample/pkg/search"
+	"github.com/korjavin/graphqlTinyExample/pkg/service"
+	"github.com/korjavin/graphqlTinyExample/pkg/stats"
+	"github.com/korjavin/graphqlTinyExample/pkg/tax"
+	"github.com/korjavin/graphqlTinyExample/pkg/views"
+	"github.com/korjavin/graphqlTinyExample/pkg/webhook"
 )
 
 // Resolver is the root resolver for all GraphQL queries
 type Resolver struct {
-	repo     *repository.Repository
-	eventBus *events.EventBus
+	repo        *repository.Repository
+	eventBus    *events.EventBus
+	viewCounter *views.Counter
+	rates       *rates.Cache
+	stats       *stats.Cache
+	reviewer    *fraud.Reviewer
+	cursors     *cursor.Codec
+	indexer     *search.Indexer
+	recommender recommend.Recommender
+	webhooks    *webhook.Dispatcher
+
+	sellers    *service.Sellers
+	listings   *service.Listings
+	purchases  *service.Purchases
+	deliveries *service.Deliveries
+
+	pageLimits   atomic.Pointer[PageLimits]
+	backpressure Backpressure
 }
 
 // NewResolver creates a new resolver with the given repository
 func NewResolver(repo *repository.Repository) *Resolver {
-	return &Resolver{
-		repo:     repo,
-		eventBus: events.NewEventBus(),
+	r := &Resolver{
+		repo:         repo,
+		eventBus:     events.NewEventBus(),
+		viewCounter:  views.NewCounter(repo),
+		stats:        stats.NewCache(repo),
+		recommender:  recommend.Similar{Store: repo},
+		webhooks:     webhook.NewDispatcher(repo),
+		sellers:      service.NewSellers(repo),
+		backpressure: DefaultBackpressure(),
 	}
+	r.SetPageLimits(DefaultPageLimits())
+	r.listings = service.NewListings(repo, moderation.NewWordlist(moderation.DefaultWords, moderation.Reject), r.eventBus)
+	r.purchases = service.NewPurchases(repo, tax.FlatRate{}, r.eventBus, r.purchaseCreated)
+	r.purchases.SetCanceledHandler(r.webhooks.PurchaseCanceled)
+	r.deliveries = service.NewDeliveries(repo, r.eventBus)
+	r.SetFraudChecker(fraud.ApproveAll{})
+	// crypto/rand never fails since Go 1.24, so a random codec is always available
+	r.cursors, _ = cursor.NewRandomCodec()
+	return r
 }
 
-// Schema loads the GraphQL schema from the schema.graphql file
+// ViewCounter returns the buffer of listing views, which the caller must flush periodically
+func (r *Resolver) ViewCounter() *views.Counter {
+	return r.viewCounter
+}
+
+// StatsCache returns the statistics cache, which the caller must refresh periodically
+func (r *Resolver) StatsCache() *stats.Cache {
+	return r.stats
+}
+
+// SetTaxCalculator sets the calculator used to charge tax on new purchases
+func (r *Resolver) SetTaxCalculator(calc tax.Calculator) {
+	r.purchases.SetTaxCalculator(calc)
+}
+
+// SetFraudChecker sets the checker used to screen new purchases
+func (r *Resolver) SetFraudChecker(checker fraud.Checker) {
+	r.reviewer = fraud.NewReviewer(checker, r.repo, r.purchaseReviewed)
+}
+
+// purchaseCreated queues a new purchase for review and notifies its seller
+func (r *Resolver) purchaseCreated(purchase *models.Purchase) {
+	// Screen the purchase asynchronously; it stays PENDING_REVIEW until then
+	r.reviewer.Submit(purchase)
+	r.webhooks.PurchaseCreated(purchase)
+}
+
+// purchaseReviewed settles the order of a reviewed purchase and notifies subscribers
+func (r *Resolver) purchaseReviewed(purchase *models.Purchase) {
+	updated, err := r.purchases.Reviewed(context.Background(), purchase)
+	if err != nil {
+		log.Printf("[GraphQL] Error settling order of purchase ID %d: %v", purchase.ID, err)
+		updated = purchase
+	}
+	r.eventBus.PublishPurchaseReviewed(updated)
+}
+
+// FraudReviewer returns the purchase review worker, which the caller must run in the background
+func (r *Resolver) FraudReviewer() *fraud.Reviewer {
+	return r.reviewer
+}
+
+// WebhookDispatcher returns the seller webhook sender, which the caller must run in the background
+func (r *Resolver) WebhookDispatcher() *webhook.Dispatcher {
+	return r.webhooks
+}
+
+// SetMaxSubscribersPerPurchase limits concurrent subscriptions watching a single purchase; zero means unlimited
+func (r *Resolver) SetMaxSubscribersPerPurchase(max int) {
+	r.eventBus.SetMaxSubscribersPerPurchase(max)
+}
+
+// SetBackpressure sets how many live events are buffered for a slow subscriber
+// and what happens once its buffer is full
+func (r *Resolver) SetBackpressure(backpressure Backpressure) {
+	r.backpressure = backpressure
+}
+
+// SetExchangeRates sets the cache used by currency conversion fields; the caller must keep it refreshed
+func (r *Resolver) SetExchangeRates(cache *rates.Cache) {
+	r.rates = cache
+}
+
+// SetPageLimits sets the default and maximum page sizes of paginated fields.
+// It may be called while requests are served
+func (r *Resolver) SetPageLimits(limits PageLimits) {
+	r.pageLimits.Store(&limits)
+}
+
+// limits returns the current page limits
+func (r *Resolver) limits() PageLimits {
+	return *r.pageLimits.Load()
+}
+
+// SetSearchIndexer makes searchListings query the indexer's search backend instead
+// of the database and pushes listing changes to it; the caller must run the indexer
+func (r *Resolver) SetSearchIndexer(indexer *search.Indexer) {
+	r.indexer = indexer
+	if indexer != nil {
+		r.listings.SetObserver(indexer)
+	}
+}
+
+// SetRecommender sets the engine behind recommendedListings
+func (r *Resolver) SetRecommender(recommender recommend.Recommender) {
+	r.recommender = recommender
+}
+
+// SetMaxListingsPerSeller sets the number of listings sellers without a quota
+// of their own may have; zero means unlimited
+func (r *Resolver) SetMaxListingsPerSeller(max int) {
+	r.listings.SetMaxListings(max)
+}
+
+// SetModerationChecker sets the checker screening listing titles and descriptions
+func (r *Resolver) SetModerationChecker(checker moderation.Checker) {
+	r.listings.SetModerationChecker(checker)
+}
+
+// SetCursorKey sets the secret signing pagination cursors. Without it cursors are
+// signed with a random key and become invalid when the server restarts
+func (r *Resolver) SetCursorKey(key []byte) {
+	r.cursors = cursor.NewCodec(key)
+}
+
+// GetSchema parses the schema for the resolver with the default options
 func GetSchema(resolver *Resolver) (*graphql.Schema, error) {
+	return NewSchema(resolver, DefaultSchemaConfig())
+}
+
+// SchemaConfig holds the execution options of the schema
+type SchemaConfig struct {
+	// SubscribeResolverTimeout bounds how long a subscriber may take to accept
+	// an event before it is dropped
+	SubscribeResolverTimeout time.Duration
+	// MaxParallelism limits how many resolvers of a single request may run
+	// concurrently, protecting the database pool from large list queries
+	MaxParallelism int
+	// MaxDepth rejects queries nested deeper than it; zero means unlimited
+	MaxDepth int
+	// Tracer traces operations and fields in addition to the built-in
+	// instrumentation tracer, e.g. graphql-go's trace/otel tracer; nil adds none
+	Tracer tracer.Tracer
+	// DisableIntrospection hides __schema and __type from every operation. The
+	// HTTP handler rejects such operations outright with its own option
+	DisableIntrospection bool
+	// KnownClients are the clients operation metrics are broken down by; all
+	// others are labeled "other"
+	KnownClients KnownClients
+}
+
+// DefaultSchemaConfig returns a 60 second subscription timeout, the graphql-go
+// default parallelism of 10 and no depth limit
+func DefaultSchemaConfig() SchemaConfig {
+	return SchemaConfig{
+		SubscribeResolverTimeout: 60 * time.Second,
+		MaxParallelism:           10,
+	}
+}
+
+// NewSchema parses the schema for the resolver with the given options
+func NewSchema(resolver *Resolver, config SchemaConfig) (*graphql.Schema, error) {
 	schemaString := Schema
-	schema, err := graphql.ParseSchema(schemaString, resolver,
+	observer := &instrumentationTracer{clients: config.KnownClients}
+	var t tracer.Tracer = observer
+	if config.Tracer != nil {
+		// The instrumentation tracer finishes first, so the other one sees the error codes it assigns
+		t = chainTracer{config.Tracer, observer}
+	}
+
+	opts := []graphql.SchemaOpt{
 		graphql.UseStringDescriptions(),
-		graphql.SubscribeResolverTimeout(60*time.Second),
-	)
+		graphql.Tracer(t),
+	}
+	if config.SubscribeResolverTimeout > 0 {
+		opts = append(opts, graphql.SubscribeResolverTimeout(config.SubscribeResolverTimeout))
+	}
+	if config.MaxParallelism > 0 {
+		opts = append(opts, graphql.MaxParallelism(config.MaxParallelism))
+	}
+	if config.MaxDepth > 0 {
+		opts = append(opts, graphql.MaxDepth(config.MaxDepth))
+	}
+	if config.DisableIntrospection {
+		opts = append(opts, graphql.RestrictIntrospection(func(context.Context) bool { return false }))
+	}
+
+	schema, err := graphql.ParseSchema(schemaString, resolver, opts...)
 	if err != nil {
 		return nil, err
 	}
+	observer.deprecated = deprecatedFields(schema.AST())
 	return schema, nil
 }
 
 // Seller resolver
 type SellerResolver struct {
-	seller *models.Seller
-	repo   *repository.Repository
+	seller     *models.Seller
+	repo       *repository.Repository
+	rates      *rates.Cache
+	pageLimits PageLimits
+
+	// salesOnce fetches the lifetime sales once for totalSales and totalRevenue
+	salesOnce sync.Once
+	sales     *models.SalesSummary
+	salesErr  error
 }
 
 func (r *SellerResolver) ID() graphql.ID {
-	return graphql.ID(strconv.Itoa(r.seller.ID))
+	return graphql.ID(id.FormatSellerID(r.seller.ID))
 }
 
 func (r *SellerResolver) Name() string {
@@ -58,48 +278,171 @@ func (r *SellerResolver) Address() string {
 	return r.seller.Address
 }
 
-func (r *SellerResolver) Listings() ([]*ListingResolver, error) {
-	log.Printf("[GraphQL] Fetching listings for seller ID: %d", r.seller.ID)
+func (r *SellerResolver) DigestOptIn() bool {
+	return r.seller.DigestOptIn
+}
+
+// Listings returns a page of the seller's listings ordered by ID
+func (r *SellerResolver) Listings(args struct {
+	Limit  *int32
+	Offset *int32
+}) ([]*ListingResolver, error) {
+	limit, offset, err := r.pageLimits.limitOffset("Seller.listings", args.Limit, args.Offset)
+	if err != nil {
+		return nil, err
+	}
 
 	sellerID := r.seller.ID
 	filter := &models.ListingFilter{
 		SellerID: &sellerID,
+		Limit:    limit,
+		Offset:   offset,
 	}
 
 	listings, err := r.repo.GetListings(filter)
 	if err != nil {
-		log.Printf("[GraphQL] Error fetching listings: %v", err)
 		return nil, err
 	}
 
-	var resolvers []*ListingResolver
-	for _, listing := range listings {
-		resolvers = append(resolvers, &ListingResolver{listing: listing, repo: r.repo})
+	return newListingResolvers(listings, r.repo, r.rates, r.pageLimits), nil
+}
+
+func (r *SellerResolver) TotalSales() (int32, error) {
+	sales, err := r.lifetimeSales()
+	if err != nil {
+		return 0, err
 	}
+	return int32(sales.Sales), nil
+}
 
-	return resolvers, nil
+func (r *SellerResolver) TotalRevenue() (models.Money, error) {
+	sales, err := r.lifetimeSales()
+	if err != nil {
+		return 0, err
+	}
+	return sales.Revenue, nil
+}
+
+// lifetimeSales aggregates all sales of the seller
+func (r *SellerResolver) lifetimeSales() (*models.SalesSummary, error) {
+	r.salesOnce.Do(func() {
+		r.sales, r.salesErr = r.repo.GetSellerSales(r.seller.ID, nil, nil)
+		if r.salesErr != nil {
+			log.Printf("[GraphQL] Error fetching seller sales: %v", r.salesErr)
+		}
+	})
+	return r.sales, r.salesErr
+}
+
+// Sales summary resolver
+type SalesSummaryResolver struct {
+	summary *models.SalesSummary
+	seller  *SellerResolver
+}
+
+func (r *SalesSummaryResolver) Seller() *SellerResolver {
+	return r.seller
+}
+
+func (r *SalesSummaryResolver) From() *string {
+	if r.summary.From == nil {
+		return nil
+	}
+	from := r.summary.From.Format(time.RFC3339)
+	return &from
+}
+
+func (r *SalesSummaryResolver) To() *string {
+	if r.summary.To == nil {
+		return nil
+	}
+	to := r.summary.To.Format(time.RFC3339)
+	return &to
+}
+
+func (r *SalesSummaryResolver) TotalSales() int32 {
+	return int32(r.summary.Sales)
+}
+
+func (r *SalesSummaryResolver) TotalRevenue() models.Money {
+	return r.summary.Revenue
+}
+
+// Seller statistics resolver, reading sales from a statistics cache snapshot
+type SellerStatsResolver struct {
+	summary    *models.SalesSummary
+	snapshot   *stats.Snapshot
+	repo       *repository.Repository
+	rates      *rates.Cache
+	pageLimits PageLimits
+}
+
+func (r *SellerStatsResolver) Seller(ctx context.Context) (*SellerResolver, error) {
+	seller, err := loadSeller(ctx, r.summary.SellerID, r.repo.GetSeller)
+	if err != nil {
+		return nil, err
+	}
+	return &SellerResolver{seller: seller, repo: r.repo, rates: r.rates, pageLimits: r.pageLimits}, nil
+}
+
+func (r *SellerStatsResolver) TotalSales() int32 {
+	return int32(r.summary.Sales)
+}
+
+func (r *SellerStatsResolver) TotalRevenue() models.Money {
+	return r.summary.Revenue
+}
+
+func (r *SellerStatsResolver) RefreshedAt() string {
+	return r.snapshot.RefreshedAt.Format(time.RFC3339)
+}
+
+// Staleness is the age of the statistics in whole seconds
+func (r *SellerStatsResolver) Staleness() int32 {
+	return int32(r.snapshot.Staleness() / time.Second)
+}
+
+// convertPrice converts a price from the base currency using the cached exchange rates
+func convertPrice(cache *rates.Cache, price models.Money, currency string) (models.Money, error) {
+	if cache == nil {
+		return 0, fmt.Errorf("currency conversion is not configured")
+	}
+
+	converted, err := cache.Convert(price.Float64(), currency)
+	if err != nil {
+		return 0, err
+	}
+
+	return models.MoneyFromFloat(converted), nil
 }
 
 // Listing resolver
 type ListingResolver struct {
-	listing *models.Listing
-	repo    *repository.Repository
+	listing    *models.Listing
+	repo       *repository.Repository
+	rates      *rates.Cache
+	pageLimits PageLimits
+	// batch loads nested fields together with the listings returned alongside; nil for a single listing
+	batch *listingBatch
 }
 
 func (r *ListingResolver) ID() graphql.ID {
-	return graphql.ID(strconv.Itoa(r.listing.ID))
+	return graphql.ID(id.FormatListingID(r.listing.ID))
 }
 
-func (r *ListingResolver) Seller() (*SellerResolver, error) {
-	log.Printf("[GraphQL] Fetching seller for listing ID: %d", r.listing.ID)
-
-	seller, err := r.repo.GetSeller(r.listing.SellerID)
+func (r *ListingResolver) Seller(ctx context.Context) (*SellerResolver, error) {
+	var seller *models.Seller
+	var err error
+	if r.batch != nil {
+		seller, err = r.batch.seller(r.listing.SellerID)
+	} else {
+		seller, err = loadSeller(ctx, r.listing.SellerID, r.repo.GetSeller)
+	}
 	if err != nil {
-		log.Printf("[GraphQL] Error fetching seller: %v", err)
 		return nil, err
 	}
 
-	return &SellerResolver{seller: seller, repo: r.repo}, nil
+	return &SellerResolver{seller: seller, repo: r.repo, rates: r.rates, pageLimits: r.pageLimits}, nil
 }
 
 func (r *ListingResolver) Title() string {
@@ -110,565 +453,2539 @@ func (r *ListingResolver) Description() string {
 	return r.listing.Description
 }
 
-func (r *ListingResolver) Price() float64 {
+func (r *ListingResolver) Price() models.Money {
 	return r.listing.Price
 }
 
-func (r *ListingResolver) Purchases() ([]*PurchaseResolver, error) {
-	log.Printf("[GraphQL] Fetching purchases for listing ID: %d", r.listing.ID)
+func (r *ListingResolver) PriceIn(args struct{ Currency string }) (models.Money, error) {
+	return convertPrice(r.rates, r.listing.Price, args.Currency)
+}
+
+func (r *ListingResolver) Views() (int32, error) {
+	var views int64
+	var err error
+	if r.batch != nil {
+		views, err = r.batch.viewsOf(r.listing.ID)
+	} else {
+		views, err = r.repo.GetListingViews(r.listing.ID)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int32(views), nil
+}
 
-	listingID := r.listing.ID
-	filter := &models.PurchaseFilter{
-		ListingID: &listingID,
+func (r *ListingResolver) PriceHistory(args struct {
+	FromDate *string
+	ToDate   *string
+}) ([]*PricePointResolver, error) {
+	now := time.Now()
+	var fromDate, toDate *time.Time
+
+	if args.FromDate != nil {
+		t, err := parseDateFilter(*args.FromDate, false, now)
+		if err != nil {
+			return nil, invalidInput("invalid fromDate: %v", err)
+		}
+		fromDate = &t
 	}
 
-	purchases, err := r.repo.GetPurchases(filter)
+	if args.ToDate != nil {
+		t, err := parseDateFilter(*args.ToDate, true, now)
+		if err != nil {
+			return nil, invalidInput("invalid toDate: %v", err)
+		}
+		toDate = &t
+	}
+
+	points, err := r.repo.GetPriceHistory(r.listing.ID, fromDate, toDate)
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := make([]*PricePointResolver, 0, len(points))
+	for _, point := range points {
+		resolvers = append(resolvers, &PricePointResolver{point: point})
+	}
+
+	return resolvers, nil
+}
+
+// Purchases returns a page of the purchases of the listing ordered by ID
+func (r *ListingResolver) Purchases(args struct {
+	Limit  *int32
+	Offset *int32
+}) ([]*PurchaseResolver, error) {
+	limit, offset, err := r.pageLimits.limitOffset("Listing.purchases", args.Limit, args.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	var purchases []*models.Purchase
+	if r.batch != nil {
+		purchases, err = r.batch.purchasesOf(r.listing.ID, limit, offset)
+	} else {
+		listingID := r.listing.ID
+		purchases, err = r.repo.GetPurchases(&models.PurchaseFilter{
+			ListingID: &listingID,
+			Limit:     limit,
+			Offset:    offset,
+		})
+	}
 	if err != nil {
-		log.Printf("[GraphQL] Error fetching purchases: %v", err)
 		return nil, err
 	}
 
-	var resolvers []*PurchaseResolver
+	resolvers := make([]*PurchaseResolver, 0, len(purchases))
 	for _, purchase := range purchases {
-		resolvers = append(resolvers, &PurchaseResolver{purchase: purchase, repo: r.repo})
+		resolvers = append(resolvers, &PurchaseResolver{purchase: purchase, repo: r.repo, rates: r.rates, pageLimits: r.pageLimits})
 	}
 
 	return resolvers, nil
 }
 
-// Purchase resolver
-type PurchaseResolver struct {
-	purchase *models.Purchase
-	repo     *repository.Repository
+// PricePointResolver resolves a recorded listing price
+type PricePointResolver struct {
+	point *models.PricePoint
 }
 
-func (r *PurchaseResolver) ID() graphql.ID {
-	return graphql.ID(strconv.Itoa(r.purchase.ID))
+func (r *PricePointResolver) Price() models.Money {
+	return r.point.Price
 }
 
-func (r *PurchaseResolver) Listing() (*ListingResolver, error) {
-	log.Printf("[GraphQL] Fetching listing for purchase ID: %d", r.purchase.ID)
+func (r *PricePointResolver) ChangedAt() string {
+	return r.point.ChangedAt.Format(time.RFC3339)
+}
 
-	listing, err := r.repo.GetListing(r.purchase.ListingID)
+// SellerQuotaResolver resolves a listing limit set for a seller
+type SellerQuotaResolver struct {
+	quota *models.SellerQuota
+}
+
+func (r *SellerQuotaResolver) MaxListings() int32 {
+	return int32(r.quota.MaxListings)
+}
+
+func (r *SellerQuotaResolver) UpdatedAt() string {
+	return r.quota.UpdatedAt.Format(time.RFC3339)
+}
+
+// SellerWebhookResolver resolves a registered seller webhook
+type SellerWebhookResolver struct {
+	hook *models.SellerWebhook
+}
+
+func (r *SellerWebhookResolver) URL() string {
+	return r.hook.URL
+}
+
+func (r *SellerWebhookResolver) Secret() string {
+	return r.hook.Secret
+}
+
+func (r *SellerWebhookResolver) CreatedAt() string {
+	return r.hook.CreatedAt.Format(time.RFC3339)
+}
+
+// PickupPointResolver resolves a pickup point
+type PickupPointResolver struct {
+	point *models.PickupPoint
+}
+
+func (r *PickupPointResolver) ID() graphql.ID {
+	return graphql.ID(id.FormatPickupPointID(r.point.ID))
+}
+
+func (r *PickupPointResolver) Name() string {
+	return r.point.Name
+}
+
+func (r *PickupPointResolver) Address() string {
+	return r.point.Address
+}
+
+func (r *PickupPointResolver) Latitude() float64 {
+	return r.point.Latitude
+}
+
+func (r *PickupPointResolver) Longitude() float64 {
+	return r.point.Longitude
+}
+
+func (r *PickupPointResolver) DistanceKm() *float64 {
+	return r.point.DistanceKm
+}
+
+// ReceiptResolver resolves a purchase receipt
+type ReceiptResolver struct {
+	receipt    *receipt.Receipt
+	repo       *repository.Repository
+	rates      *rates.Cache
+	pageLimits PageLimits
+}
+
+func (r *ReceiptResolver) Number() string {
+	return r.receipt.Number
+}
+
+func (r *ReceiptResolver) Purchase() (*PurchaseResolver, error) {
+	purchase, err := r.repo.GetPurchase(r.receipt.PurchaseID)
 	if err != nil {
-		log.Printf("[GraphQL] Error fetching listing: %v", err)
 		return nil, err
 	}
+	return &PurchaseResolver{purchase: purchase, repo: r.repo, rates: r.rates, pageLimits: r.pageLimits}, nil
+}
 
-	return &ListingResolver{listing: listing, repo: r.repo}, nil
+func (r *ReceiptResolver) IssuedAt() string {
+	return r.receipt.IssuedAt.Format(time.RFC3339)
 }
 
-func (r *PurchaseResolver) Price() float64 {
-	return r.purchase.Price
+func (r *ReceiptResolver) SellerName() string {
+	return r.receipt.SellerName
 }
 
-func (r *PurchaseResolver) BankTxId() string {
-	return r.purchase.BankTxID
+func (r *ReceiptResolver) SellerAddress() string {
+	return r.receipt.SellerAddress
 }
 
-func (r *PurchaseResolver) DeliveryAddress() string {
-	return r.purchase.DeliveryAddress
+func (r *ReceiptResolver) DeliveryAddress() string {
+	return r.receipt.DeliveryAddress
 }
 
-func (r *PurchaseResolver) CreatedAt() string {
-	return r.purchase.CreatedAt.Format(time.RFC3339)
+func (r *ReceiptResolver) LineItems() []*ReceiptLineItemResolver {
+	resolvers := make([]*ReceiptLineItemResolver, 0, len(r.receipt.LineItems))
+	for i := range r.receipt.LineItems {
+		resolvers = append(resolvers, &ReceiptLineItemResolver{item: &r.receipt.LineItems[i]})
+	}
+	return resolvers
 }
 
-func (r *PurchaseResolver) Deliveries() ([]*DeliveryResolver, error) {
-	log.Printf("[GraphQL] Fetching deliveries for purchase ID: %d", r.purchase.ID)
+func (r *ReceiptResolver) Taxes() []*ReceiptTaxResolver {
+	resolvers := make([]*ReceiptTaxResolver, 0, len(r.receipt.Taxes))
+	for i := range r.receipt.Taxes {
+		resolvers = append(resolvers, &ReceiptTaxResolver{tax: &r.receipt.Taxes[i]})
+	}
+	return resolvers
+}
 
-	deliveries, err := r.repo.GetDeliveriesByPurchaseID(r.purchase.ID)
-	if err != nil {
-		log.Printf("[GraphQL] Error fetching deliveries: %v", err)
-		return nil, err
+func (r *ReceiptResolver) Subtotal() models.Money {
+	return r.receipt.Subtotal
+}
+
+func (r *ReceiptResolver) TaxTotal() models.Money {
+	return r.receipt.TaxTotal
+}
+
+func (r *ReceiptResolver) Total() models.Money {
+	return r.receipt.Total
+}
+
+func (r *ReceiptResolver) PdfUrl() string {
+	return fmt.Sprintf("/receipts/%s/pdf", id.FormatPurchaseID(r.receipt.PurchaseID))
+}
+
+type ReceiptLineItemResolver struct {
+	item *receipt.LineItem
+}
+
+func (r *ReceiptLineItemResolver) Description() string {
+	return r.item.Description
+}
+
+func (r *ReceiptLineItemResolver) Quantity() int32 {
+	return int32(r.item.Quantity)
+}
+
+func (r *ReceiptLineItemResolver) UnitPrice() models.Money {
+	return r.item.UnitPrice
+}
+
+func (r *ReceiptLineItemResolver) Amount() models.Money {
+	return r.item.Amount
+}
+
+type ReceiptTaxResolver struct {
+	tax *receipt.Tax
+}
+
+func (r *ReceiptTaxResolver) Name() string {
+	return r.tax.Name
+}
+
+func (r *ReceiptTaxResolver) Rate() float64 {
+	return r.tax.Rate
+}
+
+func (r *ReceiptTaxResolver) Amount() models.Money {
+	return r.tax.Amount
+}
+
+// Price statistics resolvers
+type PriceStatsResolver struct {
+	stats *models.PriceStats
+}
+
+func (r *PriceStatsResolver) Count() int32 {
+	return int32(r.stats.Count)
+}
+
+func (r *PriceStatsResolver) Min() *float64 {
+	return r.stats.Min
+}
+
+func (r *PriceStatsResolver) Max() *float64 {
+	return r.stats.Max
+}
+
+func (r *PriceStatsResolver) Avg() *float64 {
+	return r.stats.Avg
+}
+
+func (r *PriceStatsResolver) Median() *float64 {
+	return r.stats.Median
+}
+
+func (r *PriceStatsResolver) Histogram() []*PriceBucketResolver {
+	resolvers := make([]*PriceBucketResolver, 0, len(r.stats.Histogram))
+	for i := range r.stats.Histogram {
+		resolvers = append(resolvers, &PriceBucketResolver{bucket: &r.stats.Histogram[i]})
 	}
+	return resolvers
+}
 
-	var resolvers []*DeliveryResolver
-	for _, delivery := range deliveries {
-		resolvers = append(resolvers, &DeliveryResolver{delivery: delivery, repo: r.repo})
+type PriceBucketResolver struct {
+	bucket *models.PriceBucket
+}
+
+func (r *PriceBucketResolver) Min() float64 {
+	return r.bucket.Min
+}
+
+func (r *PriceBucketResolver) Max() float64 {
+	return r.bucket.Max
+}
+
+func (r *PriceBucketResolver) Count() int32 {
+	return int32(r.bucket.Count)
+}
+
+// Search result resolver; exactly one of the entities is set
+type SearchResultResolver struct {
+	result interface{}
+}
+
+func (r *SearchResultResolver) ToSeller() (*SellerResolver, bool) {
+	seller, ok := r.result.(*SellerResolver)
+	return seller, ok
+}
+
+func (r *SearchResultResolver) ToListing() (*ListingResolver, bool) {
+	listing, ok := r.result.(*ListingResolver)
+	return listing, ok
+}
+
+func (r *SearchResultResolver) ToPurchase() (*PurchaseResolver, bool) {
+	purchase, ok := r.result.(*PurchaseResolver)
+	return purchase, ok
+}
+
+// User error resolver, describing invalid mutation input
+type UserErrorResolver struct {
+	err *service.InputError
+}
+
+func (r *UserErrorResolver) Field() *string {
+	if r.err.Field == "" {
+		return nil
 	}
+	return &r.err.Field
+}
 
-	return resolvers, nil
+func (r *UserErrorResolver) Message() string {
+	return r.err.Message
 }
 
-// Delivery resolver
-type DeliveryResolver struct {
-	delivery *models.Delivery
-	repo     *repository.Repository
+func (r *UserErrorResolver) Code() string {
+	return r.err.Code
+}
+
+// newUserErrors returns the user errors of a mutation payload refusing input
+func newUserErrors(err *service.InputError) []*UserErrorResolver {
+	return []*UserErrorResolver{{err: err}}
 }
 
-func (r *DeliveryResolver) ID() graphql.ID {
-	return graphql.ID(strconv.Itoa(r.delivery.ID))
+// userErrors turns an input error into the user errors of a mutation payload.
+// Any other error is returned as is, to be reported as a GraphQL error
+func userErrors(err error) ([]*UserErrorResolver, error) {
+	var inputErr *service.InputError
+	if !errors.As(err, &inputErr) {
+		return nil, err
+	}
+	return newUserErrors(inputErr), nil
+}
+
+// invalidID reports an ID field of the input that failed to parse
+func invalidID(field string, err error) *service.InputError {
+	return &service.InputError{Field: field, Code: service.CodeInvalidInput, Message: err.Error(), Err: err}
+}
+
+// Seller mutation payload resolver
+type SellerPayloadResolver struct {
+	seller     *SellerResolver
+	userErrors []*UserErrorResolver
+}
+
+func (r *SellerPayloadResolver) Seller() *SellerResolver {
+	return r.seller
+}
+
+func (r *SellerPayloadResolver) UserErrors() []*UserErrorResolver {
+	return r.userErrors
+}
+
+// Listing mutation payload resolver. Within a batch, err holds an unexpected
+// failure of this listing, reported as a GraphQL error on its listing field
+type ListingPayloadResolver struct {
+	listing    *ListingResolver
+	userErrors []*UserErrorResolver
+	err        error
+}
+
+func (r *ListingPayloadResolver) Listing() (*ListingResolver, error) {
+	return r.listing, r.err
+}
+
+func (r *ListingPayloadResolver) UserErrors() []*UserErrorResolver {
+	return r.userErrors
+}
+
+// Purchase mutation payload resolver
+type PurchasePayloadResolver struct {
+	purchase   *PurchaseResolver
+	userErrors []*UserErrorResolver
+}
+
+func (r *PurchasePayloadResolver) Purchase() *PurchaseResolver {
+	return r.purchase
+}
+
+func (r *PurchasePayloadResolver) UserErrors() []*UserErrorResolver {
+	return r.userErrors
+}
+
+// Resolver of the payload of a purchase created together with its initial delivery
+type PurchaseWithDeliveryPayloadResolver struct {
+	purchase   *PurchaseResolver
+	delivery   *DeliveryResolver
+	userErrors []*UserErrorResolver
+}
+
+func (r *PurchaseWithDeliveryPayloadResolver) Purchase() *PurchaseResolver {
+	return r.purchase
+}
+
+func (r *PurchaseWithDeliveryPayloadResolver) Delivery() *DeliveryResolver {
+	return r.delivery
+}
+
+func (r *PurchaseWithDeliveryPayloadResolver) UserErrors() []*UserErrorResolver {
+	return r.userErrors
+}
+
+// Delivery mutation payload resolver
+type DeliveryPayloadResolver struct {
+	delivery   *DeliveryResolver
+	userErrors []*UserErrorResolver
+}
+
+func (r *DeliveryPayloadResolver) Delivery() *DeliveryResolver {
+	return r.delivery
+}
+
+func (r *DeliveryPayloadResolver) UserErrors() []*UserErrorResolver {
+	return r.userErrors
+}
+
+// Purchase cancellation resolver
+type PurchaseCancellationResolver struct {
+	cancellation *models.PurchaseCancellation
+}
+
+func (r *PurchaseCancellationResolver) Reason() *string {
+	if r.cancellation.Reason == "" {
+		return nil
+	}
+	return &r.cancellation.Reason
+}
+
+func (r *PurchaseCancellationResolver) CanceledAt() string {
+	return r.cancellation.CanceledAt.Format(time.RFC3339)
+}
+
+// Purchase statistics resolvers
+type PurchaseStatsResolver struct {
+	stats *models.PurchaseStats
+}
+
+func (r *PurchaseStatsResolver) Count() int32 {
+	return int32(r.stats.Count)
+}
+
+func (r *PurchaseStatsResolver) Revenue() models.Money {
+	return r.stats.Revenue
+}
+
+// DashboardResolver exposes the key figures of the operations dashboard
+type DashboardResolver struct {
+	dashboard *models.Dashboard
+}
+
+func (r *DashboardResolver) OpenPurchases() int32 {
+	return int32(r.dashboard.OpenPurchases)
+}
+
+func (r *DashboardResolver) OutForDelivery() int32 {
+	return int32(r.dashboard.OutForDelivery)
+}
+
+func (r *DashboardResolver) RevenueToday() models.Money {
+	return r.dashboard.RevenueToday
+}
+
+func (r *DashboardResolver) Version() string {
+	return r.dashboard.Version
+}
+
+// Purchase resolver
+type PurchaseResolver struct {
+	purchase   *models.Purchase
+	repo       *repository.Repository
+	rates      *rates.Cache
+	pageLimits PageLimits
+	// asOf limits the deliveries of a purchase fetched as of a past time to
+	// those recorded by then
+	asOf *time.Time
+
+	// latest caches the latest delivery shared by latestDelivery and currentStatus
+	latestOnce sync.Once
+	latest     *models.Delivery
+	latestErr  error
+}
+
+func (r *PurchaseResolver) ID() graphql.ID {
+	return graphql.ID(id.FormatPurchaseID(r.purchase.ID))
+}
+
+func (r *PurchaseResolver) Listing() (*ListingResolver, error) {
+	listing, err := r.repo.GetListing(r.purchase.ListingID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ListingResolver{listing: listing, repo: r.repo, rates: r.rates, pageLimits: r.pageLimits}, nil
+}
+
+func (r *PurchaseResolver) Price() models.Money {
+	return r.purchase.Price
+}
+
+func (r *PurchaseResolver) PriceIn(args struct{ Currency string }) (models.Money, error) {
+	return convertPrice(r.rates, r.purchase.Price, args.Currency)
+}
+
+func (r *PurchaseResolver) Status() string {
+	return purchaseStatusToEnum(r.purchase.Status)
+}
+
+func (r *PurchaseResolver) OrderStatus() string {
+	return orderStatusToEnum(r.purchase.OrderStatus)
+}
+
+func (r *PurchaseResolver) TaxAmount() models.Money {
+	return r.purchase.TaxAmount
+}
+
+func (r *PurchaseResolver) TotalWithTax() models.Money {
+	return r.purchase.Price + r.purchase.TaxAmount
+}
+
+func (r *PurchaseResolver) BankTxId() string {
+	return r.purchase.BankTxID
+}
+
+func (r *PurchaseResolver) DeliveryAddress() string {
+	return r.purchase.DeliveryAddress
+}
+
+func (r *PurchaseResolver) PickupPoint() (*PickupPointResolver, error) {
+	if r.purchase.PickupPointID == nil {
+		return nil, nil
+	}
+
+	point, err := r.repo.GetPickupPoint(*r.purchase.PickupPointID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PickupPointResolver{point: point}, nil
+}
+
+// Cancellation is only looked up for canceled purchases
+func (r *PurchaseResolver) Cancellation() (*PurchaseCancellationResolver, error) {
+	if r.purchase.Cancellation != nil {
+		return &PurchaseCancellationResolver{r.purchase.Cancellation}, nil
+	}
+	if r.purchase.Status != models.PurchaseStatusCanceled {
+		return nil, nil
+	}
+
+	cancellation, err := r.repo.GetPurchaseCancellation(r.purchase.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Canceled before reasons were recorded
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &PurchaseCancellationResolver{cancellation}, nil
+}
+
+func (r *PurchaseResolver) CreatedAt() string {
+	return r.purchase.CreatedAt.Format(time.RFC3339)
+}
+
+// latestDelivery fetches the purchase's most recent delivery once, returning
+// nil if it has none yet
+func (r *PurchaseResolver) latestDelivery() (*models.Delivery, error) {
+	r.latestOnce.Do(func() {
+		if r.asOf != nil {
+			r.latest, r.latestErr = latestDeliveryAsOf(r.repo, r.purchase.ID, *r.asOf)
+			return
+		}
+		r.latest, r.latestErr = r.repo.GetLatestDelivery(r.purchase.ID)
+		if r.latestErr == sql.ErrNoRows {
+			r.latest, r.latestErr = nil, nil
+		}
+	})
+	return r.latest, r.latestErr
+}
+
+// latestDeliveryAsOf returns the most recent delivery of a purchase recorded
+// by the given time, or nil if there was none yet
+func latestDeliveryAsOf(repo *repository.Repository, purchaseID int, asOf time.Time) (*models.Delivery, error) {
+	deliveries, err := repo.GetDeliveries(&models.DeliveryFilter{PurchaseID: &purchaseID, ToDate: &asOf, Limit: 1})
+	if err != nil || len(deliveries) == 0 {
+		return nil, err
+	}
+	return deliveries[0], nil
+}
+
+func (r *PurchaseResolver) LatestDelivery() (*DeliveryResolver, error) {
+	delivery, err := r.latestDelivery()
+	if err != nil {
+		return nil, err
+	}
+	if delivery == nil {
+		return nil, nil
+	}
+	return &DeliveryResolver{delivery: delivery, repo: r.repo, rates: r.rates, pageLimits: r.pageLimits, asOf: r.asOf}, nil
+}
+
+// CurrentStatus is the status of the latest delivery, null before the first one
+func (r *PurchaseResolver) CurrentStatus() (*string, error) {
+	delivery, err := r.latestDelivery()
+	if err != nil {
+		return nil, err
+	}
+	if delivery == nil {
+		return nil, nil
+	}
+	status := deliveryStatusToEnum(delivery.Status)
+	return &status, nil
+}
+
+// Deliveries returns a page of the delivery updates of the purchase, latest first
+func (r *PurchaseResolver) Deliveries(args struct {
+	Limit  *int32
+	Offset *int32
+}) ([]*DeliveryResolver, error) {
+	limit, offset, err := r.pageLimits.limitOffset("Purchase.deliveries", args.Limit, args.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	purchaseID := r.purchase.ID
+	deliveries, err := r.repo.GetDeliveries(&models.DeliveryFilter{
+		PurchaseID: &purchaseID,
+		ToDate:     r.asOf,
+		Limit:      limit,
+		Offset:     offset,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := make([]*DeliveryResolver, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		resolvers = append(resolvers, &DeliveryResolver{delivery: delivery, repo: r.repo, rates: r.rates, pageLimits: r.pageLimits, asOf: r.asOf})
+	}
+
+	return resolvers, nil
+}
+
+// Delivery resolver
+type DeliveryResolver struct {
+	delivery   *models.Delivery
+	repo       *repository.Repository
+	rates      *rates.Cache
+	pageLimits PageLimits
+	// asOf resolves the purchase of a delivery fetched as of a past time as of
+	// the same time
+	asOf *time.Time
+}
+
+func (r *DeliveryResolver) ID() graphql.ID {
+	return graphql.ID(id.FormatDeliveryID(r.delivery.ID))
+}
+
+func (r *DeliveryResolver) Purchase() (*PurchaseResolver, error) {
+	if r.asOf != nil {
+		purchase, err := r.repo.GetPurchaseAsOf(r.delivery.PurchaseID, *r.asOf)
+		if err != nil {
+			return nil, err
+		}
+		return &PurchaseResolver{purchase: purchase, repo: r.repo, rates: r.rates, pageLimits: r.pageLimits, asOf: r.asOf}, nil
+	}
+
+	purchase, err := r.repo.GetPurchase(r.delivery.PurchaseID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PurchaseResolver{purchase: purchase, repo: r.repo, rates: r.rates, pageLimits: r.pageLimits}, nil
+}
+
+func (r *DeliveryResolver) Timestamp() string {
+	return r.delivery.Timestamp.Format(time.RFC3339)
+}
+
+func (r *DeliveryResolver) ScheduledFor() *string {
+	if r.delivery.ScheduledFor == nil {
+		return nil
+	}
+	scheduledFor := r.delivery.ScheduledFor.Format(time.RFC3339)
+	return &scheduledFor
+}
+
+func (r *DeliveryResolver) AttemptNumber() int32 {
+	return int32(r.delivery.AttemptNumber)
+}
+
+func (r *DeliveryResolver) Status() string {
+	return deliveryStatusToEnum(r.delivery.Status)
+}
+
+// deliveryStatusToEnum converts a database status to the GraphQL enum value
+func deliveryStatusToEnum(status string) string {
+	// Convert status to uppercase to match the GraphQL enum
+	switch status {
+	case "packed":
+		return "PACKED"
+	case "out_for_delivery":
+		return "OUT_FOR_DELIVERY"
+	case "delivered":
+		return "DELIVERED"
+	case "rescheduled":
+		return "RESCHEDULED"
+	case "canceled":
+		return "CANCELED"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// purchaseStatusToEnum converts a database purchase status to the GraphQL enum
+func purchaseStatusToEnum(status string) string {
+	switch status {
+	case models.PurchaseStatusPendingReview:
+		return "PENDING_REVIEW"
+	case models.PurchaseStatusApproved:
+		return "APPROVED"
+	case models.PurchaseStatusRejected:
+		return "REJECTED"
+	case models.PurchaseStatusCanceled:
+		return "CANCELED"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// purchaseStatusFromEnum converts a GraphQL enum value to the database purchase status
+func purchaseStatusFromEnum(status string) (string, bool) {
+	switch status {
+	case "PENDING_REVIEW":
+		return models.PurchaseStatusPendingReview, true
+	case "APPROVED":
+		return models.PurchaseStatusApproved, true
+	case "REJECTED":
+		return models.PurchaseStatusRejected, true
+	case "CANCELED":
+		return models.PurchaseStatusCanceled, true
+	default:
+		return "", false
+	}
+}
+
+// orderStatusToEnum converts a database order status to the GraphQL enum
+func orderStatusToEnum(status string) string {
+	switch status {
+	case models.OrderStatusPending:
+		return "PENDING"
+	case models.OrderStatusPaid:
+		return "PAID"
+	case models.OrderStatusShipped:
+		return "SHIPPED"
+	case models.OrderStatusCompleted:
+		return "COMPLETED"
+	case models.OrderStatusCanceled:
+		return "CANCELED"
+	case models.OrderStatusRefunded:
+		return "REFUNDED"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// orderStatusFromEnum converts a GraphQL enum value to the database order status
+func orderStatusFromEnum(status string) (string, bool) {
+	switch status {
+	case "PENDING":
+		return models.OrderStatusPending, true
+	case "PAID":
+		return models.OrderStatusPaid, true
+	case "SHIPPED":
+		return models.OrderStatusShipped, true
+	case "COMPLETED":
+		return models.OrderStatusCompleted, true
+	case "CANCELED":
+		return models.OrderStatusCanceled, true
+	case "REFUNDED":
+		return models.OrderStatusRefunded, true
+	default:
+		return "", false
+	}
+}
+
+// deliveryStatusFromEnum converts a GraphQL enum value to the database status
+func deliveryStatusFromEnum(status string) (string, bool) {
+	switch status {
+	case "PACKED":
+		return "packed", true
+	case "OUT_FOR_DELIVERY":
+		return "out_for_delivery", true
+	case "DELIVERED":
+		return "delivered", true
+	case "RESCHEDULED":
+		return "rescheduled", true
+	case "CANCELED":
+		return "canceled", true
+	default:
+		return "", false
+	}
+}
+
+// Delivery timeline resolvers
+type DeliveryTimelineDayResolver struct {
+	day        *models.DeliveryTimelineDay
+	purchaseID int
+	repo       *repository.Repository
+	rates      *rates.Cache
+	pageLimits PageLimits
+}
+
+func (r *DeliveryTimelineDayResolver) Date() string {
+	return r.day.Date.Format("2006-01-02")
+}
+
+func (r *DeliveryTimelineDayResolver) Total() int32 {
+	var total int
+	for _, count := range r.day.StatusCounts {
+		total += count.Count
+	}
+	return int32(total)
+}
+
+func (r *DeliveryTimelineDayResolver) StatusCounts() []*DeliveryStatusCountResolver {
+	resolvers := make([]*DeliveryStatusCountResolver, 0, len(r.day.StatusCounts))
+	for i := range r.day.StatusCounts {
+		resolvers = append(resolvers, &DeliveryStatusCountResolver{count: &r.day.StatusCounts[i]})
+	}
+	return resolvers
+}
+
+func (r *DeliveryTimelineDayResolver) Deliveries() ([]*DeliveryResolver, error) {
+	fromDate := r.day.Date
+	toDate := r.day.Date.Add(24*time.Hour - time.Nanosecond)
+	filter := &models.DeliveryFilter{
+		PurchaseID: &r.purchaseID,
+		FromDate:   &fromDate,
+		ToDate:     &toDate,
+	}
+
+	deliveries, err := r.repo.GetDeliveries(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := make([]*DeliveryResolver, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		resolvers = append(resolvers, &DeliveryResolver{delivery: delivery, repo: r.repo, rates: r.rates, pageLimits: r.pageLimits})
+	}
+
+	return resolvers, nil
+}
+
+type DeliveryStatusCountResolver struct {
+	count *models.DeliveryStatusCount
+}
+
+func (r *DeliveryStatusCountResolver) Status() string {
+	return deliveryStatusToEnum(r.count.Status)
+}
+
+func (r *DeliveryStatusCountResolver) Count() int32 {
+	return int32(r.count.Count)
+}
+
+// Input type resolvers
+type ListingFilterInput struct {
+	SellerID     *graphql.ID
+	SellerIDIn   *[]graphql.ID
+	SellerName   *string
+	MinPrice     *models.Money
+	MaxPrice     *models.Money
+	Title        *string
+	TitleNotLike *string
+	And          *[]*ListingFilterInput
+	Or           *[]*ListingFilterInput
+}
+
+// maxFilterDepth bounds how deeply and/or filters may be nested
+const maxFilterDepth = 5
+
+func (r *Resolver) resolveListingFilter(filter *ListingFilterInput) (*models.ListingFilter, error) {
+	return r.resolveNestedListingFilter(filter, 0)
+}
+
+// resolveNestedListingFilter resolves a listing filter nested depth levels deep
+// in and/or filters
+func (r *Resolver) resolveNestedListingFilter(filter *ListingFilterInput, depth int) (*models.ListingFilter, error) {
+	if filter == nil {
+		return nil, nil
+	}
+	if depth > maxFilterDepth {
+		return nil, invalidInput("listing filters may be nested at most %d levels deep", maxFilterDepth)
+	}
+
+	result := &models.ListingFilter{}
+
+	if filter.SellerID != nil {
+		sellerID, err := id.ParseSellerID(string(*filter.SellerID))
+		if err != nil {
+			return nil, err
+		}
+		result.SellerID = &sellerID
+	}
+
+	if filter.SellerIDIn != nil {
+		result.SellerIDIn = make([]int, 0, len(*filter.SellerIDIn))
+		for _, value := range *filter.SellerIDIn {
+			sellerID, err := id.ParseSellerID(string(value))
+			if err != nil {
+				return nil, err
+			}
+			result.SellerIDIn = append(result.SellerIDIn, sellerID)
+		}
+	}
+
+	result.SellerName = filter.SellerName
+	result.MinPrice = filter.MinPrice
+	result.MaxPrice = filter.MaxPrice
+	result.Title = filter.Title
+	result.TitleNotLike = filter.TitleNotLike
+
+	var err error
+	if filter.And != nil {
+		if result.And, err = r.resolveListingFilters(*filter.And, depth+1); err != nil {
+			return nil, err
+		}
+	}
+	if filter.Or != nil {
+		if result.Or, err = r.resolveListingFilters(*filter.Or, depth+1); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// resolveListingFilters resolves the sub-filters of an and/or filter
+func (r *Resolver) resolveListingFilters(filters []*ListingFilterInput, depth int) ([]*models.ListingFilter, error) {
+	result := make([]*models.ListingFilter, 0, len(filters))
+	for _, filter := range filters {
+		resolved, err := r.resolveNestedListingFilter(filter, depth)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, resolved)
+	}
+	return result, nil
+}
+
+type PurchaseFilterInput struct {
+	ListingID   *graphql.ID
+	ListingIDIn *[]graphql.ID
+	BankTxID    *string
+	Status      *string
+	OrderStatus *string
+	FromDate    *string
+	ToDate      *string
+}
+
+// resolvePurchaseFilter translates a purchase filter. Only admins may filter by
+// bank transaction ID, which they alone may see
+func (r *Resolver) resolvePurchaseFilter(ctx context.Context, filter *PurchaseFilterInput) (*models.PurchaseFilter, error) {
+	if filter == nil {
+		return nil, nil
+	}
+	if filter.BankTxID != nil && RoleFromContext(ctx) != AdminRole {
+		return nil, forbidden("role %q is not permitted to filter purchases by bankTxId", RoleFromContext(ctx))
+	}
+
+	result := &models.PurchaseFilter{}
+
+	if filter.ListingID != nil {
+		listingID, err := id.ParseListingID(string(*filter.ListingID))
+		if err != nil {
+			return nil, err
+		}
+		result.ListingID = &listingID
+	}
+
+	if filter.ListingIDIn != nil {
+		result.ListingIDIn = make([]int, 0, len(*filter.ListingIDIn))
+		for _, value := range *filter.ListingIDIn {
+			listingID, err := id.ParseListingID(string(value))
+			if err != nil {
+				return nil, err
+			}
+			result.ListingIDIn = append(result.ListingIDIn, listingID)
+		}
+	}
+
+	result.BankTxID = filter.BankTxID
+
+	if filter.Status != nil {
+		status, ok := purchaseStatusFromEnum(*filter.Status)
+		if !ok {
+			return nil, invalidInput("unknown purchase status %q", *filter.Status)
+		}
+		result.Status = &status
+	}
+
+	if filter.OrderStatus != nil {
+		status, ok := orderStatusFromEnum(*filter.OrderStatus)
+		if !ok {
+			return nil, invalidInput("unknown order status %q", *filter.OrderStatus)
+		}
+		result.OrderStatus = &status
+	}
+
+	now := time.Now()
+
+	if filter.FromDate != nil {
+		fromDate, err := parseDateFilter(*filter.FromDate, false, now)
+		if err == nil {
+			result.FromDate = &fromDate
+		}
+	}
+
+	if filter.ToDate != nil {
+		toDate, err := parseDateFilter(*filter.ToDate, true, now)
+		if err == nil {
+			result.ToDate = &toDate
+		}
+	}
+
+	return result, nil
+}
+
+type DeliveryFilterInput struct {
+	PurchaseID    *graphql.ID
+	Status        *string
+	Statuses      *[]string
+	StatusIn      *[]string
+	StatusNot     *string
+	FromDate      *string
+	ToDate        *string
+	ScheduledFrom *string
+	ScheduledTo   *string
+}
+
+func (r *Resolver) resolveDeliveryFilter(filter *DeliveryFilterInput) (*models.DeliveryFilter, error) {
+	if filter == nil {
+		return nil, nil
+	}
+
+	result := &models.DeliveryFilter{}
+
+	if filter.PurchaseID != nil {
+		purchaseID, err := id.ParsePurchaseID(string(*filter.PurchaseID))
+		if err != nil {
+			return nil, err
+		}
+		result.PurchaseID = &purchaseID
+	}
+
+	if filter.Status != nil {
+		status, ok := deliveryStatusFromEnum(*filter.Status)
+		if !ok {
+			return nil, invalidInput("unknown delivery status %q", *filter.Status)
+		}
+		result.Status = &status
+	}
+
+	// statuses and statusIn are two names for the same filter
+	statusIn := filter.StatusIn
+	if filter.Statuses != nil {
+		if statusIn != nil {
+			return nil, invalidInput("statuses and statusIn cannot be combined")
+		}
+		statusIn = filter.Statuses
+	}
+
+	if statusIn != nil {
+		result.StatusIn = make([]string, 0, len(*statusIn))
+		for _, value := range *statusIn {
+			status, ok := deliveryStatusFromEnum(value)
+			if !ok {
+				return nil, invalidInput("unknown delivery status %q", value)
+			}
+			result.StatusIn = append(result.StatusIn, status)
+		}
+	}
+
+	if filter.StatusNot != nil {
+		status, ok := deliveryStatusFromEnum(*filter.StatusNot)
+		if !ok {
+			return nil, invalidInput("unknown delivery status %q", *filter.StatusNot)
+		}
+		result.StatusNot = &status
+	}
+
+	now := time.Now()
+
+	if filter.FromDate != nil {
+		fromDate, err := parseDateFilter(*filter.FromDate, false, now)
+		if err == nil {
+			result.FromDate = &fromDate
+		}
+	}
+
+	if filter.ToDate != nil {
+		toDate, err := parseDateFilter(*filter.ToDate, true, now)
+		if err == nil {
+			result.ToDate = &toDate
+		}
+	}
+
+	if filter.ScheduledFrom != nil {
+		scheduledFrom, err := parseDateFilter(*filter.ScheduledFrom, false, now)
+		if err == nil {
+			result.ScheduledFrom = &scheduledFrom
+		}
+	}
+
+	if filter.ScheduledTo != nil {
+		scheduledTo, err := parseDateFilter(*filter.ScheduledTo, true, now)
+		if err == nil {
+			result.ScheduledTo = &scheduledTo
+		}
+	}
+
+	return result, nil
+}
+
+// Input types for mutations
+type CreateListingInput struct {
+	SellerID    graphql.ID
+	Title       string
+	Description string
+	Price       models.Money
+}
+
+type UpdateListingInput struct {
+	ID          graphql.ID
+	Title       *string
+	Description *string
+	Price       *models.Money
+}
+
+type CreateSellerInput struct {
+	Name        string
+	Address     string
+	DigestOptIn *bool
+}
+
+type UpdateSellerInput struct {
+	ID          graphql.ID
+	Name        *string
+	Address     *string
+	DigestOptIn *bool
+}
+
+type CreatePurchaseInput struct {
+	ListingID       graphql.ID
+	Price           models.Money
+	BankTxID        string
+	DeliveryAddress *string
+	PickupPointID   *graphql.ID
+}
+
+type CreateDeliveryInput struct {
+	PurchaseID graphql.ID
+	Status     string
+}
+
+// Mutation resolvers. Mutations taking an input object return a payload:
+// invalid input is reported in its userErrors, while unexpected failures are
+// returned as GraphQL errors. Mutations with a dryRun argument run in a
+// transaction that is rolled back, returning what they would have stored
+func (r *Resolver) CreateSeller(ctx context.Context, args struct {
+	Input  CreateSellerInput
+	DryRun bool
+}) (*SellerPayloadResolver, error) {
+	digestOptIn := args.Input.DigestOptIn != nil && *args.Input.DigestOptIn
+	sellers := r.sellers
+	if args.DryRun {
+		repo, rollback, err := r.repo.DryRun()
+		if err != nil {
+			return nil, err
+		}
+		defer rollback()
+		sellers = r.sellers.DryRun(repo)
+	}
+
+	seller, err := sellers.Create(ctx, args.Input.Name, args.Input.Address, digestOptIn)
+	if err != nil {
+		log.Printf("[GraphQL] Error creating seller: %v", err)
+		userErrs, err := userErrors(err)
+		if err != nil {
+			return nil, err
+		}
+		return &SellerPayloadResolver{userErrors: userErrs}, nil
+	}
+
+	log.Printf("[GraphQL] Successfully created seller ID: %d", seller.ID)
+	return &SellerPayloadResolver{seller: &SellerResolver{seller: seller, repo: r.repo, rates: r.rates, pageLimits: r.limits()}}, nil
+}
+
+func (r *Resolver) UpdateSeller(ctx context.Context, args struct {
+	Input  UpdateSellerInput
+	DryRun bool
+}) (*SellerPayloadResolver, error) {
+	// Parse seller ID
+	sellerID, err := id.ParseSellerID(string(args.Input.ID))
+	if err != nil {
+		return &SellerPayloadResolver{userErrors: newUserErrors(invalidID("id", err))}, nil
+	}
+
+	sellers := r.sellers
+	if args.DryRun {
+		repo, rollback, err := r.repo.DryRun()
+		if err != nil {
+			return nil, err
+		}
+		defer rollback()
+		sellers = r.sellers.DryRun(repo)
+	}
+
+	seller, err := sellers.Update(ctx, sellerID, args.Input.Name, args.Input.Address, args.Input.DigestOptIn)
+	if err != nil {
+		log.Printf("[GraphQL] Error updating seller: %v", err)
+		userErrs, err := userErrors(err)
+		if err != nil {
+			return nil, err
+		}
+		return &SellerPayloadResolver{userErrors: userErrs}, nil
+	}
+
+	log.Printf("[GraphQL] Successfully updated seller ID: %d", seller.ID)
+	return &SellerPayloadResolver{seller: &SellerResolver{seller: seller, repo: r.repo, rates: r.rates, pageLimits: r.limits()}}, nil
+}
+
+// DeleteSeller removes a seller without listings
+func (r *Resolver) DeleteSeller(ctx context.Context, args struct{ ID graphql.ID }) (bool, error) {
+	// Parse seller ID
+	sellerID, err := id.ParseSellerID(string(args.ID))
+	if err != nil {
+		return false, err
+	}
+
+	if err := r.sellers.Delete(ctx, sellerID); err != nil {
+		return false, err
+	}
+
+	log.Printf("[GraphQL] Successfully deleted seller ID: %d", sellerID)
+	return true, nil
+}
+
+func (r *Resolver) CreateListing(ctx context.Context, args struct {
+	Input  CreateListingInput
+	DryRun bool
+}) (*ListingPayloadResolver, error) {
+	// Parse seller ID
+	sellerID, err := id.ParseSellerID(string(args.Input.SellerID))
+	if err != nil {
+		return &ListingPayloadResolver{userErrors: newUserErrors(invalidID("sellerId", err))}, nil
+	}
+
+	listings := r.listings
+	if args.DryRun {
+		repo, rollback, err := r.repo.DryRun()
+		if err != nil {
+			return nil, err
+		}
+		defer rollback()
+		listings = r.listings.DryRun(repo)
+	}
+
+	listing, err := listings.Create(ctx, sellerID, args.Input.Title, args.Input.Description, args.Input.Price)
+	if err != nil {
+		log.Printf("[GraphQL] Error creating listing: %v", err)
+		userErrs, err := userErrors(err)
+		if err != nil {
+			return nil, err
+		}
+		return &ListingPayloadResolver{userErrors: userErrs}, nil
+	}
+
+	log.Printf("[GraphQL] Successfully created listing ID: %d", listing.ID)
+	return &ListingPayloadResolver{listing: &ListingResolver{listing: listing, repo: r.repo, rates: r.rates, pageLimits: r.limits()}}, nil
+}
+
+// maxListingBatch bounds the number of listings created by one createListings call
+const maxListingBatch = 100
+
+// CreateListings creates several listings at once. Each input gets a payload
+// holding either the created listing or the reason it was refused; the
+// listings that pass validation are stored together
+func (r *Resolver) CreateListings(ctx context.Context, args struct{ Input []CreateListingInput }) ([]*ListingPayloadResolver, error) {
+	if len(args.Input) > maxListingBatch {
+		return nil, invalidInput("at most %d listings can be created at once", maxListingBatch)
+	}
+
+	results := make([]*ListingPayloadResolver, len(args.Input))
+	var inputs []models.NewListing
+	var positions []int
+	for i, input := range args.Input {
+		sellerID, err := id.ParseSellerID(string(input.SellerID))
+		if err != nil {
+			results[i] = &ListingPayloadResolver{userErrors: newUserErrors(invalidID("sellerId", err))}
+			continue
+		}
+		inputs = append(inputs, models.NewListing{
+			SellerID:    sellerID,
+			Title:       input.Title,
+			Description: input.Description,
+			Price:       input.Price,
+		})
+		positions = append(positions, i)
+	}
+
+	created, err := r.listings.CreateBatch(ctx, inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	for j, result := range created {
+		resolver := &ListingPayloadResolver{}
+		if result.Err != nil {
+			// Unexpected failures only fail the listing they occurred for
+			resolver.userErrors, resolver.err = userErrors(result.Err)
+		} else {
+			resolver.listing = &ListingResolver{listing: result.Listing, repo: r.repo, rates: r.rates, pageLimits: r.limits()}
+		}
+		results[positions[j]] = resolver
+	}
+
+	return results, nil
+}
+
+func (r *Resolver) UpdateListing(ctx context.Context, args struct {
+	Input  UpdateListingInput
+	DryRun bool
+}) (*ListingPayloadResolver, error) {
+	// Parse listing ID
+	listingID, err := id.ParseListingID(string(args.Input.ID))
+	if err != nil {
+		return &ListingPayloadResolver{userErrors: newUserErrors(invalidID("id", err))}, nil
+	}
+
+	listings := r.listings
+	if args.DryRun {
+		repo, rollback, err := r.repo.DryRun()
+		if err != nil {
+			return nil, err
+		}
+		defer rollback()
+		listings = r.listings.DryRun(repo)
+	}
+
+	listing, err := listings.Update(ctx, listingID, args.Input.Title, args.Input.Description, args.Input.Price)
+	if err != nil {
+		log.Printf("[GraphQL] Error updating listing: %v", err)
+		userErrs, err := userErrors(err)
+		if err != nil {
+			return nil, err
+		}
+		return &ListingPayloadResolver{userErrors: userErrs}, nil
+	}
+
+	log.Printf("[GraphQL] Successfully updated listing ID: %d", listing.ID)
+	return &ListingPayloadResolver{listing: &ListingResolver{listing: listing, repo: r.repo, rates: r.rates, pageLimits: r.limits()}}, nil
+}
+
+func (r *Resolver) CreatePurchase(ctx context.Context, args struct {
+	Input  CreatePurchaseInput
+	DryRun bool
+}) (*PurchasePayloadResolver, error) {
+	input, inputErr := newPurchaseInput(args.Input)
+	if inputErr != nil {
+		return &PurchasePayloadResolver{userErrors: newUserErrors(inputErr)}, nil
+	}
+
+	purchases := r.purchases
+	if args.DryRun {
+		repo, rollback, err := r.repo.DryRun()
+		if err != nil {
+			return nil, err
+		}
+		defer rollback()
+		purchases = r.purchases.DryRun(repo)
+	}
+
+	purchase, err := purchases.Create(ctx, input)
+	if err != nil {
+		log.Printf("[GraphQL] Error creating purchase: %v", err)
+		userErrs, err := userErrors(err)
+		if err != nil {
+			return nil, err
+		}
+		return &PurchasePayloadResolver{userErrors: userErrs}, nil
+	}
+
+	log.Printf("[GraphQL] Successfully created purchase ID: %d", purchase.ID)
+	return &PurchasePayloadResolver{purchase: &PurchaseResolver{purchase: purchase, repo: r.repo, rates: r.rates, pageLimits: r.limits()}}, nil
+}
+
+// CreatePurchaseWithDelivery creates a purchase and its initial PACKED delivery
+// atomically, so clients don't need a separate createDelivery call
+func (r *Resolver) CreatePurchaseWithDelivery(ctx context.Context, args struct{ Input CreatePurchaseInput }) (*PurchaseWithDeliveryPayloadResolver, error) {
+	input, inputErr := newPurchaseInput(args.Input)
+	if inputErr != nil {
+		return &PurchaseWithDeliveryPayloadResolver{userErrors: newUserErrors(inputErr)}, nil
+	}
+
+	purchase, delivery, err := r.purchases.CreateWithDelivery(ctx, input)
+	if err != nil {
+		log.Printf("[GraphQL] Error creating purchase with delivery: %v", err)
+		userErrs, err := userErrors(err)
+		if err != nil {
+			return nil, err
+		}
+		return &PurchaseWithDeliveryPayloadResolver{userErrors: userErrs}, nil
+	}
+
+	log.Printf("[GraphQL] Successfully created purchase ID %d with delivery ID: %d", purchase.ID, delivery.ID)
+	return &PurchaseWithDeliveryPayloadResolver{
+		purchase: &PurchaseResolver{purchase: purchase, repo: r.repo, rates: r.rates, pageLimits: r.limits()},
+		delivery: &DeliveryResolver{delivery: delivery, repo: r.repo, rates: r.rates, pageLimits: r.limits()},
+	}, nil
+}
+
+// newPurchaseInput parses the IDs of a purchase input
+func newPurchaseInput(input CreatePurchaseInput) (service.NewPurchase, *service.InputError) {
+	listingID, err := id.ParseListingID(string(input.ListingID))
+	if err != nil {
+		return service.NewPurchase{}, invalidID("listingId", err)
+	}
+
+	purchase := service.NewPurchase{
+		ListingID:       listingID,
+		Price:           input.Price,
+		BankTxID:        input.BankTxID,
+		DeliveryAddress: input.DeliveryAddress,
+	}
+	if input.PickupPointID != nil {
+		pickupPointID, err := id.ParsePickupPointID(string(*input.PickupPointID))
+		if err != nil {
+			return service.NewPurchase{}, invalidID("pickupPointId", err)
+		}
+		purchase.PickupPointID = &pickupPointID
+	}
+	return purchase, nil
+}
+
+// CancelPurchase cancels a purchase that hasn't shipped, recording the reason
+// and a CANCELED delivery update, and notifies delivery subscribers and the seller
+func (r *Resolver) CancelPurchase(ctx context.Context, args struct {
+	ID     graphql.ID
+	Reason *string
+}) (*PurchaseResolver, error) {
+	// Parse purchase ID
+	purchaseID, err := id.ParsePurchaseID(string(args.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	var reason string
+	if args.Reason != nil {
+		reason = *args.Reason
+	}
+
+	purchase, err := r.purchases.Cancel(ctx, purchaseID, reason)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("[GraphQL] Successfully canceled purchase ID: %d", purchase.ID)
+	return &PurchaseResolver{purchase: purchase, repo: r.repo, rates: r.rates, pageLimits: r.limits()}, nil
+}
+
+// CreateDelivery mutation resolver
+func (r *Resolver) CreateDelivery(ctx context.Context, args struct{ Input CreateDeliveryInput }) (*DeliveryPayloadResolver, error) {
+	// Parse purchase ID
+	purchaseID, err := id.ParsePurchaseID(string(args.Input.PurchaseID))
+	if err != nil {
+		return &DeliveryPayloadResolver{userErrors: newUserErrors(invalidID("purchaseId", err))}, nil
+	}
+
+	// Convert GraphQL enum to database enum
+	status, ok := deliveryStatusFromEnum(args.Input.Status)
+	if !ok {
+		return nil, invalidInput("invalid status: %s", args.Input.Status)
+	}
+
+	delivery, err := r.deliveries.Create(ctx, purchaseID, status)
+	if err != nil {
+		log.Printf("[GraphQL] Error creating delivery: %v", err)
+		userErrs, err := userErrors(err)
+		if err != nil {
+			return nil, err
+		}
+		return &DeliveryPayloadResolver{userErrors: userErrs}, nil
+	}
+
+	log.Printf("[GraphQL] Successfully created delivery ID: %d", delivery.ID)
+	return &DeliveryPayloadResolver{delivery: &DeliveryResolver{delivery: delivery, repo: r.repo, rates: r.rates, pageLimits: r.limits()}}, nil
+}
+
+func (r *Resolver) RescheduleDelivery(ctx context.Context, args struct {
+	PurchaseID   graphql.ID
+	ScheduledFor string
+}) (*DeliveryResolver, error) {
+	// Parse purchase ID
+	purchaseID, err := id.ParsePurchaseID(string(args.PurchaseID))
+	if err != nil {
+		return nil, err
+	}
+
+	scheduledFor, err := time.Parse(time.RFC3339, args.ScheduledFor)
+	if err != nil {
+		return nil, invalidInput("invalid scheduledFor format, expected RFC3339: %v", err)
+	}
+
+	delivery, err := r.deliveries.Reschedule(ctx, purchaseID, scheduledFor)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("[GraphQL] Successfully rescheduled delivery ID: %d, attempt %d", delivery.ID, delivery.AttemptNumber)
+	return &DeliveryResolver{delivery: delivery, repo: r.repo, rates: r.rates, pageLimits: r.limits()}, nil
+}
+
+// UpdateDeliveryStatus moves a delivery to a new status, recording it as a new
+// status update of the same attempt after validating the transition
+func (r *Resolver) UpdateDeliveryStatus(ctx context.Context, args struct {
+	DeliveryID graphql.ID
+	Status     string
+}) (*DeliveryResolver, error) {
+	// Parse delivery ID
+	deliveryID, err := id.ParseDeliveryID(string(args.DeliveryID))
+	if err != nil {
+		return nil, err
+	}
+
+	status, ok := deliveryStatusFromEnum(args.Status)
+	if !ok {
+		return nil, invalidInput("invalid status: %s", args.Status)
+	}
+
+	delivery, err := r.deliveries.UpdateStatus(ctx, deliveryID, status)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("[GraphQL] Successfully created delivery ID: %d", delivery.ID)
+	return &DeliveryResolver{delivery: delivery, repo: r.repo, rates: r.rates, pageLimits: r.limits()}, nil
+}
+
+// SetSellerWebhook registers the URL notified of purchases of a seller's
+// listings, generating a new signing secret
+func (r *Resolver) SetSellerWebhook(ctx context.Context, args struct {
+	SellerID graphql.ID
+	URL      string
+}) (*SellerWebhookResolver, error) {
+	// Parse seller ID
+	sellerID, err := id.ParseSellerID(string(args.SellerID))
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(args.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, invalidInput("invalid webhook URL, expected an absolute http(s) URL: %s", args.URL)
+	}
+
+	// Validate seller exists
+	_, err = r.repo.GetSeller(sellerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, notFound("seller not found: %s", args.SellerID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hook, err := r.repo.SetSellerWebhook(sellerID, args.URL, webhook.NewSecret())
+	if err != nil {
+		return nil, err
+	}
+
+	return &SellerWebhookResolver{hook: hook}, nil
+}
+
+// RemoveSellerWebhook stops purchase notifications of a seller, returning false if none were registered
+func (r *Resolver) RemoveSellerWebhook(ctx context.Context, args struct{ SellerID graphql.ID }) (bool, error) {
+	sellerID, err := id.ParseSellerID(string(args.SellerID))
+	if err != nil {
+		return false, err
+	}
+
+	return r.repo.DeleteSellerWebhook(sellerID)
+}
+
+// SetSellerQuota overrides the number of listings a seller may have
+func (r *Resolver) SetSellerQuota(ctx context.Context, args struct {
+	SellerID    graphql.ID
+	MaxListings int32
+}) (*SellerQuotaResolver, error) {
+	sellerID, err := id.ParseSellerID(string(args.SellerID))
+	if err != nil {
+		return nil, err
+	}
+	if args.MaxListings < 0 {
+		return nil, invalidInput("maxListings must not be negative, got %d", args.MaxListings)
+	}
+
+	// Validate seller exists
+	_, err = r.repo.GetSeller(sellerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, notFound("seller not found: %s", args.SellerID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	quota, err := r.repo.SetSellerQuota(sellerID, int(args.MaxListings))
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("[GraphQL] Set listing quota of seller ID %d to %d", sellerID, quota.MaxListings)
+	return &SellerQuotaResolver{quota: quota}, nil
+}
+
+// RemoveSellerQuota returns a seller to the default listing limit, returning false if no quota was set
+func (r *Resolver) RemoveSellerQuota(ctx context.Context, args struct{ SellerID graphql.ID }) (bool, error) {
+	sellerID, err := id.ParseSellerID(string(args.SellerID))
+	if err != nil {
+		return false, err
+	}
+
+	return r.repo.DeleteSellerQuota(sellerID)
+}
+
+// RecordListingView mutation resolver
+func (r *Resolver) RecordListingView(ctx context.Context, args struct{ ListingID graphql.ID }) (bool, error) {
+	listingID, err := id.ParseListingID(string(args.ListingID))
+	if err != nil {
+		return false, err
+	}
+
+	// Validate listing exists so a bad ID can't poison the flushed batch
+	_, err = r.repo.GetListing(listingID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, notFound("listing not found: %s", args.ListingID)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	r.viewCounter.Record(listingID)
+	return true, nil
+}
+
+// DeliveryUpdated subscription resolver. Delivery IDs double as event IDs: when
+// lastEventId is given, the deliveries recorded after it are replayed before live events
+func (r *Resolver) DeliveryUpdated(ctx context.Context, args struct {
+	PurchaseID  *graphql.ID
+	LastEventID *graphql.ID
+	Statuses    *[]string
+}) (<-chan *DeliveryResolver, error) {
+	purchaseIDStr, purchaseID, err := subscriptionKey(args.PurchaseID)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses statusFilter
+	if args.Statuses != nil {
+		if len(*args.Statuses) == 0 {
+			return nil, invalidInput("statuses must not be empty; omit it to receive every status")
+		}
+		statuses = make(statusFilter)
+		for _, value := range *args.Statuses {
+			status, _ := deliveryStatusFromEnum(value)
+			statuses[status] = true
+		}
+	}
+
+	var lastEventID int
+	if args.LastEventID != nil {
+		lastEventID, err = id.ParseDeliveryID(string(*args.LastEventID))
+		if err != nil {
+			return nil, invalidInput("invalid last event ID: %v", err)
+		}
+	}
+
+	// Create event channel before reading missed deliveries so no event falls between the two
+	updates, err := r.eventBus.SubscribeToDeliveries(purchaseIDStr)
+	if err != nil {
+		return nil, err
+	}
+
+	var missed []*models.Delivery
+	if args.LastEventID != nil {
+		// Read one more than may be replayed to tell whether the client missed too many
+		missed, err = r.repo.GetDeliveriesSince(purchaseID, lastEventID, r.backpressure.Replay+1)
+		if err != nil {
+			r.eventBus.Unsubscribe(purchaseIDStr, updates)
+			return nil, err
+		}
+		if len(missed) > r.backpressure.Replay {
+			r.eventBus.Unsubscribe(purchaseIDStr, updates)
+			return nil, invalidInput("more than %d deliveries were recorded after event ID %s; query deliveries to resync, then subscribe without lastEventId", r.backpressure.Replay, *args.LastEventID)
+		}
+		log.Printf("[GraphQL] Replaying %d missed deliveries after event ID %d", len(missed), lastEventID)
+	}
+
+	return r.forwardDeliveries(ctx, purchaseIDStr, updates, missed, statuses), nil
+}
+
+// statusFilter holds the database statuses of the deliveries a subscription
+// receives; a nil filter lets every status through
+type statusFilter map[string]bool
+
+func (f statusFilter) matches(delivery *models.Delivery) bool {
+	return f == nil || f[delivery.Status]
+}
+
+// subscriptionKey parses the purchase ID a subscription is filtered by and
+// returns the key the event bus files its events under, the decimal database
+// ID, or "" for all purchases
+func subscriptionKey(purchaseID *graphql.ID) (string, *int, error) {
+	if purchaseID == nil {
+		return "", nil, nil
+	}
+	parsed, err := id.ParsePurchaseID(string(*purchaseID))
+	if err != nil {
+		return "", nil, err
+	}
+	return strconv.Itoa(parsed), &parsed, nil
+}
+
+// forwardDeliveries sends the replayed deliveries followed by live events to the
+// subscriber until the subscription is closed. Live events for deliveries the
+// replay already sent are skipped, as are deliveries with a status the filter
+// doesn't match. Deliveries of concurrent transactions may be published out of
+// ID order, so live events are not skipped by their ID alone.
+// Events keep being read from the bus while the subscriber is slow; they are
+// buffered up to the resolver's backpressure limit, beyond which its lag policy applies
+func (r *Resolver) forwardDeliveries(ctx context.Context, purchaseIDStr string, updates chan events.DeliveryEvent, missed []*models.Delivery, statuses statusFilter) <-chan *DeliveryResolver {
+	c := make(chan *DeliveryResolver)
+	queue := &deliveryQueue{subscription: "deliveryUpdated", backpressure: r.backpressure}
+	replayed := make(map[int]bool, len(missed))
+	for _, delivery := range missed {
+		replayed[delivery.ID] = true
+		if statuses.matches(delivery) {
+			queue.replay(delivery)
+		}
+	}
+
+	go func() {
+		defer close(c)
+		defer r.eventBus.Unsubscribe(purchaseIDStr, updates)
+		defer queue.discard()
+
+		for {
+			// Sending is only enabled while a delivery is waiting
+			var send chan *DeliveryResolver
+			var next *DeliveryResolver
+			if delivery, ok := queue.next(); ok {
+				send = c
+				next = &DeliveryResolver{delivery: delivery, repo: r.repo, rates: r.rates, pageLimits: r.limits()}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-updates:
+				if replayed[event.Delivery.ID] {
+					continue
+				}
+				if !statuses.matches(event.Delivery) {
+					continue
+				}
+				if !queue.push(event.Delivery, event.Published) {
+					return
+				}
+			case send <- next:
+				queue.sent()
+			}
+		}
+	}()
+
+	return c
+}
+
+// PurchaseReviewed subscription resolver
+func (r *Resolver) PurchaseReviewed(ctx context.Context, args struct{ PurchaseID *graphql.ID }) (<-chan *PurchaseResolver, error) {
+	purchaseIDStr, _, err := subscriptionKey(args.PurchaseID)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := r.eventBus.SubscribeToPurchaseReviews(purchaseIDStr)
+	if err != nil {
+		return nil, err
+	}
+	c := make(chan *PurchaseResolver, 1)
+
+	// Forward events to client until the subscription is closed
+	go func() {
+		defer close(c)
+		defer r.eventBus.UnsubscribePurchaseReviews(purchaseIDStr, events)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-events:
+				select {
+				case c <- &PurchaseResolver{purchase: event.Purchase, repo: r.repo, rates: r.rates, pageLimits: r.limits()}:
+					log.Printf("[GraphQL] Sent purchase review event to subscriber")
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return c, nil
+}
+
+// ListingCreated subscription resolver
+func (r *Resolver) ListingCreated(ctx context.Context, args struct{ SellerID *graphql.ID }) (<-chan *ListingResolver, error) {
+	sellerIDStr := ""
+	if args.SellerID != nil {
+		sellerID, err := id.ParseSellerID(string(*args.SellerID))
+		if err != nil {
+			return nil, err
+		}
+		// The event bus files listings under the decimal database ID
+		sellerIDStr = strconv.Itoa(sellerID)
+	}
+
+	events := r.eventBus.SubscribeToListings(sellerIDStr)
+	c := make(chan *ListingResolver, 1)
+
+	// Forward events to client until the subscription is closed
+	go func() {
+		defer close(c)
+		defer r.eventBus.UnsubscribeListings(sellerIDStr, events)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-events:
+				select {
+				case c <- &ListingResolver{listing: event.Listing, repo: r.repo, rates: r.rates, pageLimits: r.limits()}:
+					log.Printf("[GraphQL] Sent new listing event to subscriber")
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return c, nil
+}
+
+// Root Query resolvers
+func (r *Resolver) Seller(ctx context.Context, args struct{ ID graphql.ID }) (*SellerResolver, error) {
+	sellerID, err := id.ParseSellerID(string(args.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	seller, err := loadSeller(ctx, sellerID, r.repo.GetSeller)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SellerResolver{seller: seller, repo: r.repo, rates: r.rates, pageLimits: r.limits()}, nil
+}
+
+func (r *Resolver) Sellers(ctx context.Context) ([]*SellerResolver, error) {
+	sellers, err := r.repo.GetAllSellers()
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := make([]*SellerResolver, 0, len(sellers))
+	for _, seller := range sellers {
+		resolvers = append(resolvers, &SellerResolver{seller: seller, repo: r.repo, rates: r.rates, pageLimits: r.limits()})
+	}
+
+	return resolvers, nil
+}
+
+func (r *Resolver) SalesSummary(ctx context.Context, args struct {
+	SellerID graphql.ID
+	From     *string
+	To       *string
+}) (*SalesSummaryResolver, error) {
+	sellerID, err := id.ParseSellerID(string(args.SellerID))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var from, to *time.Time
+
+	if args.From != nil {
+		t, err := parseDateFilter(*args.From, false, now)
+		if err != nil {
+			return nil, invalidInput("invalid from: %v", err)
+		}
+		from = &t
+	}
+
+	if args.To != nil {
+		t, err := parseDateFilter(*args.To, true, now)
+		if err != nil {
+			return nil, invalidInput("invalid to: %v", err)
+		}
+		to = &t
+	}
+
+	seller, err := loadSeller(ctx, sellerID, r.repo.GetSeller)
+	if err == sql.ErrNoRows {
+		return nil, notFound("seller not found: %s", args.SellerID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	summary, err := r.repo.GetSellerSales(sellerID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SalesSummaryResolver{
+		summary: summary,
+		seller:  &SellerResolver{seller: seller, repo: r.repo, rates: r.rates, pageLimits: r.limits()},
+	}, nil
+}
+
+// SellerStats returns the all-time sales of a seller from the statistics cache
+func (r *Resolver) SellerStats(ctx context.Context, args struct{ SellerID graphql.ID }) (*SellerStatsResolver, error) {
+	sellerID, err := id.ParseSellerID(string(args.SellerID))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := loadSeller(ctx, sellerID, r.repo.GetSeller); err == sql.ErrNoRows {
+		return nil, notFound("seller not found: %s", args.SellerID)
+	} else if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := r.stats.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	return &SellerStatsResolver{summary: snapshot.Seller(sellerID), snapshot: snapshot, repo: r.repo, rates: r.rates, pageLimits: r.limits()}, nil
+}
+
+// TopSellers returns the sellers with the highest all-time revenue from the statistics cache
+func (r *Resolver) TopSellers(ctx context.Context, args struct{ Limit *int32 }) ([]*SellerStatsResolver, error) {
+	limit, err := r.limits().size("topSellers", args.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := r.stats.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*SellerStatsResolver
+	for _, summary := range snapshot.TopSellers(limit) {
+		result = append(result, &SellerStatsResolver{summary: summary, snapshot: snapshot, repo: r.repo, rates: r.rates, pageLimits: r.limits()})
+	}
+	return result, nil
+}
+
+func (r *Resolver) Listing(ctx context.Context, args struct{ ID graphql.ID }) (*ListingResolver, error) {
+	listingID, err := id.ParseListingID(string(args.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	listing, err := r.repo.GetListing(listingID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ListingResolver{listing: listing, repo: r.repo, rates: r.rates, pageLimits: r.limits()}, nil
 }
 
-func (r *DeliveryResolver) Purchase() (*PurchaseResolver, error) {
-	log.Printf("[GraphQL] Fetching purchase for delivery ID: %d", r.delivery.ID)
-
-	purchase, err := r.repo.GetPurchase(r.delivery.PurchaseID)
+func (r *Resolver) Listings(ctx context.Context, args struct {
+	Filter  *ListingFilterInput
+	OrderBy *string
+}) ([]*ListingResolver, error) {
+	filter, err := r.resolveListingFilter(args.Filter)
+	if err != nil {
+		return nil, err
+	}
+	if args.OrderBy != nil {
+		if filter == nil {
+			filter = &models.ListingFilter{}
+		}
+		filter.OrderBy = *args.OrderBy
+	}
+	listings, err := r.repo.GetListings(filter)
 	if err != nil {
-		log.Printf("[GraphQL] Error fetching purchase: %v", err)
 		return nil, err
 	}
 
-	return &PurchaseResolver{purchase: purchase, repo: r.repo}, nil
+	return newListingResolvers(listings, r.repo, r.rates, r.limits()), nil
 }
 
-func (r *DeliveryResolver) Timestamp() string {
-	return r.delivery.Timestamp.Format(time.RFC3339)
-}
+// SearchListings finds listings matching a text query, ranked by the search
+// backend if one is configured and by Postgres full-text search otherwise
+func (r *Resolver) SearchListings(ctx context.Context, args struct {
+	Query string
+	Limit *int32
+}) ([]*ListingResolver, error) {
+	limit, err := r.limits().size("searchListings", args.Limit)
+	if err != nil {
+		return nil, err
+	}
 
-func (r *DeliveryResolver) Status() string {
-	// Convert status to uppercase to match the GraphQL enum
-	switch r.delivery.Status {
-	case "packed":
-		return "PACKED"
-	case "out_for_delivery":
-		return "OUT_FOR_DELIVERY"
-	case "delivered":
-		return "DELIVERED"
-	case "rescheduled":
-		return "RESCHEDULED"
-	case "canceled":
-		return "CANCELED"
-	default:
-		return "UNKNOWN"
+	var listings []*models.Listing
+	if r.indexer != nil {
+		ids, err := r.indexer.Backend().Search(ctx, args.Query, limit)
+		if err != nil {
+			return nil, err
+		}
+		listings, err = r.repo.GetListingsByIDs(ids)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		listings, err = r.repo.SearchListings(args.Query, limit)
+		if err != nil {
+			return nil, err
+		}
 	}
-}
 
-// Input type resolvers
-type ListingFilterInput struct {
-	SellerID *graphql.ID
-	MinPrice *float64
-	MaxPrice *float64
-	Title    *string
+	return newListingResolvers(listings, r.repo, r.rates, r.limits()), nil
 }
 
-func (r *Resolver) resolveListingFilter(filter *ListingFilterInput) *models.ListingFilter {
-	if filter == nil {
-		return nil
+// Search finds sellers, listings and purchases containing a term, returning up
+// to the default page size of search results of each kind: sellers first, then
+// listings, then purchases
+func (r *Resolver) Search(ctx context.Context, args struct {
+	Term string
+}) ([]*SearchResultResolver, error) {
+	if strings.TrimSpace(args.Term) == "" {
+		return nil, invalidInput("search term cannot be empty")
 	}
 
-	result := &models.ListingFilter{}
-
-	if filter.SellerID != nil {
-		id, _ := strconv.Atoi(string(*filter.SellerID))
-		result.SellerID = &id
+	// Bank transaction IDs are admin only, so others can't probe for them
+	byBankTxID := RoleFromContext(ctx) == AdminRole
+	results, err := r.repo.Search(args.Term, r.limits().For("search").Default, byBankTxID)
+	if err != nil {
+		return nil, err
 	}
 
-	result.MinPrice = filter.MinPrice
-	result.MaxPrice = filter.MaxPrice
-	result.Title = filter.Title
-
-	return result
-}
+	resolvers := make([]*SearchResultResolver, 0, len(results.Sellers)+len(results.Listings)+len(results.Purchases))
+	for _, seller := range results.Sellers {
+		resolvers = append(resolvers, &SearchResultResolver{&SellerResolver{seller: seller, repo: r.repo, rates: r.rates, pageLimits: r.limits()}})
+	}
+	for _, listing := range results.Listings {
+		resolvers = append(resolvers, &SearchResultResolver{&ListingResolver{listing: listing, repo: r.repo, rates: r.rates, pageLimits: r.limits()}})
+	}
+	for _, purchase := range results.Purchases {
+		resolvers = append(resolvers, &SearchResultResolver{&PurchaseResolver{purchase: purchase, repo: r.repo, rates: r.rates, pageLimits: r.limits()}})
+	}
 
-type PurchaseFilterInput struct {
-	ListingID *graphql.ID
-	BankTxID  *string
-	FromDate  *string
-	ToDate    *string
+	return resolvers, nil
 }
 
-func (r *Resolver) resolvePurchaseFilter(filter *PurchaseFilterInput) *models.PurchaseFilter {
-	if filter == nil {
-		return nil
+// RecommendedListings suggests listings related to a listing
+func (r *Resolver) RecommendedListings(ctx context.Context, args struct {
+	ForListingID graphql.ID
+	Limit        *int32
+}) ([]*ListingResolver, error) {
+	listingID, err := id.ParseListingID(string(args.ForListingID))
+	if err != nil {
+		return nil, err
+	}
+	limit, err := r.limits().size("recommendedListings", args.Limit)
+	if err != nil {
+		return nil, err
 	}
 
-	result := &models.PurchaseFilter{}
+	ids, err := r.recommender.Recommend(ctx, listingID, limit)
+	if err != nil {
+		return nil, err
+	}
 
-	if filter.ListingID != nil {
-		id, _ := strconv.Atoi(string(*filter.ListingID))
-		result.ListingID = &id
+	listings, err := r.repo.GetListingsByIDs(ids)
+	if err != nil {
+		return nil, err
 	}
 
-	result.BankTxID = filter.BankTxID
+	return newListingResolvers(listings, r.repo, r.rates, r.limits()), nil
+}
 
-	if filter.FromDate != nil {
-		fromDate, err := time.Parse(time.RFC3339, *filter.FromDate)
-		if err == nil {
-			result.FromDate = &fromDate
+// ListingsConnection pages through listings ordered by ID, or by price and then
+// ID, using opaque keyset cursors
+func (r *Resolver) ListingsConnection(ctx context.Context, args struct {
+	Filter  *ListingFilterInput
+	OrderBy *string
+	First   *int32
+	After   *string
+	Last    *int32
+	Before  *string
+}) (*ListingConnectionResolver, error) {
+	var orderBy string
+	if args.OrderBy != nil {
+		orderBy = *args.OrderBy
+		if orderBy != models.OrderByPriceAsc && orderBy != models.OrderByPriceDesc {
+			return nil, invalidInput("listingsConnection can only be ordered by %s or %s", models.OrderByPriceAsc, models.OrderByPriceDesc)
 		}
 	}
 
-	if filter.ToDate != nil {
-		toDate, err := time.Parse(time.RFC3339, *filter.ToDate)
-		if err == nil {
-			result.ToDate = &toDate
-		}
+	page, req, err := resolvePage(r.cursors, "listingsConnection", r.limits().For("listingsConnection"), orderBy, args.First, args.After, args.Last, args.Before)
+	if err != nil {
+		return nil, err
 	}
 
-	return result
-}
-
-type DeliveryFilterInput struct {
-	PurchaseID *graphql.ID
-	Status     *string
-	FromDate   *string
-	ToDate     *string
-}
-
-func (r *Resolver) resolveDeliveryFilter(filter *DeliveryFilterInput) *models.DeliveryFilter {
+	filter, err := r.resolveListingFilter(args.Filter)
+	if err != nil {
+		return nil, err
+	}
 	if filter == nil {
-		return nil
+		filter = &models.ListingFilter{}
 	}
+	filter.Page = page
 
-	result := &models.DeliveryFilter{}
-
-	if filter.PurchaseID != nil {
-		id, _ := strconv.Atoi(string(*filter.PurchaseID))
-		result.PurchaseID = &id
+	listings, err := r.repo.GetListings(filter)
+	if err != nil {
+		return nil, err
 	}
 
-	if filter.Status != nil {
-		var status string
-		// Convert GraphQL enum to database enum
-		switch *filter.Status {
-		case "PACKED":
-			status = "packed"
-		case "OUT_FOR_DELIVERY":
-			status = "out_for_delivery"
-		case "DELIVERED":
-			status = "delivered"
-		case "RESCHEDULED":
-			status = "rescheduled"
-		case "CANCELED":
-			status = "canceled"
-		}
-		result.Status = &status
+	start, end, hasPrevious, hasNext := req.trim(len(listings))
+	conn := &ListingConnectionResolver{
+		edges:    make([]*ListingEdgeResolver, 0, end-start),
+		pageInfo: &PageInfoResolver{hasPreviousPage: hasPrevious, hasNextPage: hasNext},
 	}
-
-	if filter.FromDate != nil {
-		fromDate, err := time.Parse(time.RFC3339, *filter.FromDate)
-		if err == nil {
-			result.FromDate = &fromDate
+	for _, node := range newListingResolvers(listings[start:end], r.repo, r.rates, r.limits()) {
+		c, err := encodeCursor(r.cursors, page, node.listing.ID, node.listing.Price)
+		if err != nil {
+			return nil, err
 		}
+		conn.edges = append(conn.edges, &ListingEdgeResolver{
+			cursor: c,
+			node:   node,
+		})
 	}
-
-	if filter.ToDate != nil {
-		toDate, err := time.Parse(time.RFC3339, *filter.ToDate)
-		if err == nil {
-			result.ToDate = &toDate
-		}
+	if len(conn.edges) > 0 {
+		conn.pageInfo.startCursor = &conn.edges[0].cursor
+		conn.pageInfo.endCursor = &conn.edges[len(conn.edges)-1].cursor
 	}
 
-	return result
+	return conn, nil
 }
 
-// Input types for mutations
-type CreateListingInput struct {
-	SellerID    graphql.ID
-	Title       string
-	Description string
-	Price       float64
+// ListingConnectionResolver is a Relay connection over listings
+type ListingConnectionResolver struct {
+	edges    []*ListingEdgeResolver
+	pageInfo *PageInfoResolver
 }
 
-type CreatePurchaseInput struct {
-	ListingID       graphql.ID
-	Price           float64
-	BankTxID        string
-	DeliveryAddress string
+func (r *ListingConnectionResolver) Edges() []*ListingEdgeResolver {
+	return r.edges
 }
 
-type CreateDeliveryInput struct {
-	PurchaseID graphql.ID
-	Status     string
+func (r *ListingConnectionResolver) PageInfo() *PageInfoResolver {
+	return r.pageInfo
 }
 
-// Mutation resolvers
-func (r *Resolver) CreateListing(ctx context.Context, args struct{ Input CreateListingInput }) (*ListingResolver, error) {
-	log.Printf("[GraphQL] CreateListing mutation with input: %+v", args.Input)
+// ListingEdgeResolver pairs a listing with its cursor
+type ListingEdgeResolver struct {
+	cursor string
+	node   *ListingResolver
+}
 
-	// Parse seller ID
-	sellerID, err := strconv.Atoi(string(args.Input.SellerID))
-	if err != nil {
-		log.Printf("[GraphQL] Invalid seller ID format: %v", err)
-		return nil, fmt.Errorf("invalid seller ID format: %v", err)
+func (r *ListingEdgeResolver) Cursor() string {
+	return r.cursor
+}
+
+func (r *ListingEdgeResolver) Node() *ListingResolver {
+	return r.node
+}
+
+func (r *Resolver) ListingPriceStats(ctx context.Context, args struct {
+	Filter  *ListingFilterInput
+	Buckets int32
+}) (*PriceStatsResolver, error) {
+	if args.Buckets < 1 || args.Buckets > 100 {
+		return nil, invalidInput("buckets must be between 1 and 100, got %d", args.Buckets)
 	}
 
-	// Validate seller exists
-	_, err = r.repo.GetSeller(sellerID)
+	filter, err := r.resolveListingFilter(args.Filter)
 	if err != nil {
-		log.Printf("[GraphQL] Seller not found: %v", err)
-		return nil, fmt.Errorf("seller not found: %v", err)
+		return nil, err
 	}
-
-	// Create listing
-	listing, err := r.repo.CreateListing(
-		sellerID,
-		args.Input.Title,
-		args.Input.Description,
-		args.Input.Price,
-	)
+	stats, err := r.repo.GetListingPriceStats(filter, int(args.Buckets))
 	if err != nil {
-		log.Printf("[GraphQL] Error creating listing: %v", err)
 		return nil, err
 	}
 
-	log.Printf("[GraphQL] Successfully created listing ID: %d", listing.ID)
-	return &ListingResolver{listing: listing, repo: r.repo}, nil
+	return &PriceStatsResolver{stats: stats}, nil
 }
 
-func (r *Resolver) CreatePurchase(ctx context.Context, args struct{ Input CreatePurchaseInput }) (*PurchaseResolver, error) {
-	log.Printf("[GraphQL] CreatePurchase mutation with input: %+v", args.Input)
-
-	// Parse listing ID
-	listingID, err := strconv.Atoi(string(args.Input.ListingID))
+func (r *Resolver) Dashboard(ctx context.Context) (*DashboardResolver, error) {
+	dashboard, err := r.repo.GetDashboard()
 	if err != nil {
-		log.Printf("[GraphQL] Invalid listing ID format: %v", err)
-		return nil, fmt.Errorf("invalid listing ID format: %v", err)
+		return nil, err
 	}
+	return &DashboardResolver{dashboard: dashboard}, nil
+}
 
-	// Validate listing exists
-	_, err = r.repo.GetListing(listingID)
+// DashboardVersion lets clients poll cheaply for changes to the dashboard
+func (r *Resolver) DashboardVersion(ctx context.Context) (string, error) {
+	return r.repo.GetDashboardVersion()
+}
+
+func (r *Resolver) PurchaseStats(ctx context.Context, args struct{ Filter *PurchaseFilterInput }) (*PurchaseStatsResolver, error) {
+	filter, err := r.resolvePurchaseFilter(ctx, args.Filter)
 	if err != nil {
-		log.Printf("[GraphQL] Listing not found: %v", err)
-		return nil, fmt.Errorf("listing not found: %v", err)
+		return nil, err
 	}
-
-	// Create purchase
-	purchase, err := r.repo.CreatePurchase(
-		listingID,
-		args.Input.Price,
-		args.Input.BankTxID,
-		args.Input.DeliveryAddress,
-	)
+	stats, err := r.repo.GetPurchaseStats(filter)
 	if err != nil {
-		log.Printf("[GraphQL] Error creating purchase: %v", err)
 		return nil, err
 	}
 
-	log.Printf("[GraphQL] Successfully created purchase ID: %d", purchase.ID)
-	return &PurchaseResolver{purchase: purchase, repo: r.repo}, nil
+	return &PurchaseStatsResolver{stats: stats}, nil
 }
 
-// CreateDelivery mutation resolver
-func (r *Resolver) CreateDelivery(ctx context.Context, args struct{ Input CreateDeliveryInput }) (*DeliveryResolver, error) {
-	log.Printf("[GraphQL] CreateDelivery mutation with input: %+v", args.Input)
-
-	// Parse purchase ID
-	purchaseID, err := strconv.Atoi(string(args.Input.PurchaseID))
+// Purchase fetches a purchase, as it was at asOf if given
+func (r *Resolver) Purchase(ctx context.Context, args struct {
+	ID   graphql.ID
+	AsOf *string
+}) (*PurchaseResolver, error) {
+	purchaseID, err := id.ParsePurchaseID(string(args.ID))
 	if err != nil {
-		log.Printf("[GraphQL] Invalid purchase ID format: %v", err)
-		return nil, fmt.Errorf("invalid purchase ID format: %v", err)
+		return nil, err
 	}
-
-	// Validate purchase exists
-	_, err = r.repo.GetPurchase(purchaseID)
+	asOf, err := parseAsOf(args.AsOf, time.Now())
 	if err != nil {
-		log.Printf("[GraphQL] Purchase not found: %v", err)
-		return nil, fmt.Errorf("purchase not found: %v", err)
+		return nil, err
 	}
 
-	// Convert GraphQL enum to database enum
-	var status string
-	switch args.Input.Status {
-	case "PACKED":
-		status = "packed"
-	case "OUT_FOR_DELIVERY":
-		status = "out_for_delivery"
-	case "DELIVERED":
-		status = "delivered"
-	case "RESCHEDULED":
-		status = "rescheduled"
-	case "CANCELED":
-		status = "canceled"
-	default:
-		log.Printf("[GraphQL] Invalid status: %s", args.Input.Status)
-		return nil, fmt.Errorf("invalid status: %s", args.Input.Status)
+	var purchase *models.Purchase
+	if asOf != nil {
+		purchase, err = r.repo.GetPurchaseAsOf(purchaseID, *asOf)
+	} else {
+		purchase, err = r.repo.GetPurchase(purchaseID)
 	}
-
-	// Create delivery
-	delivery, err := r.repo.CreateDelivery(purchaseID, status)
 	if err != nil {
-		log.Printf("[GraphQL] Error creating delivery: %v", err)
 		return nil, err
 	}
 
-	log.Printf("[GraphQL] Successfully created delivery ID: %d", delivery.ID)
-
-	// Publish the event
-	r.eventBus.PublishDelivery(delivery)
-
-	return &DeliveryResolver{delivery: delivery, repo: r.repo}, nil
+	return &PurchaseResolver{purchase: purchase, repo: r.repo, rates: r.rates, pageLimits: r.limits(), asOf: asOf}, nil
 }
 
-// DeliveryUpdated subscription resolver
-func (r *Resolver) DeliveryUpdated(ctx context.Context, args struct{ PurchaseID *graphql.ID }) (<-chan *DeliveryResolver, error) {
-	var purchaseIDStr string
-	if args.PurchaseID != nil {
-		purchaseIDStr = string(*args.PurchaseID)
-		log.Printf("[GraphQL] DeliveryUpdated subscription for purchase ID: %s", purchaseIDStr)
-	} else {
-		log.Printf("[GraphQL] DeliveryUpdated subscription for all deliveries")
+func (r *Resolver) Purchases(ctx context.Context, args struct {
+	Filter *PurchaseFilterInput
+	Limit  *int32
+	Offset *int32
+}) ([]*PurchaseResolver, error) {
+	limit, offset, err := r.limits().limitOffset("purchases", args.Limit, args.Offset)
+	if err != nil {
+		return nil, err
 	}
 
-	// Create event channel
-	events := r.eventBus.SubscribeToDeliveries(purchaseIDStr)
-	c := make(chan *DeliveryResolver, 1)
-
-	// Handle clean up when subscription is closed
-	go func() {
-		<-ctx.Done()
-		log.Printf("[GraphQL] Subscription context done, cleaning up")
-		r.eventBus.Unsubscribe(purchaseIDStr, events)
-		close(c)
-	}()
-
-	// Forward events to client
-	go func() {
-		for event := range events {
-			select {
-			case <-ctx.Done():
-				return
-			case c <- &DeliveryResolver{delivery: event.Delivery, repo: r.repo}:
-				log.Printf("[GraphQL] Sent delivery event to subscriber")
-			}
-		}
-	}()
-
-	return c, nil
-}
-
-// Root Query resolvers
-func (r *Resolver) Seller(ctx context.Context, args struct{ ID graphql.ID }) (*SellerResolver, error) {
-	log.Printf("[GraphQL] Seller query with ID: %s", args.ID)
-
-	id, err := strconv.Atoi(string(args.ID))
+	filter, err := r.resolvePurchaseFilter(ctx, args.Filter)
 	if err != nil {
-		log.Printf("[GraphQL] Invalid seller ID format: %v", err)
-		return nil, fmt.Errorf("invalid seller ID format: %v", err)
+		return nil, err
 	}
-
-	seller, err := r.repo.GetSeller(id)
+	if filter == nil {
+		filter = &models.PurchaseFilter{}
+	}
+	filter.Limit, filter.Offset = limit, offset
+	purchases, err := r.repo.GetPurchases(filter)
 	if err != nil {
-		log.Printf("[GraphQL] Error fetching seller: %v", err)
 		return nil, err
 	}
 
-	return &SellerResolver{seller: seller, repo: r.repo}, nil
+	resolvers := make([]*PurchaseResolver, 0, len(purchases))
+	for _, purchase := range purchases {
+		resolvers = append(resolvers, &PurchaseResolver{purchase: purchase, repo: r.repo, rates: r.rates, pageLimits: r.limits()})
+	}
+
+	return resolvers, nil
 }
 
-func (r *Resolver) Sellers(ctx context.Context) ([]*SellerResolver, error) {
-	log.Printf("[GraphQL] Sellers query")
+// maxBankTxIDBatch bounds the number of bank transactions looked up by one
+// purchasesByBankTxIds call
+const maxBankTxIDBatch = 1000
 
-	sellers, err := r.repo.GetAllSellers()
+// PurchasesByBankTxIds resolves many bank transaction IDs to their purchases
+// with a single query, for reconciliation
+func (r *Resolver) PurchasesByBankTxIds(ctx context.Context, args struct{ Ids []string }) ([]*PurchaseResolver, error) {
+	if len(args.Ids) > maxBankTxIDBatch {
+		return nil, invalidInput("at most %d bank transaction IDs can be looked up at once", maxBankTxIDBatch)
+	}
+	if len(args.Ids) == 0 {
+		return []*PurchaseResolver{}, nil
+	}
+
+	purchases, err := r.repo.GetPurchasesByBankTxIDs(args.Ids)
 	if err != nil {
-		log.Printf("[GraphQL] Error fetching sellers: %v", err)
 		return nil, err
 	}
 
-	var resolvers []*SellerResolver
-	for _, seller := range sellers {
-		resolvers = append(resolvers, &SellerResolver{seller: seller, repo: r.repo})
+	resolvers := make([]*PurchaseResolver, 0, len(purchases))
+	for _, purchase := range purchases {
+		resolvers = append(resolvers, &PurchaseResolver{purchase: purchase, repo: r.repo, rates: r.rates, pageLimits: r.limits()})
 	}
 
 	return resolvers, nil
 }
 
-func (r *Resolver) Listing(ctx context.Context, args struct{ ID graphql.ID }) (*ListingResolver, error) {
-	log.Printf("[GraphQL] Listing query with ID: %s", args.ID)
-
-	id, err := strconv.Atoi(string(args.ID))
+// Delivery fetches a delivery update. With asOf, updates recorded later don't
+// exist yet and its purchase is resolved as of the same time
+func (r *Resolver) Delivery(ctx context.Context, args struct {
+	ID   graphql.ID
+	AsOf *string
+}) (*DeliveryResolver, error) {
+	deliveryID, err := id.ParseDeliveryID(string(args.ID))
+	if err != nil {
+		return nil, err
+	}
+	asOf, err := parseAsOf(args.AsOf, time.Now())
 	if err != nil {
-		log.Printf("[GraphQL] Invalid listing ID format: %v", err)
-		return nil, fmt.Errorf("invalid listing ID format: %v", err)
+		return nil, err
 	}
 
-	listing, err := r.repo.GetListing(id)
+	delivery, err := r.repo.GetDelivery(deliveryID)
 	if err != nil {
-		log.Printf("[GraphQL] Error fetching listing: %v", err)
 		return nil, err
 	}
+	if asOf != nil && delivery.Timestamp.After(*asOf) {
+		return nil, notFound("delivery %s was recorded after %s", args.ID, asOf.Format(time.RFC3339))
+	}
 
-	return &ListingResolver{listing: listing, repo: r.repo}, nil
+	return &DeliveryResolver{delivery: delivery, repo: r.repo, rates: r.rates, pageLimits: r.limits(), asOf: asOf}, nil
 }
 
-func (r *Resolver) Listings(ctx context.Context, args struct{ Filter *ListingFilterInput }) ([]*ListingResolver, error) {
-	log.Printf("[GraphQL] Listings query with filter")
+func (r *Resolver) Deliveries(ctx context.Context, args struct {
+	Filter *DeliveryFilterInput
+	Limit  *int32
+	Offset *int32
+	AsOf   *string
+}) ([]*DeliveryResolver, error) {
+	limit, offset, err := r.limits().limitOffset("deliveries", args.Limit, args.Offset)
+	if err != nil {
+		return nil, err
+	}
+	asOf, err := parseAsOf(args.AsOf, time.Now())
+	if err != nil {
+		return nil, err
+	}
 
-	filter := r.resolveListingFilter(args.Filter)
-	listings, err := r.repo.GetListings(filter)
+	filter, err := r.resolveDeliveryFilter(args.Filter)
+	if err != nil {
+		return nil, err
+	}
+	if filter == nil {
+		filter = &models.DeliveryFilter{}
+	}
+	filter.Limit, filter.Offset = limit, offset
+	// Updates recorded after asOf didn't exist yet
+	if asOf != nil && (filter.ToDate == nil || filter.ToDate.After(*asOf)) {
+		filter.ToDate = asOf
+	}
+	deliveries, err := r.repo.GetDeliveries(filter)
 	if err != nil {
-		log.Printf("[GraphQL] Error fetching listings: %v", err)
 		return nil, err
 	}
 
-	var resolvers []*ListingResolver
-	for _, listing := range listings {
-		resolvers = append(resolvers, &ListingResolver{listing: listing, repo: r.repo})
+	resolvers := make([]*DeliveryResolver, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		resolvers = append(resolvers, &DeliveryResolver{delivery: delivery, repo: r.repo, rates: r.rates, pageLimits: r.limits(), asOf: asOf})
 	}
 
 	return resolvers, nil
 }
 
-func (r *Resolver) Purchase(ctx context.Context, args struct{ ID graphql.ID }) (*PurchaseResolver, error) {
-	log.Printf("[GraphQL] Purchase query with ID: %s", args.ID)
+func (r *Resolver) LatestDelivery(ctx context.Context, args struct {
+	PurchaseID graphql.ID
+	AsOf       *string
+}) (*DeliveryResolver, error) {
+	purchaseID, err := id.ParsePurchaseID(string(args.PurchaseID))
+	if err != nil {
+		return nil, err
+	}
+	asOf, err := parseAsOf(args.AsOf, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if asOf != nil {
+		delivery, err := latestDeliveryAsOf(r.repo, purchaseID, *asOf)
+		if err != nil || delivery == nil {
+			return nil, err
+		}
+		return &DeliveryResolver{delivery: delivery, repo: r.repo, rates: r.rates, pageLimits: r.limits(), asOf: asOf}, nil
+	}
+
+	delivery, err := r.repo.GetLatestDelivery(purchaseID)
+	if err == sql.ErrNoRows {
+		// No delivery updates yet
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	id, err := strconv.Atoi(string(args.ID))
+	return &DeliveryResolver{delivery: delivery, repo: r.repo, rates: r.rates, pageLimits: r.limits()}, nil
+}
+
+func (r *Resolver) DeliveryTimeline(ctx context.Context, args struct{ PurchaseID graphql.ID }) ([]*DeliveryTimelineDayResolver, error) {
+	purchaseID, err := id.ParsePurchaseID(string(args.PurchaseID))
 	if err != nil {
-		log.Printf("[GraphQL] Invalid purchase ID format: %v", err)
-		return nil, fmt.Errorf("invalid purchase ID format: %v", err)
+		return nil, err
 	}
 
-	purchase, err := r.repo.GetPurchase(id)
+	days, err := r.repo.GetDeliveryTimeline(purchaseID)
 	if err != nil {
-		log.Printf("[GraphQL] Error fetching purchase: %v", err)
 		return nil, err
 	}
 
-	return &PurchaseResolver{purchase: purchase, repo: r.repo}, nil
+	resolvers := make([]*DeliveryTimelineDayResolver, 0, len(days))
+	for _, day := range days {
+		resolvers = append(resolvers, &DeliveryTimelineDayResolver{day: day, purchaseID: purchaseID, repo: r.repo, rates: r.rates, pageLimits: r.limits()})
+	}
+
+	return resolvers, nil
 }
 
-func (r *Resolver) Purchases(ctx context.Context, args struct{ Filter *PurchaseFilterInput }) ([]*PurchaseResolver, error) {
-	log.Printf("[GraphQL] Purchases query with filter")
+func (r *Resolver) PurchasesByDeliveryStatus(ctx context.Context, args struct{ Status string }) ([]*PurchaseResolver, error) {
+	status, ok := deliveryStatusFromEnum(args.Status)
+	if !ok {
+		return nil, invalidInput("invalid status: %s", args.Status)
+	}
 
-	filter := r.resolvePurchaseFilter(args.Filter)
-	purchases, err := r.repo.GetPurchases(filter)
+	purchases, err := r.repo.GetPurchasesByLatestDeliveryStatus(status)
 	if err != nil {
-		log.Printf("[GraphQL] Error fetching purchases: %v", err)
 		return nil, err
 	}
 
-	var resolvers []*PurchaseResolver
+	resolvers := make([]*PurchaseResolver, 0, len(purchases))
 	for _, purchase := range purchases {
-		resolvers = append(resolvers, &PurchaseResolver{purchase: purchase, repo: r.repo})
+		resolvers = append(resolvers, &PurchaseResolver{purchase: purchase, repo: r.repo, rates: r.rates, pageLimits: r.limits()})
 	}
 
 	return resolvers, nil
 }
 
-func (r *Resolver) Delivery(ctx context.Context, args struct{ ID graphql.ID }) (*DeliveryResolver, error) {
-	log.Printf("[GraphQL] Delivery query with ID: %s", args.ID)
-
-	id, err := strconv.Atoi(string(args.ID))
+func (r *Resolver) NearestPickupPoints(ctx context.Context, args struct {
+	Lat   float64
+	Lon   float64
+	Limit *int32
+}) ([]*PickupPointResolver, error) {
+	if args.Lat < -90 || args.Lat > 90 || args.Lon < -180 || args.Lon > 180 {
+		return nil, invalidInput("invalid coordinates: lat must be within [-90, 90] and lon within [-180, 180]")
+	}
+	limit, err := r.limits().size("nearestPickupPoints", args.Limit)
 	if err != nil {
-		log.Printf("[GraphQL] Invalid delivery ID format: %v", err)
-		return nil, fmt.Errorf("invalid delivery ID format: %v", err)
+		return nil, err
 	}
 
-	delivery, err := r.repo.GetDelivery(id)
+	points, err := r.repo.GetNearestPickupPoints(args.Lat, args.Lon, limit)
 	if err != nil {
-		log.Printf("[GraphQL] Error fetching delivery: %v", err)
 		return nil, err
 	}
 
-	return &DeliveryResolver{delivery: delivery, repo: r.repo}, nil
-}
+	resolvers := make([]*PickupPointResolver, 0, len(points))
+	for _, point := range points {
+		resolvers = append(resolvers, &PickupPointResolver{point: point})
+	}
 
-func (r *Resolver) Deliveries(ctx context.Context, args struct{ Filter *DeliveryFilterInput }) ([]*DeliveryResolver, error) {
-	log.Printf("[GraphQL] Deliveries query with filter")
+	return resolvers, nil
+}
 
-	filter := r.resolveDeliveryFilter(args.Filter)
-	deliveries, err := r.repo.GetDeliveries(filter)
+func (r *Resolver) Receipt(ctx context.Context, args struct{ PurchaseID graphql.ID }) (*ReceiptResolver, error) {
+	purchaseID, err := id.ParsePurchaseID(string(args.PurchaseID))
 	if err != nil {
-		log.Printf("[GraphQL] Error fetching deliveries: %v", err)
 		return nil, err
 	}
 
-	var resolvers []*DeliveryResolver
-	for _, delivery := range deliveries {
-		resolvers = append(resolvers, &DeliveryResolver{delivery: delivery, repo: r.repo})
+	rec, err := receipt.Load(r.repo, purchaseID)
+	if err != nil {
+		return nil, err
 	}
 
-	return resolvers, nil
+	return &ReceiptResolver{receipt: rec, repo: r.repo, rates: r.rates, pageLimits: r.limits()}, nil
 }
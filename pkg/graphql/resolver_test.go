@@ -0,0 +1,460 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/graph-gophers/graphql-go"
+	"github.com/korjavin/graphqlTinyExample/pkg/id"
+	"github.com/korjavin/graphqlTinyExample/pkg/receipt"
+	"github.com/korjavin/graphqlTinyExample/pkg/repository"
+)
+
+func TestGetSchema(t *testing.T) {
+	// Parsing with the real resolver validates every resolver signature against the schema
+	if _, err := GetSchema(NewResolver(nil)); err != nil {
+		t.Fatalf("Failed to parse schema with resolver: %v", err)
+	}
+}
+
+func TestResolveFilterInvalidID(t *testing.T) {
+	r := NewResolver(nil)
+	sellerID := graphql.ID("abc")
+
+	if _, err := r.resolveListingFilter(&ListingFilterInput{SellerID: &sellerID}); err == nil {
+		t.Errorf("Expected an invalid seller ID to be rejected")
+	}
+
+	listingIDs := []graphql.ID{"1", "x"}
+	if _, err := r.resolvePurchaseFilter(context.Background(), &PurchaseFilterInput{ListingIDIn: &listingIDs}); err == nil {
+		t.Errorf("Expected an invalid listing ID to be rejected")
+	}
+}
+
+func TestPurchaseFilterByBankTxIDRequiresAdmin(t *testing.T) {
+	r := NewResolver(nil)
+	bankTxID := "TX123"
+
+	_, err := r.resolvePurchaseFilter(WithRole(context.Background(), "seller"), &PurchaseFilterInput{BankTxID: &bankTxID})
+	if errorCode(err) != CodeForbidden {
+		t.Errorf("Expected %s for a seller, got %v", CodeForbidden, err)
+	}
+
+	filter, err := r.resolvePurchaseFilter(WithRole(context.Background(), AdminRole), &PurchaseFilterInput{BankTxID: &bankTxID})
+	if err != nil || filter.BankTxID == nil || *filter.BankTxID != bankTxID {
+		t.Errorf("Expected admins to filter by bank transaction ID, got %+v, %v", filter, err)
+	}
+}
+
+func TestEmptyListsResolveToEmptyArrays(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock database: %v", err)
+	}
+	defer db.Close()
+
+	// Root fields may be resolved concurrently
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectQuery("FROM sellers").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery("FROM purchases").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	schema, err := GetSchema(NewResolver(repository.NewRepository(db)))
+	if err != nil {
+		t.Fatalf("Failed to parse schema: %v", err)
+	}
+
+	resp := schema.Exec(context.Background(), "{ sellers { id } purchases { id } }", "", nil)
+	if len(resp.Errors) > 0 {
+		t.Fatalf("Unexpected errors: %v", resp.Errors)
+	}
+
+	data, err := json.Marshal(resp.Data)
+	if err != nil {
+		t.Fatalf("Failed to marshal response: %v", err)
+	}
+	if expected := `{"sellers":[],"purchases":[]}`; string(data) != expected {
+		t.Errorf("Expected %s, got %s", expected, data)
+	}
+}
+
+func TestSearchResolvesUnionMembers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock database: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("FROM sellers").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "address", "digest_opt_in"}).
+			AddRow(1, "Main Street Books", "1 Main St", false))
+	mock.ExpectQuery("FROM listings").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "seller_id", "title", "description", "price"}).
+			AddRow(2, 1, "Main Course", "A cookbook", 20.0))
+	// Anonymous callers can't match purchases by bank transaction ID
+	mock.ExpectQuery("FROM purchases WHERE delivery_address ILIKE").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "listing_id", "price", "tax_amount", "bank_tx_id", "delivery_address", "pickup_point_id", "status", "order_status", "created_at"}))
+
+	schema, err := GetSchema(NewResolver(repository.NewRepository(db)))
+	if err != nil {
+		t.Fatalf("Failed to parse schema: %v", err)
+	}
+
+	query := `{ search(term: "main") { __typename ... on Seller { name } ... on Listing { title } ... on Purchase { bankTxId } } }`
+	resp := schema.Exec(context.Background(), query, "", nil)
+	if len(resp.Errors) > 0 {
+		t.Fatalf("Unexpected errors: %v", resp.Errors)
+	}
+
+	data, err := json.Marshal(resp.Data)
+	if err != nil {
+		t.Fatalf("Failed to marshal response: %v", err)
+	}
+	expected := `{"search":[{"__typename":"Seller","name":"Main Street Books"},{"__typename":"Listing","title":"Main Course"}]}`
+	if string(data) != expected {
+		t.Errorf("Expected %s, got %s", expected, data)
+	}
+}
+
+func TestMutationReportsUserErrors(t *testing.T) {
+	// Input is validated before the database is touched
+	schema, err := GetSchema(NewResolver(nil))
+	if err != nil {
+		t.Fatalf("Failed to parse schema: %v", err)
+	}
+
+	resp := schema.Exec(context.Background(), `mutation {
+		negative: createListing(input: {sellerId: "1", title: "Bike", description: "Red", price: -5}) {
+			listing { id }
+			userErrors { field message code }
+		}
+		untitled: createListing(input: {sellerId: "1", title: "", description: "Red", price: 5}) {
+			listing { id }
+			userErrors { field code }
+		}
+	}`, "", nil)
+	if len(resp.Errors) > 0 {
+		t.Fatalf("Expected no GraphQL errors, got %v", resp.Errors)
+	}
+
+	expected := `{"negative":{"listing":null,"userErrors":[{"field":"price","message":"price must be positive, got -5.00","code":"INVALID_INPUT"}]},` +
+		`"untitled":{"listing":null,"userErrors":[{"field":"title","code":"INVALID_INPUT"}]}}`
+	if string(resp.Data) != expected {
+		t.Errorf("Expected %s, got %s", expected, resp.Data)
+	}
+}
+
+func TestTopSellersReadsStatisticsCache(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock database: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("FROM sellers s").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "count", "sum"}).
+			AddRow(1, 1, "10.00").
+			AddRow(2, 3, "90.00"))
+
+	resolver := NewResolver(repository.NewRepository(db))
+	schema, err := GetSchema(resolver)
+	if err != nil {
+		t.Fatalf("Failed to parse schema: %v", err)
+	}
+
+	// Statistics are served only once computed
+	resp := schema.Exec(context.Background(), "{ topSellers { totalSales } }", "", nil)
+	if len(resp.Errors) == 0 {
+		t.Error("Expected an error before the statistics are computed")
+	}
+
+	if err := resolver.StatsCache().Refresh(); err != nil {
+		t.Fatalf("Failed to refresh statistics: %v", err)
+	}
+	resp = schema.Exec(context.Background(), "{ topSellers(limit: 1) { totalSales totalRevenue staleness } }", "", nil)
+	if len(resp.Errors) > 0 {
+		t.Fatalf("Unexpected errors: %v", resp.Errors)
+	}
+	if expected := `{"topSellers":[{"totalSales":3,"totalRevenue":90.00,"staleness":0}]}`; string(resp.Data) != expected {
+		t.Errorf("Expected %s, got %s", expected, resp.Data)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestNestedListsArePaginated(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock database: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("FROM sellers").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "address", "digest_opt_in"}).
+			AddRow(1, "Main Street Books", "1 Main St", false))
+	mock.ExpectQuery("FROM listings WHERE seller_id = \\$1 ORDER BY id LIMIT \\$2 OFFSET \\$3").
+		WithArgs(1, 1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "seller_id", "title", "description", "price"}).
+			AddRow(3, 1, "Main Course", "A cookbook", 20.0))
+
+	schema, err := GetSchema(NewResolver(repository.NewRepository(db)))
+	if err != nil {
+		t.Fatalf("Failed to parse schema: %v", err)
+	}
+
+	resp := schema.Exec(context.Background(), `{ seller(id: "1") { listings(limit: 1, offset: 2) { id } } }`, "", nil)
+	if len(resp.Errors) > 0 {
+		t.Fatalf("Unexpected errors: %v", resp.Errors)
+	}
+
+	data, err := json.Marshal(resp.Data)
+	if err != nil {
+		t.Fatalf("Failed to marshal response: %v", err)
+	}
+	if expected := `{"seller":{"listings":[{"id":"3"}]}}`; string(data) != expected {
+		t.Errorf("Expected %s, got %s", expected, data)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPurchaseLatestDelivery(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock database: %v", err)
+	}
+	defer db.Close()
+
+	purchaseColumns := []string{"id", "listing_id", "price", "tax_amount", "bank_tx_id", "delivery_address", "pickup_point_id", "status", "order_status", "created_at"}
+	deliveryColumns := []string{"id", "purchase_id", "timestamp", "status", "scheduled_for", "attempt_number"}
+	schema, err := GetSchema(NewResolver(repository.NewRepository(db)))
+	if err != nil {
+		t.Fatalf("Failed to parse schema: %v", err)
+	}
+
+	// Both fields share a single query for the latest delivery
+	mock.ExpectQuery("FROM purchases").
+		WillReturnRows(sqlmock.NewRows(purchaseColumns).AddRow(1, 1, 10.0, 0.0, "TX1", "Address", nil, "approved", "shipped", time.Now()))
+	mock.ExpectQuery("FROM deliveries WHERE purchase_id = \\$1 ORDER BY timestamp DESC LIMIT 1").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(deliveryColumns).AddRow(7, 1, time.Now(), "out_for_delivery", nil, 1))
+
+	resp := schema.Exec(context.Background(), `{ purchase(id: "1") { currentStatus latestDelivery { id } } }`, "", nil)
+	if len(resp.Errors) > 0 {
+		t.Fatalf("Unexpected errors: %v", resp.Errors)
+	}
+	if expected := `{"purchase":{"currentStatus":"OUT_FOR_DELIVERY","latestDelivery":{"id":"7"}}}`; string(resp.Data) != expected {
+		t.Errorf("Expected %s, got %s", expected, resp.Data)
+	}
+
+	// Before the first delivery update both are null
+	mock.ExpectQuery("FROM purchases").
+		WillReturnRows(sqlmock.NewRows(purchaseColumns).AddRow(2, 1, 10.0, 0.0, "TX2", "Address", nil, "pending", "pending", time.Now()))
+	mock.ExpectQuery("FROM deliveries WHERE purchase_id = \\$1 ORDER BY timestamp DESC LIMIT 1").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows(deliveryColumns))
+
+	resp = schema.Exec(context.Background(), `{ purchase(id: "2") { currentStatus latestDelivery { id } } }`, "", nil)
+	if len(resp.Errors) > 0 {
+		t.Fatalf("Unexpected errors: %v", resp.Errors)
+	}
+	if expected := `{"purchase":{"currentStatus":null,"latestDelivery":null}}`; string(resp.Data) != expected {
+		t.Errorf("Expected %s, got %s", expected, resp.Data)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPurchaseAsOf(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock database: %v", err)
+	}
+	defer db.Close()
+
+	purchaseColumns := []string{"id", "listing_id", "price", "tax_amount", "bank_tx_id", "delivery_address", "pickup_point_id", "status", "order_status", "created_at"}
+	deliveryColumns := []string{"id", "purchase_id", "timestamp", "status", "scheduled_for", "attempt_number"}
+	schema, err := GetSchema(NewResolver(repository.NewRepository(db)))
+	if err != nil {
+		t.Fatalf("Failed to parse schema: %v", err)
+	}
+
+	// currentStatus and deliveries are resolved concurrently
+	mock.MatchExpectationsInOrder(false)
+	asOf := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("FROM purchases p JOIN LATERAL").
+		WithArgs(1, asOf).
+		WillReturnRows(sqlmock.NewRows(purchaseColumns).AddRow(1, 1, 10.0, 0.0, "TX1", "Address", nil, "approved", "paid", asOf.Add(-time.Hour)))
+	// Deliveries recorded after asOf are left out
+	mock.ExpectQuery("FROM deliveries WHERE purchase_id = \\$1 AND timestamp <= \\$2 ORDER BY timestamp DESC, id DESC LIMIT \\$3").
+		WithArgs(1, asOf, 1).
+		WillReturnRows(sqlmock.NewRows(deliveryColumns).AddRow(3, 1, asOf.Add(-time.Minute), "packed", nil, 1))
+	mock.ExpectQuery("FROM deliveries WHERE purchase_id = \\$1 AND timestamp <= \\$2 ORDER BY timestamp DESC, id DESC").
+		WithArgs(1, asOf, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(deliveryColumns).AddRow(3, 1, asOf.Add(-time.Minute), "packed", nil, 1))
+
+	resp := schema.Exec(context.Background(), `{ purchase(id: "1", asOf: "2024-03-01T12:00:00Z") { orderStatus currentStatus deliveries { id } } }`, "", nil)
+	if len(resp.Errors) > 0 {
+		t.Fatalf("Unexpected errors: %v", resp.Errors)
+	}
+	if expected := `{"purchase":{"orderStatus":"PAID","currentStatus":"PACKED","deliveries":[{"id":"3"}]}}`; string(resp.Data) != expected {
+		t.Errorf("Expected %s, got %s", expected, resp.Data)
+	}
+
+	// A delivery recorded after asOf didn't exist yet
+	mock.ExpectQuery("FROM deliveries WHERE id = \\$1").
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows(deliveryColumns).AddRow(4, 1, asOf.Add(time.Hour), "out_for_delivery", nil, 1))
+	resp = schema.Exec(context.Background(), `{ delivery(id: "4", asOf: "2024-03-01T12:00:00Z") { id } }`, "", nil)
+	if len(resp.Errors) != 1 || resp.Errors[0].Extensions["code"] != CodeNotFound {
+		t.Errorf("Expected a NOT_FOUND error, got %v", resp.Errors)
+	}
+
+	resp = schema.Exec(context.Background(), `{ purchase(id: "1", asOf: "someday") { id } }`, "", nil)
+	if len(resp.Errors) != 1 || resp.Errors[0].Extensions["code"] != CodeInvalidInput {
+		t.Errorf("Expected an INVALID_INPUT error for an invalid asOf, got %v", resp.Errors)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestResolveDeliveryFilterStatuses(t *testing.T) {
+	r := NewResolver(nil)
+	statuses := []string{"PACKED", "OUT_FOR_DELIVERY"}
+
+	filter, err := r.resolveDeliveryFilter(&DeliveryFilterInput{Statuses: &statuses})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(filter.StatusIn) != 2 || filter.StatusIn[0] != "packed" || filter.StatusIn[1] != "out_for_delivery" {
+		t.Errorf("Expected packed and out_for_delivery, got %v", filter.StatusIn)
+	}
+
+	if _, err := r.resolveDeliveryFilter(&DeliveryFilterInput{Statuses: &statuses, StatusIn: &statuses}); err == nil {
+		t.Errorf("Expected statuses and statusIn to be rejected together")
+	}
+}
+
+func TestResolveListingFilterAndOr(t *testing.T) {
+	r := NewResolver(nil)
+	sellerID, title := graphql.ID("1"), "sale"
+
+	filter, err := r.resolveListingFilter(&ListingFilterInput{
+		Or: &[]*ListingFilterInput{{SellerID: &sellerID}, {Title: &title}},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(filter.Or) != 2 || filter.Or[0].SellerID == nil || *filter.Or[0].SellerID != 1 || filter.Or[1].Title == nil {
+		t.Errorf("Expected both alternatives to be resolved, got %+v", filter.Or)
+	}
+
+	invalidID := graphql.ID("abc")
+	if _, err := r.resolveListingFilter(&ListingFilterInput{And: &[]*ListingFilterInput{{SellerID: &invalidID}}}); err == nil {
+		t.Errorf("Expected an invalid seller ID in a sub-filter to be rejected")
+	}
+
+	// Nest one level deeper than allowed
+	deep := &ListingFilterInput{}
+	for i := 0; i <= maxFilterDepth; i++ {
+		deep = &ListingFilterInput{And: &[]*ListingFilterInput{deep}}
+	}
+	if _, err := r.resolveListingFilter(deep); err == nil {
+		t.Errorf("Expected filters nested deeper than %d levels to be rejected", maxFilterDepth)
+	}
+}
+
+func TestPurchasesByBankTxIdsBatchSize(t *testing.T) {
+	r := NewResolver(nil)
+
+	purchases, err := r.PurchasesByBankTxIds(context.Background(), struct{ Ids []string }{})
+	if err != nil || len(purchases) != 0 {
+		t.Errorf("Expected no purchases for no IDs, got %v, %v", purchases, err)
+	}
+
+	ids := make([]string, maxBankTxIDBatch+1)
+	if _, err := r.PurchasesByBankTxIds(context.Background(), struct{ Ids []string }{ids}); err == nil {
+		t.Errorf("Expected more than %d IDs to be rejected", maxBankTxIDBatch)
+	}
+}
+
+func FuzzResolvePurchaseFilter(f *testing.F) {
+	f.Add("1", "APPROVED", "PAID", "last7d", "2025-04-01T00:00:00Z")
+	f.Add("-1", "approved", "", "last99999999999999999999d", "'; --")
+	f.Add("1e3", "CANCELED", "REFUNDED", "thisWeek", "today")
+
+	r := NewResolver(nil)
+	f.Fuzz(func(t *testing.T, listingID, status, orderStatus, fromDate, toDate string) {
+		ids := []graphql.ID{graphql.ID(listingID)}
+		filter, err := r.resolvePurchaseFilter(context.Background(), &PurchaseFilterInput{
+			ListingIDIn: &ids,
+			Status:      &status,
+			OrderStatus: &orderStatus,
+			FromDate:    &fromDate,
+			ToDate:      &toDate,
+		})
+		if err != nil {
+			return
+		}
+
+		if expected, _ := purchaseStatusFromEnum(status); *filter.Status != expected || expected == "" {
+			t.Errorf("Status %q resolved to %q", status, *filter.Status)
+		}
+		if expected, _ := orderStatusFromEnum(orderStatus); *filter.OrderStatus != expected || expected == "" {
+			t.Errorf("Order status %q resolved to %q", orderStatus, *filter.OrderStatus)
+		}
+	})
+}
+
+func FuzzResolveDeliveryFilter(f *testing.F) {
+	f.Add("1", "PACKED", "CANCELED", "last24h", "thisMonth")
+	f.Add("x", "packed", "DELIVERED\x00", "last0h", "2025-13-01T00:00:00Z")
+
+	r := NewResolver(nil)
+	f.Fuzz(func(t *testing.T, purchaseID, status, statusNot, scheduledFrom, scheduledTo string) {
+		id := graphql.ID(purchaseID)
+		statuses := []string{status, statusNot}
+		filter, err := r.resolveDeliveryFilter(&DeliveryFilterInput{
+			PurchaseID:    &id,
+			Statuses:      &statuses,
+			StatusNot:     &statusNot,
+			ScheduledFrom: &scheduledFrom,
+			ScheduledTo:   &scheduledTo,
+		})
+		if err != nil {
+			return
+		}
+
+		for i, value := range statuses {
+			if expected, ok := deliveryStatusFromEnum(value); !ok || filter.StatusIn[i] != expected {
+				t.Errorf("Status %q resolved to %q", value, filter.StatusIn[i])
+			}
+		}
+		if filter.ScheduledFrom != nil && filter.ScheduledTo != nil && filter.ScheduledTo.Before(*filter.ScheduledFrom) &&
+			scheduledFrom == scheduledTo {
+			t.Errorf("Period %q ends before it starts", scheduledFrom)
+		}
+	})
+}
+
+func TestReceiptPdfUrlUsesPublicID(t *testing.T) {
+	id.SetCodec(id.NewObfuscator([]byte("secret")))
+	defer id.SetCodec(id.Decimal{})
+
+	r := &ReceiptResolver{receipt: &receipt.Receipt{PurchaseID: 7}}
+	purchaseID, err := id.ParsePurchaseID(strings.TrimSuffix(strings.TrimPrefix(r.PdfUrl(), "/receipts/"), "/pdf"))
+	if err != nil || purchaseID != 7 {
+		t.Errorf("Expected the URL %s to carry the public ID of purchase 7, got %d, %v", r.PdfUrl(), purchaseID, err)
+	}
+}
@@ -0,0 +1,320 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/types"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+// AnonymousRole is the role of requests that don't carry a role
+const AnonymousRole = "anonymous"
+
+type roleKey struct{}
+
+// WithRole attaches the caller's role to the context
+func WithRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, roleKey{}, role)
+}
+
+// RoleFromContext returns the caller's role, or AnonymousRole if none was attached
+func RoleFromContext(ctx context.Context) string {
+	if role, ok := ctx.Value(roleKey{}).(string); ok && role != "" {
+		return role
+	}
+	return AnonymousRole
+}
+
+// RoleOrAnonymous returns role, or AnonymousRole if it is empty
+func RoleOrAnonymous(role string) string {
+	if role == "" {
+		return AnonymousRole
+	}
+	return role
+}
+
+// RoleWhitelist maps roles to the root fields (of any operation type) they may
+// select, e.g. {"courier": ["deliveries", "createDelivery"]}. "*" permits every
+// field. Roles missing from the whitelist may not run any operation
+type RoleWhitelist map[string][]string
+
+// LoadRoleWhitelist reads a role whitelist from a JSON file
+func LoadRoleWhitelist(path string) (RoleWhitelist, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read role whitelist: %w", err)
+	}
+
+	var whitelist RoleWhitelist
+	if err := json.Unmarshal(data, &whitelist); err != nil {
+		return nil, fmt.Errorf("failed to parse role whitelist: %w", err)
+	}
+	return whitelist, nil
+}
+
+// Check returns an error if the operation selects a root field the role may not use.
+// Without an operation name every operation in the document is checked
+func (w RoleWhitelist) Check(role, query, operationName string) error {
+	role = RoleOrAnonymous(role)
+
+	allowed, ok := w[role]
+	if !ok {
+		return fmt.Errorf("role %q is not permitted to run operations", role)
+	}
+
+	permitted := make(map[string]bool, len(allowed))
+	for _, field := range allowed {
+		if field == "*" {
+			return nil
+		}
+		permitted[field] = true
+	}
+
+	fields, err := RootFields(query, operationName)
+	if err != nil {
+		return err
+	}
+
+	for _, field := range fields {
+		if !permitted[field] {
+			return fmt.Errorf("role %q is not permitted to use %s", role, field)
+		}
+	}
+	return nil
+}
+
+// RootFields returns the names of the root fields selected by the operation,
+// following fragments and skipping introspection fields
+func RootFields(query, operationName string) ([]string, error) {
+	selected, err := selectedRootFields(query, operationName)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make([]string, 0, len(selected))
+	for _, field := range selected {
+		if !strings.HasPrefix(field, "__") {
+			fields = append(fields, field)
+		}
+	}
+	return fields, nil
+}
+
+// selectedRootFields returns the names of all root fields selected by the
+// operation, introspection fields included, following fragments
+func selectedRootFields(query, operationName string) ([]string, error) {
+	doc, err := parser.ParseQuery(&ast.Source{Input: query})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse query: %w", err)
+	}
+
+	var operations ast.OperationList
+	if operationName == "" {
+		operations = doc.Operations
+	} else if op := doc.Operations.ForName(operationName); op != nil {
+		operations = ast.OperationList{op}
+	} else {
+		return nil, fmt.Errorf("unknown operation %q", operationName)
+	}
+
+	seen := make(map[string]bool)
+	var fields []string
+	var collect func(set ast.SelectionSet, visited map[string]bool)
+	collect = func(set ast.SelectionSet, visited map[string]bool) {
+		for _, selection := range set {
+			switch sel := selection.(type) {
+			case *ast.Field:
+				if seen[sel.Name] {
+					continue
+				}
+				seen[sel.Name] = true
+				fields = append(fields, sel.Name)
+			case *ast.InlineFragment:
+				collect(sel.SelectionSet, visited)
+			case *ast.FragmentSpread:
+				// Guard against fragment cycles, which validation rejects later
+				if visited[sel.Name] {
+					continue
+				}
+				visited[sel.Name] = true
+				if fragment := doc.Fragments.ForName(sel.Name); fragment != nil {
+					collect(fragment.SelectionSet, visited)
+				}
+			}
+		}
+	}
+
+	for _, op := range operations {
+		collect(op.SelectionSet, make(map[string]bool))
+	}
+	return fields, nil
+}
+
+// SelectsIntrospection reports whether the operation queries the schema through
+// __schema or __type. Queries that can't be parsed report true, so they are
+// rejected rather than run unchecked while introspection is disabled
+func SelectsIntrospection(query, operationName string) bool {
+	fields, err := selectedRootFields(query, operationName)
+	if err != nil {
+		return true
+	}
+	for _, field := range fields {
+		if field == "__schema" || field == "__type" {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckFieldRoles returns an error if the operation selects a field the role
+// may not see. Fields marked @auth require a role other than anonymous, and
+// fields marked @hasRole require the given role; admins may see every field.
+// Fields excluded by @skip or @include aren't checked. Queries that can't be
+// parsed are rejected, as their fields can't be checked. Without an operation
+// name every operation in the document is checked
+func CheckFieldRoles(schema *graphql.Schema, role, query, operationName string, variables map[string]interface{}) error {
+	role = RoleOrAnonymous(role)
+	if role == AdminRole {
+		return nil
+	}
+
+	doc, err := parser.ParseQuery(&ast.Source{Input: query})
+	if err != nil {
+		return fmt.Errorf("failed to parse query: %w", err)
+	}
+	operations := doc.Operations
+	if operationName != "" {
+		op := doc.Operations.ForName(operationName)
+		if op == nil {
+			return fmt.Errorf("unknown operation %q", operationName)
+		}
+		operations = ast.OperationList{op}
+	}
+
+	for _, op := range operations {
+		root := schema.AST().RootOperationTypes[string(op.Operation)]
+		if root == nil {
+			continue
+		}
+		c := &fieldRoleChecker{schema: schema.AST(), role: role, fragments: doc.Fragments, variables: withDefaults(op, variables)}
+		if err := c.check(op.SelectionSet, root.TypeName(), map[string]bool{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withDefaults returns the variables with the defaults of the operation's
+// variable definitions filled in
+func withDefaults(op *ast.OperationDefinition, variables map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(variables))
+	for name, value := range variables {
+		merged[name] = value
+	}
+	for _, definition := range op.VariableDefinitions {
+		if _, ok := merged[definition.Variable]; ok || definition.DefaultValue == nil {
+			continue
+		}
+		if value, err := definition.DefaultValue.Value(nil); err == nil {
+			merged[definition.Variable] = value
+		}
+	}
+	return merged
+}
+
+// fieldRoleChecker walks the selections of an operation, checking the role
+// directives of every selected field
+type fieldRoleChecker struct {
+	schema    *types.Schema
+	role      string
+	fragments ast.FragmentDefinitionList
+	variables map[string]interface{}
+}
+
+func (c *fieldRoleChecker) check(set ast.SelectionSet, typeName string, visiting map[string]bool) error {
+	for _, selection := range set {
+		switch sel := selection.(type) {
+		case *ast.Field:
+			if !included(sel.Directives, c.variables) {
+				continue
+			}
+			definition := fieldDefinition(c.schema, typeName, sel.Name)
+			if definition == nil {
+				continue
+			}
+			if err := c.permitted(typeName, definition); err != nil {
+				return err
+			}
+			named, _ := unwrapType(definition.Type)
+			if err := c.check(sel.SelectionSet, named, visiting); err != nil {
+				return err
+			}
+		case *ast.InlineFragment:
+			if !included(sel.Directives, c.variables) {
+				continue
+			}
+			if err := c.check(sel.SelectionSet, fragmentType(sel.TypeCondition, typeName), visiting); err != nil {
+				return err
+			}
+		case *ast.FragmentSpread:
+			// Guard against fragment cycles, which validation rejects later
+			fragment := c.fragments.ForName(sel.Name)
+			if fragment == nil || visiting[sel.Name] || !included(sel.Directives, c.variables) {
+				continue
+			}
+			visiting[sel.Name] = true
+			err := c.check(fragment.SelectionSet, fragmentType(fragment.TypeCondition, typeName), visiting)
+			delete(visiting, sel.Name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// permitted returns an error if the field's directives deny the role
+func (c *fieldRoleChecker) permitted(typeName string, definition *types.FieldDefinition) error {
+	if definition.Directives.Get("auth") != nil && c.role == AnonymousRole {
+		return fmt.Errorf("%s.%s requires an authenticated role", typeName, definition.Name)
+	}
+	if directive := definition.Directives.Get("hasRole"); directive != nil {
+		value, ok := directive.Arguments.Get("role")
+		if !ok {
+			return nil
+		}
+		required, _ := value.Deserialize(nil).(string)
+		if c.role != strings.ToLower(required) {
+			return fmt.Errorf("role %q is not permitted to see %s.%s", c.role, typeName, definition.Name)
+		}
+	}
+	return nil
+}
+
+// included evaluates the @skip and @include directives of a selection,
+// including it if their conditions can't be evaluated
+func included(directives ast.DirectiveList, variables map[string]interface{}) bool {
+	for _, directive := range directives {
+		if directive.Name != "skip" && directive.Name != "include" {
+			continue
+		}
+		arg := directive.Arguments.ForName("if")
+		if arg == nil {
+			continue
+		}
+		value, err := arg.Value.Value(variables)
+		if err != nil {
+			continue
+		}
+		if condition, ok := value.(bool); ok && condition == (directive.Name == "skip") {
+			return false
+		}
+	}
+	return true
+}
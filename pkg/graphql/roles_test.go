@@ -0,0 +1,180 @@
+package graphql
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/graph-gophers/graphql-go"
+)
+
+func TestRootFields(t *testing.T) {
+	query := `
+	query Dashboard {
+	  sellers { id }
+	  ... on Query { listings { id } }
+	  ...PurchaseFields
+	  __typename
+	}
+	fragment PurchaseFields on Query { purchases { id } }
+	mutation Create { createDelivery(input: {purchaseId: "1", status: PACKED}) { id } }
+	`
+
+	fields, err := RootFields(query, "Dashboard")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []string{"sellers", "listings", "purchases"}
+	if len(fields) != len(expected) {
+		t.Fatalf("Expected fields %v, got %v", expected, fields)
+	}
+	for i, field := range expected {
+		if fields[i] != field {
+			t.Errorf("Expected field %s at %d, got %s", field, i, fields[i])
+		}
+	}
+
+	// Without an operation name all operations are considered
+	fields, err = RootFields(query, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(fields) != 4 {
+		t.Errorf("Expected 4 fields across all operations, got %v", fields)
+	}
+}
+
+func TestSelectsIntrospection(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected bool
+	}{
+		{`{ __schema { types { name } } }`, true},
+		{`{ sellers { id } __type(name: "Seller") { name } }`, true},
+		{`query { ...Schema } fragment Schema on Query { __schema { queryType { name } } }`, true},
+		{`{ sellers { id __typename } __typename }`, false},
+		{`{ __schema`, true},
+		{`{ sellers { id }`, true},
+	}
+
+	for _, tt := range tests {
+		if got := SelectsIntrospection(tt.query, ""); got != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.query, tt.expected, got)
+		}
+	}
+}
+
+func TestRoleWhitelistCheck(t *testing.T) {
+	whitelist := RoleWhitelist{
+		"courier": {"deliveries", "createDelivery"},
+		"admin":   {"*"},
+	}
+
+	if err := whitelist.Check("courier", `{ deliveries { id } }`, ""); err != nil {
+		t.Errorf("Expected courier to query deliveries, got %v", err)
+	}
+	if err := whitelist.Check("courier", `{ deliveries { id } sellers { id } }`, ""); err == nil {
+		t.Errorf("Expected courier to be denied sellers")
+	}
+	if err := whitelist.Check("admin", `mutation { createListing(input: {}) { id } }`, ""); err != nil {
+		t.Errorf("Expected admin to be allowed everything, got %v", err)
+	}
+	if err := whitelist.Check("", `{ sellers { id } }`, ""); err == nil {
+		t.Errorf("Expected anonymous role without an entry to be denied")
+	}
+	if err := whitelist.Check("courier", `{ deliveries { id }`, ""); err == nil {
+		t.Errorf("Expected unparsable query to be denied")
+	}
+}
+
+func TestRoleFromContext(t *testing.T) {
+	if role := RoleFromContext(context.Background()); role != AnonymousRole {
+		t.Errorf("Expected %s, got %s", AnonymousRole, role)
+	}
+	if role := RoleFromContext(WithRole(context.Background(), "courier")); role != "courier" {
+		t.Errorf("Expected %s, got %s", "courier", role)
+	}
+}
+
+func TestCheckFieldRoles(t *testing.T) {
+	schema, err := GetSchema(NewResolver(nil))
+	if err != nil {
+		t.Fatalf("Failed to parse schema: %v", err)
+	}
+
+	query := `query Purchase($admin: Boolean = false) { purchase(id: "1") { id bankTxId @include(if: $admin) } }`
+	if err := CheckFieldRoles(schema, "", query, "", nil); err != nil {
+		t.Errorf("Expected excluded fields to pass, got %v", err)
+	}
+	if err := CheckFieldRoles(schema, "courier", query, "Purchase", map[string]interface{}{"admin": true}); err == nil {
+		t.Errorf("Expected bankTxId to be denied to couriers")
+	}
+	if err := CheckFieldRoles(schema, AdminRole, query, "", map[string]interface{}{"admin": true}); err != nil {
+		t.Errorf("Expected admins to see bankTxId, got %v", err)
+	}
+
+	// Fields are found through fragments and nested selections
+	nested := `{ deliveries { purchase { ...Payment } } } fragment Payment on Purchase { bankTxId }`
+	if err := CheckFieldRoles(schema, "", nested, "", nil); err == nil || !strings.Contains(err.Error(), "Purchase.bankTxId") {
+		t.Errorf("Expected bankTxId within a fragment to be denied, got %v", err)
+	}
+
+	// Root fields looking up bank transactions are admin only as well
+	lookup := `{ purchasesByBankTxIds(ids: ["TX1"]) { id } }`
+	if err := CheckFieldRoles(schema, "seller", lookup, "", nil); err == nil || !strings.Contains(err.Error(), "Query.purchasesByBankTxIds") {
+		t.Errorf("Expected purchasesByBankTxIds to be denied to sellers, got %v", err)
+	}
+
+	// Queries that can't be checked are rejected rather than let through
+	if err := CheckFieldRoles(schema, "courier", `{ purchase(id: "1") { bankTxId }`, "", nil); err == nil {
+		t.Errorf("Expected an unparseable query to be rejected")
+	}
+	if err := CheckFieldRoles(schema, "courier", query, "Other", nil); err == nil {
+		t.Errorf("Expected an unknown operation to be rejected")
+	}
+}
+
+func TestCheckFieldRolesAuth(t *testing.T) {
+	schema := graphql.MustParseSchema(`
+directive @auth on FIELD_DEFINITION
+directive @hasRole(role: Role!) on FIELD_DEFINITION
+
+enum Role {
+  ADMIN
+  COURIER
+}
+
+schema {
+  query: Query
+}
+
+type Query {
+  name: String!
+  email: String! @auth
+  route: String! @hasRole(role: COURIER)
+}
+`, &fieldRolesTestQuery{})
+
+	if err := CheckFieldRoles(schema, "", `{ name }`, "", nil); err != nil {
+		t.Errorf("Expected unannotated fields to pass, got %v", err)
+	}
+	if err := CheckFieldRoles(schema, "", `{ email }`, "", nil); err == nil {
+		t.Errorf("Expected @auth to deny anonymous callers")
+	}
+	if err := CheckFieldRoles(schema, "seller", `{ email }`, "", nil); err != nil {
+		t.Errorf("Expected @auth to permit any role, got %v", err)
+	}
+	if err := CheckFieldRoles(schema, "seller", `{ route }`, "", nil); err == nil {
+		t.Errorf("Expected @hasRole to deny other roles")
+	}
+	if err := CheckFieldRoles(schema, "courier", `{ route }`, "", nil); err != nil {
+		t.Errorf("Expected @hasRole to permit its role, got %v", err)
+	}
+}
+
+type fieldRolesTestQuery struct{}
+
+func (*fieldRolesTestQuery) Name() string  { return "name" }
+func (*fieldRolesTestQuery) Email() string { return "email" }
+func (*fieldRolesTestQuery) Route() string { return "route" }
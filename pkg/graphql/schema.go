@@ -8,45 +8,199 @@ schema {
   subscription: Subscription
 }
 
+# Incremental delivery over HTTP: deferred fragments and the items of streamed
+# lists beyond initialCount are sent in later parts of a multipart response.
+# Clients not accepting multipart/mixed get everything in one response
+directive @defer(label: String, if: Boolean = true) on FRAGMENT_SPREAD | INLINE_FRAGMENT
+directive @stream(label: String, if: Boolean = true, initialCount: Int = 0) on FIELD
+
+# Field access by the caller's role, checked before an operation runs: @auth
+# requires any role but anonymous, @hasRole the given role. Admins may see
+# every field
+directive @auth on FIELD_DEFINITION
+directive @hasRole(role: Role!) on FIELD_DEFINITION
+
+enum Role {
+  ADMIN
+  SELLER
+  COURIER
+}
+
+# Exact amount of currency with two fractional digits, serialized as a JSON
+# number such as 19.90; inputs also accept decimal strings like "19.90"
+scalar Money
+
 type Query {
   # Seller queries
   seller(id: ID!): Seller
   sellers: [Seller!]!
   
+  # A seller's sales within an optional period; from and to accept the same
+  # values as date filters
+  salesSummary(sellerId: ID!, from: String, to: String): SalesSummary!
+  
+  # All-time sales statistics, served from a cache refreshed in the background.
+  # Sellers are ranked by revenue, ties broken by ID
+  sellerStats(sellerId: ID!): SellerStats!
+  topSellers(limit: Int): [SellerStats!]!
+  
   # Listing queries
   listing(id: ID!): Listing
-  listings(filter: ListingFilter): [Listing!]!
+  listings(filter: ListingFilter, orderBy: OrderBy): [Listing!]!
+  
+  # Page through listings with Relay cursors; first/after pages forward,
+  # last/before backward. Listings are ordered by ID, or by price and then ID
+  # with orderBy PRICE_ASC or PRICE_DESC; other orders are rejected. Page sizes
+  # default to PAGE_SIZE_DEFAULT and are capped at PAGE_SIZE_MAX
+  listingsConnection(filter: ListingFilter, orderBy: OrderBy, first: Int, after: String, last: Int, before: String): ListingConnection!
+  
+  # Ranked full-text search over listing titles and descriptions, served by the
+  # search engine when one is configured and by Postgres otherwise
+  searchListings(query: String!, limit: Int): [Listing!]!
+  
+  # Listings related to a listing, for 'you may also like' sections
+  recommendedListings(forListingId: ID!, limit: Int): [Listing!]!
+  listingPriceStats(filter: ListingFilter, buckets: Int = 10): PriceStats!
+  
+  # Pickup points ordered by distance from the given coordinates
+  nearestPickupPoints(lat: Float!, lon: Float!, limit: Int): [PickupPoint!]!
+  
+  # Purchase queries. asOf, an RFC3339 time or relative period like "yesterday",
+  # returns the state at that time: the statuses then and the deliveries recorded by then
+  purchase(id: ID!, asOf: String): Purchase
+  purchases(filter: PurchaseFilter, limit: Int, offset: Int): [Purchase!]!
+  purchasesByDeliveryStatus(status: DeliveryStatus!): [Purchase!]!
+  # Purchases paid with any of the bank transactions, looked up at once for
+  # reconciliation. Admin only, like bankTxId itself
+  purchasesByBankTxIds(ids: [String!]!): [Purchase!]! @hasRole(role: ADMIN)
+  
+  # Purchase count and revenue, aggregated in the database
+  purchaseStats(filter: PurchaseFilter): PurchaseStats!
+  
+  # Key figures for the operations dashboard, computed in one database round trip
+  dashboard: Dashboard!
+  # Changes whenever the dashboard figures may have changed; poll it and refetch
+  # dashboard when it differs from the version last fetched
+  dashboardVersion: String!
+  
+  # Structured invoice for a purchase; the PDF rendering is served at pdfUrl
+  receipt(purchaseId: ID!): Receipt!
   
-  # Purchase queries
-  purchase(id: ID!): Purchase
-  purchases(filter: PurchaseFilter): [Purchase!]!
+  # Delivery queries; asOf works as for purchase
+  delivery(id: ID!, asOf: String): Delivery
+  deliveries(filter: DeliveryFilter, limit: Int, offset: Int, asOf: String): [Delivery!]!
+  latestDelivery(purchaseId: ID!, asOf: String): Delivery
+  deliveryTimeline(purchaseId: ID!): [DeliveryTimelineDay!]!
   
-  # Delivery queries
-  delivery(id: ID!): Delivery
-  deliveries(filter: DeliveryFilter): [Delivery!]!
+  # Search queries. Purchases are matched by bank transaction ID for admins only
+  search(term: String!): [SearchResult!]!
 }
 
 type Mutation {
+  # Mutations with a dryRun argument validate their input against the database
+  # and return the would-be result when it is true, without storing anything
+
+  # Manage sellers; sellers with listings cannot be deleted
+  createSeller(input: CreateSellerInput!, dryRun: Boolean = false): CreateSellerPayload!
+  updateSeller(input: UpdateSellerInput!, dryRun: Boolean = false): UpdateSellerPayload!
+  deleteSeller(id: ID!): Boolean!
+  
   # Create a new listing
-  createListing(input: CreateListingInput!): Listing!
+  createListing(input: CreateListingInput!, dryRun: Boolean = false): CreateListingPayload!
+  
+  # Create several listings at once, e.g. to import a catalog. Each input gets a
+  # payload in the same position; the listings that pass validation are stored together
+  createListings(input: [CreateListingInput!]!): [CreateListingPayload!]!
+  
+  # Update a listing, recording price changes in its price history
+  updateListing(input: UpdateListingInput!, dryRun: Boolean = false): UpdateListingPayload!
   
   # Create a new purchase
-  createPurchase(input: CreatePurchaseInput!): Purchase!
+  createPurchase(input: CreatePurchaseInput!, dryRun: Boolean = false): CreatePurchasePayload!
+  
+  # Create a purchase together with its initial PACKED delivery in one transaction
+  createPurchaseWithDelivery(input: CreatePurchaseInput!): CreatePurchaseWithDeliveryPayload!
+  
+  # Cancel a purchase that hasn't shipped, refunding it if it was paid and recording
+  # the reason and a CANCELED delivery update
+  cancelPurchase(id: ID!, reason: String): Purchase!
   
   # Create a new delivery status update
-  createDelivery(input: CreateDeliveryInput!): Delivery!
+  createDelivery(input: CreateDeliveryInput!): CreateDeliveryPayload!
+  
+  # Reschedule the delivery of a purchase to a future time, starting a new attempt
+  rescheduleDelivery(purchaseId: ID!, scheduledFor: String!): Delivery!
+  
+  # Move the latest delivery update of a purchase to its next status, recorded as a
+  # new update. PACKED -> OUT_FOR_DELIVERY -> DELIVERED; DELIVERED and CANCELED are final
+  updateDeliveryStatus(deliveryId: ID!, status: DeliveryStatus!): Delivery!
+  recordListingView(listingId: ID!): Boolean!
+  
+  # Register or replace the URL notified of purchases of a seller's listings; each
+  # call returns a new secret signing the notifications
+  setSellerWebhook(sellerId: ID!, url: String!): SellerWebhook!
+  removeSellerWebhook(sellerId: ID!): Boolean!
+  
+  # Override the number of listings a seller may have, 0 for unlimited, or
+  # return them to the default limit
+  setSellerQuota(sellerId: ID!, maxListings: Int!): SellerQuota! @hasRole(role: ADMIN)
+  removeSellerQuota(sellerId: ID!): Boolean! @hasRole(role: ADMIN)
 }
 
 type Subscription {
-  # Subscribe to delivery updates
-  deliveryUpdated(purchaseId: ID): Delivery!
+  # Subscribe to delivery updates; pass the last received delivery ID as
+  # lastEventId to first replay the updates missed while disconnected (at
+  # most 1000 by default; beyond that the client must resync from queries),
+  # and statuses to only receive updates to those statuses
+  deliveryUpdated(purchaseId: ID, lastEventId: ID, statuses: [DeliveryStatus!]): Delivery!
+  
+  # Subscribe to fraud review outcomes of new purchases
+  purchaseReviewed(purchaseId: ID): Purchase!
+
+  # Subscribe to listings as sellers publish them, e.g. to live-update a storefront
+  listingCreated(sellerId: ID): Listing!
 }
 
 type Seller {
   id: ID!
   name: String!
   address: String!
-  listings: [Listing!]!
+  # Whether the seller receives the daily sales digest through their webhook
+  digestOptIn: Boolean!
+  # A page of the seller's listings ordered by ID, bounded by the page size
+  listings(limit: Int, offset: Int): [Listing!]!
+  # Purchases of the seller's listings, leaving out rejected and canceled ones
+  totalSales: Int!
+  totalRevenue: Money!
+}
+
+type SalesSummary {
+  seller: Seller!
+  from: String
+  to: String
+  totalSales: Int!
+  totalRevenue: Money!
+}
+
+# Cached all-time sales of a seller; rejected and canceled purchases don't count.
+# refreshedAt is when the figures were computed and staleness their age in seconds
+type SellerStats {
+  seller: Seller!
+  totalSales: Int!
+  totalRevenue: Money!
+  refreshedAt: String!
+  staleness: Int!
+}
+
+type SellerWebhook {
+  url: String!
+  secret: String!
+  createdAt: String!
+}
+
+type SellerQuota {
+  maxListings: Int!
+  updatedAt: String!
 }
 
 type Listing {
@@ -54,18 +208,191 @@ type Listing {
   seller: Seller!
   title: String!
   description: String!
-  price: Float!
-  purchases: [Purchase!]!
+  price: Money!
+  # Price converted from USD using cached exchange rates
+  priceIn(currency: String!): Money!
+  views: Int!
+  priceHistory(fromDate: String, toDate: String): [PricePoint!]!
+  # A page of the listing's purchases ordered by ID, bounded by the page size
+  purchases(limit: Int, offset: Int): [Purchase!]!
+}
+
+type ListingConnection {
+  edges: [ListingEdge!]!
+  pageInfo: PageInfo!
+}
+
+type ListingEdge {
+  cursor: String!
+  node: Listing!
+}
+
+type PageInfo {
+  hasPreviousPage: Boolean!
+  hasNextPage: Boolean!
+  startCursor: String
+  endCursor: String
+}
+
+type PricePoint {
+  price: Money!
+  changedAt: String!
+}
+
+type PriceStats {
+  count: Int!
+  min: Float
+  max: Float
+  avg: Float
+  median: Float
+  histogram: [PriceBucket!]!
+}
+
+type PriceBucket {
+  min: Float!
+  max: Float!
+  count: Int!
+}
+
+# Input refused by a mutation. field names the input field at fault and is
+# null when the error concerns the input as a whole
+type UserError {
+  field: String
+  message: String!
+  code: UserErrorCode!
+}
+
+enum UserErrorCode {
+  INVALID_INPUT
+  NOT_FOUND
+  CONFLICT
+  QUOTA_EXCEEDED
+}
+
+# Mutation payloads hold the changed object, or the userErrors explaining why the
+# input was refused. Unexpected failures are still reported as GraphQL errors
+type CreateSellerPayload {
+  seller: Seller
+  userErrors: [UserError!]!
+}
+
+type UpdateSellerPayload {
+  seller: Seller
+  userErrors: [UserError!]!
+}
+
+type CreateListingPayload {
+  listing: Listing
+  userErrors: [UserError!]!
+}
+
+type UpdateListingPayload {
+  listing: Listing
+  userErrors: [UserError!]!
+}
+
+type CreatePurchasePayload {
+  purchase: Purchase
+  userErrors: [UserError!]!
+}
+
+type CreatePurchaseWithDeliveryPayload {
+  purchase: Purchase
+  delivery: Delivery
+  userErrors: [UserError!]!
+}
+
+type CreateDeliveryPayload {
+  delivery: Delivery
+  userErrors: [UserError!]!
+}
+
+# reason is null when none was given
+type PurchaseCancellation {
+  reason: String
+  canceledAt: String!
+}
+
+# A seller, listing or purchase matching a search term
+union SearchResult = Seller | Listing | Purchase
+
+# revenue sums the prices of matching purchases, leaving out rejected and canceled ones
+type PurchaseStats {
+  count: Int!
+  revenue: Money!
+}
+
+# openPurchases counts orders pending, paid or shipped, outForDelivery purchases
+# whose latest delivery update is OUT_FOR_DELIVERY, and revenueToday sums today's
+# purchases, leaving out rejected and canceled ones
+type Dashboard {
+  openPurchases: Int!
+  outForDelivery: Int!
+  revenueToday: Money!
+  version: String!
 }
 
 type Purchase {
   id: ID!
   listing: Listing!
-  price: Float!
-  bankTxId: String!
+  price: Money!
+  priceIn(currency: String!): Money!
+  status: PurchaseStatus!
+  # Where the order stands from payment to completion, maintained as it is
+  # reviewed and delivered
+  orderStatus: OrderStatus!
+  taxAmount: Money!
+  totalWithTax: Money!
+  bankTxId: String! @hasRole(role: ADMIN)
   deliveryAddress: String!
+  pickupPoint: PickupPoint
   createdAt: String!
-  deliveries: [Delivery!]!
+  # Why and when the purchase was canceled; null unless it was canceled
+  cancellation: PurchaseCancellation
+  # A page of the purchase's delivery updates, latest first, bounded by the page size
+  deliveries(limit: Int, offset: Int): [Delivery!]!
+  # The most recent delivery update and its status, fetched without the whole list;
+  # null before the first update
+  latestDelivery: Delivery
+  currentStatus: DeliveryStatus
+}
+
+# Invoice for a purchase; the purchase price is the net amount and taxes are added on top
+type Receipt {
+  number: String!
+  purchase: Purchase!
+  issuedAt: String!
+  sellerName: String!
+  sellerAddress: String!
+  deliveryAddress: String!
+  lineItems: [ReceiptLineItem!]!
+  taxes: [ReceiptTax!]!
+  subtotal: Money!
+  taxTotal: Money!
+  total: Money!
+  pdfUrl: String!
+}
+
+type ReceiptLineItem {
+  description: String!
+  quantity: Int!
+  unitPrice: Money!
+  amount: Money!
+}
+
+type ReceiptTax {
+  name: String!
+  rate: Float!
+  amount: Money!
+}
+
+type PickupPoint {
+  id: ID!
+  name: String!
+  address: String!
+  latitude: Float!
+  longitude: Float!
+  distanceKm: Float
 }
 
 type Delivery {
@@ -73,6 +400,20 @@ type Delivery {
   purchase: Purchase!
   timestamp: String!
   status: DeliveryStatus!
+  scheduledFor: String
+  attemptNumber: Int!
+}
+
+type DeliveryTimelineDay {
+  date: String!
+  total: Int!
+  statusCounts: [DeliveryStatusCount!]!
+  deliveries: [Delivery!]!
+}
+
+type DeliveryStatusCount {
+  status: DeliveryStatus!
+  count: Int!
 }
 
 enum DeliveryStatus {
@@ -83,16 +424,53 @@ enum DeliveryStatus {
   CANCELED
 }
 
+# New purchases are PENDING_REVIEW until fraud screening approves or rejects them
+enum PurchaseStatus {
+  PENDING_REVIEW
+  APPROVED
+  REJECTED
+  CANCELED
+}
+
+enum OrderStatus {
+  PENDING
+  PAID
+  SHIPPED
+  COMPLETED
+  CANCELED
+  REFUNDED
+}
+
+enum OrderBy {
+  POPULARITY
+  PRICE_ASC
+  PRICE_DESC
+  TITLE_ASC
+  CREATED_AT_DESC
+}
+
 input ListingFilter {
   sellerId: ID
-  minPrice: Float
-  maxPrice: Float
+  sellerIdIn: [ID!]
+  # Listings of sellers whose name contains this, case-insensitively
+  sellerName: String
+  minPrice: Money
+  maxPrice: Money
   title: String
+  titleNotLike: String
+  # Listings matching every one of the filters, combined with the fields above
+  and: [ListingFilter!]
+  # Listings matching at least one of the filters, combined with the fields above
+  or: [ListingFilter!]
 }
 
 input PurchaseFilter {
   listingId: ID
+  listingIdIn: [ID!]
+  # Only admins may filter by bank transaction ID
   bankTxId: String
+  status: PurchaseStatus
+  orderStatus: OrderStatus
   fromDate: String
   toDate: String
 }
@@ -100,8 +478,30 @@ input PurchaseFilter {
 input DeliveryFilter {
   purchaseId: ID
   status: DeliveryStatus
+  # Any of the given statuses, e.g. [PACKED, OUT_FOR_DELIVERY] for dispatch dashboards
+  statuses: [DeliveryStatus!]
+  # Same as statuses, kept for existing clients; cannot be combined with it
+  statusIn: [DeliveryStatus!]
+  statusNot: DeliveryStatus
   fromDate: String
   toDate: String
+  scheduledFrom: String
+  scheduledTo: String
+}
+
+# Input for creating a new seller
+input CreateSellerInput {
+  name: String!
+  address: String!
+  digestOptIn: Boolean
+}
+
+# Input for updating a seller, omitted fields are left unchanged
+input UpdateSellerInput {
+  id: ID!
+  name: String
+  address: String
+  digestOptIn: Boolean
 }
 
 # Input for creating a new listing
@@ -109,15 +509,25 @@ input CreateListingInput {
   sellerId: ID!
   title: String!
   description: String!
-  price: Float!
+  price: Money!
+}
+
+# Input for updating a listing, omitted fields are left unchanged
+input UpdateListingInput {
+  id: ID!
+  title: String
+  description: String
+  price: Money
 }
 
 # Input for creating a new purchase
 input CreatePurchaseInput {
   listingId: ID!
-  price: Float!
+  price: Money!
   bankTxId: String!
-  deliveryAddress: String!
+  # Exactly one of deliveryAddress or pickupPointId must be set
+  deliveryAddress: String
+  pickupPointId: ID
 }
 
 # Input for creating a new delivery update
@@ -0,0 +1,198 @@
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/graph-gophers/graphql-go/ast"
+	"github.com/graph-gophers/graphql-go/errors"
+	"github.com/graph-gophers/graphql-go/introspection"
+	"github.com/graph-gophers/graphql-go/trace/tracer"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/metrics"
+)
+
+// ClientInfo identifies the application calling the API
+type ClientInfo struct {
+	Name    string
+	Version string
+}
+
+type clientInfoKey struct{}
+
+// WithClientInfo attaches the calling client to the context
+func WithClientInfo(ctx context.Context, client ClientInfo) context.Context {
+	return context.WithValue(ctx, clientInfoKey{}, client)
+}
+
+// ClientInfoFromContext returns the calling client, using "unknown" for missing values
+func ClientInfoFromContext(ctx context.Context) ClientInfo {
+	client, _ := ctx.Value(clientInfoKey{}).(ClientInfo)
+	if client.Name == "" {
+		client.Name = "unknown"
+	}
+	if client.Version == "" {
+		client.Version = "unknown"
+	}
+	return client
+}
+
+// instrumentationTracer adapts graphql-go's tracing hooks to our logging and
+// metrics, so resolvers don't need to instrument themselves. It logs every
+// operation and every resolver method call with its outcome, and records
+// per-client operation metrics, resolver latency and usage of deprecated
+// fields. It also gives every resolver error a code, as it sees all of them
+type instrumentationTracer struct {
+	// deprecated holds "Type.field" keys of fields marked with @deprecated
+	deprecated map[string]bool
+	// clients and operations bound the values metrics are labeled with
+	clients    KnownClients
+	operations operationLabels
+}
+
+var _ tracer.Tracer = (*instrumentationTracer)(nil)
+
+func (t *instrumentationTracer) TraceQuery(ctx context.Context, queryString string, operationName string, variables map[string]interface{}, varTypes map[string]*introspection.Type) (context.Context, tracer.QueryFinishFunc) {
+	client := ClientInfoFromContext(ctx)
+	if operationName == "" {
+		operationName = "anonymous"
+	}
+
+	start := time.Now()
+	return ctx, func(errs []*errors.QueryError) {
+		duration := time.Since(start)
+		status := "success"
+		if len(errs) > 0 {
+			status = "error"
+		}
+		log.Printf("[GraphQL] Operation %s from %s finished in %s with %d errors", operationName, client.Name, duration, len(errs))
+		operation := t.operations.label(operationName)
+		name, version := t.clients.Labels(client)
+		metrics.OperationsTotal.WithLabelValues(operation, name, version, status).Inc()
+		metrics.OperationDuration.WithLabelValues(operation, name).Observe(duration.Seconds())
+	}
+}
+
+func (t *instrumentationTracer) TraceField(ctx context.Context, label, typeName, fieldName string, trivial bool, args map[string]interface{}) (context.Context, tracer.FieldFinishFunc) {
+	field := typeName + "." + fieldName
+	if t.deprecated[field] {
+		name, _ := t.clients.Labels(ClientInfoFromContext(ctx))
+		metrics.DeprecatedFieldUsage.WithLabelValues(field, name).Inc()
+	}
+
+	start := time.Now()
+	return ctx, func(err *errors.QueryError) {
+		// Errors implementing Extensions already carry their code
+		if err != nil && err.Extensions == nil {
+			err.Extensions = map[string]interface{}{"code": errorCode(err.ResolverError)}
+		}
+		// Fields read straight from a struct cost nothing worth recording
+		if trivial {
+			return
+		}
+
+		duration := time.Since(start)
+		metrics.FieldDuration.WithLabelValues(field).Observe(duration.Seconds())
+		if err != nil {
+			log.Printf("[GraphQL] %s at %s failed after %s: [%v] %s", field, formatPath(err.Path), duration, err.Extensions["code"], err.Message)
+			return
+		}
+		log.Printf("[GraphQL] Resolved %s%s in %s", field, formatArgs(args), duration)
+	}
+}
+
+// formatPath formats a response path like listings.0.seller
+func formatPath(path []interface{}) string {
+	parts := make([]string, len(path))
+	for i, segment := range path {
+		parts[i] = fmt.Sprint(segment)
+	}
+	return strings.Join(parts, ".")
+}
+
+// formatArgs formats the scalar arguments of a field like (id: 1, limit: 10).
+// Input objects are left out as they may hold personal data such as addresses
+func formatArgs(args map[string]interface{}) string {
+	names := make([]string, 0, len(args))
+	for name, value := range args {
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			continue
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return ""
+	}
+
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s: %v", name, args[name])
+	}
+	return "(" + strings.Join(parts, ", ") + ")"
+}
+
+// deprecatedFields collects all object fields annotated with @deprecated
+func deprecatedFields(schema *ast.Schema) map[string]bool {
+	fields := make(map[string]bool)
+	for _, object := range schema.Objects {
+		for _, field := range object.Fields {
+			if field.Directives.Get("deprecated") != nil {
+				fields[object.Name+"."+field.Name] = true
+			}
+		}
+	}
+	return fields
+}
+
+// chainTracer runs several tracers, finishing them in reverse order
+type chainTracer []tracer.Tracer
+
+var _ tracer.Tracer = chainTracer(nil)
+
+func (c chainTracer) TraceQuery(ctx context.Context, queryString string, operationName string, variables map[string]interface{}, varTypes map[string]*introspection.Type) (context.Context, tracer.QueryFinishFunc) {
+	finishes := make([]tracer.QueryFinishFunc, 0, len(c))
+	for _, t := range c {
+		var finish tracer.QueryFinishFunc
+		ctx, finish = t.TraceQuery(ctx, queryString, operationName, variables, varTypes)
+		finishes = append(finishes, finish)
+	}
+	return ctx, func(errs []*errors.QueryError) {
+		for i := len(finishes) - 1; i >= 0; i-- {
+			finishes[i](errs)
+		}
+	}
+}
+
+func (c chainTracer) TraceField(ctx context.Context, label, typeName, fieldName string, trivial bool, args map[string]interface{}) (context.Context, tracer.FieldFinishFunc) {
+	finishes := make([]tracer.FieldFinishFunc, 0, len(c))
+	for _, t := range c {
+		var finish tracer.FieldFinishFunc
+		ctx, finish = t.TraceField(ctx, label, typeName, fieldName, trivial, args)
+		finishes = append(finishes, finish)
+	}
+	return ctx, func(err *errors.QueryError) {
+		for i := len(finishes) - 1; i >= 0; i-- {
+			finishes[i](err)
+		}
+	}
+}
+
+func (c chainTracer) TraceValidation(ctx context.Context) tracer.ValidationFinishFunc {
+	var finishes []tracer.ValidationFinishFunc
+	for _, t := range c {
+		if v, ok := t.(tracer.ValidationTracer); ok {
+			finishes = append(finishes, v.TraceValidation(ctx))
+		}
+	}
+	return func(errs []*errors.QueryError) {
+		for i := len(finishes) - 1; i >= 0; i-- {
+			finishes[i](errs)
+		}
+	}
+}
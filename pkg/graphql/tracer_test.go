@@ -0,0 +1,183 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/errors"
+	"github.com/graph-gophers/graphql-go/introspection"
+	"github.com/graph-gophers/graphql-go/trace/tracer"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/metrics"
+)
+
+func TestDeprecatedFieldUsage(t *testing.T) {
+	schema, err := graphql.ParseSchema(`
+schema {
+  query: Query
+}
+
+type Query {
+  name: String!
+  title: String! @deprecated(reason: "Use name instead")
+}
+`, nil)
+	if err != nil {
+		t.Fatalf("Failed to parse schema: %v", err)
+	}
+
+	tracer := &instrumentationTracer{deprecated: deprecatedFields(schema.AST()), clients: ParseKnownClients("storefront")}
+	if len(tracer.deprecated) != 1 || !tracer.deprecated["Query.title"] {
+		t.Fatalf("Expected only Query.title to be deprecated, got %v", tracer.deprecated)
+	}
+
+	ctx := WithClientInfo(context.Background(), ClientInfo{Name: "storefront", Version: "1.2.0"})
+	counter := metrics.DeprecatedFieldUsage.WithLabelValues("Query.title", "storefront")
+	before := testutil.ToFloat64(counter)
+
+	tracer.TraceField(ctx, "", "Query", "name", true, nil)
+	tracer.TraceField(ctx, "", "Query", "title", true, nil)
+
+	if got := testutil.ToFloat64(counter) - before; got != 1 {
+		t.Errorf("Expected deprecated usage to increase by 1, got %v", got)
+	}
+}
+
+func TestOperationMetricLabels(t *testing.T) {
+	tracer := &instrumentationTracer{clients: ParseKnownClients("storefront@1.2.0, cli")}
+
+	// Unknown clients and versions share the "other" series
+	for _, tt := range []struct {
+		client        ClientInfo
+		name, version string
+	}{
+		{ClientInfo{Name: "storefront", Version: "1.2.0"}, "storefront", "1.2.0"},
+		{ClientInfo{Name: "storefront", Version: "9.9.9"}, "storefront", OtherLabel},
+		{ClientInfo{Name: "cli", Version: "0.1.0"}, "cli", OtherLabel},
+		{ClientInfo{Name: "random-4711", Version: "1.0"}, OtherLabel, OtherLabel},
+	} {
+		name, version := tracer.clients.Labels(tt.client)
+		if name != tt.name || version != tt.version {
+			t.Errorf("%+v: expected %s %s, got %s %s", tt.client, tt.name, tt.version, name, version)
+		}
+	}
+
+	// Operation names beyond the cap, and overly long ones, are labeled "other"
+	for i := 0; i < maxOperationLabels; i++ {
+		tracer.operations.label(fmt.Sprintf("Operation%d", i))
+	}
+	if got := tracer.operations.label("Operation0"); got != "Operation0" {
+		t.Errorf("Expected a seen operation name to keep its label, got %s", got)
+	}
+	if got := tracer.operations.label("OneTooMany"); got != OtherLabel {
+		t.Errorf("Expected a new operation name beyond the cap to be labeled %s, got %s", OtherLabel, got)
+	}
+	if got := (&operationLabels{}).label(strings.Repeat("x", maxOperationLabelLength+1)); got != OtherLabel {
+		t.Errorf("Expected an overly long operation name to be labeled %s, got %s", OtherLabel, got)
+	}
+}
+
+func TestTraceFieldLogging(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	tracer := &instrumentationTracer{}
+	ctx := context.Background()
+	series := testutil.CollectAndCount(metrics.FieldDuration)
+
+	_, finish := tracer.TraceField(ctx, "", "Query", "tracedListings", false, map[string]interface{}{
+		"limit":  int32(10),
+		"filter": map[string]interface{}{"sellerName": "Jane"},
+	})
+	finish(nil)
+
+	_, finish = tracer.TraceField(ctx, "", "Query", "tracedListing", false, map[string]interface{}{"id": "7"})
+	queryErr := errors.Errorf("listing 7 not found")
+	queryErr.ResolverError = notFound("listing 7 not found")
+	queryErr.Path = []interface{}{"tracedListing"}
+	finish(queryErr)
+
+	// Trivial fields are neither logged nor timed
+	_, finish = tracer.TraceField(ctx, "", "Listing", "tracedTitle", true, nil)
+	finish(nil)
+
+	output := logs.String()
+	for _, fragment := range []string{
+		"[GraphQL] Resolved Query.tracedListings(limit: 10) in ",
+		"[GraphQL] Query.tracedListing at tracedListing failed after ",
+		"[NOT_FOUND] listing 7 not found",
+	} {
+		if !strings.Contains(output, fragment) {
+			t.Errorf("Expected the log to contain %q, got:\n%s", fragment, output)
+		}
+	}
+	if strings.Contains(output, "Jane") || strings.Contains(output, "tracedTitle") {
+		t.Errorf("Expected input objects and trivial fields to be left out, got:\n%s", output)
+	}
+
+	if got := testutil.CollectAndCount(metrics.FieldDuration) - series; got != 2 {
+		t.Errorf("Expected 2 timed fields, got %d", got)
+	}
+}
+
+// recordingTracer records the traced operations and the error codes of fields
+type recordingTracer struct {
+	mu         sync.Mutex
+	operations []string
+	codes      []interface{}
+}
+
+func (r *recordingTracer) TraceQuery(ctx context.Context, queryString string, operationName string, variables map[string]interface{}, varTypes map[string]*introspection.Type) (context.Context, tracer.QueryFinishFunc) {
+	r.mu.Lock()
+	r.operations = append(r.operations, operationName)
+	r.mu.Unlock()
+	return ctx, func([]*errors.QueryError) {}
+}
+
+func (r *recordingTracer) TraceField(ctx context.Context, label, typeName, fieldName string, trivial bool, args map[string]interface{}) (context.Context, tracer.FieldFinishFunc) {
+	return ctx, func(err *errors.QueryError) {
+		if err != nil {
+			r.mu.Lock()
+			r.codes = append(r.codes, err.Extensions["code"])
+			r.mu.Unlock()
+		}
+	}
+}
+
+func TestNewSchemaConfig(t *testing.T) {
+	recorder := &recordingTracer{}
+	config := DefaultSchemaConfig()
+	config.MaxDepth = 2
+	config.Tracer = recorder
+
+	schema, err := NewSchema(NewResolver(nil), config)
+	if err != nil {
+		t.Fatalf("Failed to parse schema: %v", err)
+	}
+
+	resp := schema.Exec(context.Background(), `query Deep { sellers { listings { seller { id } } } }`, "", nil)
+	if len(resp.Errors) == 0 {
+		t.Errorf("Expected a query deeper than 2 levels to be rejected")
+	}
+
+	// The extra tracer runs alongside the instrumentation tracer and sees its error codes
+	resp = schema.Exec(context.Background(), `query Invalid { listing(id: "abc") { id } }`, "", nil)
+	if len(resp.Errors) == 0 {
+		t.Fatalf("Expected an invalid ID to fail")
+	}
+	if len(recorder.operations) != 1 || recorder.operations[0] != "Invalid" {
+		t.Errorf("Expected the Invalid operation to be traced, got %v", recorder.operations)
+	}
+	if len(recorder.codes) != 1 || recorder.codes[0] == nil {
+		t.Errorf("Expected the field error to carry a code, got %v", recorder.codes)
+	}
+}
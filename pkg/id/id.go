@@ -0,0 +1,109 @@
+// Package id converts between database IDs and the string IDs exposed by the
+// API, reporting malformed IDs with the same message everywhere
+package id
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Codec converts database IDs to the IDs exposed by the API and back. kind
+// names the kind of ID, e.g. "seller"
+type Codec interface {
+	Encode(kind string, n int) string
+	Decode(kind, s string) (int, error)
+}
+
+// Decimal exposes database IDs as decimal numbers
+type Decimal struct{}
+
+func (Decimal) Encode(kind string, n int) string {
+	return strconv.Itoa(n)
+}
+
+func (Decimal) Decode(kind, s string) (int, error) {
+	return strconv.Atoi(s)
+}
+
+// codec converts all IDs; Decimal unless SetCodec replaced it
+var codec Codec = Decimal{}
+
+// SetCodec sets the codec of all IDs. It must be called before IDs are
+// formatted or parsed, as it isn't safe for concurrent use
+func SetCodec(c Codec) {
+	codec = c
+}
+
+// FormatSellerID formats a seller ID
+func FormatSellerID(n int) string {
+	return codec.Encode("seller", n)
+}
+
+// FormatListingID formats a listing ID
+func FormatListingID(n int) string {
+	return codec.Encode("listing", n)
+}
+
+// FormatPurchaseID formats a purchase ID
+func FormatPurchaseID(n int) string {
+	return codec.Encode("purchase", n)
+}
+
+// FormatDeliveryID formats a delivery ID
+func FormatDeliveryID(n int) string {
+	return codec.Encode("delivery", n)
+}
+
+// FormatPickupPointID formats a pickup point ID
+func FormatPickupPointID(n int) string {
+	return codec.Encode("pickup point", n)
+}
+
+// ParseSellerID parses a seller ID
+func ParseSellerID(s string) (int, error) {
+	return parse("seller", s)
+}
+
+// ParseListingID parses a listing ID
+func ParseListingID(s string) (int, error) {
+	return parse("listing", s)
+}
+
+// ParsePurchaseID parses a purchase ID
+func ParsePurchaseID(s string) (int, error) {
+	return parse("purchase", s)
+}
+
+// ParseDeliveryID parses a delivery ID
+func ParseDeliveryID(s string) (int, error) {
+	return parse("delivery", s)
+}
+
+// ParsePickupPointID parses a pickup point ID
+func ParsePickupPointID(s string) (int, error) {
+	return parse("pickup point", s)
+}
+
+// FormatError reports a malformed ID
+type FormatError struct {
+	// Kind names the kind of ID, e.g. "seller"
+	Kind string
+	Err  error
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("invalid %s ID format: %v", e.Kind, e.Err)
+}
+
+func (e *FormatError) Unwrap() error {
+	return e.Err
+}
+
+// parse decodes an ID, naming the kind of ID in the error
+func parse(kind, s string) (int, error) {
+	n, err := codec.Decode(kind, s)
+	if err != nil {
+		return 0, &FormatError{Kind: kind, Err: err}
+	}
+	return n, nil
+}
@@ -0,0 +1,63 @@
+package id
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	n, err := ParseListingID("42")
+	if err != nil || n != 42 {
+		t.Errorf("Expected 42, got %d and %v", n, err)
+	}
+
+	_, err = ParseSellerID("abc")
+	expected := `invalid seller ID format: strconv.Atoi: parsing "abc": invalid syntax`
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected %q, got %v", expected, err)
+	}
+
+	if _, err := ParsePickupPointID(""); err == nil {
+		t.Errorf("Expected an empty ID to be rejected")
+	}
+}
+
+func TestObfuscator(t *testing.T) {
+	o := NewObfuscator([]byte("secret"))
+
+	seller := o.Encode("seller", 42)
+	if len(seller) != 11 || seller == "42" {
+		t.Errorf("Expected an 11 digit obfuscated ID, got %q", seller)
+	}
+	if n, err := o.Decode("seller", seller); err != nil || n != 42 {
+		t.Errorf("Expected %q to decode to 42, got %d and %v", seller, n, err)
+	}
+	if listing := o.Encode("listing", 42); listing == seller {
+		t.Errorf("Expected kinds of IDs to be obfuscated differently, both got %q", listing)
+	}
+	if next := o.Encode("seller", 43); next[:8] == seller[:8] {
+		t.Errorf("Expected consecutive IDs to look unrelated, got %q and %q", seller, next)
+	}
+	if other := NewObfuscator([]byte("other")).Encode("seller", 42); other == seller {
+		t.Errorf("Expected the secret to change the ID, both got %q", other)
+	}
+
+	for _, malformed := range []string{"42", "", "zzzzzzzzzzz", "0000000000-"} {
+		if _, err := o.Decode("seller", malformed); err == nil {
+			t.Errorf("Expected %q to be rejected", malformed)
+		}
+	}
+}
+
+func TestSetCodec(t *testing.T) {
+	SetCodec(NewObfuscator([]byte("secret")))
+	defer SetCodec(Decimal{})
+
+	formatted := FormatPurchaseID(7)
+	if n, err := ParsePurchaseID(formatted); err != nil || n != 7 {
+		t.Errorf("Expected %q to parse back to 7, got %d and %v", formatted, n, err)
+	}
+
+	_, err := ParsePurchaseID("7")
+	expected := "invalid purchase ID format: not an ID issued by this server"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected %q, got %v", expected, err)
+	}
+}
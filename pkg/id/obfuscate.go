@@ -0,0 +1,116 @@
+package id
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+	"strings"
+)
+
+// base62Alphabet holds the digits of obfuscated IDs
+const base62Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// obfuscatedLength is the number of base62 digits needed for 64 bits
+const obfuscatedLength = 11
+
+// feistelRounds is the number of rounds permuting an ID
+const feistelRounds = 4
+
+// errNotIssued reports an ID the obfuscator didn't issue
+var errNotIssued = errors.New("not an ID issued by this server")
+
+// Obfuscator exposes database IDs as fixed-length base62 strings that reveal
+// neither the order of rows nor their number, so IDs can't be enumerated by
+// incrementing them. IDs are permuted by a Feistel network keyed with the
+// secret and the kind of ID, so a seller and a listing with the same database
+// ID get unrelated public IDs
+type Obfuscator struct {
+	secret []byte
+}
+
+// NewObfuscator returns an obfuscator keyed with secret. Changing the secret
+// changes every public ID
+func NewObfuscator(secret []byte) *Obfuscator {
+	return &Obfuscator{secret: secret}
+}
+
+func (o *Obfuscator) Encode(kind string, n int) string {
+	return formatBase62(o.permute(kind, uint64(n)))
+}
+
+func (o *Obfuscator) Decode(kind, s string) (int, error) {
+	permuted, err := parseBase62(s)
+	if err != nil {
+		return 0, err
+	}
+	n := o.unpermute(kind, permuted)
+	if n > math.MaxInt {
+		return 0, errNotIssued
+	}
+	return int(n), nil
+}
+
+// permute runs the Feistel network over the halves of n
+func (o *Obfuscator) permute(kind string, n uint64) uint64 {
+	left, right := uint32(n>>32), uint32(n)
+	for round := 0; round < feistelRounds; round++ {
+		left, right = right, left^o.round(kind, round, right)
+	}
+	return uint64(left)<<32 | uint64(right)
+}
+
+// unpermute runs the Feistel network backwards, undoing permute
+func (o *Obfuscator) unpermute(kind string, n uint64) uint64 {
+	left, right := uint32(n>>32), uint32(n)
+	for round := feistelRounds - 1; round >= 0; round-- {
+		left, right = right^o.round(kind, round, left), left
+	}
+	return uint64(left)<<32 | uint64(right)
+}
+
+// round is the keyed round function of the Feistel network
+func (o *Obfuscator) round(kind string, round int, half uint32) uint32 {
+	var block [5]byte
+	block[0] = byte(round)
+	binary.BigEndian.PutUint32(block[1:], half)
+
+	mac := hmac.New(sha256.New, o.secret)
+	mac.Write([]byte(kind))
+	mac.Write(block[:])
+	return binary.BigEndian.Uint32(mac.Sum(nil))
+}
+
+// formatBase62 formats n as obfuscatedLength base62 digits
+func formatBase62(n uint64) string {
+	var digits [obfuscatedLength]byte
+	for i := len(digits) - 1; i >= 0; i-- {
+		digits[i] = base62Alphabet[n%62]
+		n /= 62
+	}
+	return string(digits[:])
+}
+
+// parseBase62 parses obfuscatedLength base62 digits, rejecting values beyond
+// 64 bits
+func parseBase62(s string) (uint64, error) {
+	if len(s) != obfuscatedLength {
+		return 0, errNotIssued
+	}
+	var n uint64
+	for i := 0; i < len(s); i++ {
+		digit := strings.IndexByte(base62Alphabet, s[i])
+		if digit < 0 {
+			return 0, errNotIssued
+		}
+		hi, lo := bits.Mul64(n, 62)
+		lo, carry := bits.Add64(lo, uint64(digit), 0)
+		if hi != 0 || carry != 0 {
+			return 0, errNotIssued
+		}
+		n = lo
+	}
+	return n, nil
+}
@@ -0,0 +1,57 @@
+package jwt
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	h := NewHS256([]byte("secret"))
+
+	token, err := h.Sign(Claims{Subject: "seller-3", Role: "seller", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	claims, err := h.Verify(token)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if claims.Subject != "seller-3" || claims.Role != "seller" {
+		t.Errorf("Expected seller-3 as seller, got %+v", claims)
+	}
+}
+
+func TestVerifyRejectsTamperedToken(t *testing.T) {
+	h := NewHS256([]byte("secret"))
+	token, _ := h.Sign(Claims{Subject: "buyer-1", Role: "buyer"})
+	forged, _ := NewHS256([]byte("other")).Sign(Claims{Subject: "admin-1", Role: "admin"})
+
+	// Swap in the claims of the forged token under the original signature
+	parts := strings.Split(token, ".")
+	parts[1] = strings.Split(forged, ".")[1]
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + "."
+
+	for _, tok := range []string{strings.Join(parts, "."), forged, unsigned, "garbage", ""} {
+		if _, err := h.Verify(tok); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Expected ErrInvalidToken for %q, got %v", tok, err)
+		}
+	}
+}
+
+func TestVerifyChecksValidityPeriod(t *testing.T) {
+	h := NewHS256([]byte("secret"))
+	now := time.Now()
+	h.now = func() time.Time { return now }
+
+	expired, _ := h.Sign(Claims{Subject: "buyer-1", ExpiresAt: now.Add(-time.Minute).Unix()})
+	early, _ := h.Sign(Claims{Subject: "buyer-1", NotBefore: now.Add(time.Minute).Unix()})
+	for _, tok := range []string{expired, early} {
+		if _, err := h.Verify(tok); !errors.Is(err, ErrExpiredToken) {
+			t.Errorf("Expected ErrExpiredToken, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DeprecatedFieldUsage counts resolutions of deprecated schema fields per client
+var DeprecatedFieldUsage = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "graphql_deprecated_field_usage_total",
+		Help: "Number of times a deprecated field was resolved, by field and client name",
+	},
+	[]string{"field", "client"},
+)
+
+// OperationsTotal counts executed GraphQL operations per client and outcome
+var OperationsTotal = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "graphql_operations_total",
+		Help: "Number of executed GraphQL operations, by operation name, client name, client version and status",
+	},
+	[]string{"operation", "client", "client_version", "status"},
+)
+
+// OperationDuration observes GraphQL operation latency per client
+var OperationDuration = promauto.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "graphql_operation_duration_seconds",
+		Help:    "Duration of GraphQL operations, by operation name and client name",
+		Buckets: prometheus.DefBuckets,
+	},
+	[]string{"operation", "client"},
+)
+
+// FieldDuration observes the latency of resolver methods, including the
+// nested fields they return
+var FieldDuration = promauto.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "graphql_field_duration_seconds",
+		Help:    "Duration of resolver methods including their nested fields, by Type.field",
+		Buckets: prometheus.DefBuckets,
+	},
+	[]string{"field"},
+)
+
+// RateLimitedTotal counts requests rejected by the per-client rate limiter
+var RateLimitedTotal = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "graphql_rate_limited_total",
+		Help: "Number of requests rejected by the per-client rate limiter, by client name",
+	},
+	[]string{"client"},
+)
+
+// RepositoryDuration observes the latency of repository methods
+var RepositoryDuration = promauto.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "repository_method_duration_seconds",
+		Help:    "Duration of repository methods, by method",
+		Buckets: prometheus.DefBuckets,
+	},
+	[]string{"method"},
+)
+
+// RepositoryErrors counts failed repository method calls
+var RepositoryErrors = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "repository_method_errors_total",
+		Help: "Number of repository method calls that returned an error, by method",
+	},
+	[]string{"method"},
+)
+
+// RepositoryCircuitOpen is 1 while the circuit breaker in front of the
+// database is open and repository calls fail fast
+var RepositoryCircuitOpen = promauto.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "repository_circuit_breaker_open",
+		Help: "Whether the database circuit breaker is open (1) or closed (0)",
+	},
+)
+
+// WebSocketConnections tracks the open WebSocket connections per subprotocol
+var WebSocketConnections = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "websocket_connections",
+		Help: "Number of open WebSocket connections, by subprotocol",
+	},
+	[]string{"protocol"},
+)
+
+// WebSocketConnectionDuration observes how long WebSocket connections last
+// and how they ended
+var WebSocketConnectionDuration = promauto.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "websocket_connection_duration_seconds",
+		Help:    "Lifetime of WebSocket connections, by subprotocol, closing side and close code",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 8),
+	},
+	[]string{"protocol", "closed_by", "code"},
+)
+
+// WebSocketEvents counts lifecycle events of WebSocket connections
+var WebSocketEvents = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "websocket_events_total",
+		Help: "Number of WebSocket connection lifecycle events, by subprotocol and event",
+	},
+	[]string{"protocol", "event"},
+)
+
+// SubscriptionLag observes how long live events wait between publication and
+// being handed to the subscriber
+var SubscriptionLag = promauto.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "graphql_subscription_lag_seconds",
+		Help:    "Time between publishing an event and handing it to a subscriber, by subscription field",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 9),
+	},
+	[]string{"subscription"},
+)
+
+// SubscriptionBufferedEvents tracks the events buffered for subscribers that
+// haven't read them yet
+var SubscriptionBufferedEvents = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "graphql_subscription_buffered_events",
+		Help: "Number of events buffered for slow subscribers, by subscription field",
+	},
+	[]string{"subscription"},
+)
+
+// SubscriptionDroppedEvents counts events dropped because a subscriber's buffer was full
+var SubscriptionDroppedEvents = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "graphql_subscription_dropped_events_total",
+		Help: "Number of events dropped for lagging subscribers, by subscription field",
+	},
+	[]string{"subscription"},
+)
+
+// SubscriptionLagDisconnects counts subscriptions ended because they lagged too far behind
+var SubscriptionLagDisconnects = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "graphql_subscription_lag_disconnects_total",
+		Help: "Number of subscriptions ended for lagging behind, by subscription field",
+	},
+	[]string{"subscription"},
+)
+
+// RegisterDBStats exports the connection pool statistics of db (open, in-use
+// and idle connections, wait count and duration) as go_sql_* metrics
+func RegisterDBStats(db *sql.DB, name string) error {
+	return prometheus.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler returns the HTTP handler exposing all registered metrics
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,52 @@
+package models
+
+import "fmt"
+
+// Delivery statuses as stored in the database
+const (
+	DeliveryStatusPacked         = "packed"
+	DeliveryStatusOutForDelivery = "out_for_delivery"
+	DeliveryStatusDelivered      = "delivered"
+	DeliveryStatusRescheduled    = "rescheduled"
+	DeliveryStatusCanceled       = "canceled"
+)
+
+// deliveryTransitions lists the statuses reachable from each delivery status;
+// delivered and canceled deliveries are final
+var deliveryTransitions = map[string][]string{
+	DeliveryStatusPacked:         {DeliveryStatusOutForDelivery, DeliveryStatusRescheduled, DeliveryStatusCanceled},
+	DeliveryStatusOutForDelivery: {DeliveryStatusDelivered, DeliveryStatusRescheduled, DeliveryStatusCanceled},
+	DeliveryStatusRescheduled:    {DeliveryStatusPacked, DeliveryStatusOutForDelivery, DeliveryStatusCanceled},
+	DeliveryStatusDelivered:      nil,
+	DeliveryStatusCanceled:       nil,
+}
+
+// ValidateDeliveryTransition returns an error describing why a delivery may not
+// move from one status to another, or nil if the transition is allowed
+func ValidateDeliveryTransition(from, to string) error {
+	next, ok := deliveryTransitions[from]
+	if !ok {
+		return fmt.Errorf("unknown delivery status: %s", from)
+	}
+	if _, ok := deliveryTransitions[to]; !ok {
+		return fmt.Errorf("unknown delivery status: %s", to)
+	}
+	if len(next) == 0 {
+		return fmt.Errorf("delivery is already %s and can no longer change status", from)
+	}
+
+	for _, status := range next {
+		if status == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("delivery cannot change status from %s to %s, expected one of %v", from, to, next)
+}
+
+// ValidateDeliveryStatus returns an error if status isn't a known delivery status
+func ValidateDeliveryStatus(status string) error {
+	if _, ok := deliveryTransitions[status]; !ok {
+		return fmt.Errorf("invalid status: %s", status)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package models
+
+import "testing"
+
+func TestValidateDeliveryTransition(t *testing.T) {
+	tests := []struct {
+		from, to string
+		valid    bool
+	}{
+		{DeliveryStatusPacked, DeliveryStatusOutForDelivery, true},
+		{DeliveryStatusOutForDelivery, DeliveryStatusDelivered, true},
+		{DeliveryStatusRescheduled, DeliveryStatusOutForDelivery, true},
+		{DeliveryStatusPacked, DeliveryStatusCanceled, true},
+		{DeliveryStatusPacked, DeliveryStatusDelivered, false},
+		{DeliveryStatusPacked, DeliveryStatusPacked, false},
+		{DeliveryStatusDelivered, DeliveryStatusPacked, false},
+		{DeliveryStatusCanceled, DeliveryStatusOutForDelivery, false},
+		{DeliveryStatusPacked, "lost", false},
+	}
+
+	for _, tt := range tests {
+		err := ValidateDeliveryTransition(tt.from, tt.to)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s -> %s: expected valid=%v, got error %v", tt.from, tt.to, tt.valid, err)
+		}
+	}
+}
+
+func TestValidateDeliveryStatus(t *testing.T) {
+	for _, status := range []string{DeliveryStatusPacked, DeliveryStatusDelivered, DeliveryStatusCanceled} {
+		if err := ValidateDeliveryStatus(status); err != nil {
+			t.Errorf("%s: unexpected error %v", status, err)
+		}
+	}
+	if err := ValidateDeliveryStatus("lost"); err == nil {
+		t.Error("expected an error for an unknown status")
+	}
+}
@@ -4,21 +4,90 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"strings"
 	"time"
+
+	"github.com/lib/pq"
 )
 
-// Database connection string and pool
-func NewDB(host, port, user, password, dbname string) (*sql.DB, error) {
-	psqlInfo := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		host, port, user, password, dbname)
+// ConnectRetry controls how NewDB waits for the database to become reachable.
+// A zero MaxWait disables retrying
+type ConnectRetry struct {
+	MaxWait        time.Duration
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultConnectRetry waits up to a minute, backing off from 500ms to 5s
+var DefaultConnectRetry = ConnectRetry{
+	MaxWait:        time.Minute,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+}
+
+// SSLConfig holds the TLS settings of the database connection. Mode is one of
+// the libpq sslmode values (disable, require, verify-ca, verify-full);
+// the certificate and key settings are file paths
+type SSLConfig struct {
+	Mode     string
+	RootCert string
+	Cert     string
+	Key      string
+}
+
+// DSN builds a key=value connection string from discrete settings. TLS is
+// disabled unless ssl.Mode says otherwise
+func DSN(host, port, user, password, dbname string, ssl SSLConfig) string {
+	mode := ssl.Mode
+	if mode == "" {
+		mode = "disable"
+	}
+
+	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		quoteDSNValue(host), quoteDSNValue(port), quoteDSNValue(user), quoteDSNValue(password), quoteDSNValue(dbname),
+		quoteDSNValue(mode))
+
+	if ssl.RootCert != "" {
+		dsn += " sslrootcert=" + quoteDSNValue(ssl.RootCert)
+	}
+	if ssl.Cert != "" {
+		dsn += " sslcert=" + quoteDSNValue(ssl.Cert)
+	}
+	if ssl.Key != "" {
+		dsn += " sslkey=" + quoteDSNValue(ssl.Key)
+	}
+	return dsn
+}
+
+// quoteDSNValue quotes a connection string value so that spaces, quotes and
+// backslashes (common in generated passwords) survive parsing
+func quoteDSNValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, ` '\`) {
+		return value
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
 
-	db, err := sql.Open("postgres", psqlInfo)
+// NewDB opens a connection pool. dataSource is either a key=value connection
+// string (see DSN) or a postgres:// URL, whose sslmode and other query
+// parameters are passed on to the driver
+func NewDB(dataSource string, retry ConnectRetry) (*sql.DB, error) {
+	if strings.HasPrefix(dataSource, "postgres://") || strings.HasPrefix(dataSource, "postgresql://") {
+		converted, err := pq.ParseURL(dataSource)
+		if err != nil {
+			return nil, fmt.Errorf("invalid database URL: %w", err)
+		}
+		dataSource = converted
+	}
+
+	db, err := sql.Open("postgres", dataSource)
 	if err != nil {
 		return nil, err
 	}
 
-	err = db.Ping()
+	err = pingWithRetry(db.Ping, retry, time.Sleep)
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 
@@ -26,11 +95,49 @@ func NewDB(host, port, user, password, dbname string) (*sql.DB, error) {
 	return db, nil
 }
 
+// pingWithRetry calls ping until it succeeds or retry.MaxWait has elapsed,
+// doubling the delay between attempts up to retry.MaxBackoff
+func pingWithRetry(ping func() error, retry ConnectRetry, sleep func(time.Duration)) error {
+	backoff := retry.InitialBackoff
+	if backoff <= 0 {
+		backoff = DefaultConnectRetry.InitialBackoff
+	}
+
+	var waited time.Duration
+	for attempt := 1; ; attempt++ {
+		err := ping()
+		if err == nil {
+			return nil
+		}
+		if waited >= retry.MaxWait {
+			if attempt > 1 {
+				return fmt.Errorf("database not reachable after %d attempts: %w", attempt, err)
+			}
+			return err
+		}
+
+		delay := backoff
+		if remaining := retry.MaxWait - waited; delay > remaining {
+			delay = remaining
+		}
+		log.Printf("Database not ready (attempt %d): %v; retrying in %v", attempt, err, delay)
+		sleep(delay)
+		waited += delay
+
+		backoff *= 2
+		if retry.MaxBackoff > 0 && backoff > retry.MaxBackoff {
+			backoff = retry.MaxBackoff
+		}
+	}
+}
+
 // Seller represents a seller entity
 type Seller struct {
 	ID      int    `json:"id"`
 	Name    string `json:"name"`
 	Address string `json:"address"`
+	// DigestOptIn subscribes the seller to the daily sales digest
+	DigestOptIn bool `json:"digestOptIn"`
 }
 
 // Listing represents a product listing
@@ -39,48 +146,243 @@ type Listing struct {
 	SellerID    int     `json:"sellerId"`
 	Title       string  `json:"title"`
 	Description string  `json:"description"`
-	Price       float64 `json:"price"`
+	Price       Money   `json:"price"`
 	Seller      *Seller `json:"seller,omitempty"`
 }
 
+// NewListing holds the fields of a listing to create
+type NewListing struct {
+	SellerID    int
+	Title       string
+	Description string
+	Price       Money
+}
+
+// PricePoint is a recorded price of a listing
+type PricePoint struct {
+	ListingID int       `json:"listingId"`
+	Price     Money     `json:"price"`
+	ChangedAt time.Time `json:"changedAt"`
+}
+
 // Purchase represents a purchase transaction
 type Purchase struct {
 	ID              int       `json:"id"`
 	ListingID       int       `json:"listingId"`
-	Price           float64   `json:"price"`
+	Price           Money     `json:"price"`
+	TaxAmount       Money     `json:"taxAmount"`
 	BankTxID        string    `json:"bankTxId"`
 	DeliveryAddress string    `json:"deliveryAddress"`
+	PickupPointID   *int      `json:"pickupPointId,omitempty"`
+	Status          string    `json:"status"`
+	OrderStatus     string    `json:"orderStatus"`
 	CreatedAt       time.Time `json:"createdAt"`
 	Listing         *Listing  `json:"listing,omitempty"`
+	// Cancellation is set on purchases returned by CancelPurchase
+	Cancellation *PurchaseCancellation `json:"cancellation,omitempty"`
+}
+
+// PurchaseCancellation records why and when a purchase was canceled
+type PurchaseCancellation struct {
+	PurchaseID int       `json:"purchaseId"`
+	Reason     string    `json:"reason"`
+	CanceledAt time.Time `json:"canceledAt"`
+}
+
+// SellerWebhook is a seller's callback URL notified of purchases of their listings
+type SellerWebhook struct {
+	SellerID  int       `json:"sellerId"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"-"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// SellerQuota is a listing limit an admin set for a seller; zero means unlimited
+type SellerQuota struct {
+	SellerID    int       `json:"sellerId"`
+	MaxListings int       `json:"maxListings"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// SellerDigest summarizes a seller's sales and delivery updates within [From, To)
+type SellerDigest struct {
+	SellerID             int                   `json:"sellerId"`
+	From                 time.Time             `json:"from"`
+	To                   time.Time             `json:"to"`
+	Purchases            int                   `json:"purchases"`
+	Revenue              Money                 `json:"revenue"`
+	DeliveryStatusCounts []DeliveryStatusCount `json:"deliveryStatusCounts"`
+}
+
+// SalesSummary aggregates a seller's sales within an optional period; nil
+// bounds leave the period open
+type SalesSummary struct {
+	SellerID int        `json:"sellerId"`
+	From     *time.Time `json:"from"`
+	To       *time.Time `json:"to"`
+	Sales    int        `json:"sales"`
+	Revenue  Money      `json:"revenue"`
+}
+
+// PickupPoint is a location where buyers can collect their purchases
+type PickupPoint struct {
+	ID        int     `json:"id"`
+	Name      string  `json:"name"`
+	Address   string  `json:"address"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	// DistanceKm is only set when the pickup point was looked up by location
+	DistanceKm *float64 `json:"distanceKm,omitempty"`
 }
 
 // Delivery represents a delivery status update
 type Delivery struct {
-	ID         int       `json:"id"`
-	PurchaseID int       `json:"purchaseId"`
-	Timestamp  time.Time `json:"timestamp"`
-	Status     string    `json:"status"`
-	Purchase   *Purchase `json:"purchase,omitempty"`
+	ID            int        `json:"id"`
+	PurchaseID    int        `json:"purchaseId"`
+	Timestamp     time.Time  `json:"timestamp"`
+	Status        string     `json:"status"`
+	ScheduledFor  *time.Time `json:"scheduledFor,omitempty"`
+	AttemptNumber int        `json:"attemptNumber"`
+	Purchase      *Purchase  `json:"purchase,omitempty"`
+}
+
+// DeliveryTimelineDay aggregates the delivery updates of a single day
+type DeliveryTimelineDay struct {
+	Date         time.Time             `json:"date"`
+	StatusCounts []DeliveryStatusCount `json:"statusCounts"`
+}
+
+// DeliveryStatusCount is the number of delivery updates with a given status
+type DeliveryStatusCount struct {
+	Status string `json:"status"`
+	Count  int    `json:"count"`
+}
+
+// PriceStats summarizes listing prices; the aggregates are nil when no listing matches
+type PriceStats struct {
+	Count     int           `json:"count"`
+	Min       *float64      `json:"min"`
+	Max       *float64      `json:"max"`
+	Avg       *float64      `json:"avg"`
+	Median    *float64      `json:"median"`
+	Histogram []PriceBucket `json:"histogram"`
 }
 
+// PriceBucket is a histogram bucket covering prices in [Min, Max)
+type PriceBucket struct {
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Count int     `json:"count"`
+}
+
+// PurchaseStats summarizes purchases; Revenue leaves out rejected and canceled ones
+type PurchaseStats struct {
+	Count   int   `json:"count"`
+	Revenue Money `json:"revenue"`
+}
+
+// Dashboard holds the key figures of the operations dashboard. Open purchases
+// are orders pending, paid or shipped, OutForDelivery counts purchases whose
+// latest delivery update is out_for_delivery, and RevenueToday leaves out
+// rejected and canceled purchases. Version changes whenever the figures may have
+type Dashboard struct {
+	OpenPurchases  int    `json:"openPurchases"`
+	OutForDelivery int    `json:"outForDelivery"`
+	RevenueToday   Money  `json:"revenueToday"`
+	Version        string `json:"version"`
+}
+
+// SearchResults holds the sellers, listings and purchases matching a search term
+type SearchResults struct {
+	Sellers   []*Seller   `json:"sellers"`
+	Listings  []*Listing  `json:"listings"`
+	Purchases []*Purchase `json:"purchases"`
+}
+
+// Purchase review statuses
+const (
+	PurchaseStatusPendingReview = "pending_review"
+	PurchaseStatusApproved      = "approved"
+	PurchaseStatusRejected      = "rejected"
+	PurchaseStatusCanceled      = "canceled"
+)
+
+// Sort orders for listings
+const (
+	OrderByPopularity    = "POPULARITY"
+	OrderByPriceAsc      = "PRICE_ASC"
+	OrderByPriceDesc     = "PRICE_DESC"
+	OrderByTitleAsc      = "TITLE_ASC"
+	OrderByCreatedAtDesc = "CREATED_AT_DESC"
+)
+
 // Filter options for GraphQL queries
 type ListingFilter struct {
-	SellerID *int
-	MinPrice *float64
-	MaxPrice *float64
-	Title    *string
+	SellerID   *int
+	SellerIDIn []int
+	// SellerName matches listings of sellers whose name contains it, case-insensitively
+	SellerName   *string
+	MinPrice     *Money
+	MaxPrice     *Money
+	Title        *string
+	TitleNotLike *string
+	// And requires every sub-filter to match, Or at least one; both combine
+	// with the other conditions of the filter. Only their conditions are used
+	And     []*ListingFilter
+	Or      []*ListingFilter
+	OrderBy string
+	// Page restricts the result to a keyset page, overriding OrderBy
+	Page *Page
+	// Limit and Offset page through the results unless Page is set; a zero
+	// Limit returns all of them
+	Limit  int
+	Offset int
+}
+
+// Page selects a keyset page of rows ordered by ID, or by price and then ID
+// when OrderBy is OrderByPriceAsc or OrderByPriceDesc. The price bounds then
+// accompany the ID bounds, so rows of equal price are split between pages
+// without being skipped or repeated. Limit caps the number of rows, taken from
+// the end of the range when FromEnd is set; rows are always returned in the
+// page's order
+type Page struct {
+	OrderBy     string
+	AfterID     *int
+	AfterPrice  *Money
+	BeforeID    *int
+	BeforePrice *Money
+	Limit       int
+	FromEnd     bool
+}
+
+// SortsByPrice reports whether the page is ordered by price
+func (p *Page) SortsByPrice() bool {
+	return p.OrderBy == OrderByPriceAsc || p.OrderBy == OrderByPriceDesc
 }
 
 type PurchaseFilter struct {
-	ListingID *int
-	BankTxID  *string
-	FromDate  *time.Time
-	ToDate    *time.Time
+	ListingID   *int
+	ListingIDIn []int
+	BankTxID    *string
+	Status      *string
+	OrderStatus *string
+	FromDate    *time.Time
+	ToDate      *time.Time
+	// Limit and Offset page through the results; a zero Limit returns all of them
+	Limit  int
+	Offset int
 }
 
 type DeliveryFilter struct {
-	PurchaseID *int
-	Status     *string
-	FromDate   *time.Time
-	ToDate     *time.Time
+	PurchaseID    *int
+	Status        *string
+	StatusIn      []string
+	StatusNot     *string
+	FromDate      *time.Time
+	ToDate        *time.Time
+	ScheduledFrom *time.Time
+	ScheduledTo   *time.Time
+	// Limit and Offset page through the results; a zero Limit returns all of them
+	Limit  int
+	Offset int
 }
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPingWithRetry(t *testing.T) {
+	retry := ConnectRetry{MaxWait: 10 * time.Second, InitialBackoff: time.Second, MaxBackoff: 2 * time.Second}
+
+	// Succeeds on the third attempt
+	attempts := 0
+	var delays []time.Duration
+	err := pingWithRetry(func() error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}, retry, func(d time.Duration) { delays = append(delays, d) })
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+	if len(delays) != 2 || delays[0] != time.Second || delays[1] != 2*time.Second {
+		t.Errorf("Expected delays [1s 2s], got %v", delays)
+	}
+}
+
+func TestPingWithRetryGivesUp(t *testing.T) {
+	retry := ConnectRetry{MaxWait: 5 * time.Second, InitialBackoff: time.Second, MaxBackoff: 2 * time.Second}
+
+	var waited time.Duration
+	err := pingWithRetry(func() error {
+		return errors.New("connection refused")
+	}, retry, func(d time.Duration) { waited += d })
+
+	if err == nil {
+		t.Fatal("Expected error, got nil")
+	}
+	if waited != 5*time.Second {
+		t.Errorf("Expected to wait 5s in total, got %v", waited)
+	}
+
+	// Without a max wait the first failure is returned immediately
+	waited = 0
+	err = pingWithRetry(func() error {
+		return errors.New("connection refused")
+	}, ConnectRetry{}, func(d time.Duration) { waited += d })
+	if err == nil || waited != 0 {
+		t.Errorf("Expected immediate failure, got err=%v waited=%v", err, waited)
+	}
+}
+
+func TestDSN(t *testing.T) {
+	dsn := DSN("db", "5432", "app", `p@ss word'\`, "shop", SSLConfig{})
+	expected := `host=db port=5432 user=app password='p@ss word\'\\' dbname=shop sslmode=disable`
+	if dsn != expected {
+		t.Errorf("Expected %s, got %s", expected, dsn)
+	}
+
+	if dsn := DSN("db", "5432", "app", "", "shop", SSLConfig{}); !strings.Contains(dsn, "password='' ") {
+		t.Errorf("Expected empty password to be quoted, got %s", dsn)
+	}
+}
+
+func TestDSNWithSSL(t *testing.T) {
+	dsn := DSN("db", "5432", "app", "secret", "shop", SSLConfig{
+		Mode:     "verify-full",
+		RootCert: "/certs/ca.pem",
+		Cert:     "/certs/client.pem",
+		Key:      "/certs/client key.pem",
+	})
+	expected := `host=db port=5432 user=app password=secret dbname=shop sslmode=verify-full ` +
+		`sslrootcert=/certs/ca.pem sslcert=/certs/client.pem sslkey='/certs/client key.pem'`
+	if dsn != expected {
+		t.Errorf("Expected %s, got %s", expected, dsn)
+	}
+}
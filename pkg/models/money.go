@@ -0,0 +1,134 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Money is an exact amount of currency in cents. Sums and differences of Money
+// values are exact; multiplying by a rate rounds to the nearest cent
+type Money int64
+
+// MoneyFromFloat converts an amount to Money, rounding half away from zero to cents
+func MoneyFromFloat(amount float64) Money {
+	return Money(math.Round(amount * 100))
+}
+
+// ParseMoney parses a decimal amount with at most two fractional digits, e.g. "12.5" or "-3.05"
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	unsigned := strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+
+	whole, frac, _ := strings.Cut(unsigned, ".")
+	if whole == "" && frac == "" || len(frac) > 2 || strings.ContainsAny(whole+frac, "+-") {
+		return 0, fmt.Errorf("invalid money amount: %q", s)
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid money amount: %q", s)
+	}
+	cents, err := strconv.ParseInt(frac, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid money amount: %q", s)
+	}
+
+	m := Money(units*100 + cents)
+	if negative {
+		m = -m
+	}
+	return m, nil
+}
+
+// String formats the amount with two fractional digits, e.g. "12.50"
+func (m Money) String() string {
+	sign := ""
+	cents := int64(m)
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// Float64 returns the amount as a float for non-monetary calculations such as rates
+func (m Money) Float64() float64 {
+	return float64(m) / 100
+}
+
+// Mul multiplies the amount by a rate, e.g. a tax or exchange rate, rounding to cents
+func (m Money) Mul(rate float64) Money {
+	return MoneyFromFloat(m.Float64() * rate)
+}
+
+// Scan reads a NUMERIC column
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return m.parse(string(v))
+	case string:
+		return m.parse(v)
+	case float64:
+		*m = MoneyFromFloat(v)
+	case int64:
+		*m = Money(v * 100)
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+	return nil
+}
+
+// Value writes the amount as an exact decimal string, which Postgres casts to NUMERIC
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+// MarshalJSON encodes the amount as a JSON number with exactly two fractional digits
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON decodes a JSON number or string
+func (m *Money) UnmarshalJSON(data []byte) error {
+	return m.parse(strings.Trim(string(data), `"`))
+}
+
+// ImplementsGraphQLType maps Money to the GraphQL Money scalar
+func (Money) ImplementsGraphQLType(name string) bool {
+	return name == "Money"
+}
+
+// UnmarshalGraphQL accepts Money as a decimal string or as a number with at most two fractional digits
+func (m *Money) UnmarshalGraphQL(input interface{}) error {
+	switch v := input.(type) {
+	case string:
+		return m.parse(v)
+	case int32:
+		*m = Money(int64(v) * 100)
+	case int64:
+		*m = Money(v * 100)
+	case float64:
+		// Format without exponent so parse rejects sub-cent amounts
+		return m.parse(strconv.FormatFloat(v, 'f', -1, 64))
+	default:
+		return fmt.Errorf("invalid Money value of type %T", input)
+	}
+	return nil
+}
+
+func (m *Money) parse(s string) error {
+	parsed, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Money
+		valid bool
+	}{
+		{"12.34", 1234, true},
+		{"12.5", 1250, true},
+		{"12", 1200, true},
+		{".05", 5, true},
+		{"-3.05", -305, true},
+		{"0.1", 10, true},
+		{"1.005", 0, false},
+		{"abc", 0, false},
+		{"1.-5", 0, false},
+		{"", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.input)
+		if (err == nil) != tt.valid {
+			t.Errorf("%q: expected valid=%v, got error %v", tt.input, tt.valid, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: expected %d, got %d", tt.input, tt.want, got)
+		}
+	}
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+	// 0.1 + 0.2 is exact in cents, unlike in float64
+	if sum := MoneyFromFloat(0.1) + MoneyFromFloat(0.2); sum.String() != "0.30" {
+		t.Errorf("Expected 0.30, got %s", sum)
+	}
+	if tax := Money(1999).Mul(0.2); tax != 400 {
+		t.Errorf("Expected 4.00, got %s", tax)
+	}
+	if s := Money(-5).String(); s != "-0.05" {
+		t.Errorf("Expected -0.05, got %s", s)
+	}
+}
+
+func TestMoneyScanAndJSON(t *testing.T) {
+	var m Money
+	if err := m.Scan([]byte("149.90")); err != nil || m != 14990 {
+		t.Errorf("Expected 14990, got %d (%v)", m, err)
+	}
+
+	data, _ := json.Marshal(struct{ Price Money }{m})
+	if string(data) != `{"Price":149.90}` {
+		t.Errorf("Unexpected JSON %s", data)
+	}
+
+	var decoded struct{ Price Money }
+	if err := json.Unmarshal([]byte(`{"Price":"7.5"}`), &decoded); err != nil || decoded.Price != 750 {
+		t.Errorf("Expected 750, got %d (%v)", decoded.Price, err)
+	}
+}
+
+func TestMoneyUnmarshalGraphQL(t *testing.T) {
+	var m Money
+	if err := m.UnmarshalGraphQL(19.99); err != nil || m != 1999 {
+		t.Errorf("Expected 1999, got %d (%v)", m, err)
+	}
+	if err := m.UnmarshalGraphQL(int32(5)); err != nil || m != 500 {
+		t.Errorf("Expected 500, got %d (%v)", m, err)
+	}
+	if err := m.UnmarshalGraphQL(0.001); err == nil {
+		t.Errorf("Expected sub-cent amount to be rejected")
+	}
+}
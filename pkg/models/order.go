@@ -0,0 +1,80 @@
+package models
+
+import "fmt"
+
+// Order statuses tracking a purchase from payment to completion, as stored in
+// the database. Unlike the review status they never need to be inferred from
+// the deliveries of a purchase
+const (
+	OrderStatusPending   = "pending"
+	OrderStatusPaid      = "paid"
+	OrderStatusShipped   = "shipped"
+	OrderStatusCompleted = "completed"
+	OrderStatusCanceled  = "canceled"
+	OrderStatusRefunded  = "refunded"
+)
+
+// orderTransitions lists the statuses reachable from each order status;
+// completed, canceled and refunded orders are final
+var orderTransitions = map[string][]string{
+	OrderStatusPending:   {OrderStatusPaid, OrderStatusCanceled},
+	OrderStatusPaid:      {OrderStatusShipped, OrderStatusCompleted, OrderStatusRefunded},
+	OrderStatusShipped:   {OrderStatusCompleted, OrderStatusRefunded},
+	OrderStatusCompleted: nil,
+	OrderStatusCanceled:  nil,
+	OrderStatusRefunded:  nil,
+}
+
+// ValidateOrderTransition returns an error describing why an order may not
+// move from one status to another, or nil if the transition is allowed
+func ValidateOrderTransition(from, to string) error {
+	next, ok := orderTransitions[from]
+	if !ok {
+		return fmt.Errorf("unknown order status: %s", from)
+	}
+	if _, ok := orderTransitions[to]; !ok {
+		return fmt.Errorf("unknown order status: %s", to)
+	}
+
+	for _, status := range next {
+		if status == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("order cannot change status from %s to %s", from, to)
+}
+
+// OrderStatusAfterReview returns the order status of a purchase leaving fraud
+// review: approved purchases are paid, rejected ones canceled
+func OrderStatusAfterReview(reviewStatus string) (string, bool) {
+	switch reviewStatus {
+	case PurchaseStatusApproved:
+		return OrderStatusPaid, true
+	case PurchaseStatusRejected:
+		return OrderStatusCanceled, true
+	default:
+		return "", false
+	}
+}
+
+// OrderStatusAfterDelivery returns the order status a delivery update moves its
+// purchase to, if any
+func OrderStatusAfterDelivery(deliveryStatus string) (string, bool) {
+	switch deliveryStatus {
+	case DeliveryStatusOutForDelivery:
+		return OrderStatusShipped, true
+	case DeliveryStatusDelivered:
+		return OrderStatusCompleted, true
+	default:
+		return "", false
+	}
+}
+
+// OrderStatusAfterCancel returns the order status of a canceled purchase: paid
+// orders are refunded, unpaid ones simply canceled
+func OrderStatusAfterCancel(current string) string {
+	if current == OrderStatusPaid || current == OrderStatusShipped {
+		return OrderStatusRefunded
+	}
+	return OrderStatusCanceled
+}
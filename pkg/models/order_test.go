@@ -0,0 +1,35 @@
+package models
+
+import "testing"
+
+func TestValidateOrderTransition(t *testing.T) {
+	tests := []struct {
+		from, to string
+		valid    bool
+	}{
+		{OrderStatusPending, OrderStatusPaid, true},
+		{OrderStatusPaid, OrderStatusShipped, true},
+		{OrderStatusShipped, OrderStatusCompleted, true},
+		{OrderStatusPaid, OrderStatusRefunded, true},
+		{OrderStatusPending, OrderStatusShipped, false},
+		{OrderStatusCompleted, OrderStatusRefunded, false},
+		{OrderStatusCanceled, OrderStatusPaid, false},
+		{OrderStatusPaid, "lost", false},
+	}
+
+	for _, tt := range tests {
+		err := ValidateOrderTransition(tt.from, tt.to)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s -> %s: expected valid=%v, got error %v", tt.from, tt.to, tt.valid, err)
+		}
+	}
+}
+
+func TestOrderStatusAfterCancel(t *testing.T) {
+	if status := OrderStatusAfterCancel(OrderStatusPending); status != OrderStatusCanceled {
+		t.Errorf("Expected a pending order to be canceled, got %s", status)
+	}
+	if status := OrderStatusAfterCancel(OrderStatusShipped); status != OrderStatusRefunded {
+		t.Errorf("Expected a shipped order to be refunded, got %s", status)
+	}
+}
@@ -0,0 +1,111 @@
+package moderation
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Action is the outcome of a moderation check
+type Action string
+
+// Moderation actions; flagged content is accepted but recorded for review
+const (
+	Allow  Action = "allow"
+	Flag   Action = "flag"
+	Reject Action = "reject"
+)
+
+// Decision is the outcome of a moderation check with a human readable reason
+type Decision struct {
+	Action Action
+	Reason string
+}
+
+// Checker screens user submitted text such as listing titles and descriptions
+type Checker interface {
+	Check(ctx context.Context, text string) (Decision, error)
+}
+
+// DefaultWords are common spam terms blocked when no wordlist is configured
+var DefaultWords = []string{"viagra", "casino", "crypto giveaway", "free money", "click here", "whatsapp me"}
+
+// Wordlist matches words and phrases case-insensitively on word boundaries
+// and applies its action to text containing any of them
+type Wordlist struct {
+	phrases [][]string
+	action  Action
+}
+
+// NewWordlist creates a checker applying action to text containing any of the words or phrases
+func NewWordlist(words []string, action Action) *Wordlist {
+	w := &Wordlist{action: action}
+	for _, word := range words {
+		if tokens := tokenize(word); len(tokens) > 0 {
+			w.phrases = append(w.phrases, tokens)
+		}
+	}
+	return w
+}
+
+// LoadWordlist reads one word or phrase per line from a file, skipping blank lines and # comments
+func LoadWordlist(path string, action Action) (*Wordlist, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open wordlist: %w", err)
+	}
+	defer file.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read wordlist: %w", err)
+	}
+
+	return NewWordlist(words, action), nil
+}
+
+// Check returns the wordlist action for the first listed phrase found in text
+func (w *Wordlist) Check(ctx context.Context, text string) (Decision, error) {
+	tokens := tokenize(text)
+	for _, phrase := range w.phrases {
+		if containsPhrase(tokens, phrase) {
+			return Decision{Action: w.action, Reason: fmt.Sprintf("contains blocked term %q", strings.Join(phrase, " "))}, nil
+		}
+	}
+	return Decision{Action: Allow}, nil
+}
+
+// tokenize splits text into lowercase words, ignoring punctuation
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// containsPhrase reports whether the phrase tokens appear consecutively in tokens
+func containsPhrase(tokens, phrase []string) bool {
+	for i := 0; i+len(phrase) <= len(tokens); i++ {
+		match := true
+		for j, word := range phrase {
+			if tokens[i+j] != word {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
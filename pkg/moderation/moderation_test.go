@@ -0,0 +1,49 @@
+package moderation
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWordlist(t *testing.T) {
+	checker := NewWordlist([]string{"casino", "Free Money"}, Reject)
+
+	tests := []struct {
+		text   string
+		action Action
+	}{
+		{"Vintage casino chips!", Reject},
+		{"Get FREE... money now", Reject},
+		{"Occasional table", Allow},
+		{"Free shipping, no money back", Allow},
+	}
+
+	for _, tt := range tests {
+		decision, err := checker.Check(context.Background(), tt.text)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if decision.Action != tt.action {
+			t.Errorf("%q: expected %s, got %s (%s)", tt.text, tt.action, decision.Action, decision.Reason)
+		}
+	}
+}
+
+func TestLoadWordlist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	if err := os.WriteFile(path, []byte("# spam terms\n\nreplica watch\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write wordlist: %v", err)
+	}
+
+	checker, err := LoadWordlist(path, Flag)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	decision, _ := checker.Check(context.Background(), "Genuine replica watch")
+	if decision.Action != Flag || decision.Reason != `contains blocked term "replica watch"` {
+		t.Errorf("Unexpected decision: %+v", decision)
+	}
+}
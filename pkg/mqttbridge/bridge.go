@@ -0,0 +1,212 @@
+package mqttbridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	graphqlgo "github.com/graph-gophers/graphql-go"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/events"
+	"github.com/korjavin/graphqlTinyExample/pkg/graphql"
+)
+
+// clientName identifies bridge operations in the per-client metrics
+const clientName = "mqtt-bridge"
+
+const createDeliveryMutation = `mutation CreateDelivery($input: CreateDeliveryInput!) {
+  createDelivery(input: $input) {
+    delivery { id }
+    userErrors { field message }
+  }
+}`
+
+const deliveryUpdatedSubscription = `subscription DeliveryUpdated {
+  deliveryUpdated {
+    id
+    status
+    timestamp
+    scheduledFor
+    attemptNumber
+    purchase { id }
+  }
+}`
+
+// Executor runs GraphQL operations; *graphql.Schema from graph-gophers implements it
+type Executor interface {
+	Exec(ctx context.Context, queryString string, operationName string, variables map[string]interface{}) *graphqlgo.Response
+	Subscribe(ctx context.Context, queryString string, operationName string, variables map[string]interface{}) (<-chan interface{}, error)
+}
+
+// Envelope is the message format shared with the WebSocket transport:
+// {"type": "data", "payload": {"data": ...}} or {"type": "error", "payload": {"message": ...}}
+type Envelope struct {
+	Type    string      `json:"type"`
+	Payload interface{} `json:"payload,omitempty"`
+}
+
+// Config configures the broker connection and topics
+type Config struct {
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+	// CommandTopic receives createDelivery inputs, e.g. {"purchaseId": "3", "status": "DELIVERED"}
+	CommandTopic string
+	// EventTopic receives every deliveryUpdated event
+	EventTopic string
+	QoS        byte
+	// CloudEvents publishes events in the CloudEvents 1.0 JSON format instead
+	// of the WebSocket envelope, with Source as the event source
+	CloudEvents bool
+	Source      string
+}
+
+// Bridge accepts createDelivery payloads from MQTT and republishes deliveryUpdated events to MQTT
+type Bridge struct {
+	cfg    Config
+	schema Executor
+}
+
+// NewBridge creates a bridge executing operations against the given schema
+func NewBridge(schema Executor, cfg Config) *Bridge {
+	return &Bridge{cfg: cfg, schema: schema}
+}
+
+// Run connects to the broker and bridges messages until ctx is done. The
+// connection is re-established automatically after broker outages
+func (b *Bridge) Run(ctx context.Context) error {
+	ctx = graphql.WithClientInfo(ctx, graphql.ClientInfo{Name: clientName})
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(b.cfg.Broker).
+		SetClientID(b.cfg.ClientID).
+		SetUsername(b.cfg.Username).
+		SetPassword(b.cfg.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(5 * time.Second)
+
+	// Subscribe on every (re)connect, as the broker may not keep the session
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		log.Printf("[MQTT] Connected to %s, subscribing to %s", b.cfg.Broker, b.cfg.CommandTopic)
+		token := client.Subscribe(b.cfg.CommandTopic, b.cfg.QoS, func(_ mqtt.Client, msg mqtt.Message) {
+			if err := b.handleCommand(ctx, msg.Payload()); err != nil {
+				log.Printf("[MQTT] Failed to create delivery from %s: %v", msg.Topic(), err)
+			}
+		})
+		if token.Wait() && token.Error() != nil {
+			log.Printf("[MQTT] Error subscribing to %s: %v", b.cfg.CommandTopic, token.Error())
+		}
+	})
+	opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		log.Printf("[MQTT] Connection lost: %v", err)
+	})
+
+	client := mqtt.NewClient(opts)
+	client.Connect()
+	defer client.Disconnect(250)
+
+	return b.forwardEvents(ctx, func(payload []byte) error {
+		token := client.Publish(b.cfg.EventTopic, b.cfg.QoS, false, payload)
+		token.Wait()
+		return token.Error()
+	})
+}
+
+// handleCommand creates a delivery from a createDelivery input payload
+func (b *Bridge) handleCommand(ctx context.Context, payload []byte) error {
+	var input map[string]interface{}
+	if err := json.Unmarshal(payload, &input); err != nil {
+		return fmt.Errorf("invalid createDelivery payload: %w", err)
+	}
+
+	resp := b.schema.Exec(ctx, createDeliveryMutation, "CreateDelivery", map[string]interface{}{"input": input})
+	if len(resp.Errors) > 0 {
+		return resp.Errors[0]
+	}
+
+	// Refused input is reported in the payload rather than as a GraphQL error
+	var data struct {
+		CreateDelivery struct {
+			Delivery   json.RawMessage `json:"delivery"`
+			UserErrors []struct {
+				Field   *string `json:"field"`
+				Message string  `json:"message"`
+			} `json:"userErrors"`
+		} `json:"createDelivery"`
+	}
+	if err := json.Unmarshal(resp.Data, &data); err != nil {
+		return fmt.Errorf("invalid createDelivery response: %w", err)
+	}
+	if userErrors := data.CreateDelivery.UserErrors; len(userErrors) > 0 {
+		return fmt.Errorf("createDelivery refused the input: %s", userErrors[0].Message)
+	}
+
+	log.Printf("[MQTT] Created delivery: %s", data.CreateDelivery.Delivery)
+	return nil
+}
+
+// forwardEvents publishes every deliveryUpdated event until ctx is done
+func (b *Bridge) forwardEvents(ctx context.Context, publish func([]byte) error) error {
+	responses, err := b.schema.Subscribe(ctx, deliveryUpdatedSubscription, "DeliveryUpdated", nil)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to delivery updates: %w", err)
+	}
+
+	for response := range responses {
+		resp, ok := response.(*graphqlgo.Response)
+		if !ok {
+			continue
+		}
+
+		payload, err := b.encodeEvent(resp)
+		if err != nil {
+			log.Printf("[MQTT] Error encoding delivery event: %v", err)
+			continue
+		}
+		if err := publish(payload); err != nil {
+			log.Printf("[MQTT] Error publishing delivery event to %s: %v", b.cfg.EventTopic, err)
+		}
+	}
+
+	return ctx.Err()
+}
+
+// encodeEvent encodes a deliveryUpdated response in the configured event format.
+// Errors are only forwarded in the WebSocket envelope, as they are not CloudEvents
+func (b *Bridge) encodeEvent(resp *graphqlgo.Response) ([]byte, error) {
+	if len(resp.Errors) > 0 {
+		if b.cfg.CloudEvents {
+			return nil, resp.Errors[0]
+		}
+		return json.Marshal(Envelope{Type: "error", Payload: map[string]interface{}{"message": resp.Errors[0].Error()}})
+	}
+
+	if !b.cfg.CloudEvents {
+		return json.Marshal(Envelope{Type: "data", Payload: map[string]interface{}{"data": resp.Data}})
+	}
+
+	var data struct {
+		DeliveryUpdated json.RawMessage `json:"deliveryUpdated"`
+	}
+	if err := json.Unmarshal(resp.Data, &data); err != nil {
+		return nil, err
+	}
+	var delivery struct {
+		ID        string    `json:"id"`
+		Timestamp time.Time `json:"timestamp"`
+		Purchase  struct {
+			ID string `json:"id"`
+		} `json:"purchase"`
+	}
+	if err := json.Unmarshal(data.DeliveryUpdated, &delivery); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(events.NewCloudEvent(events.DeliveryUpdatedType, b.cfg.Source,
+		"delivery-"+delivery.ID, "purchases/"+delivery.Purchase.ID, delivery.Timestamp, data.DeliveryUpdated))
+}
@@ -0,0 +1,119 @@
+package mqttbridge
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	graphqlgo "github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/errors"
+)
+
+type fakeExecutor struct {
+	query     string
+	variables map[string]interface{}
+	response  *graphqlgo.Response
+	events    chan interface{}
+}
+
+func (e *fakeExecutor) Exec(ctx context.Context, queryString string, operationName string, variables map[string]interface{}) *graphqlgo.Response {
+	e.query = queryString
+	e.variables = variables
+	return e.response
+}
+
+func (e *fakeExecutor) Subscribe(ctx context.Context, queryString string, operationName string, variables map[string]interface{}) (<-chan interface{}, error) {
+	e.query = queryString
+	return e.events, nil
+}
+
+func TestHandleCommand(t *testing.T) {
+	executor := &fakeExecutor{response: &graphqlgo.Response{Data: json.RawMessage(`{"createDelivery":{"delivery":{"id":"7"},"userErrors":[]}}`)}}
+	bridge := NewBridge(executor, Config{})
+
+	err := bridge.handleCommand(context.Background(), []byte(`{"purchaseId": "3", "status": "DELIVERED"}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !strings.Contains(executor.query, "createDelivery(input: $input)") {
+		t.Errorf("Expected a createDelivery mutation, got %s", executor.query)
+	}
+	input, _ := executor.variables["input"].(map[string]interface{})
+	if input["purchaseId"] != "3" || input["status"] != "DELIVERED" {
+		t.Errorf("Expected the payload as mutation input, got %v", executor.variables)
+	}
+}
+
+func TestHandleCommandErrors(t *testing.T) {
+	executor := &fakeExecutor{response: &graphqlgo.Response{Errors: []*errors.QueryError{errors.Errorf("purchase not found")}}}
+	bridge := NewBridge(executor, Config{})
+
+	if err := bridge.handleCommand(context.Background(), []byte(`not json`)); err == nil {
+		t.Error("Expected an error for an invalid payload")
+	}
+	if err := bridge.handleCommand(context.Background(), []byte(`{"purchaseId": "99", "status": "PACKED"}`)); err == nil {
+		t.Error("Expected the mutation error to be returned")
+	}
+
+	executor.response = &graphqlgo.Response{Data: json.RawMessage(
+		`{"createDelivery":{"delivery":null,"userErrors":[{"field":"purchaseId","message":"purchase not found: 99"}]}}`)}
+	if err := bridge.handleCommand(context.Background(), []byte(`{"purchaseId": "99", "status": "PACKED"}`)); err == nil || !strings.Contains(err.Error(), "purchase not found") {
+		t.Errorf("Expected the user error to be returned, got %v", err)
+	}
+}
+
+func TestForwardEvents(t *testing.T) {
+	executor := &fakeExecutor{events: make(chan interface{}, 2)}
+	executor.events <- &graphqlgo.Response{Data: json.RawMessage(`{"deliveryUpdated":{"id":"7","status":"DELIVERED"}}`)}
+	executor.events <- &graphqlgo.Response{Errors: []*errors.QueryError{errors.Errorf("boom")}}
+	close(executor.events)
+	bridge := NewBridge(executor, Config{EventTopic: "deliveries/updated"})
+
+	var published []string
+	err := bridge.forwardEvents(context.Background(), func(payload []byte) error {
+		published = append(published, string(payload))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []string{
+		`{"type":"data","payload":{"data":{"deliveryUpdated":{"id":"7","status":"DELIVERED"}}}}`,
+		`{"type":"error","payload":{"message":"graphql: boom"}}`,
+	}
+	if len(published) != len(expected) {
+		t.Fatalf("Expected %d messages, got %d: %v", len(expected), len(published), published)
+	}
+	for i := range expected {
+		if published[i] != expected[i] {
+			t.Errorf("Message %d: expected %s, got %s", i, expected[i], published[i])
+		}
+	}
+}
+
+func TestForwardEventsAsCloudEvents(t *testing.T) {
+	executor := &fakeExecutor{events: make(chan interface{}, 1)}
+	executor.events <- &graphqlgo.Response{Data: json.RawMessage(
+		`{"deliveryUpdated":{"id":"7","status":"DELIVERED","timestamp":"2024-05-01T10:00:00Z","purchase":{"id":"3"}}}`)}
+	close(executor.events)
+	bridge := NewBridge(executor, Config{CloudEvents: true, Source: "/scanners"})
+
+	var published []string
+	err := bridge.forwardEvents(context.Background(), func(payload []byte) error {
+		published = append(published, string(payload))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := `{"specversion":"1.0","type":"io.github.korjavin.graphqltinyexample.delivery.updated","source":"/scanners",` +
+		`"id":"delivery-7","time":"2024-05-01T10:00:00Z","subject":"purchases/3","datacontenttype":"application/json",` +
+		`"data":{"id":"7","status":"DELIVERED","timestamp":"2024-05-01T10:00:00Z","purchase":{"id":"3"}}}`
+	if len(published) != 1 || published[0] != expected {
+		t.Errorf("Expected %s, got %v", expected, published)
+	}
+}
@@ -0,0 +1,55 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiterPerKey(t *testing.T) {
+	now := time.Now()
+	limiter := NewLimiter(1, 2)
+	limiter.now = func() time.Time { return now }
+
+	// Burst is available immediately
+	if !limiter.Allow("web") || !limiter.Allow("web") {
+		t.Fatalf("Expected burst of 2 requests to be allowed")
+	}
+	if limiter.Allow("web") {
+		t.Errorf("Expected third request to be rejected")
+	}
+
+	// Other keys have their own bucket
+	if !limiter.Allow("mobile") {
+		t.Errorf("Expected request for another key to be allowed")
+	}
+
+	// Tokens refill over time
+	now = now.Add(time.Second)
+	if !limiter.Allow("web") {
+		t.Errorf("Expected request to be allowed after refill")
+	}
+	if limiter.Allow("web") {
+		t.Errorf("Expected only one token to be refilled")
+	}
+}
+
+func TestLimiterSetRate(t *testing.T) {
+	now := time.Now()
+	limiter := NewLimiter(0, 0)
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 5; i++ {
+		if !limiter.Allow("web") {
+			t.Fatalf("Expected a zero rate to allow every request")
+		}
+	}
+
+	limiter.SetRate(1, 1)
+	if !limiter.Allow("web") || limiter.Allow("web") {
+		t.Errorf("Expected the new burst of 1 to apply")
+	}
+	now = now.Add(time.Second)
+	if !limiter.Allow("web") {
+		t.Errorf("Expected request to be allowed after refill at the new rate")
+	}
+}
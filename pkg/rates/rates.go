@@ -0,0 +1,156 @@
+package rates
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BaseCurrency is the currency all prices are stored in
+const BaseCurrency = "USD"
+
+// ErrRatesUnavailable is returned when no rates have been loaded yet
+var ErrRatesUnavailable = errors.New("exchange rates are not available")
+
+// ErrUnsupportedCurrency is returned when converting to a currency without a rate
+var ErrUnsupportedCurrency = errors.New("unsupported currency")
+
+// Provider fetches the current exchange rates from BaseCurrency to other currencies
+type Provider interface {
+	FetchRates(ctx context.Context) (map[string]float64, error)
+}
+
+// HTTPProvider fetches rates from a JSON API answering with
+// {"base": "USD", "rates": {"EUR": 0.92, ...}}
+type HTTPProvider struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewHTTPProvider creates a provider fetching rates from url
+func NewHTTPProvider(url string) *HTTPProvider {
+	return &HTTPProvider{
+		URL:    url,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// FetchRates downloads the latest rates
+func (p *HTTPProvider) FetchRates(ctx context.Context) (map[string]float64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rates request: %w", err)
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch rates: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rates response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("rates provider responded with %s: %s", resp.Status, string(body))
+	}
+
+	var result struct {
+		Base  string             `json:"base"`
+		Rates map[string]float64 `json:"rates"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal rates response: %w", err)
+	}
+	if result.Base != "" && !strings.EqualFold(result.Base, BaseCurrency) {
+		return nil, fmt.Errorf("rates provider returned base %s, expected %s", result.Base, BaseCurrency)
+	}
+
+	return result.Rates, nil
+}
+
+// Cache keeps the latest rates in memory so conversions never call the provider directly
+type Cache struct {
+	mu        sync.RWMutex
+	provider  Provider
+	rates     map[string]float64
+	updatedAt time.Time
+}
+
+// NewCache creates an empty rates cache backed by the given provider
+func NewCache(provider Provider) *Cache {
+	return &Cache{provider: provider}
+}
+
+// Refresh fetches fresh rates from the provider; on failure the previous rates are kept
+func (c *Cache) Refresh(ctx context.Context) error {
+	fetched, err := c.provider.FetchRates(ctx)
+	if err != nil {
+		return err
+	}
+
+	rates := make(map[string]float64, len(fetched)+1)
+	for currency, rate := range fetched {
+		rates[strings.ToUpper(currency)] = rate
+	}
+	rates[BaseCurrency] = 1
+
+	c.mu.Lock()
+	c.rates = rates
+	c.updatedAt = time.Now()
+	c.mu.Unlock()
+
+	log.Printf("[Rates] Refreshed %d exchange rates", len(rates))
+	return nil
+}
+
+// Run refreshes the rates immediately and then every interval until stop is closed
+func (c *Cache) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.Refresh(context.Background()); err != nil {
+			log.Printf("[Rates] Error refreshing exchange rates: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Convert converts an amount in BaseCurrency to the given currency
+func (c *Cache) Convert(amount float64, currency string) (float64, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.rates == nil {
+		return 0, ErrRatesUnavailable
+	}
+
+	rate, ok := c.rates[strings.ToUpper(currency)]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrUnsupportedCurrency, currency)
+	}
+
+	return amount * rate, nil
+}
+
+// UpdatedAt returns when the rates were last refreshed successfully
+func (c *Cache) UpdatedAt() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.updatedAt
+}
@@ -0,0 +1,84 @@
+package rates
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type fakeProvider struct {
+	rates map[string]float64
+	err   error
+	calls int
+}
+
+func (p *fakeProvider) FetchRates(ctx context.Context) (map[string]float64, error) {
+	p.calls++
+	return p.rates, p.err
+}
+
+func TestCacheConvert(t *testing.T) {
+	provider := &fakeProvider{rates: map[string]float64{"eur": 0.5}}
+	cache := NewCache(provider)
+
+	if _, err := cache.Convert(10, "EUR"); err != ErrRatesUnavailable {
+		t.Errorf("Expected ErrRatesUnavailable before refresh, got %v", err)
+	}
+
+	if err := cache.Refresh(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Conversions are served from the cache without calling the provider
+	for i := 0; i < 3; i++ {
+		amount, err := cache.Convert(10, "EUR")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if amount != 5 {
+			t.Errorf("Expected %.2f, got %.2f", 5.0, amount)
+		}
+	}
+	if provider.calls != 1 {
+		t.Errorf("Expected 1 provider call, got %d", provider.calls)
+	}
+
+	if amount, _ := cache.Convert(10, BaseCurrency); amount != 10 {
+		t.Errorf("Expected base currency amount %.2f, got %.2f", 10.0, amount)
+	}
+	if _, err := cache.Convert(10, "XYZ"); err == nil {
+		t.Errorf("Expected error for unsupported currency")
+	}
+}
+
+func TestCacheKeepsRatesOnFailedRefresh(t *testing.T) {
+	provider := &fakeProvider{rates: map[string]float64{"EUR": 0.5}}
+	cache := NewCache(provider)
+	cache.Refresh(context.Background())
+
+	provider.err = errors.New("provider down")
+	if err := cache.Refresh(context.Background()); err == nil {
+		t.Errorf("Expected refresh error")
+	}
+
+	if amount, err := cache.Convert(10, "EUR"); err != nil || amount != 5 {
+		t.Errorf("Expected previous rates to be kept, got %.2f, %v", amount, err)
+	}
+}
+
+func TestHTTPProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"base": "USD", "rates": {"EUR": 0.92, "GBP": 0.79}}`))
+	}))
+	defer server.Close()
+
+	rates, err := NewHTTPProvider(server.URL).FetchRates(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if rates["EUR"] != 0.92 {
+		t.Errorf("Expected EUR rate %.2f, got %.2f", 0.92, rates["EUR"])
+	}
+}
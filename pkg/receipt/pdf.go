@@ -0,0 +1,99 @@
+package receipt
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// PDF renders the receipt as a single-page A4 PDF document using the
+// built-in Helvetica font, so no font files or PDF libraries are needed
+func (r *Receipt) PDF() []byte {
+	var content bytes.Buffer
+	content.WriteString("BT\n/F1 11 Tf\n14 TL\n50 790 Td\n")
+	for _, line := range r.lines() {
+		fmt.Fprintf(&content, "(%s) Tj T*\n", escapePDFText(line))
+	}
+	content.WriteString("ET\n")
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] " +
+			"/Resources << /Font << /F1 4 0 R >> >> /Contents 5 0 R >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+
+	xrefOffset := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xrefOffset)
+
+	return buf.Bytes()
+}
+
+// lines lays out the receipt as plain text lines
+func (r *Receipt) lines() []string {
+	lines := []string{
+		"RECEIPT " + r.Number,
+		"",
+		"Issued: " + r.IssuedAt.Format("2006-01-02 15:04 MST"),
+		"Seller: " + r.SellerName,
+		"        " + r.SellerAddress,
+		"Deliver to: " + r.DeliveryAddress,
+		"Bank transaction: " + r.BankTxID,
+		"",
+		fmt.Sprintf("%-50s %5s %12s %12s", "Item", "Qty", "Unit price", "Amount"),
+	}
+
+	for _, item := range r.LineItems {
+		lines = append(lines, fmt.Sprintf("%-50s %5d %12s %12s",
+			truncate(item.Description, 50), item.Quantity, item.UnitPrice, item.Amount))
+	}
+
+	lines = append(lines, "", fmt.Sprintf("%-69s %12s", "Subtotal", r.Subtotal))
+	for _, tax := range r.Taxes {
+		lines = append(lines, fmt.Sprintf("%-69s %12s",
+			fmt.Sprintf("%s (%.2f%%)", tax.Name, tax.Rate*100), tax.Amount))
+	}
+	lines = append(lines, fmt.Sprintf("%-69s %12s", "Total", r.Total))
+
+	return lines
+}
+
+// escapePDFText escapes a string for use in a PDF literal string, replacing
+// characters outside printable ASCII since the font uses a single-byte encoding
+func escapePDFText(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch {
+		case c == '\\' || c == '(' || c == ')':
+			b.WriteByte('\\')
+			b.WriteRune(c)
+		case c < 0x20 || c > 0x7e:
+			b.WriteByte('?')
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max-3] + "..."
+}
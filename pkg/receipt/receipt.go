@@ -0,0 +1,105 @@
+package receipt
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+)
+
+// Store provides the purchase data a receipt is generated from
+type Store interface {
+	GetPurchase(id int) (*models.Purchase, error)
+	GetListing(id int) (*models.Listing, error)
+	GetSeller(id int) (*models.Seller, error)
+}
+
+// LineItem is a single billed item on a receipt
+type LineItem struct {
+	Description string
+	Quantity    int
+	UnitPrice   models.Money
+	Amount      models.Money
+}
+
+// Tax is a single tax applied to the receipt subtotal
+type Tax struct {
+	Name   string
+	Rate   float64
+	Amount models.Money
+}
+
+// Receipt is a structured invoice for a purchase
+type Receipt struct {
+	Number          string
+	PurchaseID      int
+	IssuedAt        time.Time
+	SellerName      string
+	SellerAddress   string
+	DeliveryAddress string
+	BankTxID        string
+	LineItems       []LineItem
+	Taxes           []Tax
+	Subtotal        models.Money
+	TaxTotal        models.Money
+	Total           models.Money
+}
+
+// Load fetches a purchase with its listing and seller and builds its receipt
+func Load(store Store, purchaseID int) (*Receipt, error) {
+	log.Printf("[Receipt] Generating receipt for purchase ID: %d", purchaseID)
+
+	purchase, err := store.GetPurchase(purchaseID)
+	if err != nil {
+		return nil, fmt.Errorf("purchase not found: %w", err)
+	}
+
+	listing, err := store.GetListing(purchase.ListingID)
+	if err != nil {
+		return nil, fmt.Errorf("listing not found: %w", err)
+	}
+
+	seller, err := store.GetSeller(listing.SellerID)
+	if err != nil {
+		return nil, fmt.Errorf("seller not found: %w", err)
+	}
+
+	return Build(purchase, listing, seller), nil
+}
+
+// Build computes the receipt of a purchase. The purchase price is the net
+// amount; the tax calculated at purchase time is added on top
+func Build(purchase *models.Purchase, listing *models.Listing, seller *models.Seller) *Receipt {
+	subtotal := purchase.Price
+
+	r := &Receipt{
+		Number:          fmt.Sprintf("R-%06d", purchase.ID),
+		PurchaseID:      purchase.ID,
+		IssuedAt:        purchase.CreatedAt,
+		SellerName:      seller.Name,
+		SellerAddress:   seller.Address,
+		DeliveryAddress: purchase.DeliveryAddress,
+		BankTxID:        purchase.BankTxID,
+		LineItems: []LineItem{{
+			Description: listing.Title,
+			Quantity:    1,
+			UnitPrice:   subtotal,
+			Amount:      subtotal,
+		}},
+		Subtotal: subtotal,
+	}
+
+	if purchase.TaxAmount > 0 && subtotal > 0 {
+		tax := Tax{
+			Name:   "Sales tax",
+			Rate:   purchase.TaxAmount.Float64() / subtotal.Float64(),
+			Amount: purchase.TaxAmount,
+		}
+		r.Taxes = append(r.Taxes, tax)
+		r.TaxTotal += tax.Amount
+	}
+
+	r.Total = r.Subtotal + r.TaxTotal
+	return r
+}
@@ -0,0 +1,71 @@
+package receipt
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+)
+
+func testData() (*models.Purchase, *models.Listing, *models.Seller) {
+	purchase := &models.Purchase{
+		ID:              7,
+		ListingID:       3,
+		Price:           4999,
+		TaxAmount:       412,
+		BankTxID:        "TX223456789",
+		DeliveryAddress: "77 Oak Street, Austin, TX 78701",
+		CreatedAt:       time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC),
+	}
+	listing := &models.Listing{ID: 3, SellerID: 2, Title: "Cozy Blanket (queen)", Price: 4999}
+	seller := &models.Seller{ID: 2, Name: "Home Goods", Address: "456 Broadway"}
+	return purchase, listing, seller
+}
+
+func TestBuild(t *testing.T) {
+	purchase, listing, seller := testData()
+	r := Build(purchase, listing, seller)
+
+	if r.Number != "R-000007" {
+		t.Errorf("Expected number %s, got %s", "R-000007", r.Number)
+	}
+	if len(r.LineItems) != 1 || r.LineItems[0].Amount != 4999 {
+		t.Errorf("Expected a single line item of 49.99, got %+v", r.LineItems)
+	}
+	if len(r.Taxes) != 1 || r.Taxes[0].Amount != 412 {
+		t.Errorf("Expected tax of 4.12, got %+v", r.Taxes)
+	}
+	if r.Total != 5411 {
+		t.Errorf("Expected total %s, got %s", "54.11", r.Total)
+	}
+}
+
+func TestBuildWithoutTax(t *testing.T) {
+	purchase, listing, seller := testData()
+	purchase.TaxAmount = 0
+	r := Build(purchase, listing, seller)
+
+	if len(r.Taxes) != 0 {
+		t.Errorf("Expected no taxes, got %d", len(r.Taxes))
+	}
+	if r.Total != r.Subtotal {
+		t.Errorf("Expected total %s, got %s", r.Subtotal, r.Total)
+	}
+}
+
+func TestPDF(t *testing.T) {
+	purchase, listing, seller := testData()
+	pdf := Build(purchase, listing, seller).PDF()
+
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4\n")) {
+		t.Errorf("Expected PDF header, got %q", pdf[:16])
+	}
+	if !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+		t.Errorf("Expected PDF trailer")
+	}
+	// Parentheses in text must be escaped inside PDF literal strings
+	if !bytes.Contains(pdf, []byte(`Cozy Blanket \(queen\)`)) {
+		t.Errorf("Expected escaped listing title in PDF content")
+	}
+}
@@ -0,0 +1,89 @@
+package recommend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Recommender suggests listings related to a listing, e.g. for "you may also like" sections
+type Recommender interface {
+	// Recommend returns the IDs of up to limit listings related to the given
+	// listing, most relevant first and excluding the listing itself
+	Recommend(ctx context.Context, listingID, limit int) ([]int, error)
+}
+
+// Store finds related listings in the database
+type Store interface {
+	GetSimilarListingIDs(listingID, limit int) ([]int, error)
+}
+
+// Similar recommends listings of the same seller or in a similar price range,
+// most viewed first. It is the default when no recommendation engine is configured
+type Similar struct {
+	Store Store
+}
+
+// Recommend returns the most popular similar listings
+func (s Similar) Recommend(ctx context.Context, listingID, limit int) ([]int, error) {
+	return s.Store.GetSimilarListingIDs(listingID, limit)
+}
+
+// HTTPRecommender asks an external recommendation engine. It sends
+// GET <Endpoint>?listingId=<id>&limit=<n> and expects {"listingIds": [...]}
+type HTTPRecommender struct {
+	Endpoint string
+	Client   *http.Client
+}
+
+// NewHTTPRecommender creates a recommender calling the engine at endpoint
+func NewHTTPRecommender(endpoint string) *HTTPRecommender {
+	return &HTTPRecommender{
+		Endpoint: endpoint,
+		Client:   &http.Client{Timeout: 2 * time.Second},
+	}
+}
+
+// Recommend fetches the recommended listing IDs from the engine
+func (h *HTTPRecommender) Recommend(ctx context.Context, listingID, limit int) ([]int, error) {
+	query := url.Values{}
+	query.Set("listingId", strconv.Itoa(listingID))
+	query.Set("limit", strconv.Itoa(limit))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.Endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recommendation request: %w", err)
+	}
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call recommendation engine: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recommendation response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("recommendation engine responded with %s: %s", resp.Status, string(body))
+	}
+
+	var result struct {
+		ListingIDs []int `json:"listingIds"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal recommendation response: %w", err)
+	}
+
+	if len(result.ListingIDs) > limit {
+		result.ListingIDs = result.ListingIDs[:limit]
+	}
+	return result.ListingIDs, nil
+}
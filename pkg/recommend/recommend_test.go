@@ -0,0 +1,39 @@
+package recommend
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestHTTPRecommender(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("listingId") != "3" || r.URL.Query().Get("limit") != "2" {
+			t.Errorf("Unexpected query: %s", r.URL.RawQuery)
+		}
+		// Engines returning more than asked for are cut to the limit
+		w.Write([]byte(`{"listingIds": [7, 1, 9]}`))
+	}))
+	defer server.Close()
+
+	ids, err := NewHTTPRecommender(server.URL).Recommend(context.Background(), 3, 2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(ids, []int{7, 1}) {
+		t.Errorf("Expected [7 1], got %v", ids)
+	}
+}
+
+func TestHTTPRecommenderError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	if _, err := NewHTTPRecommender(server.URL).Recommend(context.Background(), 3, 2); err == nil {
+		t.Error("Expected an error")
+	}
+}
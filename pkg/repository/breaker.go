@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/metrics"
+	"github.com/lib/pq"
+)
+
+// ErrUnavailable is wrapped by errors of repository methods that failed because
+// the database couldn't be reached, or weren't tried because the circuit
+// breaker is open
+var ErrUnavailable = errors.New("database unavailable")
+
+// errCircuitOpen is returned without trying the database while the breaker is open
+var errCircuitOpen = fmt.Errorf("%w: circuit breaker open", ErrUnavailable)
+
+// breakerState is the state of a circuit breaker
+type breakerState int
+
+const (
+	// breakerClosed lets every call through
+	breakerClosed breakerState = iota
+	// breakerOpen fails every call until the cooldown has passed
+	breakerOpen
+	// breakerHalfOpen lets a single probe through to test the database
+	breakerHalfOpen
+)
+
+// Breaker is a circuit breaker protecting the database. After Threshold
+// consecutive failures to reach the database it opens, failing calls fast
+// instead of letting every request wait for timeouts. Once Cooldown has passed
+// a single probe call is let through: if it succeeds the breaker closes, and
+// if it fails the breaker stays open for another cooldown. Errors the database
+// itself returns, such as constraint violations, show it is up and don't count
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+}
+
+// NewBreaker returns a closed breaker opening after threshold consecutive
+// failures and probing the database every cooldown while open
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// Open reports whether the breaker fails calls fast, i.e. the database is
+// considered down
+func (b *Breaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state != breakerClosed
+}
+
+// allow returns errCircuitOpen if a call may not try the database. The first
+// call after the cooldown becomes the probe
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return errCircuitOpen
+		}
+		b.state = breakerHalfOpen
+		log.Printf("[DB] Circuit breaker half-open, probing the database")
+		return nil
+	case breakerHalfOpen:
+		return errCircuitOpen
+	}
+	return nil
+}
+
+// record updates the breaker with the outcome of a call it allowed
+func (b *Breaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !failed {
+		if b.state != breakerClosed {
+			log.Printf("[DB] Circuit breaker closed, the database is reachable again")
+			metrics.RepositoryCircuitOpen.Set(0)
+		}
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= b.threshold) {
+		if b.state == breakerClosed {
+			log.Printf("[DB] Circuit breaker opened after %d consecutive failures", b.failures)
+		}
+		b.state = breakerOpen
+		b.openedAt = b.now()
+		metrics.RepositoryCircuitOpen.Set(1)
+	}
+}
+
+// unreachable reports whether err shows the database couldn't be reached, as
+// opposed to the database rejecting the statement
+func unreachable(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// Connection exceptions, shutdowns and connection limits
+		return pqErr.Code.Class() == "08" || strings.HasPrefix(string(pqErr.Code), "57P") || pqErr.Code == "53300"
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
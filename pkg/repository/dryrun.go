@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// dbtx runs statements, on the connection pool or in a transaction
+type dbtx interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// txn is a transaction of a repository method
+type txn interface {
+	dbtx
+	Commit() error
+	Rollback() error
+}
+
+// DryRun returns a repository running every statement in a new transaction,
+// along with the function rolling it back, so changes can be validated by the
+// database without keeping them. Changes made through the returned repository
+// can be read back through it until the rollback. The repository must only be
+// used by one goroutine at a time
+func (r *Repository) DryRun() (_ *Repository, rollback func(), err error) {
+	defer r.observe("DryRun", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+
+	if r.pool == nil {
+		return nil, nil, errors.New("already in a dry run")
+	}
+	tx, err := r.pool.Begin()
+	if err != nil {
+		log.Printf("[DB] Error starting dry run transaction: %v", err)
+		return nil, nil, err
+	}
+
+	dryRun := &Repository{
+		db:      tx,
+		breaker: r.breaker,
+		// Methods with a transaction of their own run it in a savepoint
+		begin: func() (txn, error) {
+			if _, err := tx.Exec("SAVEPOINT dry_run"); err != nil {
+				return nil, err
+			}
+			return &savepoint{Tx: tx}, nil
+		},
+	}
+	rollback = func() {
+		if err := tx.Rollback(); err != nil {
+			log.Printf("[DB] Error rolling back dry run: %v", err)
+		}
+	}
+	return dryRun, rollback, nil
+}
+
+// savepoint stands in for the transaction of a repository method in a dry run
+type savepoint struct {
+	*sql.Tx
+	done bool
+}
+
+func (s *savepoint) Commit() error {
+	if s.done {
+		return sql.ErrTxDone
+	}
+	s.done = true
+	_, err := s.Exec("RELEASE SAVEPOINT dry_run")
+	return err
+}
+
+// Rollback undoes the changes since the savepoint. Like sql.Tx it may be
+// deferred unconditionally: once committed or rolled back it does nothing,
+// since a failing statement would abort the whole dry run
+func (s *savepoint) Rollback() error {
+	if s.done {
+		return sql.ErrTxDone
+	}
+	s.done = true
+	_, err := s.Exec("ROLLBACK TO SAVEPOINT dry_run")
+	return err
+}
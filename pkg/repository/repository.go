@@ -1,33 +1,95 @@
 package repository
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/korjavin/graphqlTinyExample/pkg/metrics"
 	"github.com/korjavin/graphqlTinyExample/pkg/models"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
+// ErrSellerHasListings is returned when deleting a seller that still has listings
+var ErrSellerHasListings = errors.New("seller has listings")
+
+// ErrPurchaseNotCancelable is returned when canceling a purchase that was rejected,
+// already canceled or has shipped
+var ErrPurchaseNotCancelable = errors.New("purchase cannot be canceled")
+
+// ErrUnsupportedPageOrder is returned for keyset pages in an order cursors
+// can't be built for, which is any order other than by ID or by price
+var ErrUnsupportedPageOrder = errors.New("unsupported page order")
+
 // Repository handles all database operations
 type Repository struct {
-	db *sql.DB
+	db dbtx
+	// pool is the connection pool, nil for dry runs
+	pool *sql.DB
+	// begin starts the transaction of a method
+	begin   func() (txn, error)
+	breaker *Breaker
 }
 
-// NewRepository creates a new repository with the given database connection
+// NewRepository creates a new repository with the given database connection,
+// failing fast for 10 seconds at a time after 5 consecutive failures to reach
+// the database
 func NewRepository(db *sql.DB) *Repository {
-	return &Repository{db: db}
+	return &Repository{
+		db:      db,
+		pool:    db,
+		begin:   func() (txn, error) { return db.Begin() },
+		breaker: NewBreaker(5, 10*time.Second),
+	}
+}
+
+// SetBreaker replaces the circuit breaker protecting the database
+func (r *Repository) SetBreaker(breaker *Breaker) {
+	r.breaker = breaker
+}
+
+// Breaker returns the circuit breaker protecting the database
+func (r *Repository) Breaker() *Breaker {
+	return r.breaker
+}
+
+// observe records the duration and outcome of a repository method. A missing
+// row is an expected outcome rather than a failure, so it isn't counted as an
+// error. Failures to reach the database are wrapped in ErrUnavailable and
+// reported to the circuit breaker, along with successes
+func (r *Repository) observe(method string, start time.Time, err *error) {
+	metrics.RepositoryDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if *err != nil && !errors.Is(*err, sql.ErrNoRows) {
+		metrics.RepositoryErrors.WithLabelValues(method).Inc()
+	}
+	if errors.Is(*err, errCircuitOpen) {
+		return
+	}
+
+	failed := *err != nil && unreachable(*err)
+	if failed {
+		*err = fmt.Errorf("%w: %w", ErrUnavailable, *err)
+	}
+	r.breaker.record(failed)
 }
 
 // GetSeller fetches a seller by ID
-func (r *Repository) GetSeller(id int) (*models.Seller, error) {
+func (r *Repository) GetSeller(id int) (_ *models.Seller, err error) {
+	defer r.observe("GetSeller", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
 	log.Printf("[DB] Fetching seller with ID: %d", id)
 
 	var seller models.Seller
-	err := r.db.QueryRow("SELECT id, name, address FROM sellers WHERE id = $1", id).
-		Scan(&seller.ID, &seller.Name, &seller.Address)
+	err = r.db.QueryRow("SELECT id, name, address, digest_opt_in FROM sellers WHERE id = $1", id).
+		Scan(&seller.ID, &seller.Name, &seller.Address, &seller.DigestOptIn)
 	if err != nil {
 		log.Printf("[DB] Error fetching seller: %v", err)
 		return nil, err
@@ -37,20 +99,24 @@ func (r *Repository) GetSeller(id int) (*models.Seller, error) {
 }
 
 // GetAllSellers fetches all sellers
-func (r *Repository) GetAllSellers() ([]*models.Seller, error) {
+func (r *Repository) GetAllSellers() (_ []*models.Seller, err error) {
+	defer r.observe("GetAllSellers", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
 	log.Printf("[DB] Fetching all sellers")
 
-	rows, err := r.db.Query("SELECT id, name, address FROM sellers")
+	rows, err := r.db.Query("SELECT id, name, address, digest_opt_in FROM sellers")
 	if err != nil {
 		log.Printf("[DB] Error fetching sellers: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
 
-	var sellers []*models.Seller
+	sellers := []*models.Seller{}
 	for rows.Next() {
 		var seller models.Seller
-		err := rows.Scan(&seller.ID, &seller.Name, &seller.Address)
+		err := rows.Scan(&seller.ID, &seller.Name, &seller.Address, &seller.DigestOptIn)
 		if err != nil {
 			log.Printf("[DB] Error scanning seller row: %v", err)
 			return nil, err
@@ -67,12 +133,158 @@ func (r *Repository) GetAllSellers() ([]*models.Seller, error) {
 	return sellers, nil
 }
 
+// GetSellersByIDs fetches the sellers with the given IDs in the order of ids,
+// skipping IDs that don't exist
+func (r *Repository) GetSellersByIDs(ids []int) (_ []*models.Seller, err error) {
+	defer r.observe("GetSellersByIDs", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Fetching %d sellers by ID", len(ids))
+
+	rows, err := r.db.Query("SELECT id, name, address, digest_opt_in FROM sellers WHERE id = ANY($1)", pq.Array(ids))
+	if err != nil {
+		log.Printf("[DB] Error fetching sellers: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	byID := make(map[int]*models.Seller, len(ids))
+	for rows.Next() {
+		var seller models.Seller
+		err := rows.Scan(&seller.ID, &seller.Name, &seller.Address, &seller.DigestOptIn)
+		if err != nil {
+			log.Printf("[DB] Error scanning seller row: %v", err)
+			return nil, err
+		}
+		byID[seller.ID] = &seller
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("[DB] Error iterating seller rows: %v", err)
+		return nil, err
+	}
+
+	sellers := make([]*models.Seller, 0, len(byID))
+	for _, id := range ids {
+		if seller, ok := byID[id]; ok {
+			sellers = append(sellers, seller)
+		}
+	}
+
+	log.Printf("[DB] Found %d sellers", len(sellers))
+	return sellers, nil
+}
+
+// CreateSeller creates a new seller
+func (r *Repository) CreateSeller(name, address string, digestOptIn bool) (_ *models.Seller, err error) {
+	defer r.observe("CreateSeller", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Creating new seller: %s", name)
+
+	seller := &models.Seller{Name: name, Address: address, DigestOptIn: digestOptIn}
+	err = r.db.QueryRow(
+		"INSERT INTO sellers (name, address, digest_opt_in) VALUES ($1, $2, $3) RETURNING id",
+		name, address, digestOptIn).Scan(&seller.ID)
+	if err != nil {
+		log.Printf("[DB] Error creating seller: %v", err)
+		return nil, err
+	}
+
+	log.Printf("[DB] Created new seller with ID: %d", seller.ID)
+	return seller, nil
+}
+
+// UpdateSeller updates the given fields of a seller, leaving nil fields unchanged
+func (r *Repository) UpdateSeller(id int, name, address *string, digestOptIn *bool) (_ *models.Seller, err error) {
+	defer r.observe("UpdateSeller", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Updating seller with ID: %d", id)
+
+	var seller models.Seller
+	err = r.db.QueryRow(
+		`UPDATE sellers SET name = COALESCE($2, name), address = COALESCE($3, address), 
+		digest_opt_in = COALESCE($4, digest_opt_in) WHERE id = $1 
+		RETURNING id, name, address, digest_opt_in`,
+		id, name, address, digestOptIn).Scan(&seller.ID, &seller.Name, &seller.Address, &seller.DigestOptIn)
+	if err != nil {
+		log.Printf("[DB] Error updating seller: %v", err)
+		return nil, err
+	}
+
+	return &seller, nil
+}
+
+// DeleteSeller deletes a seller together with its webhook and quota. Sellers with listings
+// are kept, returning ErrSellerHasListings, since purchases reference the listings
+func (r *Repository) DeleteSeller(id int) (err error) {
+	defer r.observe("DeleteSeller", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Deleting seller with ID: %d", id)
+
+	tx, err := r.begin()
+	if err != nil {
+		log.Printf("[DB] Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the seller so no listing can be created for it concurrently
+	var sellerID int
+	err = tx.QueryRow("SELECT id FROM sellers WHERE id = $1 FOR UPDATE", id).Scan(&sellerID)
+	if err != nil {
+		log.Printf("[DB] Error fetching seller: %v", err)
+		return err
+	}
+
+	var listings int
+	err = tx.QueryRow("SELECT COUNT(*) FROM listings WHERE seller_id = $1", id).Scan(&listings)
+	if err != nil {
+		log.Printf("[DB] Error counting seller listings: %v", err)
+		return err
+	}
+	if listings > 0 {
+		return ErrSellerHasListings
+	}
+
+	if _, err = tx.Exec("DELETE FROM seller_webhooks WHERE seller_id = $1", id); err != nil {
+		log.Printf("[DB] Error deleting seller webhook: %v", err)
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM seller_quotas WHERE seller_id = $1", id); err != nil {
+		log.Printf("[DB] Error deleting seller quota: %v", err)
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM sellers WHERE id = $1", id); err != nil {
+		log.Printf("[DB] Error deleting seller: %v", err)
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("[DB] Error committing transaction: %v", err)
+		return err
+	}
+
+	log.Printf("[DB] Deleted seller with ID: %d", id)
+	return nil
+}
+
 // GetListing fetches a listing by ID
-func (r *Repository) GetListing(id int) (*models.Listing, error) {
+func (r *Repository) GetListing(id int) (_ *models.Listing, err error) {
+	defer r.observe("GetListing", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
 	log.Printf("[DB] Fetching listing with ID: %d", id)
 
 	var listing models.Listing
-	err := r.db.QueryRow("SELECT id, seller_id, title, description, price FROM listings WHERE id = $1", id).
+	err = r.db.QueryRow("SELECT id, seller_id, title, description, price FROM listings WHERE id = $1", id).
 		Scan(&listing.ID, &listing.SellerID, &listing.Title, &listing.Description, &listing.Price)
 	if err != nil {
 		log.Printf("[DB] Error fetching listing: %v", err)
@@ -83,44 +295,46 @@ func (r *Repository) GetListing(id int) (*models.Listing, error) {
 }
 
 // GetListings fetches listings with optional filtering
-func (r *Repository) GetListings(filter *models.ListingFilter) ([]*models.Listing, error) {
+func (r *Repository) GetListings(filter *models.ListingFilter) (_ []*models.Listing, err error) {
+	defer r.observe("GetListings", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
 	log.Printf("[DB] Fetching listings with filter")
 
 	query := "SELECT id, seller_id, title, description, price FROM listings"
 
-	// Build WHERE clause based on filter
-	var conditions []string
-	var args []interface{}
-	argCount := 1
+	if filter != nil && filter.OrderBy == models.OrderByPopularity {
+		query += " LEFT JOIN listing_views ON listing_views.listing_id = listings.id"
+	}
 
-	if filter != nil {
-		if filter.SellerID != nil {
-			conditions = append(conditions, fmt.Sprintf("seller_id = $%d", argCount))
-			args = append(args, *filter.SellerID)
-			argCount++
-		}
+	where, args := buildListingWhere(filter)
 
-		if filter.MinPrice != nil {
-			conditions = append(conditions, fmt.Sprintf("price >= $%d", argCount))
-			args = append(args, *filter.MinPrice)
-			argCount++
-		}
+	var page *models.Page
+	if filter != nil {
+		page = filter.Page
+	}
+	if page != nil {
+		where, args = appendPageWhere(where, args, page)
+	}
+	query += where
 
-		if filter.MaxPrice != nil {
-			conditions = append(conditions, fmt.Sprintf("price <= $%d", argCount))
-			args = append(args, *filter.MaxPrice)
-			argCount++
+	if page != nil {
+		var orderBy string
+		orderBy, args, err = pageOrderBy(page, args)
+		if err != nil {
+			return nil, err
 		}
-
-		if filter.Title != nil {
-			conditions = append(conditions, fmt.Sprintf("title ILIKE $%d", argCount))
-			args = append(args, "%"+*filter.Title+"%")
-			argCount++
+		query += orderBy
+	} else if filter != nil {
+		orderBy := listingOrderBy(filter.OrderBy)
+		// Offset pages need a stable order
+		if orderBy == "" && (filter.Limit > 0 || filter.Offset > 0) {
+			orderBy = " ORDER BY id"
 		}
-	}
-
-	if len(conditions) > 0 {
-		query += " WHERE " + strings.Join(conditions, " AND ")
+		var limit string
+		limit, args = limitOffset(filter.Limit, filter.Offset, args)
+		query += orderBy + limit
 	}
 
 	log.Printf("[DB] Executing query: %s with %d args", query, len(args))
@@ -132,7 +346,7 @@ func (r *Repository) GetListings(filter *models.ListingFilter) ([]*models.Listin
 	}
 	defer rows.Close()
 
-	var listings []*models.Listing
+	listings := []*models.Listing{}
 	for rows.Next() {
 		var listing models.Listing
 		err := rows.Scan(&listing.ID, &listing.SellerID, &listing.Title, &listing.Description, &listing.Price)
@@ -148,192 +362,1568 @@ func (r *Repository) GetListings(filter *models.ListingFilter) ([]*models.Listin
 		return nil, err
 	}
 
+	if page != nil && page.FromEnd {
+		slices.Reverse(listings)
+	}
+
 	log.Printf("[DB] Found %d listings", len(listings))
 	return listings, nil
 }
 
-// CreateListing inserts a new listing into the database
-func (r *Repository) CreateListing(sellerId int, title, description string, price float64) (*models.Listing, error) {
-	log.Printf("[DB] Creating new listing with title: %s, price: %.2f", title, price)
-
-	var id int
-	err := r.db.QueryRow(
-		`INSERT INTO listings (seller_id, title, description, price) 
-		VALUES ($1, $2, $3, $4) RETURNING id`,
-		sellerId, title, description, price).Scan(&id)
+// SearchListings runs a Postgres full-text search over listing titles and
+// descriptions and returns up to limit listings, best ranked first. The query
+// uses web search syntax: quoted phrases, "or" and "-" to exclude words
+func (r *Repository) SearchListings(query string, limit int) (_ []*models.Listing, err error) {
+	defer r.observe("SearchListings", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Searching listings for: %s", query)
 
+	rows, err := r.db.Query(
+		`SELECT id, seller_id, title, description, price FROM listings 
+		WHERE search_vector @@ websearch_to_tsquery('english', $1) 
+		ORDER BY ts_rank(search_vector, websearch_to_tsquery('english', $1)) DESC, id 
+		LIMIT $2`,
+		query, limit)
 	if err != nil {
-		log.Printf("[DB] Error creating listing: %v", err)
+		log.Printf("[DB] Error searching listings: %v", err)
 		return nil, err
 	}
+	defer rows.Close()
 
-	// Return the newly created listing
-	listing := &models.Listing{
-		ID:          id,
-		SellerID:    sellerId,
-		Title:       title,
-		Description: description,
-		Price:       price,
+	listings := []*models.Listing{}
+	for rows.Next() {
+		var listing models.Listing
+		err := rows.Scan(&listing.ID, &listing.SellerID, &listing.Title, &listing.Description, &listing.Price)
+		if err != nil {
+			log.Printf("[DB] Error scanning listing row: %v", err)
+			return nil, err
+		}
+		listings = append(listings, &listing)
 	}
 
-	log.Printf("[DB] Created new listing with ID: %d", id)
-	return listing, nil
-}
-
-// GetPurchase fetches a purchase by ID
-func (r *Repository) GetPurchase(id int) (*models.Purchase, error) {
-	log.Printf("[DB] Fetching purchase with ID: %d", id)
-
-	var purchase models.Purchase
-	err := r.db.QueryRow(
-		`SELECT id, listing_id, price, bank_tx_id, delivery_address, created_at 
-		FROM purchases WHERE id = $1`, id).
-		Scan(&purchase.ID, &purchase.ListingID, &purchase.Price,
-			&purchase.BankTxID, &purchase.DeliveryAddress, &purchase.CreatedAt)
-	if err != nil {
-		log.Printf("[DB] Error fetching purchase: %v", err)
+	if err = rows.Err(); err != nil {
+		log.Printf("[DB] Error iterating listing rows: %v", err)
 		return nil, err
 	}
 
-	return &purchase, nil
+	log.Printf("[DB] Found %d listings", len(listings))
+	return listings, nil
 }
 
-// GetPurchases fetches purchases with optional filtering
-func (r *Repository) GetPurchases(filter *models.PurchaseFilter) ([]*models.Purchase, error) {
-	log.Printf("[DB] Fetching purchases with filter")
-
-	query := `SELECT id, listing_id, price, bank_tx_id, delivery_address, created_at 
-			FROM purchases`
-
-	// Build WHERE clause based on filter
-	var conditions []string
-	var args []interface{}
-	argCount := 1
-
-	if filter != nil {
-		if filter.ListingID != nil {
-			conditions = append(conditions, fmt.Sprintf("listing_id = $%d", argCount))
-			args = append(args, *filter.ListingID)
-			argCount++
-		}
+// Search finds sellers by name or address, listings by title or description
+// and purchases by delivery address containing term, case-insensitively.
+// Purchases are also matched by bank transaction ID if byBankTxID is set. Each
+// kind is capped at limit results, ordered by ID
+func (r *Repository) Search(term string, limit int, byBankTxID bool) (_ *models.SearchResults, err error) {
+	defer r.observe("Search", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Searching sellers, listings and purchases for: %s", term)
 
-		if filter.BankTxID != nil {
-			conditions = append(conditions, fmt.Sprintf("bank_tx_id = $%d", argCount))
-			args = append(args, *filter.BankTxID)
-			argCount++
-		}
+	pattern := "%" + term + "%"
+	results := &models.SearchResults{
+		Sellers:   []*models.Seller{},
+		Listings:  []*models.Listing{},
+		Purchases: []*models.Purchase{},
+	}
 
-		if filter.FromDate != nil {
-			conditions = append(conditions, fmt.Sprintf("created_at >= $%d", argCount))
-			args = append(args, *filter.FromDate)
-			argCount++
+	rows, err := r.db.Query(
+		`SELECT id, name, address, digest_opt_in FROM sellers 
+		WHERE name ILIKE $1 OR address ILIKE $1 ORDER BY id LIMIT $2`,
+		pattern, limit)
+	if err != nil {
+		log.Printf("[DB] Error searching sellers: %v", err)
+		return nil, err
+	}
+	for rows.Next() {
+		var seller models.Seller
+		if err = rows.Scan(&seller.ID, &seller.Name, &seller.Address, &seller.DigestOptIn); err != nil {
+			rows.Close()
+			log.Printf("[DB] Error scanning seller row: %v", err)
+			return nil, err
 		}
+		results.Sellers = append(results.Sellers, &seller)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		log.Printf("[DB] Error iterating seller rows: %v", err)
+		return nil, err
+	}
 
-		if filter.ToDate != nil {
-			conditions = append(conditions, fmt.Sprintf("created_at <= $%d", argCount))
-			args = append(args, *filter.ToDate)
-			argCount++
+	rows, err = r.db.Query(
+		`SELECT id, seller_id, title, description, price FROM listings 
+		WHERE title ILIKE $1 OR description ILIKE $1 ORDER BY id LIMIT $2`,
+		pattern, limit)
+	if err != nil {
+		log.Printf("[DB] Error searching listings: %v", err)
+		return nil, err
+	}
+	for rows.Next() {
+		var listing models.Listing
+		if err = rows.Scan(&listing.ID, &listing.SellerID, &listing.Title, &listing.Description, &listing.Price); err != nil {
+			rows.Close()
+			log.Printf("[DB] Error scanning listing row: %v", err)
+			return nil, err
 		}
+		results.Listings = append(results.Listings, &listing)
 	}
-
-	if len(conditions) > 0 {
-		query += " WHERE " + strings.Join(conditions, " AND ")
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		log.Printf("[DB] Error iterating listing rows: %v", err)
+		return nil, err
 	}
 
-	log.Printf("[DB] Executing query: %s with %d args", query, len(args))
-
-	rows, err := r.db.Query(query, args...)
+	purchaseWhere := "delivery_address ILIKE $1"
+	if byBankTxID {
+		purchaseWhere = "bank_tx_id ILIKE $1 OR " + purchaseWhere
+	}
+	rows, err = r.db.Query(
+		`SELECT id, listing_id, price, tax_amount, bank_tx_id, delivery_address, pickup_point_id, status, order_status, created_at 
+		FROM purchases WHERE `+purchaseWhere+` ORDER BY id LIMIT $2`,
+		pattern, limit)
 	if err != nil {
-		log.Printf("[DB] Error fetching purchases: %v", err)
+		log.Printf("[DB] Error searching purchases: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
-
-	var purchases []*models.Purchase
 	for rows.Next() {
 		var purchase models.Purchase
-		err := rows.Scan(&purchase.ID, &purchase.ListingID, &purchase.Price,
-			&purchase.BankTxID, &purchase.DeliveryAddress, &purchase.CreatedAt)
+		err = rows.Scan(&purchase.ID, &purchase.ListingID, &purchase.Price, &purchase.TaxAmount,
+			&purchase.BankTxID, &purchase.DeliveryAddress, &purchase.PickupPointID, &purchase.Status, &purchase.OrderStatus, &purchase.CreatedAt)
 		if err != nil {
 			log.Printf("[DB] Error scanning purchase row: %v", err)
 			return nil, err
 		}
-		purchases = append(purchases, &purchase)
+		results.Purchases = append(results.Purchases, &purchase)
 	}
-
 	if err = rows.Err(); err != nil {
 		log.Printf("[DB] Error iterating purchase rows: %v", err)
 		return nil, err
 	}
 
-	log.Printf("[DB] Found %d purchases", len(purchases))
-	return purchases, nil
+	log.Printf("[DB] Found %d sellers, %d listings and %d purchases",
+		len(results.Sellers), len(results.Listings), len(results.Purchases))
+	return results, nil
 }
 
-// CreatePurchase inserts a new purchase into the database
-func (r *Repository) CreatePurchase(listingId int, price float64, bankTxId, deliveryAddress string) (*models.Purchase, error) {
-	log.Printf("[DB] Creating new purchase for listing ID: %d, price: %.2f", listingId, price)
-
-	var id int
-	var createdAt time.Time
-
-	err := r.db.QueryRow(
-		`INSERT INTO purchases (listing_id, price, bank_tx_id, delivery_address, created_at) 
-		VALUES ($1, $2, $3, $4, NOW()) RETURNING id, created_at`,
-		listingId, price, bankTxId, deliveryAddress).Scan(&id, &createdAt)
+// GetSimilarListingIDs finds listings related to the given listing: other
+// listings of its seller first, then listings priced within half to double its
+// price, each ordered by views
+func (r *Repository) GetSimilarListingIDs(listingID, limit int) (_ []int, err error) {
+	defer r.observe("GetSimilarListingIDs", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Fetching listings similar to listing ID: %d", listingID)
 
+	rows, err := r.db.Query(
+		`SELECT l.id FROM listings l 
+		JOIN listings source ON source.id = $1 
+		LEFT JOIN listing_views v ON v.listing_id = l.id 
+		WHERE l.id <> source.id 
+		AND (l.seller_id = source.seller_id OR l.price BETWEEN source.price / 2 AND source.price * 2) 
+		ORDER BY (l.seller_id = source.seller_id) DESC, COALESCE(v.views, 0) DESC, l.id 
+		LIMIT $2`,
+		listingID, limit)
 	if err != nil {
-		log.Printf("[DB] Error creating purchase: %v", err)
+		log.Printf("[DB] Error fetching similar listings: %v", err)
 		return nil, err
 	}
+	defer rows.Close()
 
-	// Return the newly created purchase
-	purchase := &models.Purchase{
-		ID:              id,
-		ListingID:       listingId,
-		Price:           price,
-		BankTxID:        bankTxId,
-		DeliveryAddress: deliveryAddress,
-		CreatedAt:       createdAt,
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			log.Printf("[DB] Error scanning listing ID: %v", err)
+			return nil, err
+		}
+		ids = append(ids, id)
 	}
 
-	log.Printf("[DB] Created new purchase with ID: %d", id)
-	return purchase, nil
-}
-
-// GetDelivery fetches a delivery by ID
-func (r *Repository) GetDelivery(id int) (*models.Delivery, error) {
-	log.Printf("[DB] Fetching delivery with ID: %d", id)
-
-	var delivery models.Delivery
-	err := r.db.QueryRow(
-		"SELECT id, purchase_id, timestamp, status FROM deliveries WHERE id = $1", id).
-		Scan(&delivery.ID, &delivery.PurchaseID, &delivery.Timestamp, &delivery.Status)
-	if err != nil {
-		log.Printf("[DB] Error fetching delivery: %v", err)
+	if err = rows.Err(); err != nil {
+		log.Printf("[DB] Error iterating listing IDs: %v", err)
 		return nil, err
 	}
 
-	return &delivery, nil
+	log.Printf("[DB] Found %d similar listings", len(ids))
+	return ids, nil
 }
 
-// GetDeliveries fetches deliveries with optional filtering
-func (r *Repository) GetDeliveries(filter *models.DeliveryFilter) ([]*models.Delivery, error) {
-	log.Printf("[DB] Fetching deliveries with filter")
+// listingOrderBy builds the ORDER BY clause of a listing sort order, breaking ties by ID
+func listingOrderBy(orderBy string) string {
+	switch orderBy {
+	case models.OrderByPopularity:
+		return " ORDER BY COALESCE(listing_views.views, 0) DESC, id"
+	case models.OrderByPriceAsc:
+		return " ORDER BY price, id"
+	case models.OrderByPriceDesc:
+		return " ORDER BY price DESC, id"
+	case models.OrderByTitleAsc:
+		return " ORDER BY title, id"
+	case models.OrderByCreatedAtDesc:
+		return " ORDER BY created_at DESC, id DESC"
+	}
+	return ""
+}
 
-	query := "SELECT id, purchase_id, timestamp, status FROM deliveries"
+// GetListingsByIDs fetches the listings with the given IDs in the order of ids,
+// skipping IDs that no longer exist
+func (r *Repository) GetListingsByIDs(ids []int) (_ []*models.Listing, err error) {
+	defer r.observe("GetListingsByIDs", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Fetching %d listings by ID", len(ids))
 
-	// Build WHERE clause based on filter
-	var conditions []string
-	var args []interface{}
-	argCount := 1
+	rows, err := r.db.Query("SELECT id, seller_id, title, description, price FROM listings WHERE id = ANY($1)", pq.Array(ids))
+	if err != nil {
+		log.Printf("[DB] Error fetching listings: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
 
-	if filter != nil {
-		if filter.PurchaseID != nil {
-			conditions = append(conditions, fmt.Sprintf("purchase_id = $%d", argCount))
-			args = append(args, *filter.PurchaseID)
-			argCount++
-		}
+	byID := make(map[int]*models.Listing, len(ids))
+	for rows.Next() {
+		var listing models.Listing
+		err := rows.Scan(&listing.ID, &listing.SellerID, &listing.Title, &listing.Description, &listing.Price)
+		if err != nil {
+			log.Printf("[DB] Error scanning listing row: %v", err)
+			return nil, err
+		}
+		byID[listing.ID] = &listing
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("[DB] Error iterating listing rows: %v", err)
+		return nil, err
+	}
+
+	listings := make([]*models.Listing, 0, len(byID))
+	for _, id := range ids {
+		if listing, ok := byID[id]; ok {
+			listings = append(listings, listing)
+		}
+	}
+
+	log.Printf("[DB] Found %d listings", len(listings))
+	return listings, nil
+}
+
+// appendPageWhere adds the keyset bounds of a page to a WHERE clause
+func appendPageWhere(where string, args []interface{}, page *models.Page) (string, []interface{}) {
+	var conditions []string
+	if page.AfterID != nil {
+		var condition string
+		condition, args = keysetBound(page, page.AfterPrice, *page.AfterID, true, args)
+		conditions = append(conditions, condition)
+	}
+	if page.BeforeID != nil {
+		var condition string
+		condition, args = keysetBound(page, page.BeforePrice, *page.BeforeID, false, args)
+		conditions = append(conditions, condition)
+	}
+
+	if len(conditions) == 0 {
+		return where, args
+	}
+	if where == "" {
+		return " WHERE " + strings.Join(conditions, " AND "), args
+	}
+	return where + " AND " + strings.Join(conditions, " AND "), args
+}
+
+// keysetBound builds the condition selecting the rows after or before the row
+// with the given price and ID in the page's order. IDs break ties between
+// equal prices
+func keysetBound(page *models.Page, price *models.Money, id int, after bool, args []interface{}) (string, []interface{}) {
+	if !page.SortsByPrice() || price == nil {
+		args = append(args, id)
+		if after {
+			return fmt.Sprintf("id > $%d", len(args)), args
+		}
+		return fmt.Sprintf("id < $%d", len(args)), args
+	}
+
+	args = append(args, *price, id)
+	priceN, idN := len(args)-1, len(args)
+	// IDs ascend within equal prices, while prices descend in OrderByPriceDesc
+	idOp, reverseOp := ">", "<"
+	if !after {
+		idOp, reverseOp = "<", ">"
+	}
+	priceOp := idOp
+	if page.OrderBy == models.OrderByPriceDesc {
+		priceOp = reverseOp
+	}
+	return fmt.Sprintf("(price %s $%d OR (price = $%d AND id %s $%d))", priceOp, priceN, priceN, idOp, idN), args
+}
+
+// pageOrderBy builds the ORDER BY and LIMIT clauses of a page. Pages taken from
+// the end of the range are read in reverse order and must be reversed by the
+// caller. Orders without a keyset, such as popularity, fail with
+// ErrUnsupportedPageOrder rather than silently falling back to ID order
+func pageOrderBy(page *models.Page, args []interface{}) (string, []interface{}, error) {
+	var clause string
+	switch page.OrderBy {
+	case models.OrderByPriceAsc:
+		clause = " ORDER BY price, id"
+		if page.FromEnd {
+			clause = " ORDER BY price DESC, id DESC"
+		}
+	case models.OrderByPriceDesc:
+		clause = " ORDER BY price DESC, id"
+		if page.FromEnd {
+			clause = " ORDER BY price, id DESC"
+		}
+	case "":
+		clause = " ORDER BY id"
+		if page.FromEnd {
+			clause = " ORDER BY id DESC"
+		}
+	default:
+		return "", nil, fmt.Errorf("%w: %s", ErrUnsupportedPageOrder, page.OrderBy)
+	}
+	if page.Limit > 0 {
+		args = append(args, page.Limit)
+		clause += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	return clause, args, nil
+}
+
+// buildListingWhere builds the WHERE clause and arguments for a listing filter
+func buildListingWhere(filter *models.ListingFilter) (string, []interface{}) {
+	conditions, args := listingConditions(filter, nil)
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// listingConditions returns the conditions of a listing filter, all of which
+// must hold, appending their arguments to args. And and Or sub-filters become
+// parenthesized groups, recursively
+func listingConditions(filter *models.ListingFilter, args []interface{}) ([]string, []interface{}) {
+	var conditions []string
+	if filter == nil {
+		return conditions, args
+	}
+
+	if filter.SellerID != nil {
+		args = append(args, *filter.SellerID)
+		conditions = append(conditions, fmt.Sprintf("seller_id = $%d", len(args)))
+	}
+
+	if filter.SellerIDIn != nil {
+		args = append(args, pq.Array(filter.SellerIDIn))
+		conditions = append(conditions, fmt.Sprintf("seller_id = ANY($%d)", len(args)))
+	}
+
+	if filter.SellerName != nil {
+		// A semi-join keeps the selected listing columns unambiguous
+		args = append(args, "%"+*filter.SellerName+"%")
+		conditions = append(conditions, fmt.Sprintf("seller_id IN (SELECT id FROM sellers WHERE name ILIKE $%d)", len(args)))
+	}
+
+	if filter.MinPrice != nil {
+		args = append(args, *filter.MinPrice)
+		conditions = append(conditions, fmt.Sprintf("price >= $%d", len(args)))
+	}
+
+	if filter.MaxPrice != nil {
+		args = append(args, *filter.MaxPrice)
+		conditions = append(conditions, fmt.Sprintf("price <= $%d", len(args)))
+	}
+
+	if filter.Title != nil {
+		args = append(args, "%"+*filter.Title+"%")
+		conditions = append(conditions, fmt.Sprintf("title ILIKE $%d", len(args)))
+	}
+
+	if filter.TitleNotLike != nil {
+		args = append(args, "%"+*filter.TitleNotLike+"%")
+		conditions = append(conditions, fmt.Sprintf("title NOT ILIKE $%d", len(args)))
+	}
+
+	for _, sub := range filter.And {
+		var group []string
+		group, args = listingConditions(sub, args)
+		if len(group) > 0 {
+			conditions = append(conditions, "("+strings.Join(group, " AND ")+")")
+		}
+	}
+
+	if filter.Or != nil {
+		alternatives := make([]string, 0, len(filter.Or))
+		for _, sub := range filter.Or {
+			var group []string
+			group, args = listingConditions(sub, args)
+			if len(group) == 0 {
+				// An empty alternative matches every listing
+				group = []string{"TRUE"}
+			}
+			alternatives = append(alternatives, "("+strings.Join(group, " AND ")+")")
+		}
+		if len(alternatives) == 0 {
+			// No alternative can match
+			alternatives = []string{"FALSE"}
+		}
+		conditions = append(conditions, "("+strings.Join(alternatives, " OR ")+")")
+	}
+
+	return conditions, args
+}
+
+// limitOffset builds the LIMIT and OFFSET clauses of a page; a zero limit means no limit
+func limitOffset(limit, offset int, args []interface{}) (string, []interface{}) {
+	var clause string
+	if limit > 0 {
+		args = append(args, limit)
+		clause += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	if offset > 0 {
+		args = append(args, offset)
+		clause += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+	return clause, args
+}
+
+// GetListingPriceStats computes price statistics and a histogram with the given
+// number of equal-width buckets for the listings matching the filter
+func (r *Repository) GetListingPriceStats(filter *models.ListingFilter, buckets int) (_ *models.PriceStats, err error) {
+	defer r.observe("GetListingPriceStats", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Computing listing price stats with %d buckets", buckets)
+
+	where, args := buildListingWhere(filter)
+
+	var stats models.PriceStats
+	var min, max, avg, median sql.NullFloat64
+	err = r.db.QueryRow(
+		`SELECT COUNT(*), MIN(price), MAX(price), AVG(price), 
+		percentile_cont(0.5) WITHIN GROUP (ORDER BY price) 
+		FROM listings`+where, args...).
+		Scan(&stats.Count, &min, &max, &avg, &median)
+	if err != nil {
+		log.Printf("[DB] Error computing listing price stats: %v", err)
+		return nil, err
+	}
+
+	if stats.Count == 0 {
+		stats.Histogram = []models.PriceBucket{}
+		return &stats, nil
+	}
+	stats.Min, stats.Max, stats.Avg, stats.Median = &min.Float64, &max.Float64, &avg.Float64, &median.Float64
+
+	// All prices are equal, width_bucket needs distinct bounds
+	if min.Float64 == max.Float64 {
+		stats.Histogram = []models.PriceBucket{{Min: min.Float64, Max: max.Float64, Count: stats.Count}}
+		return &stats, nil
+	}
+
+	// The maximum price falls into bucket n+1, so it is folded into the last bucket
+	bucketArg := len(args) + 1
+	query := fmt.Sprintf(
+		`SELECT LEAST(width_bucket(price, $%d, $%d, $%d), $%d) AS bucket, COUNT(*) 
+		FROM listings%s 
+		GROUP BY bucket ORDER BY bucket`,
+		bucketArg, bucketArg+1, bucketArg+2, bucketArg+2, where)
+	args = append(args, min.Float64, max.Float64, buckets)
+
+	log.Printf("[DB] Executing query: %s with %d args", query, len(args))
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		log.Printf("[DB] Error computing listing price histogram: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	width := (max.Float64 - min.Float64) / float64(buckets)
+	stats.Histogram = make([]models.PriceBucket, buckets)
+	for i := range stats.Histogram {
+		stats.Histogram[i].Min = min.Float64 + float64(i)*width
+		stats.Histogram[i].Max = min.Float64 + float64(i+1)*width
+	}
+
+	for rows.Next() {
+		var bucket, count int
+		if err := rows.Scan(&bucket, &count); err != nil {
+			log.Printf("[DB] Error scanning histogram row: %v", err)
+			return nil, err
+		}
+		if bucket >= 1 && bucket <= buckets {
+			stats.Histogram[bucket-1].Count = count
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("[DB] Error iterating histogram rows: %v", err)
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+// GetListingViews returns the recorded view count of a listing
+func (r *Repository) GetListingViews(listingID int) (_ int64, err error) {
+	defer r.observe("GetListingViews", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Fetching views for listing ID: %d", listingID)
+
+	var views int64
+	err = r.db.QueryRow("SELECT views FROM listing_views WHERE listing_id = $1", listingID).Scan(&views)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		log.Printf("[DB] Error fetching listing views: %v", err)
+		return 0, err
+	}
+
+	return views, nil
+}
+
+// GetListingViewsByIDs returns the recorded view counts of many listings in a
+// single query. Listings without recorded views are left out
+func (r *Repository) GetListingViewsByIDs(listingIDs []int) (_ map[int]int64, err error) {
+	defer r.observe("GetListingViewsByIDs", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Fetching views for %d listings", len(listingIDs))
+
+	rows, err := r.db.Query("SELECT listing_id, views FROM listing_views WHERE listing_id = ANY($1)", pq.Array(listingIDs))
+	if err != nil {
+		log.Printf("[DB] Error fetching listing views: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	views := make(map[int]int64, len(listingIDs))
+	for rows.Next() {
+		var listingID int
+		var count int64
+		if err := rows.Scan(&listingID, &count); err != nil {
+			log.Printf("[DB] Error scanning listing views row: %v", err)
+			return nil, err
+		}
+		views[listingID] = count
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("[DB] Error iterating listing views rows: %v", err)
+		return nil, err
+	}
+
+	return views, nil
+}
+
+// IncrementListingViews adds the given view counts per listing ID in a single statement
+func (r *Repository) IncrementListingViews(counts map[int]int64) (err error) {
+	defer r.observe("IncrementListingViews", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Incrementing views for %d listings", len(counts))
+
+	ids := make([]int64, 0, len(counts))
+	increments := make([]int64, 0, len(counts))
+	for id, count := range counts {
+		ids = append(ids, int64(id))
+		increments = append(increments, count)
+	}
+
+	_, err = r.db.Exec(
+		`INSERT INTO listing_views (listing_id, views) 
+		SELECT * FROM unnest($1::int[], $2::bigint[]) 
+		ON CONFLICT (listing_id) DO UPDATE SET views = listing_views.views + EXCLUDED.views`,
+		pq.Array(ids), pq.Array(increments))
+	if err != nil {
+		log.Printf("[DB] Error incrementing listing views: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// CreateListing inserts a new listing into the database
+func (r *Repository) CreateListing(sellerId int, title, description string, price models.Money) (_ *models.Listing, err error) {
+	defer r.observe("CreateListing", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Creating new listing with title: %s, price: %s", title, price)
+
+	var id int
+	err = r.db.QueryRow(
+		`INSERT INTO listings (seller_id, title, description, price) 
+		VALUES ($1, $2, $3, $4) RETURNING id`,
+		sellerId, title, description, price).Scan(&id)
+
+	if err != nil {
+		log.Printf("[DB] Error creating listing: %v", err)
+		return nil, err
+	}
+
+	// Return the newly created listing
+	listing := &models.Listing{
+		ID:          id,
+		SellerID:    sellerId,
+		Title:       title,
+		Description: description,
+		Price:       price,
+	}
+
+	log.Printf("[DB] Created new listing with ID: %d", id)
+	return listing, nil
+}
+
+// CreateListings stores several listings with a single multi-row INSERT, so
+// either all of them are created or none are. Listings are returned in the
+// order given
+func (r *Repository) CreateListings(listings []models.NewListing) (_ []*models.Listing, err error) {
+	defer r.observe("CreateListings", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Creating %d listings", len(listings))
+
+	created := []*models.Listing{}
+	if len(listings) == 0 {
+		return created, nil
+	}
+
+	values := make([]string, 0, len(listings))
+	args := make([]interface{}, 0, 4*len(listings))
+	for i, listing := range listings {
+		values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d)", 4*i+1, 4*i+2, 4*i+3, 4*i+4))
+		args = append(args, listing.SellerID, listing.Title, listing.Description, listing.Price)
+	}
+
+	rows, err := r.db.Query(
+		`INSERT INTO listings (seller_id, title, description, price) 
+		VALUES `+strings.Join(values, ", ")+` RETURNING id`,
+		args...)
+	if err != nil {
+		log.Printf("[DB] Error creating listings: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for i := 0; rows.Next(); i++ {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			log.Printf("[DB] Error scanning listing ID: %v", err)
+			return nil, err
+		}
+		created = append(created, &models.Listing{
+			ID:          id,
+			SellerID:    listings[i].SellerID,
+			Title:       listings[i].Title,
+			Description: listings[i].Description,
+			Price:       listings[i].Price,
+		})
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("[DB] Error iterating listing IDs: %v", err)
+		return nil, err
+	}
+
+	log.Printf("[DB] Created %d listings", len(created))
+	return created, nil
+}
+
+// UpdateListing updates the given fields of a listing and records a price change
+// in the price history, all within a single transaction
+func (r *Repository) UpdateListing(id int, title, description *string, price *models.Money) (_ *models.Listing, err error) {
+	defer r.observe("UpdateListing", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Updating listing with ID: %d", id)
+
+	tx, err := r.begin()
+	if err != nil {
+		log.Printf("[DB] Error starting transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var oldPrice models.Money
+	err = tx.QueryRow("SELECT price FROM listings WHERE id = $1 FOR UPDATE", id).Scan(&oldPrice)
+	if err != nil {
+		log.Printf("[DB] Error fetching listing: %v", err)
+		return nil, err
+	}
+
+	var listing models.Listing
+	err = tx.QueryRow(
+		`UPDATE listings SET title = COALESCE($2, title), description = COALESCE($3, description), 
+		price = COALESCE($4, price) WHERE id = $1 
+		RETURNING id, seller_id, title, description, price`,
+		id, title, description, price).
+		Scan(&listing.ID, &listing.SellerID, &listing.Title, &listing.Description, &listing.Price)
+	if err != nil {
+		log.Printf("[DB] Error updating listing: %v", err)
+		return nil, err
+	}
+
+	if listing.Price != oldPrice {
+		_, err = tx.Exec(
+			"INSERT INTO listing_price_history (listing_id, price, changed_at) VALUES ($1, $2, NOW())",
+			id, listing.Price)
+		if err != nil {
+			log.Printf("[DB] Error recording price change: %v", err)
+			return nil, err
+		}
+		log.Printf("[DB] Recorded price change for listing ID %d: %s -> %s", id, oldPrice, listing.Price)
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("[DB] Error committing transaction: %v", err)
+		return nil, err
+	}
+
+	log.Printf("[DB] Updated listing with ID: %d", id)
+	return &listing, nil
+}
+
+// GetPriceHistory fetches the recorded price changes of a listing within an optional date range
+func (r *Repository) GetPriceHistory(listingID int, fromDate, toDate *time.Time) (_ []*models.PricePoint, err error) {
+	defer r.observe("GetPriceHistory", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Fetching price history for listing ID: %d", listingID)
+
+	query := "SELECT listing_id, price, changed_at FROM listing_price_history WHERE listing_id = $1"
+	args := []interface{}{listingID}
+
+	if fromDate != nil {
+		args = append(args, *fromDate)
+		query += fmt.Sprintf(" AND changed_at >= $%d", len(args))
+	}
+
+	if toDate != nil {
+		args = append(args, *toDate)
+		query += fmt.Sprintf(" AND changed_at <= $%d", len(args))
+	}
+
+	query += " ORDER BY changed_at"
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		log.Printf("[DB] Error fetching price history: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []*models.PricePoint{}
+	for rows.Next() {
+		var point models.PricePoint
+		err := rows.Scan(&point.ListingID, &point.Price, &point.ChangedAt)
+		if err != nil {
+			log.Printf("[DB] Error scanning price history row: %v", err)
+			return nil, err
+		}
+		points = append(points, &point)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("[DB] Error iterating price history rows: %v", err)
+		return nil, err
+	}
+
+	log.Printf("[DB] Found %d price points for listing ID %d", len(points), listingID)
+	return points, nil
+}
+
+// GetPurchase fetches a purchase by ID
+func (r *Repository) GetPurchase(id int) (_ *models.Purchase, err error) {
+	defer r.observe("GetPurchase", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Fetching purchase with ID: %d", id)
+
+	var purchase models.Purchase
+	err = r.db.QueryRow(
+		`SELECT id, listing_id, price, tax_amount, bank_tx_id, delivery_address, pickup_point_id, status, order_status, created_at 
+		FROM purchases WHERE id = $1`, id).
+		Scan(&purchase.ID, &purchase.ListingID, &purchase.Price, &purchase.TaxAmount,
+			&purchase.BankTxID, &purchase.DeliveryAddress, &purchase.PickupPointID, &purchase.Status, &purchase.OrderStatus, &purchase.CreatedAt)
+	if err != nil {
+		log.Printf("[DB] Error fetching purchase: %v", err)
+		return nil, err
+	}
+
+	return &purchase, nil
+}
+
+// GetPurchaseAsOf fetches a purchase with the review and order status it had at
+// the given time, from the status history. It returns sql.ErrNoRows if the
+// purchase didn't exist yet
+func (r *Repository) GetPurchaseAsOf(id int, asOf time.Time) (_ *models.Purchase, err error) {
+	defer r.observe("GetPurchaseAsOf", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Fetching purchase with ID %d as of %s", id, asOf.Format(time.RFC3339))
+
+	var purchase models.Purchase
+	err = r.db.QueryRow(
+		`SELECT p.id, p.listing_id, p.price, p.tax_amount, p.bank_tx_id, p.delivery_address, p.pickup_point_id, h.status, h.order_status, p.created_at 
+		FROM purchases p 
+		JOIN LATERAL ( 
+			SELECT status, order_status FROM purchase_status_history 
+			WHERE purchase_id = p.id AND changed_at <= $2 ORDER BY changed_at DESC, id DESC LIMIT 1 
+		) h ON TRUE 
+		WHERE p.id = $1 AND p.created_at <= $2`, id, asOf).
+		Scan(&purchase.ID, &purchase.ListingID, &purchase.Price, &purchase.TaxAmount,
+			&purchase.BankTxID, &purchase.DeliveryAddress, &purchase.PickupPointID, &purchase.Status, &purchase.OrderStatus, &purchase.CreatedAt)
+	if err != nil {
+		log.Printf("[DB] Error fetching purchase as of %s: %v", asOf.Format(time.RFC3339), err)
+		return nil, err
+	}
+
+	return &purchase, nil
+}
+
+// GetPurchases fetches purchases with optional filtering
+func (r *Repository) GetPurchases(filter *models.PurchaseFilter) (_ []*models.Purchase, err error) {
+	defer r.observe("GetPurchases", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Fetching purchases with filter")
+
+	query := `SELECT id, listing_id, price, tax_amount, bank_tx_id, delivery_address, pickup_point_id, status, order_status, created_at 
+			FROM purchases`
+
+	where, args := buildPurchaseWhere(filter)
+	query += where
+
+	// Order by ID so pages are stable
+	query += " ORDER BY id"
+	if filter != nil {
+		var limit string
+		limit, args = limitOffset(filter.Limit, filter.Offset, args)
+		query += limit
+	}
+
+	log.Printf("[DB] Executing query: %s with %d args", query, len(args))
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		log.Printf("[DB] Error fetching purchases: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	purchases := []*models.Purchase{}
+	for rows.Next() {
+		var purchase models.Purchase
+		err := rows.Scan(&purchase.ID, &purchase.ListingID, &purchase.Price, &purchase.TaxAmount,
+			&purchase.BankTxID, &purchase.DeliveryAddress, &purchase.PickupPointID, &purchase.Status, &purchase.OrderStatus, &purchase.CreatedAt)
+		if err != nil {
+			log.Printf("[DB] Error scanning purchase row: %v", err)
+			return nil, err
+		}
+		purchases = append(purchases, &purchase)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("[DB] Error iterating purchase rows: %v", err)
+		return nil, err
+	}
+
+	log.Printf("[DB] Found %d purchases", len(purchases))
+	return purchases, nil
+}
+
+// GetPurchasesByListingIDs fetches the purchases of several listings at once,
+// ordered by listing and ID. Limit and offset page through the purchases of
+// each listing separately; a zero limit returns all of them
+func (r *Repository) GetPurchasesByListingIDs(listingIDs []int, limit, offset int) (_ []*models.Purchase, err error) {
+	defer r.observe("GetPurchasesByListingIDs", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Fetching purchases of %d listings", len(listingIDs))
+
+	query := `SELECT id, listing_id, price, tax_amount, bank_tx_id, delivery_address, pickup_point_id, status, order_status, created_at 
+			FROM (SELECT *, ROW_NUMBER() OVER (PARTITION BY listing_id ORDER BY id) AS position 
+				FROM purchases WHERE listing_id = ANY($1)) ranked 
+			WHERE position > $2`
+	args := []interface{}{pq.Array(listingIDs), offset}
+	if limit > 0 {
+		args = append(args, offset+limit)
+		query += fmt.Sprintf(" AND position <= $%d", len(args))
+	}
+	query += " ORDER BY listing_id, id"
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		log.Printf("[DB] Error fetching purchases: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	purchases := []*models.Purchase{}
+	for rows.Next() {
+		var purchase models.Purchase
+		err := rows.Scan(&purchase.ID, &purchase.ListingID, &purchase.Price, &purchase.TaxAmount,
+			&purchase.BankTxID, &purchase.DeliveryAddress, &purchase.PickupPointID, &purchase.Status, &purchase.OrderStatus, &purchase.CreatedAt)
+		if err != nil {
+			log.Printf("[DB] Error scanning purchase row: %v", err)
+			return nil, err
+		}
+		purchases = append(purchases, &purchase)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("[DB] Error iterating purchase rows: %v", err)
+		return nil, err
+	}
+
+	log.Printf("[DB] Found %d purchases", len(purchases))
+	return purchases, nil
+}
+
+// GetPurchasesByBankTxIDs fetches the purchases paid with any of the given
+// bank transactions in a single query, ordered by ID
+func (r *Repository) GetPurchasesByBankTxIDs(bankTxIDs []string) (_ []*models.Purchase, err error) {
+	defer r.observe("GetPurchasesByBankTxIDs", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Fetching purchases of %d bank transactions", len(bankTxIDs))
+
+	rows, err := r.db.Query(`SELECT id, listing_id, price, tax_amount, bank_tx_id, delivery_address, pickup_point_id, status, order_status, created_at 
+		FROM purchases WHERE bank_tx_id = ANY($1) ORDER BY id`, pq.Array(bankTxIDs))
+	if err != nil {
+		log.Printf("[DB] Error fetching purchases: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	purchases := []*models.Purchase{}
+	for rows.Next() {
+		var purchase models.Purchase
+		err := rows.Scan(&purchase.ID, &purchase.ListingID, &purchase.Price, &purchase.TaxAmount,
+			&purchase.BankTxID, &purchase.DeliveryAddress, &purchase.PickupPointID, &purchase.Status, &purchase.OrderStatus, &purchase.CreatedAt)
+		if err != nil {
+			log.Printf("[DB] Error scanning purchase row: %v", err)
+			return nil, err
+		}
+		purchases = append(purchases, &purchase)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("[DB] Error iterating purchase rows: %v", err)
+		return nil, err
+	}
+
+	log.Printf("[DB] Found %d purchases", len(purchases))
+	return purchases, nil
+}
+
+// buildPurchaseWhere builds the WHERE clause and arguments for a purchase filter
+func buildPurchaseWhere(filter *models.PurchaseFilter) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	argCount := 1
+
+	if filter != nil {
+		if filter.ListingID != nil {
+			conditions = append(conditions, fmt.Sprintf("listing_id = $%d", argCount))
+			args = append(args, *filter.ListingID)
+			argCount++
+		}
+
+		if filter.ListingIDIn != nil {
+			conditions = append(conditions, fmt.Sprintf("listing_id = ANY($%d)", argCount))
+			args = append(args, pq.Array(filter.ListingIDIn))
+			argCount++
+		}
+
+		if filter.BankTxID != nil {
+			conditions = append(conditions, fmt.Sprintf("bank_tx_id = $%d", argCount))
+			args = append(args, *filter.BankTxID)
+			argCount++
+		}
+
+		if filter.Status != nil {
+			conditions = append(conditions, fmt.Sprintf("status = $%d", argCount))
+			args = append(args, *filter.Status)
+			argCount++
+		}
+
+		if filter.OrderStatus != nil {
+			conditions = append(conditions, fmt.Sprintf("order_status = $%d", argCount))
+			args = append(args, *filter.OrderStatus)
+			argCount++
+		}
+
+		if filter.FromDate != nil {
+			conditions = append(conditions, fmt.Sprintf("created_at >= $%d", argCount))
+			args = append(args, *filter.FromDate)
+			argCount++
+		}
+
+		if filter.ToDate != nil {
+			conditions = append(conditions, fmt.Sprintf("created_at <= $%d", argCount))
+			args = append(args, *filter.ToDate)
+			argCount++
+		}
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// GetPurchaseStats counts the purchases matching the filter and sums their
+// revenue in a single aggregate query. Rejected and canceled purchases are
+// counted but don't add to the revenue
+func (r *Repository) GetPurchaseStats(filter *models.PurchaseFilter) (_ *models.PurchaseStats, err error) {
+	defer r.observe("GetPurchaseStats", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Computing purchase stats")
+
+	where, args := buildPurchaseWhere(filter)
+	statusArg := len(args) + 1
+	query := fmt.Sprintf(
+		`SELECT COUNT(*), COALESCE(SUM(price) FILTER (WHERE status NOT IN ($%d, $%d)), 0) 
+		FROM purchases%s`,
+		statusArg, statusArg+1, where)
+	args = append(args, models.PurchaseStatusRejected, models.PurchaseStatusCanceled)
+
+	log.Printf("[DB] Executing query: %s with %d args", query, len(args))
+
+	var stats models.PurchaseStats
+	err = r.db.QueryRow(query, args...).Scan(&stats.Count, &stats.Revenue)
+	if err != nil {
+		log.Printf("[DB] Error computing purchase stats: %v", err)
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+// dashboardVersionColumns select what changes whenever a dashboard figure may:
+// the day, the latest purchase, the latest delivery update and the latest
+// purchase status change. All of them are read from primary key indexes
+const dashboardVersionColumns = `CURRENT_DATE, 
+	(SELECT COALESCE(MAX(id), 0) FROM purchases), 
+	(SELECT COALESCE(MAX(id), 0) FROM deliveries), 
+	(SELECT COALESCE(MAX(id), 0) FROM purchase_status_history)`
+
+// dashboardMarks are the values of dashboardVersionColumns
+type dashboardMarks struct {
+	day          time.Time
+	purchase     int
+	delivery     int
+	statusChange int
+}
+
+// version condenses the marks into an opaque version string
+func (m dashboardMarks) version() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%d/%d/%d", m.day.Format(time.DateOnly), m.purchase, m.delivery, m.statusChange)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// GetDashboard computes the dashboard figures and their version in a single
+// query, so they are consistent with each other
+func (r *Repository) GetDashboard() (_ *models.Dashboard, err error) {
+	defer r.observe("GetDashboard", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Computing dashboard")
+
+	var dashboard models.Dashboard
+	var marks dashboardMarks
+	err = r.db.QueryRow(
+		`SELECT 
+			(SELECT COUNT(*) FROM purchases WHERE order_status IN ($1, $2, $3)), 
+			(SELECT COUNT(*) FROM ( 
+				SELECT DISTINCT ON (purchase_id) status FROM deliveries ORDER BY purchase_id, timestamp DESC, id DESC 
+			) latest WHERE status = $4), 
+			(SELECT COALESCE(SUM(price), 0) FROM purchases WHERE created_at >= CURRENT_DATE AND status NOT IN ($5, $6)), 
+			`+dashboardVersionColumns,
+		models.OrderStatusPending, models.OrderStatusPaid, models.OrderStatusShipped,
+		models.DeliveryStatusOutForDelivery,
+		models.PurchaseStatusRejected, models.PurchaseStatusCanceled).
+		Scan(&dashboard.OpenPurchases, &dashboard.OutForDelivery, &dashboard.RevenueToday,
+			&marks.day, &marks.purchase, &marks.delivery, &marks.statusChange)
+	if err != nil {
+		log.Printf("[DB] Error computing dashboard: %v", err)
+		return nil, err
+	}
+
+	dashboard.Version = marks.version()
+	return &dashboard, nil
+}
+
+// GetDashboardVersion returns the version GetDashboard would report, without
+// computing the figures
+func (r *Repository) GetDashboardVersion() (_ string, err error) {
+	defer r.observe("GetDashboardVersion", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+
+	var marks dashboardMarks
+	err = r.db.QueryRow("SELECT "+dashboardVersionColumns).
+		Scan(&marks.day, &marks.purchase, &marks.delivery, &marks.statusChange)
+	if err != nil {
+		log.Printf("[DB] Error fetching dashboard version: %v", err)
+		return "", err
+	}
+
+	return marks.version(), nil
+}
+
+// GetPurchasesSince fetches up to limit purchases created after the purchase with the given ID, oldest first
+func (r *Repository) GetPurchasesSince(afterID, limit int) (_ []*models.Purchase, err error) {
+	defer r.observe("GetPurchasesSince", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Fetching purchases after ID: %d", afterID)
+
+	rows, err := r.db.Query(
+		`SELECT id, listing_id, price, tax_amount, bank_tx_id, delivery_address, pickup_point_id, status, order_status, created_at 
+		FROM purchases WHERE id > $1 ORDER BY id LIMIT $2`, afterID, limit)
+	if err != nil {
+		log.Printf("[DB] Error fetching purchases: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	purchases := []*models.Purchase{}
+	for rows.Next() {
+		var purchase models.Purchase
+		err := rows.Scan(&purchase.ID, &purchase.ListingID, &purchase.Price, &purchase.TaxAmount,
+			&purchase.BankTxID, &purchase.DeliveryAddress, &purchase.PickupPointID, &purchase.Status, &purchase.OrderStatus, &purchase.CreatedAt)
+		if err != nil {
+			log.Printf("[DB] Error scanning purchase row: %v", err)
+			return nil, err
+		}
+		purchases = append(purchases, &purchase)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("[DB] Error iterating purchase rows: %v", err)
+		return nil, err
+	}
+
+	log.Printf("[DB] Found %d purchases after ID %d", len(purchases), afterID)
+	return purchases, nil
+}
+
+// GetPurchasesByLatestDeliveryStatus fetches purchases whose most recent delivery has the given status
+func (r *Repository) GetPurchasesByLatestDeliveryStatus(status string) (_ []*models.Purchase, err error) {
+	defer r.observe("GetPurchasesByLatestDeliveryStatus", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Fetching purchases with latest delivery status: %s", status)
+
+	rows, err := r.db.Query(
+		`SELECT p.id, p.listing_id, p.price, p.tax_amount, p.bank_tx_id, p.delivery_address, p.pickup_point_id, p.status, p.order_status, p.created_at 
+		FROM purchases p 
+		JOIN LATERAL (
+			SELECT d.status FROM deliveries d 
+			WHERE d.purchase_id = p.id 
+			ORDER BY d.timestamp DESC LIMIT 1
+		) latest ON true 
+		WHERE latest.status = $1 
+		ORDER BY p.id`,
+		status)
+	if err != nil {
+		log.Printf("[DB] Error fetching purchases: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	purchases := []*models.Purchase{}
+	for rows.Next() {
+		var purchase models.Purchase
+		err := rows.Scan(&purchase.ID, &purchase.ListingID, &purchase.Price, &purchase.TaxAmount,
+			&purchase.BankTxID, &purchase.DeliveryAddress, &purchase.PickupPointID, &purchase.Status, &purchase.OrderStatus, &purchase.CreatedAt)
+		if err != nil {
+			log.Printf("[DB] Error scanning purchase row: %v", err)
+			return nil, err
+		}
+		purchases = append(purchases, &purchase)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("[DB] Error iterating purchase rows: %v", err)
+		return nil, err
+	}
+
+	log.Printf("[DB] Found %d purchases with latest delivery status %s", len(purchases), status)
+	return purchases, nil
+}
+
+// UpdatePurchaseStatus moves a purchase from one status to another. It returns
+// sql.ErrNoRows if the purchase does not exist or is no longer in the from status
+func (r *Repository) UpdatePurchaseStatus(id int, from, to string) (_ *models.Purchase, err error) {
+	defer r.observe("UpdatePurchaseStatus", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Updating purchase ID %d status from %s to %s", id, from, to)
+
+	var purchase models.Purchase
+	err = r.db.QueryRow(
+		`UPDATE purchases SET status = $2 WHERE id = $1 AND status = $3 
+		RETURNING id, listing_id, price, tax_amount, bank_tx_id, delivery_address, pickup_point_id, status, order_status, created_at`,
+		id, to, from).
+		Scan(&purchase.ID, &purchase.ListingID, &purchase.Price, &purchase.TaxAmount,
+			&purchase.BankTxID, &purchase.DeliveryAddress, &purchase.PickupPointID, &purchase.Status, &purchase.OrderStatus, &purchase.CreatedAt)
+	if err != nil {
+		log.Printf("[DB] Error updating purchase status: %v", err)
+		return nil, err
+	}
+
+	return &purchase, nil
+}
+
+// UpdateOrderStatus moves the order status of a purchase from one status to
+// another. It returns sql.ErrNoRows if the purchase does not exist or its order
+// is no longer in the from status
+func (r *Repository) UpdateOrderStatus(id int, from, to string) (_ *models.Purchase, err error) {
+	defer r.observe("UpdateOrderStatus", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Updating purchase ID %d order status from %s to %s", id, from, to)
+
+	var purchase models.Purchase
+	err = r.db.QueryRow(
+		`UPDATE purchases SET order_status = $2 WHERE id = $1 AND order_status = $3 
+		RETURNING id, listing_id, price, tax_amount, bank_tx_id, delivery_address, pickup_point_id, status, order_status, created_at`,
+		id, to, from).
+		Scan(&purchase.ID, &purchase.ListingID, &purchase.Price, &purchase.TaxAmount,
+			&purchase.BankTxID, &purchase.DeliveryAddress, &purchase.PickupPointID, &purchase.Status, &purchase.OrderStatus, &purchase.CreatedAt)
+	if err != nil {
+		log.Printf("[DB] Error updating purchase order status: %v", err)
+		return nil, err
+	}
+
+	return &purchase, nil
+}
+
+// CancelPurchase marks a purchase canceled, or refunded if it was already paid,
+// records the reason and a canceled delivery update continuing its latest
+// attempt, all in a single transaction. The returned purchase carries its
+// cancellation. It returns an error wrapping ErrPurchaseNotCancelable if the
+// purchase can no longer be canceled, e.g. because it has shipped
+func (r *Repository) CancelPurchase(id int, reason string) (_ *models.Purchase, _ *models.Delivery, err error) {
+	defer r.observe("CancelPurchase", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Canceling purchase with ID: %d", id)
+
+	tx, err := r.begin()
+	if err != nil {
+		log.Printf("[DB] Error starting transaction: %v", err)
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var status, orderStatus string
+	err = tx.QueryRow("SELECT status, order_status FROM purchases WHERE id = $1 FOR UPDATE", id).Scan(&status, &orderStatus)
+	if err != nil {
+		log.Printf("[DB] Error fetching purchase: %v", err)
+		return nil, nil, err
+	}
+	if status == models.PurchaseStatusRejected || status == models.PurchaseStatusCanceled {
+		return nil, nil, fmt.Errorf("%w: purchase is %s", ErrPurchaseNotCancelable, status)
+	}
+	if orderStatus == models.OrderStatusShipped || orderStatus == models.OrderStatusCompleted {
+		return nil, nil, fmt.Errorf("%w: order is %s", ErrPurchaseNotCancelable, orderStatus)
+	}
+
+	// Deliveries that already arrived can't be canceled
+	attemptNumber := 1
+	var deliveryStatus string
+	err = tx.QueryRow(
+		"SELECT status, attempt_number FROM deliveries WHERE purchase_id = $1 ORDER BY id DESC LIMIT 1", id).
+		Scan(&deliveryStatus, &attemptNumber)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("[DB] Error fetching latest delivery: %v", err)
+		return nil, nil, err
+	}
+	if err == nil {
+		if err := models.ValidateDeliveryTransition(deliveryStatus, models.DeliveryStatusCanceled); err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrPurchaseNotCancelable, err)
+		}
+	}
+
+	var purchase models.Purchase
+	err = tx.QueryRow(
+		`UPDATE purchases SET status = $2, order_status = $3 WHERE id = $1 
+		RETURNING id, listing_id, price, tax_amount, bank_tx_id, delivery_address, pickup_point_id, status, order_status, created_at`,
+		id, models.PurchaseStatusCanceled, models.OrderStatusAfterCancel(orderStatus)).
+		Scan(&purchase.ID, &purchase.ListingID, &purchase.Price, &purchase.TaxAmount,
+			&purchase.BankTxID, &purchase.DeliveryAddress, &purchase.PickupPointID, &purchase.Status, &purchase.OrderStatus, &purchase.CreatedAt)
+	if err != nil {
+		log.Printf("[DB] Error canceling purchase: %v", err)
+		return nil, nil, err
+	}
+
+	purchase.Cancellation = &models.PurchaseCancellation{PurchaseID: id, Reason: reason}
+	err = tx.QueryRow(
+		"INSERT INTO purchase_cancellations (purchase_id, reason) VALUES ($1, $2) RETURNING canceled_at",
+		id, reason).Scan(&purchase.Cancellation.CanceledAt)
+	if err != nil {
+		log.Printf("[DB] Error recording cancellation: %v", err)
+		return nil, nil, err
+	}
+
+	delivery := &models.Delivery{PurchaseID: id, Status: models.DeliveryStatusCanceled, AttemptNumber: attemptNumber}
+	err = tx.QueryRow(
+		`INSERT INTO deliveries (purchase_id, timestamp, status, attempt_number) VALUES ($1, NOW(), $2, $3) 
+		RETURNING id, timestamp`,
+		id, models.DeliveryStatusCanceled, attemptNumber).Scan(&delivery.ID, &delivery.Timestamp)
+	if err != nil {
+		log.Printf("[DB] Error creating delivery: %v", err)
+		return nil, nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("[DB] Error committing transaction: %v", err)
+		return nil, nil, err
+	}
+
+	log.Printf("[DB] Canceled purchase ID %d with delivery ID: %d", id, delivery.ID)
+	return &purchase, delivery, nil
+}
+
+// GetPurchaseCancellation fetches the reason and time a purchase was canceled
+func (r *Repository) GetPurchaseCancellation(purchaseID int) (_ *models.PurchaseCancellation, err error) {
+	defer r.observe("GetPurchaseCancellation", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Fetching cancellation of purchase ID: %d", purchaseID)
+
+	cancellation := models.PurchaseCancellation{PurchaseID: purchaseID}
+	err = r.db.QueryRow("SELECT reason, canceled_at FROM purchase_cancellations WHERE purchase_id = $1", purchaseID).
+		Scan(&cancellation.Reason, &cancellation.CanceledAt)
+	if err != nil {
+		log.Printf("[DB] Error fetching purchase cancellation: %v", err)
+		return nil, err
+	}
+
+	return &cancellation, nil
+}
+
+// CreatePurchase inserts a new purchase into the database, pending fraud review and
+// payment. pickupPointID is nil for home delivery
+func (r *Repository) CreatePurchase(listingId int, price, taxAmount models.Money, bankTxId, deliveryAddress string, pickupPointID *int) (_ *models.Purchase, err error) {
+	defer r.observe("CreatePurchase", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Creating new purchase for listing ID: %d, price: %s", listingId, price)
+
+	purchase, err := insertPurchase(r.db, listingId, price, taxAmount, bankTxId, deliveryAddress, pickupPointID)
+	if err != nil {
+		log.Printf("[DB] Error creating purchase: %v", err)
+		return nil, err
+	}
+
+	log.Printf("[DB] Created new purchase with ID: %d", purchase.ID)
+	return purchase, nil
+}
+
+// CreatePurchaseWithDelivery inserts a new purchase like CreatePurchase together
+// with its initial PACKED delivery in a single transaction
+func (r *Repository) CreatePurchaseWithDelivery(listingId int, price, taxAmount models.Money, bankTxId, deliveryAddress string, pickupPointID *int) (_ *models.Purchase, _ *models.Delivery, err error) {
+	defer r.observe("CreatePurchaseWithDelivery", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Creating new purchase with delivery for listing ID: %d, price: %s", listingId, price)
+
+	tx, err := r.begin()
+	if err != nil {
+		log.Printf("[DB] Error starting transaction: %v", err)
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	purchase, err := insertPurchase(tx, listingId, price, taxAmount, bankTxId, deliveryAddress, pickupPointID)
+	if err != nil {
+		log.Printf("[DB] Error creating purchase: %v", err)
+		return nil, nil, err
+	}
+
+	delivery, err := insertDelivery(tx, purchase.ID, models.DeliveryStatusPacked)
+	if err != nil {
+		log.Printf("[DB] Error creating delivery: %v", err)
+		return nil, nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("[DB] Error committing transaction: %v", err)
+		return nil, nil, err
+	}
+
+	log.Printf("[DB] Created new purchase with ID %d and delivery ID: %d", purchase.ID, delivery.ID)
+	return purchase, delivery, nil
+}
+
+// queryRower runs single-row queries on the database or within a transaction
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// insertPurchase inserts a purchase pending fraud review and payment
+func insertPurchase(q queryRower, listingId int, price, taxAmount models.Money, bankTxId, deliveryAddress string, pickupPointID *int) (*models.Purchase, error) {
+	purchase := &models.Purchase{
+		ListingID:       listingId,
+		Price:           price,
+		TaxAmount:       taxAmount,
+		BankTxID:        bankTxId,
+		DeliveryAddress: deliveryAddress,
+		PickupPointID:   pickupPointID,
+		Status:          models.PurchaseStatusPendingReview,
+		OrderStatus:     models.OrderStatusPending,
+	}
+
+	err := q.QueryRow(
+		`INSERT INTO purchases (listing_id, price, tax_amount, bank_tx_id, delivery_address, pickup_point_id, status, order_status, created_at) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW()) RETURNING id, created_at`,
+		listingId, price, taxAmount, bankTxId, deliveryAddress, pickupPointID,
+		purchase.Status, purchase.OrderStatus).Scan(&purchase.ID, &purchase.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return purchase, nil
+}
+
+// GetPickupPoint fetches a pickup point by ID
+func (r *Repository) GetPickupPoint(id int) (_ *models.PickupPoint, err error) {
+	defer r.observe("GetPickupPoint", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Fetching pickup point with ID: %d", id)
+
+	var point models.PickupPoint
+	err = r.db.QueryRow(
+		"SELECT id, name, address, latitude, longitude FROM pickup_points WHERE id = $1", id).
+		Scan(&point.ID, &point.Name, &point.Address, &point.Latitude, &point.Longitude)
+	if err != nil {
+		log.Printf("[DB] Error fetching pickup point: %v", err)
+		return nil, err
+	}
+
+	return &point, nil
+}
+
+// GetNearestPickupPoints fetches the pickup points closest to the given coordinates,
+// using the haversine great-circle distance in kilometers
+func (r *Repository) GetNearestPickupPoints(lat, lon float64, limit int) (_ []*models.PickupPoint, err error) {
+	defer r.observe("GetNearestPickupPoints", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Fetching %d nearest pickup points to (%f, %f)", limit, lat, lon)
+
+	rows, err := r.db.Query(
+		`SELECT id, name, address, latitude, longitude, distance_km FROM (
+			SELECT id, name, address, latitude, longitude, 
+			2 * 6371 * asin(sqrt(
+				power(sin(radians(latitude - $1) / 2), 2) + 
+				cos(radians($1)) * cos(radians(latitude)) * power(sin(radians(longitude - $2) / 2), 2)
+			)) AS distance_km 
+			FROM pickup_points
+		) points 
+		ORDER BY distance_km, id LIMIT $3`,
+		lat, lon, limit)
+	if err != nil {
+		log.Printf("[DB] Error fetching pickup points: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []*models.PickupPoint{}
+	for rows.Next() {
+		var point models.PickupPoint
+		var distance float64
+		err := rows.Scan(&point.ID, &point.Name, &point.Address, &point.Latitude, &point.Longitude, &distance)
+		if err != nil {
+			log.Printf("[DB] Error scanning pickup point row: %v", err)
+			return nil, err
+		}
+		point.DistanceKm = &distance
+		points = append(points, &point)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("[DB] Error iterating pickup point rows: %v", err)
+		return nil, err
+	}
+
+	log.Printf("[DB] Found %d pickup points", len(points))
+	return points, nil
+}
+
+// GetDelivery fetches a delivery by ID
+func (r *Repository) GetDelivery(id int) (_ *models.Delivery, err error) {
+	defer r.observe("GetDelivery", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Fetching delivery with ID: %d", id)
+
+	var delivery models.Delivery
+	err = r.db.QueryRow(
+		"SELECT id, purchase_id, timestamp, status, scheduled_for, attempt_number FROM deliveries WHERE id = $1", id).
+		Scan(&delivery.ID, &delivery.PurchaseID, &delivery.Timestamp, &delivery.Status,
+			&delivery.ScheduledFor, &delivery.AttemptNumber)
+	if err != nil {
+		log.Printf("[DB] Error fetching delivery: %v", err)
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+// buildDeliveryWhere builds the WHERE clause and arguments for a delivery filter
+func buildDeliveryWhere(filter *models.DeliveryFilter) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	argCount := 1
+
+	if filter != nil {
+		if filter.PurchaseID != nil {
+			conditions = append(conditions, fmt.Sprintf("purchase_id = $%d", argCount))
+			args = append(args, *filter.PurchaseID)
+			argCount++
+		}
 
 		if filter.Status != nil {
 			conditions = append(conditions, fmt.Sprintf("status = $%d", argCount))
@@ -341,6 +1931,18 @@ func (r *Repository) GetDeliveries(filter *models.DeliveryFilter) ([]*models.Del
 			argCount++
 		}
 
+		if filter.StatusIn != nil {
+			conditions = append(conditions, fmt.Sprintf("status = ANY($%d)", argCount))
+			args = append(args, pq.Array(filter.StatusIn))
+			argCount++
+		}
+
+		if filter.StatusNot != nil {
+			conditions = append(conditions, fmt.Sprintf("status <> $%d", argCount))
+			args = append(args, *filter.StatusNot)
+			argCount++
+		}
+
 		if filter.FromDate != nil {
 			conditions = append(conditions, fmt.Sprintf("timestamp >= $%d", argCount))
 			args = append(args, *filter.FromDate)
@@ -352,14 +1954,46 @@ func (r *Repository) GetDeliveries(filter *models.DeliveryFilter) ([]*models.Del
 			args = append(args, *filter.ToDate)
 			argCount++
 		}
+
+		if filter.ScheduledFrom != nil {
+			conditions = append(conditions, fmt.Sprintf("scheduled_for >= $%d", argCount))
+			args = append(args, *filter.ScheduledFrom)
+			argCount++
+		}
+
+		if filter.ScheduledTo != nil {
+			conditions = append(conditions, fmt.Sprintf("scheduled_for <= $%d", argCount))
+			args = append(args, *filter.ScheduledTo)
+			argCount++
+		}
+	}
+
+	if len(conditions) == 0 {
+		return "", args
 	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
 
-	if len(conditions) > 0 {
-		query += " WHERE " + strings.Join(conditions, " AND ")
+// GetDeliveries fetches deliveries with optional filtering
+func (r *Repository) GetDeliveries(filter *models.DeliveryFilter) (_ []*models.Delivery, err error) {
+	defer r.observe("GetDeliveries", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
 	}
+	log.Printf("[DB] Fetching deliveries with filter")
 
-	// Add order by timestamp
-	query += " ORDER BY timestamp DESC"
+	query := "SELECT id, purchase_id, timestamp, status, scheduled_for, attempt_number FROM deliveries"
+
+	where, args := buildDeliveryWhere(filter)
+	query += where
+
+	// Add order by timestamp, breaking ties by ID so pages are stable
+	query += " ORDER BY timestamp DESC, id DESC"
+	if filter != nil {
+		var limit string
+		limit, args = limitOffset(filter.Limit, filter.Offset, args)
+		query += limit
+	}
 
 	log.Printf("[DB] Executing query: %s with %d args", query, len(args))
 
@@ -370,10 +2004,11 @@ func (r *Repository) GetDeliveries(filter *models.DeliveryFilter) ([]*models.Del
 	}
 	defer rows.Close()
 
-	var deliveries []*models.Delivery
+	deliveries := []*models.Delivery{}
 	for rows.Next() {
 		var delivery models.Delivery
-		err := rows.Scan(&delivery.ID, &delivery.PurchaseID, &delivery.Timestamp, &delivery.Status)
+		err := rows.Scan(&delivery.ID, &delivery.PurchaseID, &delivery.Timestamp, &delivery.Status,
+			&delivery.ScheduledFor, &delivery.AttemptNumber)
 		if err != nil {
 			log.Printf("[DB] Error scanning delivery row: %v", err)
 			return nil, err
@@ -391,11 +2026,15 @@ func (r *Repository) GetDeliveries(filter *models.DeliveryFilter) ([]*models.Del
 }
 
 // GetDeliveriesByPurchaseID fetches all deliveries for a specific purchase
-func (r *Repository) GetDeliveriesByPurchaseID(purchaseID int) ([]*models.Delivery, error) {
+func (r *Repository) GetDeliveriesByPurchaseID(purchaseID int) (_ []*models.Delivery, err error) {
+	defer r.observe("GetDeliveriesByPurchaseID", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
 	log.Printf("[DB] Fetching deliveries for purchase ID: %d", purchaseID)
 
 	rows, err := r.db.Query(
-		"SELECT id, purchase_id, timestamp, status FROM deliveries WHERE purchase_id = $1 ORDER BY timestamp DESC",
+		"SELECT id, purchase_id, timestamp, status, scheduled_for, attempt_number FROM deliveries WHERE purchase_id = $1 ORDER BY timestamp DESC",
 		purchaseID)
 	if err != nil {
 		log.Printf("[DB] Error fetching deliveries: %v", err)
@@ -403,10 +2042,11 @@ func (r *Repository) GetDeliveriesByPurchaseID(purchaseID int) ([]*models.Delive
 	}
 	defer rows.Close()
 
-	var deliveries []*models.Delivery
+	deliveries := []*models.Delivery{}
 	for rows.Next() {
 		var delivery models.Delivery
-		err := rows.Scan(&delivery.ID, &delivery.PurchaseID, &delivery.Timestamp, &delivery.Status)
+		err := rows.Scan(&delivery.ID, &delivery.PurchaseID, &delivery.Timestamp, &delivery.Status,
+			&delivery.ScheduledFor, &delivery.AttemptNumber)
 		if err != nil {
 			log.Printf("[DB] Error scanning delivery row: %v", err)
 			return nil, err
@@ -423,31 +2063,587 @@ func (r *Repository) GetDeliveriesByPurchaseID(purchaseID int) ([]*models.Delive
 	return deliveries, nil
 }
 
-// CreateDelivery inserts a new delivery status update
-func (r *Repository) CreateDelivery(purchaseID int, status string) (*models.Delivery, error) {
-	log.Printf("[DB] Creating new delivery for purchase ID: %d with status: %s", purchaseID, status)
+// GetDeliveriesSince fetches the deliveries recorded after the delivery with the
+// given ID, oldest first, so reconnecting subscribers can replay missed updates.
+// A nil purchaseID returns deliveries for all purchases; a zero limit returns all of them
+func (r *Repository) GetDeliveriesSince(purchaseID *int, afterID, limit int) (_ []*models.Delivery, err error) {
+	defer r.observe("GetDeliveriesSince", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Fetching deliveries after ID: %d", afterID)
 
-	var id int
-	var timestamp time.Time
+	query := "SELECT id, purchase_id, timestamp, status, scheduled_for, attempt_number FROM deliveries WHERE id > $1"
+	args := []interface{}{afterID}
+	if purchaseID != nil {
+		args = append(args, *purchaseID)
+		query += fmt.Sprintf(" AND purchase_id = $%d", len(args))
+	}
+	query += " ORDER BY id"
+	if limit > 0 {
+		args = append(args, limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		log.Printf("[DB] Error fetching deliveries: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*models.Delivery{}
+	for rows.Next() {
+		var delivery models.Delivery
+		err := rows.Scan(&delivery.ID, &delivery.PurchaseID, &delivery.Timestamp, &delivery.Status,
+			&delivery.ScheduledFor, &delivery.AttemptNumber)
+		if err != nil {
+			log.Printf("[DB] Error scanning delivery row: %v", err)
+			return nil, err
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("[DB] Error iterating delivery rows: %v", err)
+		return nil, err
+	}
+
+	log.Printf("[DB] Found %d deliveries after ID %d", len(deliveries), afterID)
+	return deliveries, nil
+}
+
+// GetLatestDelivery fetches the most recent delivery for a specific purchase
+func (r *Repository) GetLatestDelivery(purchaseID int) (_ *models.Delivery, err error) {
+	defer r.observe("GetLatestDelivery", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Fetching latest delivery for purchase ID: %d", purchaseID)
+
+	var delivery models.Delivery
+	err = r.db.QueryRow(
+		"SELECT id, purchase_id, timestamp, status, scheduled_for, attempt_number FROM deliveries WHERE purchase_id = $1 ORDER BY timestamp DESC LIMIT 1",
+		purchaseID).
+		Scan(&delivery.ID, &delivery.PurchaseID, &delivery.Timestamp, &delivery.Status,
+			&delivery.ScheduledFor, &delivery.AttemptNumber)
+	if err != nil {
+		log.Printf("[DB] Error fetching latest delivery: %v", err)
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+// GetDeliveryTimeline counts the deliveries of a purchase per day and status
+func (r *Repository) GetDeliveryTimeline(purchaseID int) (_ []*models.DeliveryTimelineDay, err error) {
+	defer r.observe("GetDeliveryTimeline", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Fetching delivery timeline for purchase ID: %d", purchaseID)
+
+	rows, err := r.db.Query(
+		`SELECT date_trunc('day', timestamp) AS day, status, COUNT(*) 
+		FROM deliveries WHERE purchase_id = $1 
+		GROUP BY day, status ORDER BY day, status`,
+		purchaseID)
+	if err != nil {
+		log.Printf("[DB] Error fetching delivery timeline: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	days := []*models.DeliveryTimelineDay{}
+	for rows.Next() {
+		var day time.Time
+		var count models.DeliveryStatusCount
+		err := rows.Scan(&day, &count.Status, &count.Count)
+		if err != nil {
+			log.Printf("[DB] Error scanning delivery timeline row: %v", err)
+			return nil, err
+		}
+
+		// Rows are ordered by day, so a new bucket starts whenever the day changes
+		if len(days) == 0 || !days[len(days)-1].Date.Equal(day) {
+			days = append(days, &models.DeliveryTimelineDay{Date: day})
+		}
+		last := days[len(days)-1]
+		last.StatusCounts = append(last.StatusCounts, count)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("[DB] Error iterating delivery timeline rows: %v", err)
+		return nil, err
+	}
+
+	log.Printf("[DB] Found %d timeline days for purchase ID %d", len(days), purchaseID)
+	return days, nil
+}
 
-	err := r.db.QueryRow(
-		`INSERT INTO deliveries (purchase_id, timestamp, status) 
-		VALUES ($1, NOW(), $2) RETURNING id, timestamp`,
-		purchaseID, status).Scan(&id, &timestamp)
+// CreateDelivery inserts a new delivery status update, continuing the current delivery attempt
+func (r *Repository) CreateDelivery(purchaseID int, status string) (_ *models.Delivery, err error) {
+	defer r.observe("CreateDelivery", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Creating new delivery for purchase ID: %d with status: %s", purchaseID, status)
 
+	delivery, err := insertDelivery(r.db, purchaseID, status)
 	if err != nil {
 		log.Printf("[DB] Error creating delivery: %v", err)
 		return nil, err
 	}
 
-	// Return the newly created delivery
+	log.Printf("[DB] Created new delivery with ID: %d", delivery.ID)
+	return delivery, nil
+}
+
+// insertDelivery inserts a delivery update continuing the latest attempt of the purchase
+func insertDelivery(q queryRower, purchaseID int, status string) (*models.Delivery, error) {
 	delivery := &models.Delivery{
-		ID:         id,
 		PurchaseID: purchaseID,
-		Timestamp:  timestamp,
 		Status:     status,
 	}
 
-	log.Printf("[DB] Created new delivery with ID: %d", id)
+	err := q.QueryRow(
+		`INSERT INTO deliveries (purchase_id, timestamp, status, attempt_number) 
+		VALUES ($1, NOW(), $2, COALESCE((SELECT MAX(attempt_number) FROM deliveries WHERE purchase_id = $1), 1)) 
+		RETURNING id, timestamp, attempt_number`,
+		purchaseID, status).Scan(&delivery.ID, &delivery.Timestamp, &delivery.AttemptNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	return delivery, nil
+}
+
+// RescheduleDelivery records a rescheduled status update that starts a new delivery attempt
+func (r *Repository) RescheduleDelivery(purchaseID int, scheduledFor time.Time) (_ *models.Delivery, err error) {
+	defer r.observe("RescheduleDelivery", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Rescheduling delivery for purchase ID: %d to %s", purchaseID, scheduledFor.Format(time.RFC3339))
+
+	delivery := &models.Delivery{
+		PurchaseID:   purchaseID,
+		Status:       "rescheduled",
+		ScheduledFor: &scheduledFor,
+	}
+
+	err = r.db.QueryRow(
+		`INSERT INTO deliveries (purchase_id, timestamp, status, scheduled_for, attempt_number) 
+		VALUES ($1, NOW(), 'rescheduled', $2, COALESCE((SELECT MAX(attempt_number) FROM deliveries WHERE purchase_id = $1), 0) + 1) 
+		RETURNING id, timestamp, attempt_number`,
+		purchaseID, scheduledFor).Scan(&delivery.ID, &delivery.Timestamp, &delivery.AttemptNumber)
+
+	if err != nil {
+		log.Printf("[DB] Error rescheduling delivery: %v", err)
+		return nil, err
+	}
+
+	log.Printf("[DB] Rescheduled delivery with ID: %d, attempt %d", delivery.ID, delivery.AttemptNumber)
+	return delivery, nil
+}
+
+// AdvanceDelivery records a new status update following the given delivery, continuing
+// its attempt. It returns sql.ErrNoRows when the delivery does not exist or is no
+// longer the latest update of its purchase, so concurrent updates can't both apply
+func (r *Repository) AdvanceDelivery(deliveryID int, status string) (_ *models.Delivery, err error) {
+	defer r.observe("AdvanceDelivery", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Advancing delivery ID: %d to status: %s", deliveryID, status)
+
+	delivery := &models.Delivery{Status: status}
+	err = r.db.QueryRow(
+		`INSERT INTO deliveries (purchase_id, timestamp, status, attempt_number) 
+		SELECT d.purchase_id, NOW(), $2, d.attempt_number FROM deliveries d 
+		WHERE d.id = $1 AND NOT EXISTS (SELECT 1 FROM deliveries WHERE purchase_id = d.purchase_id AND id > d.id) 
+		RETURNING id, purchase_id, timestamp, attempt_number`,
+		deliveryID, status).Scan(&delivery.ID, &delivery.PurchaseID, &delivery.Timestamp, &delivery.AttemptNumber)
+	if err != nil {
+		log.Printf("[DB] Error advancing delivery: %v", err)
+		return nil, err
+	}
+
+	log.Printf("[DB] Created new delivery with ID: %d", delivery.ID)
 	return delivery, nil
 }
+
+// GetSellerWebhook fetches the webhook of a seller, returning sql.ErrNoRows if none is registered
+func (r *Repository) GetSellerWebhook(sellerID int) (_ *models.SellerWebhook, err error) {
+	defer r.observe("GetSellerWebhook", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Fetching webhook for seller ID: %d", sellerID)
+
+	hook := models.SellerWebhook{SellerID: sellerID}
+	err = r.db.QueryRow(
+		"SELECT url, secret, created_at FROM seller_webhooks WHERE seller_id = $1", sellerID).
+		Scan(&hook.URL, &hook.Secret, &hook.CreatedAt)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("[DB] Error fetching seller webhook: %v", err)
+		}
+		return nil, err
+	}
+
+	return &hook, nil
+}
+
+// SetSellerWebhook registers or replaces the webhook of a seller
+func (r *Repository) SetSellerWebhook(sellerID int, url, secret string) (_ *models.SellerWebhook, err error) {
+	defer r.observe("SetSellerWebhook", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Setting webhook for seller ID: %d to %s", sellerID, url)
+
+	hook := &models.SellerWebhook{SellerID: sellerID, URL: url, Secret: secret}
+	err = r.db.QueryRow(
+		`INSERT INTO seller_webhooks (seller_id, url, secret, created_at) VALUES ($1, $2, $3, NOW()) 
+		ON CONFLICT (seller_id) DO UPDATE SET url = EXCLUDED.url, secret = EXCLUDED.secret, created_at = EXCLUDED.created_at 
+		RETURNING created_at`,
+		sellerID, url, secret).Scan(&hook.CreatedAt)
+	if err != nil {
+		log.Printf("[DB] Error setting seller webhook: %v", err)
+		return nil, err
+	}
+
+	return hook, nil
+}
+
+// DeleteSellerWebhook removes the webhook of a seller, reporting whether one was registered
+func (r *Repository) DeleteSellerWebhook(sellerID int) (_ bool, err error) {
+	defer r.observe("DeleteSellerWebhook", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Deleting webhook for seller ID: %d", sellerID)
+
+	result, err := r.db.Exec("DELETE FROM seller_webhooks WHERE seller_id = $1", sellerID)
+	if err != nil {
+		log.Printf("[DB] Error deleting seller webhook: %v", err)
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// CountSellerListings counts the listings of a seller
+func (r *Repository) CountSellerListings(sellerID int) (_ int, err error) {
+	defer r.observe("CountSellerListings", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Counting listings of seller ID: %d", sellerID)
+
+	var count int
+	err = r.db.QueryRow("SELECT COUNT(*) FROM listings WHERE seller_id = $1", sellerID).Scan(&count)
+	if err != nil {
+		log.Printf("[DB] Error counting seller listings: %v", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// GetSellerQuota fetches the listing limit set for a seller, returning sql.ErrNoRows if none is set
+func (r *Repository) GetSellerQuota(sellerID int) (_ *models.SellerQuota, err error) {
+	defer r.observe("GetSellerQuota", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Fetching quota for seller ID: %d", sellerID)
+
+	quota := models.SellerQuota{SellerID: sellerID}
+	err = r.db.QueryRow(
+		"SELECT max_listings, updated_at FROM seller_quotas WHERE seller_id = $1", sellerID).
+		Scan(&quota.MaxListings, &quota.UpdatedAt)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("[DB] Error fetching seller quota: %v", err)
+		}
+		return nil, err
+	}
+
+	return &quota, nil
+}
+
+// SetSellerQuota sets or replaces the listing limit of a seller
+func (r *Repository) SetSellerQuota(sellerID, maxListings int) (_ *models.SellerQuota, err error) {
+	defer r.observe("SetSellerQuota", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Setting quota for seller ID: %d to %d listings", sellerID, maxListings)
+
+	quota := &models.SellerQuota{SellerID: sellerID, MaxListings: maxListings}
+	err = r.db.QueryRow(
+		`INSERT INTO seller_quotas (seller_id, max_listings, updated_at) VALUES ($1, $2, NOW())
+		ON CONFLICT (seller_id) DO UPDATE SET max_listings = EXCLUDED.max_listings, updated_at = EXCLUDED.updated_at
+		RETURNING updated_at`,
+		sellerID, maxListings).Scan(&quota.UpdatedAt)
+	if err != nil {
+		log.Printf("[DB] Error setting seller quota: %v", err)
+		return nil, err
+	}
+
+	return quota, nil
+}
+
+// DeleteSellerQuota removes the listing limit set for a seller, reporting whether one was set
+func (r *Repository) DeleteSellerQuota(sellerID int) (_ bool, err error) {
+	defer r.observe("DeleteSellerQuota", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Deleting quota for seller ID: %d", sellerID)
+
+	result, err := r.db.Exec("DELETE FROM seller_quotas WHERE seller_id = $1", sellerID)
+	if err != nil {
+		log.Printf("[DB] Error deleting seller quota: %v", err)
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// GetSellerSales counts the sales of a seller's listings and sums their revenue
+// within the optional [from, to] period. Rejected and canceled purchases don't
+// count as sales
+func (r *Repository) GetSellerSales(sellerID int, from, to *time.Time) (_ *models.SalesSummary, err error) {
+	defer r.observe("GetSellerSales", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Fetching sales of seller ID: %d", sellerID)
+
+	query := `SELECT COUNT(p.id), COALESCE(SUM(p.price), 0) FROM purchases p 
+		JOIN listings l ON l.id = p.listing_id 
+		WHERE l.seller_id = $1 AND p.status NOT IN ($2, $3)`
+	args := []interface{}{sellerID, models.PurchaseStatusRejected, models.PurchaseStatusCanceled}
+
+	if from != nil {
+		args = append(args, *from)
+		query += fmt.Sprintf(" AND p.created_at >= $%d", len(args))
+	}
+	if to != nil {
+		args = append(args, *to)
+		query += fmt.Sprintf(" AND p.created_at <= $%d", len(args))
+	}
+
+	summary := models.SalesSummary{SellerID: sellerID, From: from, To: to}
+	err = r.db.QueryRow(query, args...).Scan(&summary.Sales, &summary.Revenue)
+	if err != nil {
+		log.Printf("[DB] Error fetching seller sales: %v", err)
+		return nil, err
+	}
+
+	return &summary, nil
+}
+
+// GetAllSellerSales counts the sales and sums the revenue of every seller over
+// all time, including sellers without sales. Rejected and canceled purchases
+// don't count as sales
+func (r *Repository) GetAllSellerSales() (_ []*models.SalesSummary, err error) {
+	defer r.observe("GetAllSellerSales", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Fetching sales of all sellers")
+
+	rows, err := r.db.Query(
+		`SELECT s.id, COUNT(p.id), COALESCE(SUM(p.price), 0) FROM sellers s 
+		LEFT JOIN listings l ON l.seller_id = s.id 
+		LEFT JOIN purchases p ON p.listing_id = l.id AND p.status NOT IN ($1, $2) 
+		GROUP BY s.id ORDER BY s.id`,
+		models.PurchaseStatusRejected, models.PurchaseStatusCanceled)
+	if err != nil {
+		log.Printf("[DB] Error fetching seller sales: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var summaries []*models.SalesSummary
+	for rows.Next() {
+		var summary models.SalesSummary
+		if err := rows.Scan(&summary.SellerID, &summary.Sales, &summary.Revenue); err != nil {
+			log.Printf("[DB] Error scanning seller sales: %v", err)
+			return nil, err
+		}
+		summaries = append(summaries, &summary)
+	}
+	if err = rows.Err(); err != nil {
+		log.Printf("[DB] Error iterating seller sales: %v", err)
+		return nil, err
+	}
+
+	log.Printf("[DB] Fetched sales of %d sellers", len(summaries))
+	return summaries, nil
+}
+
+// GetSellerDigests aggregates the purchases and delivery updates of every seller
+// opted in to the daily digest within [from, to). Rejected and canceled purchases
+// don't count as sales
+func (r *Repository) GetSellerDigests(from, to time.Time) (_ []*models.SellerDigest, err error) {
+	defer r.observe("GetSellerDigests", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Fetching seller digests from %s to %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+
+	rows, err := r.db.Query(
+		`SELECT s.id, COUNT(p.id), COALESCE(SUM(p.price), 0) FROM sellers s 
+		LEFT JOIN listings l ON l.seller_id = s.id 
+		LEFT JOIN purchases p ON p.listing_id = l.id AND p.created_at >= $1 AND p.created_at < $2 AND p.status NOT IN ($3, $4) 
+		WHERE s.digest_opt_in GROUP BY s.id ORDER BY s.id`,
+		from, to, models.PurchaseStatusRejected, models.PurchaseStatusCanceled)
+	if err != nil {
+		log.Printf("[DB] Error fetching seller sales: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	digests := []*models.SellerDigest{}
+	bySeller := make(map[int]*models.SellerDigest)
+	for rows.Next() {
+		digest := &models.SellerDigest{From: from, To: to, DeliveryStatusCounts: []models.DeliveryStatusCount{}}
+		if err := rows.Scan(&digest.SellerID, &digest.Purchases, &digest.Revenue); err != nil {
+			log.Printf("[DB] Error scanning seller sales row: %v", err)
+			return nil, err
+		}
+		digests = append(digests, digest)
+		bySeller[digest.SellerID] = digest
+	}
+	if err = rows.Err(); err != nil {
+		log.Printf("[DB] Error iterating seller sales rows: %v", err)
+		return nil, err
+	}
+
+	rows, err = r.db.Query(
+		`SELECT l.seller_id, d.status, COUNT(*) FROM deliveries d 
+		JOIN purchases p ON p.id = d.purchase_id 
+		JOIN listings l ON l.id = p.listing_id 
+		JOIN sellers s ON s.id = l.seller_id 
+		WHERE s.digest_opt_in AND d.timestamp >= $1 AND d.timestamp < $2 
+		GROUP BY l.seller_id, d.status ORDER BY l.seller_id, d.status`,
+		from, to)
+	if err != nil {
+		log.Printf("[DB] Error fetching seller delivery stats: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sellerID int
+		var count models.DeliveryStatusCount
+		if err := rows.Scan(&sellerID, &count.Status, &count.Count); err != nil {
+			log.Printf("[DB] Error scanning seller delivery row: %v", err)
+			return nil, err
+		}
+		if digest, ok := bySeller[sellerID]; ok {
+			digest.DeliveryStatusCounts = append(digest.DeliveryStatusCounts, count)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		log.Printf("[DB] Error iterating seller delivery rows: %v", err)
+		return nil, err
+	}
+
+	log.Printf("[DB] Built %d seller digests", len(digests))
+	return digests, nil
+}
+
+// GetExportBookmark returns the last row ID exported by the named export, or zero if it never ran
+func (r *Repository) GetExportBookmark(name string) (_ int, err error) {
+	defer r.observe("GetExportBookmark", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Fetching export bookmark: %s", name)
+
+	var lastID int
+	err = r.db.QueryRow("SELECT last_id FROM export_bookmarks WHERE name = $1", name).Scan(&lastID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		log.Printf("[DB] Error fetching export bookmark: %v", err)
+		return 0, err
+	}
+
+	return lastID, nil
+}
+
+// SetExportBookmark records the last row ID exported by the named export
+func (r *Repository) SetExportBookmark(name string, lastID int) (err error) {
+	defer r.observe("SetExportBookmark", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Setting export bookmark %s to ID %d", name, lastID)
+
+	_, err = r.db.Exec(
+		`INSERT INTO export_bookmarks (name, last_id, updated_at) VALUES ($1, $2, NOW()) 
+		ON CONFLICT (name) DO UPDATE SET last_id = EXCLUDED.last_id, updated_at = EXCLUDED.updated_at`,
+		name, lastID)
+	if err != nil {
+		log.Printf("[DB] Error setting export bookmark: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// RecordImpersonation audits an operation an admin runs as subject in role
+func (r *Repository) RecordImpersonation(role, subject, clientName, remoteAddr, operationName, query string) (err error) {
+	defer r.observe("RecordImpersonation", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Recording impersonation of %s %s by client %s", role, subject, clientName)
+
+	_, err = r.db.Exec(
+		`INSERT INTO impersonation_audit (role, subject, client_name, remote_addr, operation_name, query, created_at) 
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())`,
+		role, subject, clientName, remoteAddr, operationName, query)
+	if err != nil {
+		log.Printf("[DB] Error recording impersonation: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// RecordModerationDecision stores a rejected or flagged piece of content; contentID
+// is nil when the content was rejected before it was stored
+func (r *Repository) RecordModerationDecision(contentType string, contentID *int, content, action, reason string) (err error) {
+	defer r.observe("RecordModerationDecision", time.Now(), &err)
+	if err = r.breaker.allow(); err != nil {
+		return
+	}
+	log.Printf("[DB] Recording moderation decision %s for %s: %s", action, contentType, reason)
+
+	_, err = r.db.Exec(
+		`INSERT INTO moderation_decisions (content_type, content_id, content, action, reason, created_at) 
+		VALUES ($1, $2, $3, $4, $5, NOW())`,
+		contentType, contentID, content, action, reason)
+	if err != nil {
+		log.Printf("[DB] Error recording moderation decision: %v", err)
+		return err
+	}
+
+	return nil
+}
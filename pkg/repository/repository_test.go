@@ -2,11 +2,20 @@ package repository
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/korjavin/graphqlTinyExample/pkg/metrics"
 	"github.com/korjavin/graphqlTinyExample/pkg/models"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func setupMockDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock, *Repository) {
@@ -19,6 +28,53 @@ func setupMockDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock, *Repository) {
 	return db, mock, repo
 }
 
+func TestListsAreEmptyNotNil(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery("FROM sellers").WillReturnRows(sqlmock.NewRows([]string{"id", "name", "address", "digest_opt_in"}))
+	mock.ExpectQuery("FROM listings").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery("FROM purchases").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery("FROM deliveries").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	sellers, err := repo.GetAllSellers()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	listings, err := repo.GetListings(nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	purchases, err := repo.GetPurchases(nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	deliveries, err := repo.GetDeliveries(nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Empty results must encode as [] rather than null
+	for name, list := range map[string]interface{}{
+		"sellers":    sellers,
+		"listings":   listings,
+		"purchases":  purchases,
+		"deliveries": deliveries,
+	} {
+		data, err := json.Marshal(list)
+		if err != nil {
+			t.Fatalf("Failed to marshal %s: %v", name, err)
+		}
+		if string(data) != "[]" {
+			t.Errorf("Expected empty %s to encode as [], got %s", name, data)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
 func TestGetSeller(t *testing.T) {
 	db, mock, repo := setupMockDB(t)
 	defer db.Close()
@@ -31,10 +87,10 @@ func TestGetSeller(t *testing.T) {
 	}
 
 	// Setup expectations
-	rows := sqlmock.NewRows([]string{"id", "name", "address"}).
-		AddRow(expectedSeller.ID, expectedSeller.Name, expectedSeller.Address)
+	rows := sqlmock.NewRows([]string{"id", "name", "address", "digest_opt_in"}).
+		AddRow(expectedSeller.ID, expectedSeller.Name, expectedSeller.Address, false)
 
-	mock.ExpectQuery("SELECT id, name, address FROM sellers WHERE id = \\$1").
+	mock.ExpectQuery("SELECT id, name, address, digest_opt_in FROM sellers WHERE id = \\$1").
 		WithArgs(1).
 		WillReturnRows(rows)
 
@@ -72,12 +128,12 @@ func TestGetAllSellers(t *testing.T) {
 	}
 
 	// Setup expectations
-	rows := sqlmock.NewRows([]string{"id", "name", "address"})
+	rows := sqlmock.NewRows([]string{"id", "name", "address", "digest_opt_in"})
 	for _, s := range expectedSellers {
-		rows.AddRow(s.ID, s.Name, s.Address)
+		rows.AddRow(s.ID, s.Name, s.Address, s.DigestOptIn)
 	}
 
-	mock.ExpectQuery("SELECT id, name, address FROM sellers").
+	mock.ExpectQuery("SELECT id, name, address, digest_opt_in FROM sellers").
 		WillReturnRows(rows)
 
 	// Execute the function
@@ -109,14 +165,205 @@ func TestGetAllSellers(t *testing.T) {
 	}
 }
 
+func TestCreateAndUpdateSeller(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery("INSERT INTO sellers \\(name, address, digest_opt_in\\) VALUES \\(\\$1, \\$2, \\$3\\) RETURNING id").
+		WithArgs("New Seller", "1 Market St", false).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+
+	address := "2 Market St"
+	mock.ExpectQuery("UPDATE sellers SET name = COALESCE\\(\\$2, name\\), address = COALESCE\\(\\$3, address\\), digest_opt_in = COALESCE\\(\\$4, digest_opt_in\\) WHERE id = \\$1").
+		WithArgs(4, nil, &address, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "address", "digest_opt_in"}).AddRow(4, "New Seller", address, false))
+
+	seller, err := repo.CreateSeller("New Seller", "1 Market St", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if seller.ID != 4 {
+		t.Errorf("Expected seller ID 4, got %d", seller.ID)
+	}
+
+	seller, err = repo.UpdateSeller(4, nil, &address, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if seller.Address != address {
+		t.Errorf("Expected address %s, got %s", address, seller.Address)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreateListings(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery("INSERT INTO listings \\(seller_id, title, description, price\\) VALUES \\(\\$1, \\$2, \\$3, \\$4\\), \\(\\$5, \\$6, \\$7, \\$8\\) RETURNING id").
+		WithArgs(1, "Bike", "Red", models.Money(1000), 2, "Lamp", "Blue", models.Money(500)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7).AddRow(8))
+
+	listings, err := repo.CreateListings([]models.NewListing{
+		{SellerID: 1, Title: "Bike", Description: "Red", Price: 1000},
+		{SellerID: 2, Title: "Lamp", Description: "Blue", Price: 500},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	if len(listings) != 2 || listings[0].ID != 7 || listings[1].ID != 8 || listings[1].Title != "Lamp" {
+		t.Errorf("Expected listings 7 and 8 in input order, got %+v", listings)
+	}
+}
+
+func TestDeleteSeller(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM sellers WHERE id = \\$1 FOR UPDATE").
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM listings WHERE seller_id = \\$1").
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec("DELETE FROM seller_webhooks WHERE seller_id = \\$1").
+		WithArgs(4).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM seller_quotas WHERE seller_id = \\$1").
+		WithArgs(4).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM sellers WHERE id = \\$1").
+		WithArgs(4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := repo.DeleteSeller(4); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDeleteSellerWithListings(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM sellers WHERE id = \\$1 FOR UPDATE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM listings WHERE seller_id = \\$1").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectRollback()
+
+	if err := repo.DeleteSeller(1); err != ErrSellerHasListings {
+		t.Errorf("Expected ErrSellerHasListings, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	// The own transaction of DeleteSeller becomes a savepoint of the dry run,
+	// and nothing is committed
+	mock.ExpectBegin()
+	mock.ExpectExec("SAVEPOINT dry_run").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT id FROM sellers WHERE id = \\$1 FOR UPDATE").
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM listings WHERE seller_id = \\$1").
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec("DELETE FROM seller_webhooks WHERE seller_id = \\$1").
+		WithArgs(4).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM seller_quotas WHERE seller_id = \\$1").
+		WithArgs(4).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM sellers WHERE id = \\$1").
+		WithArgs(4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("RELEASE SAVEPOINT dry_run").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT id, name, address, digest_opt_in FROM sellers WHERE id = \\$1").
+		WithArgs(4).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	dryRun, rollback, err := repo.DryRun()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := dryRun.DeleteSeller(4); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// The deletion is visible within the dry run
+	if _, err := dryRun.GetSeller(4); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected the seller to be gone within the dry run, got %v", err)
+	}
+	rollback()
+
+	if _, _, err := dryRun.DryRun(); err == nil {
+		t.Errorf("Expected nested dry runs to be refused")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDryRunRollsBackFailedSavepoint(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SAVEPOINT dry_run").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT id FROM sellers WHERE id = \\$1 FOR UPDATE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM listings WHERE seller_id = \\$1").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectExec("ROLLBACK TO SAVEPOINT dry_run").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	dryRun, rollback, err := repo.DryRun()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := dryRun.DeleteSeller(1); err != ErrSellerHasListings {
+		t.Errorf("Expected ErrSellerHasListings, got %v", err)
+	}
+	rollback()
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
 func TestGetListings(t *testing.T) {
 	db, mock, repo := setupMockDB(t)
 	defer db.Close()
 
 	// Define test data
 	sellerId := 1
-	minPrice := 50.0
-	maxPrice := 100.0
+	minPrice := models.Money(5000)
+	maxPrice := models.Money(10000)
 	title := "test"
 
 	filter := &models.ListingFilter{
@@ -152,8 +399,8 @@ func TestGetListings(t *testing.T) {
 	if listings[0].SellerID != sellerId {
 		t.Errorf("Expected seller ID %d, got %d", sellerId, listings[0].SellerID)
 	}
-	if listings[0].Price != 75.0 {
-		t.Errorf("Expected price %.2f, got %.2f", 75.0, listings[0].Price)
+	if listings[0].Price != 7500 {
+		t.Errorf("Expected price %s, got %s", "75.00", listings[0].Price)
 	}
 }
 
@@ -176,10 +423,10 @@ func TestGetDeliveries(t *testing.T) {
 	}
 
 	// Setup expectations
-	rows := sqlmock.NewRows([]string{"id", "purchase_id", "timestamp", "status"}).
-		AddRow(1, purchaseId, now.Add(-12*time.Hour), status)
+	rows := sqlmock.NewRows([]string{"id", "purchase_id", "timestamp", "status", "scheduled_for", "attempt_number"}).
+		AddRow(1, purchaseId, now.Add(-12*time.Hour), status, nil, 1)
 
-	mock.ExpectQuery("SELECT id, purchase_id, timestamp, status FROM deliveries WHERE purchase_id = \\$1 AND status = \\$2 AND timestamp >= \\$3 AND timestamp <= \\$4 ORDER BY timestamp DESC").
+	mock.ExpectQuery("SELECT id, purchase_id, timestamp, status, scheduled_for, attempt_number FROM deliveries WHERE purchase_id = \\$1 AND status = \\$2 AND timestamp >= \\$3 AND timestamp <= \\$4 ORDER BY timestamp DESC").
 		WithArgs(purchaseId, status, fromDate, toDate).
 		WillReturnRows(rows)
 
@@ -205,3 +452,1984 @@ func TestGetDeliveries(t *testing.T) {
 		t.Errorf("Expected status %s, got %s", status, deliveries[0].Status)
 	}
 }
+
+func TestGetLatestDelivery(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	// Define test data
+	purchaseId := 1
+	now := time.Now()
+
+	// Setup expectations
+	rows := sqlmock.NewRows([]string{"id", "purchase_id", "timestamp", "status", "scheduled_for", "attempt_number"}).
+		AddRow(3, purchaseId, now, "delivered", nil, 2)
+
+	mock.ExpectQuery("SELECT id, purchase_id, timestamp, status, scheduled_for, attempt_number FROM deliveries WHERE purchase_id = \\$1 ORDER BY timestamp DESC LIMIT 1").
+		WithArgs(purchaseId).
+		WillReturnRows(rows)
+
+	// Execute the function
+	delivery, err := repo.GetLatestDelivery(purchaseId)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Verify expectations
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	// Verify result
+	if delivery.ID != 3 {
+		t.Errorf("Expected delivery ID %d, got %d", 3, delivery.ID)
+	}
+	if delivery.Status != "delivered" {
+		t.Errorf("Expected status %s, got %s", "delivered", delivery.Status)
+	}
+}
+
+func TestGetLatestDeliveryNoRows(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT id, purchase_id, timestamp, status, scheduled_for, attempt_number FROM deliveries WHERE purchase_id = \\$1 ORDER BY timestamp DESC LIMIT 1").
+		WithArgs(42).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "purchase_id", "timestamp", "status", "scheduled_for", "attempt_number"}))
+
+	_, err := repo.GetLatestDelivery(42)
+	if err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestGetDeliveriesSince(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	purchaseId := 1
+	now := time.Now()
+
+	rows := sqlmock.NewRows([]string{"id", "purchase_id", "timestamp", "status", "scheduled_for", "attempt_number"}).
+		AddRow(6, purchaseId, now, "out_for_delivery", nil, 1).
+		AddRow(9, purchaseId, now, "delivered", nil, 1)
+
+	mock.ExpectQuery("SELECT id, purchase_id, timestamp, status, scheduled_for, attempt_number FROM deliveries WHERE id > \\$1 AND purchase_id = \\$2 ORDER BY id").
+		WithArgs(5, purchaseId).
+		WillReturnRows(rows)
+
+	deliveries, err := repo.GetDeliveriesSince(&purchaseId, 5, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	if len(deliveries) != 2 {
+		t.Fatalf("Expected 2 deliveries, got %d", len(deliveries))
+	}
+	if deliveries[0].ID != 6 || deliveries[1].ID != 9 {
+		t.Errorf("Expected deliveries 6 and 9 in order, got %d and %d", deliveries[0].ID, deliveries[1].ID)
+	}
+}
+
+func TestGetDeliveryTimeline(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	// Define test data
+	purchaseId := 2
+	day1 := time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)
+	day2 := day1.Add(24 * time.Hour)
+
+	// Setup expectations
+	rows := sqlmock.NewRows([]string{"day", "status", "count"}).
+		AddRow(day1, "out_for_delivery", 1).
+		AddRow(day1, "packed", 2).
+		AddRow(day2, "delivered", 1)
+
+	mock.ExpectQuery("SELECT date_trunc\\('day', timestamp\\) AS day, status, COUNT\\(\\*\\) FROM deliveries WHERE purchase_id = \\$1 GROUP BY day, status ORDER BY day, status").
+		WithArgs(purchaseId).
+		WillReturnRows(rows)
+
+	// Execute the function
+	days, err := repo.GetDeliveryTimeline(purchaseId)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Verify expectations
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	// Verify result
+	if len(days) != 2 {
+		t.Fatalf("Expected 2 days, got %d", len(days))
+	}
+	if !days[0].Date.Equal(day1) || len(days[0].StatusCounts) != 2 {
+		t.Errorf("Expected 2 status counts on %s, got %+v", day1, days[0])
+	}
+	if days[0].StatusCounts[1].Status != "packed" || days[0].StatusCounts[1].Count != 2 {
+		t.Errorf("Expected 2 packed deliveries, got %+v", days[0].StatusCounts[1])
+	}
+	if !days[1].Date.Equal(day2) || len(days[1].StatusCounts) != 1 {
+		t.Errorf("Expected 1 status count on %s, got %+v", day2, days[1])
+	}
+}
+
+func TestGetPurchasesByLatestDeliveryStatus(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	// Define test data
+	status := "out_for_delivery"
+	now := time.Now()
+
+	// Setup expectations
+	rows := sqlmock.NewRows([]string{"id", "listing_id", "price", "tax_amount", "bank_tx_id", "delivery_address", "pickup_point_id", "status", "order_status", "created_at"}).
+		AddRow(3, 5, 89.99, 0.0, "TX323456789", "15 Pine Road", nil, "approved", "paid", now)
+
+	mock.ExpectQuery("FROM purchases p JOIN LATERAL \\(.*ORDER BY d.timestamp DESC LIMIT 1 \\) latest ON true WHERE latest.status = \\$1").
+		WithArgs(status).
+		WillReturnRows(rows)
+
+	// Execute the function
+	purchases, err := repo.GetPurchasesByLatestDeliveryStatus(status)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Verify expectations
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	// Verify result
+	if len(purchases) != 1 {
+		t.Fatalf("Expected 1 purchase, got %d", len(purchases))
+	}
+	if purchases[0].ID != 3 {
+		t.Errorf("Expected purchase ID %d, got %d", 3, purchases[0].ID)
+	}
+}
+
+func TestGetListingPriceStats(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	// Define test data
+	sellerId := 1
+	filter := &models.ListingFilter{SellerID: &sellerId}
+
+	// Setup expectations
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\), MIN\\(price\\), MAX\\(price\\), AVG\\(price\\), percentile_cont\\(0.5\\) WITHIN GROUP \\(ORDER BY price\\) FROM listings WHERE seller_id = \\$1").
+		WithArgs(sellerId).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "min", "max", "avg", "median"}).
+			AddRow(3, 10.0, 50.0, 30.0, 30.0))
+
+	mock.ExpectQuery("SELECT LEAST\\(width_bucket\\(price, \\$2, \\$3, \\$4\\), \\$4\\) AS bucket, COUNT\\(\\*\\) FROM listings WHERE seller_id = \\$1 GROUP BY bucket ORDER BY bucket").
+		WithArgs(sellerId, 10.0, 50.0, 4).
+		WillReturnRows(sqlmock.NewRows([]string{"bucket", "count"}).
+			AddRow(1, 1).
+			AddRow(3, 1).
+			AddRow(4, 1))
+
+	// Execute the function
+	stats, err := repo.GetListingPriceStats(filter, 4)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Verify expectations
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	// Verify result
+	if stats.Count != 3 || *stats.Min != 10.0 || *stats.Max != 50.0 || *stats.Median != 30.0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	expectedCounts := []int{1, 0, 1, 1}
+	if len(stats.Histogram) != len(expectedCounts) {
+		t.Fatalf("Expected %d buckets, got %d", len(expectedCounts), len(stats.Histogram))
+	}
+	for i, count := range expectedCounts {
+		if stats.Histogram[i].Count != count {
+			t.Errorf("Expected bucket %d to have count %d, got %d", i, count, stats.Histogram[i].Count)
+		}
+	}
+	if stats.Histogram[1].Min != 20.0 || stats.Histogram[1].Max != 30.0 {
+		t.Errorf("Expected bucket 1 to cover [20, 30), got [%.2f, %.2f)", stats.Histogram[1].Min, stats.Histogram[1].Max)
+	}
+}
+
+func TestGetListingPriceStatsEmpty(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery("FROM listings").
+		WillReturnRows(sqlmock.NewRows([]string{"count", "min", "max", "avg", "median"}).
+			AddRow(0, nil, nil, nil, nil))
+
+	stats, err := repo.GetListingPriceStats(nil, 10)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if stats.Count != 0 || stats.Min != nil || stats.Histogram == nil || len(stats.Histogram) != 0 {
+		t.Errorf("Expected empty stats, got %+v", stats)
+	}
+}
+
+func TestGetDashboard(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT \\(SELECT COUNT\\(\\*\\) FROM purchases WHERE order_status IN \\(\\$1, \\$2, \\$3\\)\\), .* WHERE status = \\$4\\), \\(SELECT COALESCE\\(SUM\\(price\\), 0\\) FROM purchases WHERE created_at >= CURRENT_DATE AND status NOT IN \\(\\$5, \\$6\\)\\), CURRENT_DATE").
+		WithArgs(models.OrderStatusPending, models.OrderStatusPaid, models.OrderStatusShipped,
+			models.DeliveryStatusOutForDelivery, models.PurchaseStatusRejected, models.PurchaseStatusCanceled).
+		WillReturnRows(sqlmock.NewRows([]string{"open", "out_for_delivery", "revenue", "day", "purchase", "delivery", "status_change"}).
+			AddRow(4, 2, "59.97", day, 10, 20, 30))
+	mock.ExpectQuery("^SELECT CURRENT_DATE, \\(SELECT COALESCE\\(MAX\\(id\\), 0\\) FROM purchases\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"day", "purchase", "delivery", "status_change"}).
+			AddRow(day, 10, 20, 30))
+	mock.ExpectQuery("^SELECT CURRENT_DATE").
+		WillReturnRows(sqlmock.NewRows([]string{"day", "purchase", "delivery", "status_change"}).
+			AddRow(day, 10, 21, 30))
+
+	dashboard, err := repo.GetDashboard()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if dashboard.OpenPurchases != 4 || dashboard.OutForDelivery != 2 || dashboard.RevenueToday != 5997 {
+		t.Errorf("Unexpected dashboard: %+v", dashboard)
+	}
+
+	// The polled version matches the dashboard's until something changes
+	version, err := repo.GetDashboardVersion()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if version != dashboard.Version {
+		t.Errorf("Expected version %s, got %s", dashboard.Version, version)
+	}
+	if version, _ := repo.GetDashboardVersion(); version == dashboard.Version {
+		t.Errorf("Expected a new delivery update to change the version")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetPurchaseAsOf(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	asOf := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	columns := []string{"id", "listing_id", "price", "tax_amount", "bank_tx_id", "delivery_address", "pickup_point_id", "status", "order_status", "created_at"}
+
+	// The statuses come from the latest history entry up to asOf
+	mock.ExpectQuery("FROM purchases p JOIN LATERAL \\( SELECT status, order_status FROM purchase_status_history WHERE purchase_id = p.id AND changed_at <= \\$2 ORDER BY changed_at DESC, id DESC LIMIT 1 \\) h ON TRUE WHERE p.id = \\$1 AND p.created_at <= \\$2").
+		WithArgs(5, asOf).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(5, 2, 10.0, 0.0, "TX5", "Address", nil, "pending_review", "pending", asOf.Add(-time.Hour)))
+
+	purchase, err := repo.GetPurchaseAsOf(5, asOf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if purchase.Status != models.PurchaseStatusPendingReview || purchase.OrderStatus != models.OrderStatusPending {
+		t.Errorf("Expected the statuses as of %s, got %s/%s", asOf, purchase.Status, purchase.OrderStatus)
+	}
+
+	// Purchases created later didn't exist yet
+	mock.ExpectQuery("FROM purchases p JOIN LATERAL").
+		WithArgs(6, asOf).
+		WillReturnRows(sqlmock.NewRows(columns))
+	if _, err := repo.GetPurchaseAsOf(6, asOf); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetPurchaseStats(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	// Define test data
+	listingID := 1
+	filter := &models.PurchaseFilter{ListingID: &listingID}
+
+	// Setup expectations
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\), COALESCE\\(SUM\\(price\\) FILTER \\(WHERE status NOT IN \\(\\$2, \\$3\\)\\), 0\\) FROM purchases WHERE listing_id = \\$1").
+		WithArgs(listingID, models.PurchaseStatusRejected, models.PurchaseStatusCanceled).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "revenue"}).
+			AddRow(3, "149.97"))
+
+	// Execute the function
+	stats, err := repo.GetPurchaseStats(filter)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Verify expectations
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	// Verify result
+	if stats.Count != 3 || stats.Revenue != 14997 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestGetListingsSetOperators(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	// Define test data
+	sellerIds := []int{1, 2}
+	titleNotLike := "refurbished"
+
+	filter := &models.ListingFilter{
+		SellerIDIn:   sellerIds,
+		TitleNotLike: &titleNotLike,
+	}
+
+	// Setup expectations
+	rows := sqlmock.NewRows([]string{"id", "seller_id", "title", "description", "price"}).
+		AddRow(1, 2, "Test Listing", "Description", 75.0)
+
+	mock.ExpectQuery("SELECT id, seller_id, title, description, price FROM listings WHERE seller_id = ANY\\(\\$1\\) AND title NOT ILIKE \\$2").
+		WithArgs(pq.Array(sellerIds), "%"+titleNotLike+"%").
+		WillReturnRows(rows)
+
+	// Execute the function
+	listings, err := repo.GetListings(filter)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Verify expectations
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	// Verify result
+	if len(listings) != 1 {
+		t.Errorf("Expected 1 listing, got %d", len(listings))
+	}
+}
+
+func TestGetListingsAndOr(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	// (seller 1 AND price <= 10) OR title contains "sale", excluding refurbished ones
+	sellerID := 1
+	maxPrice := models.Money(1000)
+	title, titleNotLike := "sale", "refurbished"
+
+	filter := &models.ListingFilter{
+		TitleNotLike: &titleNotLike,
+		Or: []*models.ListingFilter{
+			{And: []*models.ListingFilter{{SellerID: &sellerID}, {MaxPrice: &maxPrice}}},
+			{Title: &title},
+		},
+	}
+
+	rows := sqlmock.NewRows([]string{"id", "seller_id", "title", "description", "price"}).
+		AddRow(1, 1, "Cheap Listing", "Description", 5.0)
+
+	mock.ExpectQuery("SELECT id, seller_id, title, description, price FROM listings WHERE title NOT ILIKE \\$1 AND \\(\\(\\(seller_id = \\$2\\) AND \\(price <= \\$3\\)\\) OR \\(title ILIKE \\$4\\)\\)$").
+		WithArgs("%"+titleNotLike+"%", sellerID, maxPrice, "%"+title+"%").
+		WillReturnRows(rows)
+
+	listings, err := repo.GetListings(filter)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	if len(listings) != 1 {
+		t.Errorf("Expected 1 listing, got %d", len(listings))
+	}
+}
+
+func TestBuildListingWhereEmptyOr(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter *models.ListingFilter
+		where  string
+	}{
+		{"no alternatives", &models.ListingFilter{Or: []*models.ListingFilter{}}, " WHERE (FALSE)"},
+		{"empty alternative", &models.ListingFilter{Or: []*models.ListingFilter{{}}}, " WHERE ((TRUE))"},
+		{"empty and", &models.ListingFilter{And: []*models.ListingFilter{{}}}, ""},
+	}
+
+	for _, tt := range tests {
+		if where, _ := buildListingWhere(tt.filter); where != tt.where {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.where, where)
+		}
+	}
+}
+
+func TestGetListingsSellerName(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	sellerName := "acme"
+	maxPrice := models.Money(1000)
+	filter := &models.ListingFilter{SellerName: &sellerName, MaxPrice: &maxPrice}
+
+	rows := sqlmock.NewRows([]string{"id", "seller_id", "title", "description", "price"}).
+		AddRow(1, 2, "Anvil", "Description", 5.0)
+
+	mock.ExpectQuery("SELECT id, seller_id, title, description, price FROM listings WHERE seller_id IN \\(SELECT id FROM sellers WHERE name ILIKE \\$1\\) AND price <= \\$2$").
+		WithArgs("%"+sellerName+"%", maxPrice).
+		WillReturnRows(rows)
+
+	listings, err := repo.GetListings(filter)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	if len(listings) != 1 || listings[0].SellerID != 2 {
+		t.Errorf("Expected the listing of seller 2, got %+v", listings)
+	}
+}
+
+func TestGetDeliveriesSetOperators(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	// Define test data
+	statusIn := []string{"packed", "out_for_delivery"}
+	statusNot := "canceled"
+
+	filter := &models.DeliveryFilter{
+		StatusIn:  statusIn,
+		StatusNot: &statusNot,
+	}
+
+	// Setup expectations
+	rows := sqlmock.NewRows([]string{"id", "purchase_id", "timestamp", "status", "scheduled_for", "attempt_number"}).
+		AddRow(1, 1, time.Now(), "packed", nil, 1)
+
+	mock.ExpectQuery("SELECT id, purchase_id, timestamp, status, scheduled_for, attempt_number FROM deliveries WHERE status = ANY\\(\\$1\\) AND status <> \\$2 ORDER BY timestamp DESC").
+		WithArgs(pq.Array(statusIn), statusNot).
+		WillReturnRows(rows)
+
+	// Execute the function
+	deliveries, err := repo.GetDeliveries(filter)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Verify expectations
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	// Verify result
+	if len(deliveries) != 1 {
+		t.Errorf("Expected 1 delivery, got %d", len(deliveries))
+	}
+}
+
+func TestGetListingsOrderByPopularity(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	// Define test data
+	minPrice := models.Money(1000)
+	filter := &models.ListingFilter{
+		MinPrice: &minPrice,
+		OrderBy:  models.OrderByPopularity,
+	}
+
+	// Setup expectations
+	rows := sqlmock.NewRows([]string{"id", "seller_id", "title", "description", "price"}).
+		AddRow(2, 1, "Popular Listing", "Description", 75.0).
+		AddRow(1, 1, "Quiet Listing", "Description", 25.0)
+
+	mock.ExpectQuery("SELECT id, seller_id, title, description, price FROM listings LEFT JOIN listing_views ON listing_views.listing_id = listings.id WHERE price >= \\$1 ORDER BY COALESCE\\(listing_views.views, 0\\) DESC, id").
+		WithArgs(minPrice).
+		WillReturnRows(rows)
+
+	// Execute the function
+	listings, err := repo.GetListings(filter)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Verify expectations
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	// Verify result
+	if len(listings) != 2 || listings[0].ID != 2 {
+		t.Errorf("Expected most popular listing first, got %+v", listings)
+	}
+}
+
+func TestGetListingsPage(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	sellerID := 1
+	afterID := 3
+	filter := &models.ListingFilter{
+		SellerID: &sellerID,
+		Page:     &models.Page{AfterID: &afterID, Limit: 3},
+	}
+
+	rows := sqlmock.NewRows([]string{"id", "seller_id", "title", "description", "price"}).
+		AddRow(4, 1, "Listing 4", "Description", 10.0).
+		AddRow(7, 1, "Listing 7", "Description", 20.0)
+
+	mock.ExpectQuery("SELECT id, seller_id, title, description, price FROM listings WHERE seller_id = \\$1 AND id > \\$2 ORDER BY id LIMIT \\$3").
+		WithArgs(sellerID, afterID, 3).
+		WillReturnRows(rows)
+
+	listings, err := repo.GetListings(filter)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	if len(listings) != 2 || listings[0].ID != 4 || listings[1].ID != 7 {
+		t.Errorf("Expected listings 4 and 7, got %+v", listings)
+	}
+}
+
+func TestGetListingsPriceOrderedPage(t *testing.T) {
+	id, price := 4, models.Money(1990)
+	tests := []struct {
+		name  string
+		page  *models.Page
+		query string
+	}{
+		{
+			name:  "ascending after",
+			page:  &models.Page{OrderBy: models.OrderByPriceAsc, AfterID: &id, AfterPrice: &price, Limit: 3},
+			query: "WHERE \\(price > \\$1 OR \\(price = \\$1 AND id > \\$2\\)\\) ORDER BY price, id LIMIT \\$3$",
+		},
+		{
+			name:  "ascending before, from the end",
+			page:  &models.Page{OrderBy: models.OrderByPriceAsc, BeforeID: &id, BeforePrice: &price, Limit: 3, FromEnd: true},
+			query: "WHERE \\(price < \\$1 OR \\(price = \\$1 AND id < \\$2\\)\\) ORDER BY price DESC, id DESC LIMIT \\$3$",
+		},
+		{
+			name:  "descending after",
+			page:  &models.Page{OrderBy: models.OrderByPriceDesc, AfterID: &id, AfterPrice: &price, Limit: 3},
+			query: "WHERE \\(price < \\$1 OR \\(price = \\$1 AND id > \\$2\\)\\) ORDER BY price DESC, id LIMIT \\$3$",
+		},
+		{
+			name:  "descending before, from the end",
+			page:  &models.Page{OrderBy: models.OrderByPriceDesc, BeforeID: &id, BeforePrice: &price, Limit: 3, FromEnd: true},
+			query: "WHERE \\(price > \\$1 OR \\(price = \\$1 AND id < \\$2\\)\\) ORDER BY price, id DESC LIMIT \\$3$",
+		},
+	}
+
+	for _, tt := range tests {
+		db, mock, repo := setupMockDB(t)
+
+		mock.ExpectQuery("^SELECT id, seller_id, title, description, price FROM listings "+tt.query).
+			WithArgs(models.Money(1990), 4, 3).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "seller_id", "title", "description", "price"}))
+
+		if _, err := repo.GetListings(&models.ListingFilter{Page: tt.page}); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: there were unfulfilled expectations: %s", tt.name, err)
+		}
+		db.Close()
+	}
+}
+
+func TestGetListingsPageRejectsUnsupportedOrder(t *testing.T) {
+	for _, orderBy := range []string{models.OrderByPopularity, models.OrderByTitleAsc, models.OrderByCreatedAtDesc} {
+		db, mock, repo := setupMockDB(t)
+
+		// The page is refused before any query is run, not read in ID order
+		_, err := repo.GetListings(&models.ListingFilter{Page: &models.Page{OrderBy: orderBy, Limit: 3}})
+		if !errors.Is(err, ErrUnsupportedPageOrder) {
+			t.Errorf("%s: expected ErrUnsupportedPageOrder, got %v", orderBy, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: there were unfulfilled expectations: %s", orderBy, err)
+		}
+		db.Close()
+	}
+}
+
+// Rows of equal price are split between pages by ID
+func TestGetListingsPriceOrderedPagesWithEqualPrices(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	columns := []string{"id", "seller_id", "title", "description", "price"}
+	mock.ExpectQuery("ORDER BY price, id LIMIT \\$1$").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(2, 1, "Listing 2", "Description", 5.0).
+			AddRow(3, 1, "Listing 3", "Description", 10.0).
+			AddRow(6, 1, "Listing 6", "Description", 10.0))
+	// The next page continues after listing 6 among the listings priced 10.00
+	mock.ExpectQuery("WHERE \\(price > \\$1 OR \\(price = \\$1 AND id > \\$2\\)\\) ORDER BY price, id LIMIT \\$3$").
+		WithArgs(models.Money(1000), 6, 3).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(8, 1, "Listing 8", "Description", 10.0).
+			AddRow(1, 1, "Listing 1", "Description", 12.0))
+
+	first, err := repo.GetListings(&models.ListingFilter{Page: &models.Page{OrderBy: models.OrderByPriceAsc, Limit: 3}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	last := first[len(first)-1]
+	second, err := repo.GetListings(&models.ListingFilter{Page: &models.Page{
+		OrderBy:    models.OrderByPriceAsc,
+		AfterID:    &last.ID,
+		AfterPrice: &last.Price,
+		Limit:      3,
+	}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+	var ids []string
+	for _, listing := range append(first, second...) {
+		ids = append(ids, strconv.Itoa(listing.ID))
+	}
+	if got := strings.Join(ids, ","); got != "2,3,6,8,1" {
+		t.Errorf("Expected listings 2,3,6,8,1, got %s", got)
+	}
+}
+
+func TestGetListingsLimitOffset(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	sellerID := 1
+	filter := &models.ListingFilter{SellerID: &sellerID, Limit: 2, Offset: 4}
+
+	mock.ExpectQuery("SELECT id, seller_id, title, description, price FROM listings WHERE seller_id = \\$1 ORDER BY id LIMIT \\$2 OFFSET \\$3$").
+		WithArgs(sellerID, 2, 4).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "seller_id", "title", "description", "price"}).
+			AddRow(5, 1, "Listing 5", "Description", 10.0))
+
+	listings, err := repo.GetListings(filter)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(listings) != 1 || listings[0].ID != 5 {
+		t.Errorf("Expected listing 5, got %+v", listings)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetListingsPageFromEnd(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	beforeID := 9
+	filter := &models.ListingFilter{
+		Page: &models.Page{BeforeID: &beforeID, Limit: 2, FromEnd: true},
+	}
+
+	// The last rows before the cursor are read in descending order
+	rows := sqlmock.NewRows([]string{"id", "seller_id", "title", "description", "price"}).
+		AddRow(8, 1, "Listing 8", "Description", 10.0).
+		AddRow(5, 1, "Listing 5", "Description", 20.0)
+
+	mock.ExpectQuery("SELECT id, seller_id, title, description, price FROM listings WHERE id < \\$1 ORDER BY id DESC LIMIT \\$2").
+		WithArgs(beforeID, 2).
+		WillReturnRows(rows)
+
+	listings, err := repo.GetListings(filter)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	if len(listings) != 2 || listings[0].ID != 5 || listings[1].ID != 8 {
+		t.Errorf("Expected listings in ascending order, got %+v", listings)
+	}
+}
+
+func TestIncrementListingViews(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	// A single listing keeps the argument order deterministic
+	mock.ExpectExec("INSERT INTO listing_views \\(listing_id, views\\) SELECT \\* FROM unnest\\(\\$1::int\\[\\], \\$2::bigint\\[\\]\\) ON CONFLICT \\(listing_id\\) DO UPDATE SET views = listing_views.views \\+ EXCLUDED.views").
+		WithArgs(pq.Array([]int64{7}), pq.Array([]int64{3})).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := repo.IncrementListingViews(map[int]int64{7: 3}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestUpdateListingRecordsPriceChange(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	// Define test data
+	listingId := 4
+	newPrice := models.Money(12000)
+
+	// Setup expectations
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT price FROM listings WHERE id = \\$1 FOR UPDATE").
+		WithArgs(listingId).
+		WillReturnRows(sqlmock.NewRows([]string{"price"}).AddRow(100.0))
+	mock.ExpectQuery("UPDATE listings SET title = COALESCE\\(\\$2, title\\)").
+		WithArgs(listingId, nil, nil, newPrice).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "seller_id", "title", "description", "price"}).
+			AddRow(listingId, 1, "Laptop", "Used laptop", newPrice))
+	mock.ExpectExec("INSERT INTO listing_price_history \\(listing_id, price, changed_at\\) VALUES \\(\\$1, \\$2, NOW\\(\\)\\)").
+		WithArgs(listingId, newPrice).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	// Execute the function
+	listing, err := repo.UpdateListing(listingId, nil, nil, &newPrice)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Verify expectations
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	// Verify result
+	if listing.Price != newPrice {
+		t.Errorf("Expected price %s, got %s", newPrice, listing.Price)
+	}
+}
+
+func TestUpdateListingWithoutPriceChange(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	// Define test data
+	listingId := 4
+	title := "Gaming laptop"
+
+	// Setup expectations: no history row is written when the price is unchanged
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT price FROM listings WHERE id = \\$1 FOR UPDATE").
+		WithArgs(listingId).
+		WillReturnRows(sqlmock.NewRows([]string{"price"}).AddRow(100.0))
+	mock.ExpectQuery("UPDATE listings SET title = COALESCE\\(\\$2, title\\)").
+		WithArgs(listingId, title, nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "seller_id", "title", "description", "price"}).
+			AddRow(listingId, 1, title, "Used laptop", 100.0))
+	mock.ExpectCommit()
+
+	// Execute the function
+	_, err := repo.UpdateListing(listingId, &title, nil, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Verify expectations
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetPriceHistory(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	// Define test data
+	listingId := 4
+	from := time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)
+	changedAt := from.Add(48 * time.Hour)
+
+	// Setup expectations
+	rows := sqlmock.NewRows([]string{"listing_id", "price", "changed_at"}).
+		AddRow(listingId, 120.0, changedAt)
+
+	mock.ExpectQuery("SELECT listing_id, price, changed_at FROM listing_price_history WHERE listing_id = \\$1 AND changed_at >= \\$2 ORDER BY changed_at").
+		WithArgs(listingId, from).
+		WillReturnRows(rows)
+
+	// Execute the function
+	points, err := repo.GetPriceHistory(listingId, &from, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Verify expectations
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	// Verify result
+	if len(points) != 1 {
+		t.Fatalf("Expected 1 price point, got %d", len(points))
+	}
+	if !points[0].ChangedAt.Equal(changedAt) {
+		t.Errorf("Expected changedAt %v, got %v", changedAt, points[0].ChangedAt)
+	}
+}
+
+func TestAdvanceDelivery(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	now := time.Now()
+
+	mock.ExpectQuery("INSERT INTO deliveries \\(purchase_id, timestamp, status, attempt_number\\) SELECT (.+) WHERE d.id = \\$1 AND NOT EXISTS").
+		WithArgs(7, "out_for_delivery").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "purchase_id", "timestamp", "attempt_number"}).AddRow(8, 5, now, 1))
+
+	delivery, err := repo.AdvanceDelivery(7, "out_for_delivery")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if delivery.ID != 8 || delivery.PurchaseID != 5 || delivery.Status != "out_for_delivery" {
+		t.Errorf("Unexpected delivery: %+v", delivery)
+	}
+
+	// A superseded delivery inserts nothing
+	mock.ExpectQuery("INSERT INTO deliveries").
+		WithArgs(7, "delivered").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "purchase_id", "timestamp", "attempt_number"}))
+
+	if _, err := repo.AdvanceDelivery(7, "delivered"); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRescheduleDelivery(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	// Define test data
+	purchaseId := 5
+	scheduledFor := time.Now().Add(48 * time.Hour)
+	now := time.Now()
+
+	// Setup expectations
+	mock.ExpectQuery("INSERT INTO deliveries \\(purchase_id, timestamp, status, scheduled_for, attempt_number\\)").
+		WithArgs(purchaseId, scheduledFor).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "timestamp", "attempt_number"}).AddRow(9, now, 2))
+
+	// Execute the function
+	delivery, err := repo.RescheduleDelivery(purchaseId, scheduledFor)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Verify expectations
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	// Verify result
+	if delivery.Status != "rescheduled" {
+		t.Errorf("Expected status %s, got %s", "rescheduled", delivery.Status)
+	}
+	if delivery.AttemptNumber != 2 {
+		t.Errorf("Expected attempt number %d, got %d", 2, delivery.AttemptNumber)
+	}
+	if delivery.ScheduledFor == nil || !delivery.ScheduledFor.Equal(scheduledFor) {
+		t.Errorf("Expected scheduledFor %v, got %v", scheduledFor, delivery.ScheduledFor)
+	}
+}
+
+func TestGetDeliveriesScheduledWindow(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	// Define test data
+	from := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(7 * 24 * time.Hour)
+	scheduledFor := from.Add(24 * time.Hour)
+
+	filter := &models.DeliveryFilter{
+		ScheduledFrom: &from,
+		ScheduledTo:   &to,
+	}
+
+	// Setup expectations
+	rows := sqlmock.NewRows([]string{"id", "purchase_id", "timestamp", "status", "scheduled_for", "attempt_number"}).
+		AddRow(4, 2, time.Now(), "rescheduled", scheduledFor, 2)
+
+	mock.ExpectQuery("SELECT id, purchase_id, timestamp, status, scheduled_for, attempt_number FROM deliveries WHERE scheduled_for >= \\$1 AND scheduled_for <= \\$2 ORDER BY timestamp DESC").
+		WithArgs(from, to).
+		WillReturnRows(rows)
+
+	// Execute the function
+	deliveries, err := repo.GetDeliveries(filter)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Verify expectations
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	// Verify result
+	if len(deliveries) != 1 {
+		t.Fatalf("Expected 1 delivery, got %d", len(deliveries))
+	}
+	if deliveries[0].ScheduledFor == nil || !deliveries[0].ScheduledFor.Equal(scheduledFor) {
+		t.Errorf("Expected scheduledFor %v, got %v", scheduledFor, deliveries[0].ScheduledFor)
+	}
+}
+
+func TestCreatePurchaseWithPickupPoint(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	// Define test data
+	pickupPointId := 2
+	address := "200 Congress Ave, Austin, TX 78701"
+	now := time.Now()
+
+	// Setup expectations
+	mock.ExpectQuery("INSERT INTO purchases \\(listing_id, price, tax_amount, bank_tx_id, delivery_address, pickup_point_id, status, order_status, created_at\\)").
+		WithArgs(3, models.Money(4999), models.Money(412), "TX999", address, &pickupPointId, "pending_review", "pending").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(10, now))
+
+	// Execute the function
+	purchase, err := repo.CreatePurchase(3, 4999, 412, "TX999", address, &pickupPointId)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Verify expectations
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	// Verify result
+	if purchase.PickupPointID == nil || *purchase.PickupPointID != pickupPointId {
+		t.Errorf("Expected pickup point ID %d, got %v", pickupPointId, purchase.PickupPointID)
+	}
+}
+
+func TestCreatePurchaseWithDelivery(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO purchases \\(listing_id, price, tax_amount, bank_tx_id, delivery_address, pickup_point_id, status, order_status, created_at\\)").
+		WithArgs(3, models.Money(4999), models.Money(0), "TX1000", "1 Main St", nil, "pending_review", "pending").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(11, now))
+	mock.ExpectQuery("INSERT INTO deliveries \\(purchase_id, timestamp, status, attempt_number\\)").
+		WithArgs(11, "packed").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "timestamp", "attempt_number"}).AddRow(20, now, 1))
+	mock.ExpectCommit()
+
+	purchase, delivery, err := repo.CreatePurchaseWithDelivery(3, 4999, 0, "TX1000", "1 Main St", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	if purchase.ID != 11 || delivery.ID != 20 || delivery.PurchaseID != 11 || delivery.Status != "packed" {
+		t.Errorf("Unexpected purchase %+v or delivery %+v", purchase, delivery)
+	}
+}
+
+func TestCreatePurchaseWithDeliveryRollsBack(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO purchases").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(11, time.Now()))
+	mock.ExpectQuery("INSERT INTO deliveries").
+		WillReturnError(sql.ErrConnDone)
+	mock.ExpectRollback()
+
+	if _, _, err := repo.CreatePurchaseWithDelivery(3, 4999, 0, "TX1000", "1 Main St", nil); err == nil {
+		t.Errorf("Expected the delivery failure to be returned")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetNearestPickupPoints(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	// Define test data
+	lat, lon := 30.27, -97.74
+
+	// Setup expectations
+	rows := sqlmock.NewRows([]string{"id", "name", "address", "latitude", "longitude", "distance_km"}).
+		AddRow(2, "Downtown Austin Hub", "200 Congress Ave", 30.2651, -97.7437, 0.65).
+		AddRow(1, "Back Bay Locker", "500 Boylston St", 42.3505, -71.0760, 2720.4)
+
+	mock.ExpectQuery("SELECT id, name, address, latitude, longitude, distance_km FROM \\(.*FROM pickup_points \\) points ORDER BY distance_km, id LIMIT \\$3").
+		WithArgs(lat, lon, 2).
+		WillReturnRows(rows)
+
+	// Execute the function
+	points, err := repo.GetNearestPickupPoints(lat, lon, 2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Verify expectations
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	// Verify result
+	if len(points) != 2 {
+		t.Fatalf("Expected 2 pickup points, got %d", len(points))
+	}
+	if points[0].ID != 2 {
+		t.Errorf("Expected nearest pickup point ID %d, got %d", 2, points[0].ID)
+	}
+	if points[0].DistanceKm == nil || *points[0].DistanceKm != 0.65 {
+		t.Errorf("Expected distance %.2f, got %v", 0.65, points[0].DistanceKm)
+	}
+}
+
+func TestUpdatePurchaseStatus(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	// Define test data
+	purchaseId := 8
+	now := time.Now()
+
+	// Setup expectations
+	rows := sqlmock.NewRows([]string{"id", "listing_id", "price", "tax_amount", "bank_tx_id", "delivery_address", "pickup_point_id", "status", "order_status", "created_at"}).
+		AddRow(purchaseId, 2, 1299.99, 0.0, "TX888", "1 Main St", nil, "rejected", "pending", now)
+
+	mock.ExpectQuery("UPDATE purchases SET status = \\$2 WHERE id = \\$1 AND status = \\$3").
+		WithArgs(purchaseId, "rejected", "pending_review").
+		WillReturnRows(rows)
+
+	// Execute the function
+	purchase, err := repo.UpdatePurchaseStatus(purchaseId, models.PurchaseStatusPendingReview, models.PurchaseStatusRejected)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Verify expectations
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	// Verify result
+	if purchase.Status != models.PurchaseStatusRejected {
+		t.Errorf("Expected status %s, got %s", models.PurchaseStatusRejected, purchase.Status)
+	}
+}
+
+func TestUpdateOrderStatus(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	// Define test data
+	purchaseId := 8
+	now := time.Now()
+
+	// Setup expectations
+	rows := sqlmock.NewRows([]string{"id", "listing_id", "price", "tax_amount", "bank_tx_id", "delivery_address", "pickup_point_id", "status", "order_status", "created_at"}).
+		AddRow(purchaseId, 2, 1299.99, 0.0, "TX888", "1 Main St", nil, "approved", "shipped", now)
+
+	mock.ExpectQuery("UPDATE purchases SET order_status = \\$2 WHERE id = \\$1 AND order_status = \\$3").
+		WithArgs(purchaseId, "shipped", "paid").
+		WillReturnRows(rows)
+
+	// Execute the function
+	purchase, err := repo.UpdateOrderStatus(purchaseId, models.OrderStatusPaid, models.OrderStatusShipped)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Verify expectations
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	// Verify result
+	if purchase.OrderStatus != models.OrderStatusShipped {
+		t.Errorf("Expected order status %s, got %s", models.OrderStatusShipped, purchase.OrderStatus)
+	}
+}
+
+func TestRepositoryMetrics(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	errors := metrics.RepositoryErrors.WithLabelValues("GetSeller")
+	before := testutil.ToFloat64(errors)
+
+	// Setup expectations
+	mock.ExpectQuery("SELECT id, name, address, digest_opt_in FROM sellers WHERE id = \\$1").
+		WithArgs(1).
+		WillReturnError(sql.ErrConnDone)
+	mock.ExpectQuery("SELECT id, name, address, digest_opt_in FROM sellers WHERE id = \\$1").
+		WithArgs(2).
+		WillReturnError(sql.ErrNoRows)
+
+	// Execute the function
+	if _, err := repo.GetSeller(1); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+	if _, err := repo.GetSeller(2); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+
+	// Verify result: a missing row is not counted as an error
+	if got := testutil.ToFloat64(errors) - before; got != 1 {
+		t.Errorf("Expected error count to increase by 1, got %v", got)
+	}
+
+	// Verify expectations
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetPurchasesSince(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	rows := sqlmock.NewRows([]string{"id", "listing_id", "price", "tax_amount", "bank_tx_id", "delivery_address", "pickup_point_id", "status", "order_status", "created_at"}).
+		AddRow(11, 1, 100.0, 0.0, "TX11", "Main St 1", nil, "approved", "paid", time.Now())
+
+	mock.ExpectQuery("SELECT (.+) FROM purchases WHERE id > \\$1 ORDER BY id LIMIT \\$2").
+		WithArgs(10, 500).
+		WillReturnRows(rows)
+
+	purchases, err := repo.GetPurchasesSince(10, 500)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	if len(purchases) != 1 || purchases[0].ID != 11 {
+		t.Errorf("Expected purchase 11, got %+v", purchases)
+	}
+}
+
+func TestExportBookmarks(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	// An export that never ran starts from the beginning
+	mock.ExpectQuery("SELECT last_id FROM export_bookmarks WHERE name = \\$1").
+		WithArgs("purchases").
+		WillReturnRows(sqlmock.NewRows([]string{"last_id"}))
+	mock.ExpectExec("INSERT INTO export_bookmarks \\(name, last_id, updated_at\\) VALUES \\(\\$1, \\$2, NOW\\(\\)\\)").
+		WithArgs("purchases", 42).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	lastID, err := repo.GetExportBookmark("purchases")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if lastID != 0 {
+		t.Errorf("Expected bookmark 0, got %d", lastID)
+	}
+
+	if err := repo.SetExportBookmark("purchases", 42); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetPurchasesLimitOffset(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	status := "approved"
+	filter := &models.PurchaseFilter{Status: &status, Limit: 10, Offset: 20}
+
+	rows := sqlmock.NewRows([]string{"id", "listing_id", "price", "tax_amount", "bank_tx_id", "delivery_address", "pickup_point_id", "status", "order_status", "created_at"}).
+		AddRow(21, 1, 100.0, 0.0, "TX21", "Main St 1", nil, status, "pending", time.Now())
+
+	mock.ExpectQuery("SELECT (.+) FROM purchases WHERE status = \\$1 ORDER BY id LIMIT \\$2 OFFSET \\$3").
+		WithArgs(status, 10, 20).
+		WillReturnRows(rows)
+
+	purchases, err := repo.GetPurchases(filter)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	if len(purchases) != 1 || purchases[0].ID != 21 {
+		t.Errorf("Expected purchase 21, got %+v", purchases)
+	}
+}
+
+func TestGetDeliveriesLimit(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	rows := sqlmock.NewRows([]string{"id", "purchase_id", "timestamp", "status", "scheduled_for", "attempt_number"}).
+		AddRow(9, 1, time.Now(), "delivered", nil, 1)
+
+	mock.ExpectQuery("SELECT id, purchase_id, timestamp, status, scheduled_for, attempt_number FROM deliveries ORDER BY timestamp DESC, id DESC LIMIT \\$1$").
+		WithArgs(5).
+		WillReturnRows(rows)
+
+	if _, err := repo.GetDeliveries(&models.DeliveryFilter{Limit: 5}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetListingsByIDs(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	ids := []int{3, 9, 1}
+	rows := sqlmock.NewRows([]string{"id", "seller_id", "title", "description", "price"}).
+		AddRow(1, 1, "Listing 1", "Description", 10.0).
+		AddRow(3, 1, "Listing 3", "Description", 30.0)
+
+	mock.ExpectQuery("SELECT id, seller_id, title, description, price FROM listings WHERE id = ANY\\(\\$1\\)").
+		WithArgs(pq.Array(ids)).
+		WillReturnRows(rows)
+
+	listings, err := repo.GetListingsByIDs(ids)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	// Results keep the requested order and skip missing IDs
+	if len(listings) != 2 || listings[0].ID != 3 || listings[1].ID != 1 {
+		t.Errorf("Expected listings 3 and 1, got %+v", listings)
+	}
+}
+
+func TestGetSellersByIDs(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	ids := []int{2, 5, 1}
+	rows := sqlmock.NewRows([]string{"id", "name", "address", "digest_opt_in"}).
+		AddRow(1, "Seller 1", "Address 1", false).
+		AddRow(2, "Seller 2", "Address 2", true)
+
+	mock.ExpectQuery("SELECT id, name, address, digest_opt_in FROM sellers WHERE id = ANY\\(\\$1\\)").
+		WithArgs(pq.Array(ids)).
+		WillReturnRows(rows)
+
+	sellers, err := repo.GetSellersByIDs(ids)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	// Results keep the requested order and skip missing IDs
+	if len(sellers) != 2 || sellers[0].ID != 2 || sellers[1].ID != 1 {
+		t.Errorf("Expected sellers 2 and 1, got %+v", sellers)
+	}
+}
+
+func TestGetListingViewsByIDs(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	ids := []int{1, 2, 3}
+	mock.ExpectQuery("SELECT listing_id, views FROM listing_views WHERE listing_id = ANY\\(\\$1\\)").
+		WithArgs(pq.Array(ids)).
+		WillReturnRows(sqlmock.NewRows([]string{"listing_id", "views"}).AddRow(1, 7).AddRow(3, 2))
+
+	views, err := repo.GetListingViewsByIDs(ids)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	// Listings never viewed are left out and count as zero views
+	if len(views) != 2 || views[1] != 7 || views[2] != 0 || views[3] != 2 {
+		t.Errorf("Expected 7 views of listing 1 and 2 of listing 3, got %v", views)
+	}
+}
+
+func TestGetPurchasesByListingIDs(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	ids := []int{1, 2}
+	rows := sqlmock.NewRows([]string{"id", "listing_id", "price", "tax_amount", "bank_tx_id", "delivery_address", "pickup_point_id", "status", "order_status", "created_at"}).
+		AddRow(4, 1, 10.0, 0.0, "TX4", "Address", nil, "approved", "paid", time.Now()).
+		AddRow(7, 2, 20.0, 0.0, "TX7", "Address", nil, "approved", "paid", time.Now())
+
+	// Each listing is paged separately
+	mock.ExpectQuery("PARTITION BY listing_id ORDER BY id\\) AS position\\s+FROM purchases WHERE listing_id = ANY\\(\\$1\\)\\) ranked\\s+WHERE position > \\$2 AND position <= \\$3 ORDER BY listing_id, id").
+		WithArgs(pq.Array(ids), 3, 5).
+		WillReturnRows(rows)
+
+	purchases, err := repo.GetPurchasesByListingIDs(ids, 2, 3)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	if len(purchases) != 2 || purchases[0].ListingID != 1 || purchases[1].ListingID != 2 {
+		t.Errorf("Expected a purchase of each listing, got %+v", purchases)
+	}
+}
+
+func TestGetPurchasesByBankTxIDs(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	bankTxIDs := []string{"TX4", "TX7", "TX9"}
+	rows := sqlmock.NewRows([]string{"id", "listing_id", "price", "tax_amount", "bank_tx_id", "delivery_address", "pickup_point_id", "status", "order_status", "created_at"}).
+		AddRow(4, 1, 10.0, 0.0, "TX4", "Address", nil, "approved", "paid", time.Now()).
+		AddRow(7, 2, 20.0, 0.0, "TX7", "Address", nil, "approved", "paid", time.Now())
+
+	mock.ExpectQuery("FROM purchases WHERE bank_tx_id = ANY\\(\\$1\\) ORDER BY id").
+		WithArgs(pq.Array(bankTxIDs)).
+		WillReturnRows(rows)
+
+	purchases, err := repo.GetPurchasesByBankTxIDs(bankTxIDs)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	if len(purchases) != 2 || purchases[0].BankTxID != "TX4" || purchases[1].BankTxID != "TX7" {
+		t.Errorf("Expected the purchases of TX4 and TX7, got %+v", purchases)
+	}
+}
+
+func TestGetListingsOrderBy(t *testing.T) {
+	tests := []struct {
+		orderBy string
+		clause  string
+	}{
+		{models.OrderByPriceAsc, "ORDER BY price, id"},
+		{models.OrderByPriceDesc, "ORDER BY price DESC, id"},
+		{models.OrderByTitleAsc, "ORDER BY title, id"},
+		{models.OrderByCreatedAtDesc, "ORDER BY created_at DESC, id DESC"},
+	}
+
+	for _, tt := range tests {
+		db, mock, repo := setupMockDB(t)
+
+		mock.ExpectQuery("^SELECT id, seller_id, title, description, price FROM listings " + tt.clause + "$").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "seller_id", "title", "description", "price"}))
+
+		if _, err := repo.GetListings(&models.ListingFilter{OrderBy: tt.orderBy}); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.orderBy, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: there were unfulfilled expectations: %s", tt.orderBy, err)
+		}
+		db.Close()
+	}
+}
+
+func TestSearchListings(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	rows := sqlmock.NewRows([]string{"id", "seller_id", "title", "description", "price"}).
+		AddRow(4, 1, "Gaming Laptop", "Fast laptop", 1200.0).
+		AddRow(2, 1, "Laptop Bag", "Fits a laptop", 40.0)
+
+	mock.ExpectQuery("WHERE search_vector @@ websearch_to_tsquery\\('english', \\$1\\) ORDER BY ts_rank\\(search_vector, websearch_to_tsquery\\('english', \\$1\\)\\) DESC, id LIMIT \\$2").
+		WithArgs("laptop", 10).
+		WillReturnRows(rows)
+
+	listings, err := repo.SearchListings("laptop", 10)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	if len(listings) != 2 || listings[0].ID != 4 {
+		t.Errorf("Expected the best ranked listing first, got %+v", listings)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery("FROM sellers WHERE name ILIKE \\$1 OR address ILIKE \\$1 ORDER BY id LIMIT \\$2").
+		WithArgs("%main%", 20).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "address", "digest_opt_in"}).
+			AddRow(1, "Tech Store", "123 Main St", false))
+	mock.ExpectQuery("FROM listings WHERE title ILIKE \\$1 OR description ILIKE \\$1 ORDER BY id LIMIT \\$2").
+		WithArgs("%main%", 20).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "seller_id", "title", "description", "price"}))
+	mock.ExpectQuery("FROM purchases WHERE bank_tx_id ILIKE \\$1 OR delivery_address ILIKE \\$1 ORDER BY id LIMIT \\$2").
+		WithArgs("%main%", 20).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "listing_id", "price", "tax_amount", "bank_tx_id", "delivery_address", "pickup_point_id", "status", "order_status", "created_at"}).
+			AddRow(3, 1, 99.99, 0, "TX-3", "9 Main St", nil, "approved", "paid", time.Now()))
+
+	results, err := repo.Search("main", 20, true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	if len(results.Sellers) != 1 || results.Sellers[0].ID != 1 {
+		t.Errorf("Expected seller 1, got %+v", results.Sellers)
+	}
+	if results.Listings == nil || len(results.Listings) != 0 {
+		t.Errorf("Expected no listings, got %+v", results.Listings)
+	}
+	if len(results.Purchases) != 1 || results.Purchases[0].ID != 3 {
+		t.Errorf("Expected purchase 3, got %+v", results.Purchases)
+	}
+}
+
+func TestGetSimilarListingIDs(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT l.id FROM listings l JOIN listings source ON source.id = \\$1 (.+) LIMIT \\$2").
+		WithArgs(3, 5).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(8).AddRow(2))
+
+	ids, err := repo.GetSimilarListingIDs(3, 5)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+
+	if len(ids) != 2 || ids[0] != 8 || ids[1] != 2 {
+		t.Errorf("Expected IDs 8 and 2, got %v", ids)
+	}
+}
+
+func TestRecordModerationDecision(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectExec("INSERT INTO moderation_decisions").
+		WithArgs("listing", nil, "Casino chips", "reject", "contains blocked term \"casino\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	if err := repo.RecordModerationDecision("listing", nil, "Casino chips", "reject", `contains blocked term "casino"`); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRecordImpersonation(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectExec("INSERT INTO impersonation_audit \\(role, subject, client_name, remote_addr, operation_name, query, created_at\\)").
+		WithArgs("seller", "3", "support-console", "10.0.0.7:51234", "", "{ sellers { id } }").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	if err := repo.RecordImpersonation("seller", "3", "support-console", "10.0.0.7:51234", "", "{ sellers { id } }"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSellerWebhook(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	now := time.Now()
+
+	mock.ExpectQuery("INSERT INTO seller_webhooks (.+) ON CONFLICT \\(seller_id\\) DO UPDATE").
+		WithArgs(1, "https://example.com/hook", "s3cret").
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(now))
+	mock.ExpectQuery("SELECT url, secret, created_at FROM seller_webhooks WHERE seller_id = \\$1").
+		WithArgs(2).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectExec("DELETE FROM seller_webhooks WHERE seller_id = \\$1").
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	hook, err := repo.SetSellerWebhook(1, "https://example.com/hook", "s3cret")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if hook.SellerID != 1 || hook.Secret != "s3cret" || !hook.CreatedAt.Equal(now) {
+		t.Errorf("Unexpected webhook: %+v", hook)
+	}
+
+	if _, err := repo.GetSellerWebhook(2); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows, got %v", err)
+	}
+
+	deleted, err := repo.DeleteSellerWebhook(1)
+	if err != nil || !deleted {
+		t.Errorf("Expected webhook to be deleted, got %v, %v", deleted, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCancelPurchase(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT status, order_status FROM purchases WHERE id = \\$1 FOR UPDATE").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "order_status"}).AddRow("approved", "paid"))
+	mock.ExpectQuery("SELECT status, attempt_number FROM deliveries WHERE purchase_id = \\$1 ORDER BY id DESC LIMIT 1").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "attempt_number"}).AddRow("rescheduled", 2))
+	mock.ExpectQuery("UPDATE purchases SET status = \\$2, order_status = \\$3 WHERE id = \\$1").
+		WithArgs(3, "canceled", "refunded").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "listing_id", "price", "tax_amount", "bank_tx_id", "delivery_address", "pickup_point_id", "status", "order_status", "created_at"}).
+			AddRow(3, 1, 100.0, 0.0, "TX3", "Main St 1", nil, "canceled", "refunded", now))
+	mock.ExpectQuery("INSERT INTO purchase_cancellations \\(purchase_id, reason\\) VALUES \\(\\$1, \\$2\\) RETURNING canceled_at").
+		WithArgs(3, "Ordered by mistake").
+		WillReturnRows(sqlmock.NewRows([]string{"canceled_at"}).AddRow(now))
+	mock.ExpectQuery("INSERT INTO deliveries \\(purchase_id, timestamp, status, attempt_number\\)").
+		WithArgs(3, "canceled", 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "timestamp"}).AddRow(11, now))
+	mock.ExpectCommit()
+
+	purchase, delivery, err := repo.CancelPurchase(3, "Ordered by mistake")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if purchase.Cancellation == nil || purchase.Cancellation.Reason != "Ordered by mistake" || !purchase.Cancellation.CanceledAt.Equal(now) {
+		t.Errorf("Unexpected cancellation: %+v", purchase.Cancellation)
+	}
+	if purchase.Status != "canceled" || purchase.OrderStatus != "refunded" {
+		t.Errorf("Expected a canceled and refunded purchase, got %s and %s", purchase.Status, purchase.OrderStatus)
+	}
+	if delivery.ID != 11 || delivery.Status != "canceled" || delivery.AttemptNumber != 2 {
+		t.Errorf("Unexpected delivery: %+v", delivery)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCancelShippedPurchase(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT status, order_status FROM purchases WHERE id = \\$1 FOR UPDATE").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "order_status"}).AddRow("approved", "shipped"))
+	mock.ExpectRollback()
+
+	if _, _, err := repo.CancelPurchase(3, ""); !errors.Is(err, ErrPurchaseNotCancelable) {
+		t.Errorf("Expected ErrPurchaseNotCancelable, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetSellerSales(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("SELECT COUNT\\(p.id\\), COALESCE\\(SUM\\(p.price\\), 0\\) FROM purchases p JOIN listings l ON l.id = p.listing_id WHERE l.seller_id = \\$1 AND p.status NOT IN \\(\\$2, \\$3\\) AND p.created_at >= \\$4$").
+		WithArgs(1, "rejected", "canceled", from).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "sum"}).
+			AddRow(4, "210.50"))
+
+	summary, err := repo.GetSellerSales(1, &from, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if summary.Sales != 4 || summary.Revenue != 21050 || summary.From != &from || summary.To != nil {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetAllSellerSales(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT s.id, COUNT\\(p.id\\), COALESCE\\(SUM\\(p.price\\), 0\\) FROM sellers s (.+) GROUP BY s.id ORDER BY s.id").
+		WithArgs("rejected", "canceled").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "count", "sum"}).
+			AddRow(1, 3, "120.00").
+			AddRow(2, 0, "0"))
+
+	summaries, err := repo.GetAllSellerSales()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(summaries) != 2 || summaries[0].Sales != 3 || summaries[0].Revenue != 12000 || summaries[1].SellerID != 2 {
+		t.Errorf("Unexpected summaries: %+v", summaries)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetSellerDigests(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 1)
+
+	mock.ExpectQuery("SELECT s.id, COUNT\\(p.id\\), COALESCE\\(SUM\\(p.price\\), 0\\) FROM sellers s (.+) WHERE s.digest_opt_in GROUP BY s.id").
+		WithArgs(from, to, "rejected", "canceled").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "count", "sum"}).
+			AddRow(1, 2, 150.0).
+			AddRow(2, 0, 0.0))
+	mock.ExpectQuery("SELECT l.seller_id, d.status, COUNT\\(\\*\\) FROM deliveries d (.+) GROUP BY l.seller_id, d.status").
+		WithArgs(from, to).
+		WillReturnRows(sqlmock.NewRows([]string{"seller_id", "status", "count"}).
+			AddRow(1, "delivered", 1).
+			AddRow(1, "packed", 2))
+
+	digests, err := repo.GetSellerDigests(from, to)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(digests) != 2 {
+		t.Fatalf("Expected 2 digests, got %d", len(digests))
+	}
+	if digests[0].Purchases != 2 || digests[0].Revenue != 15000 || len(digests[0].DeliveryStatusCounts) != 2 {
+		t.Errorf("Unexpected digest: %+v", digests[0])
+	}
+	if digests[1].Purchases != 0 || digests[1].DeliveryStatusCounts == nil || len(digests[1].DeliveryStatusCounts) != 0 {
+		t.Errorf("Unexpected digest: %+v", digests[1])
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+var placeholderPattern = regexp.MustCompile(`\$(\d+)`)
+
+// checkWhere fails if a WHERE clause could carry injected input: it must not
+// contain quotes, statement separators or comments, its parentheses must
+// balance and its placeholders must number exactly the arguments
+func checkWhere(t *testing.T, where string, args []interface{}) {
+	t.Helper()
+
+	if strings.ContainsAny(where, "';\\") || strings.Contains(where, "--") || strings.Contains(where, "/*") {
+		t.Fatalf("WHERE clause contains input: %s", where)
+	}
+	if where != "" && !strings.HasPrefix(where, " WHERE ") {
+		t.Fatalf("Malformed WHERE clause: %s", where)
+	}
+
+	depth := 0
+	for _, c := range where {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+		if depth < 0 {
+			t.Fatalf("Unbalanced parentheses: %s", where)
+		}
+	}
+	if depth != 0 {
+		t.Fatalf("Unbalanced parentheses: %s", where)
+	}
+
+	seen := make(map[int]bool)
+	for _, match := range placeholderPattern.FindAllStringSubmatch(where, -1) {
+		n, _ := strconv.Atoi(match[1])
+		if n < 1 || n > len(args) {
+			t.Fatalf("Placeholder $%d out of range for %d args: %s", n, len(args), where)
+		}
+		seen[n] = true
+	}
+	if len(seen) != len(args) {
+		t.Fatalf("%d placeholders for %d args: %s", len(seen), len(args), where)
+	}
+}
+
+// fuzzListingFilter builds a listing filter tree. Each byte of shape picks the
+// conditions of one level and whether it nests the rest in and/or sub-filters
+func fuzzListingFilter(shape []byte, sellerID int, price models.Money, title, titleNotLike string) *models.ListingFilter {
+	filter := &models.ListingFilter{}
+	if len(shape) == 0 {
+		return filter
+	}
+
+	b, rest := shape[0], shape[1:]
+	if b&1 != 0 {
+		filter.SellerID = &sellerID
+	}
+	if b&2 != 0 {
+		filter.SellerIDIn = []int{sellerID, sellerID + 1}
+	}
+	if b&4 != 0 {
+		filter.MaxPrice = &price
+	}
+	if b&8 != 0 {
+		filter.Title = &title
+	}
+	if b&16 != 0 {
+		filter.TitleNotLike = &titleNotLike
+	}
+
+	sub := func() *models.ListingFilter {
+		return fuzzListingFilter(rest, sellerID, price, title, titleNotLike)
+	}
+	switch b >> 5 {
+	case 1:
+		filter.And = []*models.ListingFilter{sub()}
+	case 2:
+		filter.Or = []*models.ListingFilter{sub(), {}}
+	case 3:
+		filter.Or = []*models.ListingFilter{}
+	case 4:
+		filter.And = []*models.ListingFilter{sub(), {}}
+		filter.Or = []*models.ListingFilter{{Title: &title}, sub()}
+	}
+	return filter
+}
+
+func FuzzBuildListingWhere(f *testing.F) {
+	f.Add(1, int64(1000), "sale", "refurbished", []byte{0x41, 0x08})
+	f.Add(0, int64(-1), "'; DROP TABLE listings; --", "$1", []byte{0x9f, 0x7f, 0x3f})
+	f.Add(-1, int64(0), "%_\\", ")) OR ((TRUE", []byte{0x60})
+
+	f.Fuzz(func(t *testing.T, sellerID int, price int64, title, titleNotLike string, shape []byte) {
+		// Trees double with every level nesting both and and or
+		if len(shape) > 8 {
+			shape = shape[:8]
+		}
+		filter := fuzzListingFilter(shape, sellerID, models.Money(price), title, titleNotLike)
+
+		where, args := buildListingWhere(filter)
+		checkWhere(t, where, args)
+	})
+}
+
+func FuzzBuildPurchaseWhere(f *testing.F) {
+	f.Add(1, "TX123", "approved", "paid", int64(0), uint8(0xff))
+	f.Add(-1, "' OR 1=1 --", "$2", ");", int64(-1), uint8(0x55))
+
+	f.Fuzz(func(t *testing.T, listingID int, bankTxID, status, orderStatus string, unix int64, fields uint8) {
+		date := time.Unix(unix, 0)
+		filter := &models.PurchaseFilter{}
+		if fields&1 != 0 {
+			filter.ListingID = &listingID
+		}
+		if fields&2 != 0 {
+			filter.ListingIDIn = []int{listingID}
+		}
+		if fields&4 != 0 {
+			filter.BankTxID = &bankTxID
+		}
+		if fields&8 != 0 {
+			filter.Status = &status
+		}
+		if fields&16 != 0 {
+			filter.OrderStatus = &orderStatus
+		}
+		if fields&32 != 0 {
+			filter.FromDate = &date
+		}
+		if fields&64 != 0 {
+			filter.ToDate = &date
+		}
+
+		where, args := buildPurchaseWhere(filter)
+		checkWhere(t, where, args)
+	})
+}
+
+func FuzzBuildDeliveryWhere(f *testing.F) {
+	f.Add(1, "packed", "canceled", int64(0), uint8(0xff))
+	f.Add(-1, "'; --", "/* $1 */", int64(-1), uint8(0xaa))
+
+	f.Fuzz(func(t *testing.T, purchaseID int, status, statusNot string, unix int64, fields uint8) {
+		date := time.Unix(unix, 0)
+		filter := &models.DeliveryFilter{}
+		if fields&1 != 0 {
+			filter.PurchaseID = &purchaseID
+		}
+		if fields&2 != 0 {
+			filter.Status = &status
+		}
+		if fields&4 != 0 {
+			filter.StatusIn = []string{status, statusNot}
+		}
+		if fields&8 != 0 {
+			filter.StatusNot = &statusNot
+		}
+		if fields&16 != 0 {
+			filter.FromDate = &date
+			filter.ToDate = &date
+		}
+		if fields&32 != 0 {
+			filter.ScheduledFrom = &date
+		}
+		if fields&64 != 0 {
+			filter.ScheduledTo = &date
+		}
+
+		where, args := buildDeliveryWhere(filter)
+		checkWhere(t, where, args)
+	})
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	breaker := NewBreaker(2, 10*time.Second)
+	breaker.now = func() time.Time { return now }
+	repo.SetBreaker(breaker)
+
+	// Errors returned by the database itself don't open the breaker
+	mock.ExpectQuery("FROM sellers").WillReturnError(&pq.Error{Code: "42P01"})
+	if _, err := repo.GetSeller(1); err == nil || errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected a plain database error, got %v", err)
+	}
+
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery("FROM sellers").WillReturnError(refused)
+		if _, err := repo.GetSeller(1); !errors.Is(err, ErrUnavailable) {
+			t.Errorf("Expected ErrUnavailable for an unreachable database, got %v", err)
+		}
+	}
+	if !breaker.Open() {
+		t.Fatalf("Expected the breaker to open after 2 consecutive failures")
+	}
+
+	// While open, calls fail without touching the database
+	if _, err := repo.GetSeller(1); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected an open breaker to fail fast, got %v", err)
+	}
+
+	// After the cooldown a failed probe keeps it open, a successful one closes it
+	now = now.Add(10 * time.Second)
+	mock.ExpectQuery("FROM sellers").WillReturnError(refused)
+	repo.GetSeller(1)
+	if !breaker.Open() {
+		t.Errorf("Expected a failed probe to keep the breaker open")
+	}
+	now = now.Add(10 * time.Second)
+	mock.ExpectQuery("FROM sellers").WillReturnRows(sqlmock.NewRows([]string{"id", "name", "address", "digest_opt_in"}).AddRow(1, "Acme", "Main St", false))
+	if _, err := repo.GetSeller(1); err != nil {
+		t.Errorf("Expected the probe to succeed, got %v", err)
+	}
+	if breaker.Open() {
+		t.Errorf("Expected a successful probe to close the breaker")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package search
+
+import (
+	"context"
+	"log"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+)
+
+// queueSize bounds the number of index updates waiting in memory; overflow is
+// picked up by the next full reindex
+const queueSize = 100
+
+// Store loads the listings for a full reindex
+type Store interface {
+	GetListings(filter *models.ListingFilter) ([]*models.Listing, error)
+}
+
+// update is a queued index change; a nil listing deletes the ID
+type update struct {
+	id      int
+	listing *models.Listing
+}
+
+// Indexer pushes listing changes to the search backend in the background, so
+// mutations don't wait for the search engine
+type Indexer struct {
+	backend Backend
+	store   Store
+	queue   chan update
+}
+
+// NewIndexer creates an indexer updating the given backend
+func NewIndexer(backend Backend, store Store) *Indexer {
+	return &Indexer{
+		backend: backend,
+		store:   store,
+		queue:   make(chan update, queueSize),
+	}
+}
+
+// Backend returns the search backend the indexer updates
+func (i *Indexer) Backend() Backend {
+	return i.backend
+}
+
+// ListingChanged queues a created or updated listing for indexing without blocking the caller
+func (i *Indexer) ListingChanged(listing *models.Listing) {
+	i.enqueue(update{id: listing.ID, listing: listing})
+}
+
+// ListingDeleted queues the removal of a listing from the index without blocking the caller
+func (i *Indexer) ListingDeleted(id int) {
+	i.enqueue(update{id: id})
+}
+
+func (i *Indexer) enqueue(u update) {
+	select {
+	case i.queue <- u:
+	default:
+		log.Printf("[Search] Index queue is full, listing ID %d will be picked up by the next reindex", u.id)
+	}
+}
+
+// Reindex indexes every listing, catching up on changes made while the
+// search engine was unreachable or the queue overflowed
+func (i *Indexer) Reindex(ctx context.Context) error {
+	listings, err := i.store.GetListings(nil)
+	if err != nil {
+		return err
+	}
+
+	for _, listing := range listings {
+		if err := i.backend.Index(ctx, listing); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("[Search] Reindexed %d listings", len(listings))
+	return nil
+}
+
+// apply writes a single queued change to the backend
+func (i *Indexer) apply(ctx context.Context, u update) error {
+	if u.listing == nil {
+		return i.backend.Delete(ctx, u.id)
+	}
+	return i.backend.Index(ctx, u.listing)
+}
+
+// Run reindexes all listings and then applies queued changes until stop is closed
+func (i *Indexer) Run(stop <-chan struct{}) {
+	ctx := context.Background()
+
+	if err := i.Reindex(ctx); err != nil {
+		log.Printf("[Search] Error reindexing listings: %v", err)
+	}
+
+	for {
+		select {
+		case u := <-i.queue:
+			if err := i.apply(ctx, u); err != nil {
+				log.Printf("[Search] Error indexing listing ID %d: %v", u.id, err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+)
+
+// Backend keeps a search index of listings and answers full-text queries
+type Backend interface {
+	// Index adds or replaces a listing in the index
+	Index(ctx context.Context, listing *models.Listing) error
+	// Delete removes a listing from the index
+	Delete(ctx context.Context, id int) error
+	// Search returns the IDs of up to limit listings matching the query, best match first
+	Search(ctx context.Context, query string, limit int) ([]int, error)
+}
+
+// document is the indexed representation of a listing
+type document struct {
+	ID          int          `json:"id"`
+	SellerID    int          `json:"sellerId"`
+	Title       string       `json:"title"`
+	Description string       `json:"description"`
+	Price       models.Money `json:"price"`
+}
+
+func newDocument(listing *models.Listing) document {
+	return document{
+		ID:          listing.ID,
+		SellerID:    listing.SellerID,
+		Title:       listing.Title,
+		Description: listing.Description,
+		Price:       listing.Price,
+	}
+}
+
+// client sends JSON requests to a search engine's REST API
+type client struct {
+	baseURL    string
+	authHeader string
+	http       *http.Client
+}
+
+func newClient(baseURL, authHeader string) client {
+	return client{baseURL: baseURL, authHeader: authHeader, http: &http.Client{Timeout: 5 * time.Second}}
+}
+
+// do sends the request and decodes a JSON response into result, if given
+func (c client) do(ctx context.Context, method, path string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal search request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create search request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.authHeader != "" {
+		req.Header.Set("Authorization", c.authHeader)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call search engine: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read search engine response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("search engine responded with %s: %s", resp.Status, string(respBody))
+	}
+
+	if result != nil {
+		if err := json.Unmarshal(respBody, result); err != nil {
+			return fmt.Errorf("failed to unmarshal search engine response: %w", err)
+		}
+	}
+	return nil
+}
+
+// Meilisearch indexes listings in a Meilisearch index
+type Meilisearch struct {
+	client client
+	index  string
+}
+
+// NewMeilisearch creates a backend for the given index of the Meilisearch server at baseURL
+func NewMeilisearch(baseURL, apiKey, index string) *Meilisearch {
+	var auth string
+	if apiKey != "" {
+		auth = "Bearer " + apiKey
+	}
+	return &Meilisearch{client: newClient(baseURL, auth), index: url.PathEscape(index)}
+}
+
+// Index adds or replaces the listing document
+func (m *Meilisearch) Index(ctx context.Context, listing *models.Listing) error {
+	return m.client.do(ctx, http.MethodPost, "/indexes/"+m.index+"/documents?primaryKey=id",
+		[]document{newDocument(listing)}, nil)
+}
+
+// Delete removes the listing document
+func (m *Meilisearch) Delete(ctx context.Context, id int) error {
+	return m.client.do(ctx, http.MethodDelete, "/indexes/"+m.index+"/documents/"+strconv.Itoa(id), nil, nil)
+}
+
+// Search runs a ranked query over the listing documents
+func (m *Meilisearch) Search(ctx context.Context, query string, limit int) ([]int, error) {
+	req := map[string]interface{}{
+		"q":                    query,
+		"limit":                limit,
+		"attributesToRetrieve": []string{"id"},
+	}
+	var result struct {
+		Hits []struct {
+			ID int `json:"id"`
+		} `json:"hits"`
+	}
+	if err := m.client.do(ctx, http.MethodPost, "/indexes/"+m.index+"/search", req, &result); err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, 0, len(result.Hits))
+	for _, hit := range result.Hits {
+		ids = append(ids, hit.ID)
+	}
+	return ids, nil
+}
+
+// Elasticsearch indexes listings in an Elasticsearch index
+type Elasticsearch struct {
+	client client
+	index  string
+}
+
+// NewElasticsearch creates a backend for the given index of the Elasticsearch cluster at baseURL
+func NewElasticsearch(baseURL, apiKey, index string) *Elasticsearch {
+	var auth string
+	if apiKey != "" {
+		auth = "ApiKey " + apiKey
+	}
+	return &Elasticsearch{client: newClient(baseURL, auth), index: url.PathEscape(index)}
+}
+
+// Index adds or replaces the listing document
+func (e *Elasticsearch) Index(ctx context.Context, listing *models.Listing) error {
+	return e.client.do(ctx, http.MethodPut, "/"+e.index+"/_doc/"+strconv.Itoa(listing.ID), newDocument(listing), nil)
+}
+
+// Delete removes the listing document
+func (e *Elasticsearch) Delete(ctx context.Context, id int) error {
+	return e.client.do(ctx, http.MethodDelete, "/"+e.index+"/_doc/"+strconv.Itoa(id), nil, nil)
+}
+
+// Search runs a ranked query over titles and descriptions, weighting title matches higher
+func (e *Elasticsearch) Search(ctx context.Context, query string, limit int) ([]int, error) {
+	req := map[string]interface{}{
+		"size":    limit,
+		"_source": false,
+		"query": map[string]interface{}{
+			"multi_match": map[string]interface{}{
+				"query":  query,
+				"fields": []string{"title^2", "description"},
+			},
+		},
+	}
+	var result struct {
+		Hits struct {
+			Hits []struct {
+				ID string `json:"_id"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := e.client.do(ctx, http.MethodPost, "/"+e.index+"/_search", req, &result); err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, 0, len(result.Hits.Hits))
+	for _, hit := range result.Hits.Hits {
+		id, err := strconv.Atoi(hit.ID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listing ID in search results: %s", hit.ID)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,142 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+)
+
+func TestMeilisearch(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.RequestURI())
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("Expected API key, got %q", r.Header.Get("Authorization"))
+		}
+
+		if r.URL.Path == "/indexes/listings/search" {
+			var req map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&req)
+			if req["q"] != "laptop" || req["limit"] != 5.0 {
+				t.Errorf("Unexpected search request: %v", req)
+			}
+			w.Write([]byte(`{"hits": [{"id": 3}, {"id": 1}]}`))
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"taskUid": 1}`))
+	}))
+	defer server.Close()
+
+	backend := NewMeilisearch(server.URL, "secret", "listings")
+	ctx := context.Background()
+
+	if err := backend.Index(ctx, &models.Listing{ID: 3, Title: "Laptop"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := backend.Delete(ctx, 4); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	ids, err := backend.Search(ctx, "laptop", 5)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(ids, []int{3, 1}) {
+		t.Errorf("Expected IDs [3 1], got %v", ids)
+	}
+	expected := []string{
+		"POST /indexes/listings/documents?primaryKey=id",
+		"DELETE /indexes/listings/documents/4",
+		"POST /indexes/listings/search",
+	}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("Expected requests %v, got %v", expected, requests)
+	}
+}
+
+func TestElasticsearchSearch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/listings/_search" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		w.Write([]byte(`{"hits": {"hits": [{"_id": "7"}, {"_id": "2"}]}}`))
+	}))
+	defer server.Close()
+
+	ids, err := NewElasticsearch(server.URL, "", "listings").Search(context.Background(), "chair", 10)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(ids, []int{7, 2}) {
+		t.Errorf("Expected IDs [7 2], got %v", ids)
+	}
+}
+
+func TestSearchEngineError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "index not found", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	if _, err := NewMeilisearch(server.URL, "", "listings").Search(context.Background(), "x", 1); err == nil {
+		t.Error("Expected an error for a failed search")
+	}
+}
+
+type fakeBackend struct {
+	indexed []int
+	deleted []int
+}
+
+func (b *fakeBackend) Index(ctx context.Context, listing *models.Listing) error {
+	b.indexed = append(b.indexed, listing.ID)
+	return nil
+}
+
+func (b *fakeBackend) Delete(ctx context.Context, id int) error {
+	b.deleted = append(b.deleted, id)
+	return nil
+}
+
+func (b *fakeBackend) Search(ctx context.Context, query string, limit int) ([]int, error) {
+	return nil, nil
+}
+
+type fakeStore struct {
+	listings []*models.Listing
+}
+
+func (s *fakeStore) GetListings(filter *models.ListingFilter) ([]*models.Listing, error) {
+	return s.listings, nil
+}
+
+func TestIndexer(t *testing.T) {
+	backend := &fakeBackend{}
+	indexer := NewIndexer(backend, &fakeStore{listings: []*models.Listing{{ID: 1}, {ID: 2}}})
+	ctx := context.Background()
+
+	if err := indexer.Reindex(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	indexer.ListingChanged(&models.Listing{ID: 3})
+	indexer.ListingDeleted(2)
+	for len(indexer.queue) > 0 {
+		if err := indexer.apply(ctx, <-indexer.queue); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if !reflect.DeepEqual(backend.indexed, []int{1, 2, 3}) {
+		t.Errorf("Expected listings 1, 2 and 3 indexed, got %v", backend.indexed)
+	}
+	if !reflect.DeepEqual(backend.deleted, []int{2}) {
+		t.Errorf("Expected listing 2 deleted, got %v", backend.deleted)
+	}
+}
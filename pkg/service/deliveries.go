@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	ids "github.com/korjavin/graphqlTinyExample/pkg/id"
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+)
+
+// DeliveryStore persists delivery updates
+type DeliveryStore interface {
+	GetPurchase(id int) (*models.Purchase, error)
+	GetDelivery(id int) (*models.Delivery, error)
+	CreateDelivery(purchaseID int, status string) (*models.Delivery, error)
+	RescheduleDelivery(purchaseID int, scheduledFor time.Time) (*models.Delivery, error)
+	AdvanceDelivery(deliveryID int, status string) (*models.Delivery, error)
+	UpdateOrderStatus(id int, from, to string) (*models.Purchase, error)
+}
+
+// Deliveries records delivery updates and publishes them to subscribers
+type Deliveries struct {
+	store     DeliveryStore
+	publisher DeliveryPublisher
+	now       func() time.Time
+}
+
+// NewDeliveries creates the delivery service
+func NewDeliveries(store DeliveryStore, publisher DeliveryPublisher) *Deliveries {
+	return &Deliveries{store: store, publisher: publisher, now: time.Now}
+}
+
+// Create records a delivery update of an existing purchase
+func (s *Deliveries) Create(ctx context.Context, purchaseID int, status string) (*models.Delivery, error) {
+	if err := models.ValidateDeliveryStatus(status); err != nil {
+		return nil, invalidInput("status", "%v", err)
+	}
+
+	if _, err := s.store.GetPurchase(purchaseID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, notFound("purchaseId", "purchase not found: %s", ids.FormatPurchaseID(purchaseID))
+		}
+		return nil, err
+	}
+
+	delivery, err := s.store.CreateDelivery(purchaseID, status)
+	if err != nil {
+		return nil, err
+	}
+
+	s.advanceOrder(delivery)
+	s.publisher.PublishDelivery(delivery)
+	return delivery, nil
+}
+
+// Reschedule starts a new delivery attempt of a purchase at a future date
+func (s *Deliveries) Reschedule(ctx context.Context, purchaseID int, scheduledFor time.Time) (*models.Delivery, error) {
+	if !scheduledFor.After(s.now()) {
+		return nil, invalidInput("scheduledFor", "scheduledFor must be in the future: %s", scheduledFor.Format(time.RFC3339))
+	}
+
+	if _, err := s.store.GetPurchase(purchaseID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, notFound("purchaseId", "purchase not found: %s", ids.FormatPurchaseID(purchaseID))
+		}
+		return nil, err
+	}
+
+	delivery, err := s.store.RescheduleDelivery(purchaseID, scheduledFor)
+	if err != nil {
+		return nil, err
+	}
+
+	s.advanceOrder(delivery)
+	s.publisher.PublishDelivery(delivery)
+	return delivery, nil
+}
+
+// UpdateStatus moves a delivery to a new status, recording it as a new status
+// update of the same attempt after validating the transition
+func (s *Deliveries) UpdateStatus(ctx context.Context, deliveryID int, status string) (*models.Delivery, error) {
+	// A new attempt needs a date, which only Reschedule takes
+	if status == models.DeliveryStatusRescheduled {
+		return nil, invalidInput("status", "use rescheduleDelivery to reschedule a delivery")
+	}
+
+	current, err := s.store.GetDelivery(deliveryID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, notFound("deliveryId", "delivery not found: %s", ids.FormatDeliveryID(deliveryID))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := models.ValidateDeliveryTransition(current.Status, status); err != nil {
+		return nil, conflict("status", "invalid status transition for delivery %d: %v", deliveryID, err)
+	}
+
+	delivery, err := s.store.AdvanceDelivery(deliveryID, status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, conflict("deliveryId", "delivery %d is no longer the latest update of purchase %d", deliveryID, current.PurchaseID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.advanceOrder(delivery)
+	s.publisher.PublishDelivery(delivery)
+	return delivery, nil
+}
+
+// advanceOrder moves the order of a delivered purchase forward, e.g. to SHIPPED
+// once it is out for delivery. Orders that can't make the transition, such as
+// ones still pending review, keep their status; failures are logged since the
+// delivery update itself has already been stored
+func (s *Deliveries) advanceOrder(delivery *models.Delivery) {
+	status, ok := models.OrderStatusAfterDelivery(delivery.Status)
+	if !ok {
+		return
+	}
+
+	purchase, err := s.store.GetPurchase(delivery.PurchaseID)
+	if err != nil {
+		log.Printf("[Service] Error fetching purchase ID %d: %v", delivery.PurchaseID, err)
+		return
+	}
+	if err := models.ValidateOrderTransition(purchase.OrderStatus, status); err != nil {
+		log.Printf("[Service] Order of purchase ID %d stays %s: %v", purchase.ID, purchase.OrderStatus, err)
+		return
+	}
+
+	if _, err := s.store.UpdateOrderStatus(purchase.ID, purchase.OrderStatus, status); err != nil {
+		log.Printf("[Service] Error updating order status of purchase ID %d: %v", purchase.ID, err)
+	}
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+	"github.com/korjavin/graphqlTinyExample/pkg/repository"
+)
+
+type fakeDeliveryStore struct {
+	current     *models.Delivery
+	advanceErr  error
+	getErr      error
+	orderStatus string
+}
+
+func (s *fakeDeliveryStore) GetPurchase(id int) (*models.Purchase, error) {
+	if s.getErr != nil {
+		return nil, s.getErr
+	}
+	if id != 1 {
+		return nil, sql.ErrNoRows
+	}
+	return &models.Purchase{ID: id, OrderStatus: s.orderStatus}, nil
+}
+
+func (s *fakeDeliveryStore) UpdateOrderStatus(id int, from, to string) (*models.Purchase, error) {
+	if from != s.orderStatus {
+		return nil, sql.ErrNoRows
+	}
+	s.orderStatus = to
+	return &models.Purchase{ID: id, OrderStatus: to}, nil
+}
+
+func (s *fakeDeliveryStore) GetDelivery(id int) (*models.Delivery, error) {
+	if s.current == nil || s.current.ID != id {
+		return nil, sql.ErrNoRows
+	}
+	return s.current, nil
+}
+
+func (s *fakeDeliveryStore) CreateDelivery(purchaseID int, status string) (*models.Delivery, error) {
+	return &models.Delivery{ID: 1, PurchaseID: purchaseID, Status: status}, nil
+}
+
+func (s *fakeDeliveryStore) RescheduleDelivery(purchaseID int, scheduledFor time.Time) (*models.Delivery, error) {
+	return &models.Delivery{ID: 2, PurchaseID: purchaseID, Status: models.DeliveryStatusRescheduled, AttemptNumber: 2}, nil
+}
+
+func (s *fakeDeliveryStore) AdvanceDelivery(deliveryID int, status string) (*models.Delivery, error) {
+	if s.advanceErr != nil {
+		return nil, s.advanceErr
+	}
+	return &models.Delivery{ID: deliveryID + 1, PurchaseID: s.current.PurchaseID, Status: status}, nil
+}
+
+func TestDeliveriesCreate(t *testing.T) {
+	publisher := &fakePublisher{}
+	deliveries := NewDeliveries(&fakeDeliveryStore{}, publisher)
+	ctx := context.Background()
+
+	if _, err := deliveries.Create(ctx, 1, "lost"); err == nil {
+		t.Errorf("Expected an unknown status to be rejected")
+	}
+	if _, err := deliveries.Create(ctx, 2, models.DeliveryStatusPacked); err == nil {
+		t.Errorf("Expected an unknown purchase to be rejected")
+	}
+
+	delivery, err := deliveries.Create(ctx, 1, models.DeliveryStatusPacked)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(publisher.published) != 1 || publisher.published[0] != delivery {
+		t.Errorf("Expected the delivery to be published, got %v", publisher.published)
+	}
+}
+
+func TestDeliveriesCreateStoreUnavailable(t *testing.T) {
+	unavailable := fmt.Errorf("%w: circuit breaker open", repository.ErrUnavailable)
+	deliveries := NewDeliveries(&fakeDeliveryStore{getErr: unavailable}, &fakePublisher{})
+
+	// Only a missing purchase is not found; other lookup errors pass through
+	_, err := deliveries.Create(context.Background(), 1, models.DeliveryStatusPacked)
+	var inputErr *InputError
+	if !errors.Is(err, repository.ErrUnavailable) || errors.As(err, &inputErr) {
+		t.Errorf("Expected the unavailable error to pass through, got %v", err)
+	}
+}
+
+func TestDeliveriesReschedule(t *testing.T) {
+	publisher := &fakePublisher{}
+	deliveries := NewDeliveries(&fakeDeliveryStore{}, publisher)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	deliveries.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if _, err := deliveries.Reschedule(ctx, 1, now.Add(-time.Hour)); err == nil {
+		t.Errorf("Expected a past date to be rejected")
+	}
+
+	delivery, err := deliveries.Reschedule(ctx, 1, now.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if delivery.AttemptNumber != 2 || len(publisher.published) != 1 {
+		t.Errorf("Expected a published second attempt, got %+v and %d published", delivery, len(publisher.published))
+	}
+}
+
+func TestDeliveriesUpdateStatus(t *testing.T) {
+	store := &fakeDeliveryStore{
+		current:     &models.Delivery{ID: 5, PurchaseID: 1, Status: models.DeliveryStatusPacked},
+		orderStatus: models.OrderStatusPaid,
+	}
+	publisher := &fakePublisher{}
+	deliveries := NewDeliveries(store, publisher)
+	ctx := context.Background()
+
+	if _, err := deliveries.UpdateStatus(ctx, 5, models.DeliveryStatusRescheduled); err == nil {
+		t.Errorf("Expected rescheduling to be refused")
+	}
+	if _, err := deliveries.UpdateStatus(ctx, 5, models.DeliveryStatusDelivered); err == nil {
+		t.Errorf("Expected packed -> delivered to be refused")
+	}
+	if _, err := deliveries.UpdateStatus(ctx, 6, models.DeliveryStatusOutForDelivery); err == nil || err.Error() != "delivery not found: 6" {
+		t.Errorf("Expected delivery not found, got %v", err)
+	}
+
+	store.advanceErr = sql.ErrNoRows
+	if _, err := deliveries.UpdateStatus(ctx, 5, models.DeliveryStatusOutForDelivery); err == nil {
+		t.Errorf("Expected a superseded delivery to be refused")
+	}
+	if len(publisher.published) != 0 {
+		t.Fatalf("Expected nothing to be published, got %v", publisher.published)
+	}
+
+	store.advanceErr = nil
+	delivery, err := deliveries.UpdateStatus(ctx, 5, models.DeliveryStatusOutForDelivery)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if delivery.Status != models.DeliveryStatusOutForDelivery || len(publisher.published) != 1 {
+		t.Errorf("Expected the new status to be published, got %+v", delivery)
+	}
+	if store.orderStatus != models.OrderStatusShipped {
+		t.Errorf("Expected the order to be shipped, got %s", store.orderStatus)
+	}
+}
+
+func TestDeliveriesKeepUnpaidOrders(t *testing.T) {
+	store := &fakeDeliveryStore{orderStatus: models.OrderStatusPending}
+	deliveries := NewDeliveries(store, &fakePublisher{})
+
+	if _, err := deliveries.Create(context.Background(), 1, models.DeliveryStatusDelivered); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if store.orderStatus != models.OrderStatusPending {
+		t.Errorf("Expected an unpaid order to stay pending, got %s", store.orderStatus)
+	}
+}
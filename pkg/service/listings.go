@@ -0,0 +1,298 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	ids "github.com/korjavin/graphqlTinyExample/pkg/id"
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+	"github.com/korjavin/graphqlTinyExample/pkg/moderation"
+)
+
+// ListingStore persists listings and moderation decisions
+type ListingStore interface {
+	GetSeller(id int) (*models.Seller, error)
+	CreateListing(sellerID int, title, description string, price models.Money) (*models.Listing, error)
+	CreateListings(listings []models.NewListing) ([]*models.Listing, error)
+	UpdateListing(id int, title, description *string, price *models.Money) (*models.Listing, error)
+	RecordModerationDecision(contentType string, contentID *int, content, action, reason string) error
+	CountSellerListings(sellerID int) (int, error)
+	GetSellerQuota(sellerID int) (*models.SellerQuota, error)
+}
+
+// ListingObserver is told about every stored listing, e.g. to keep a search index in sync
+type ListingObserver interface {
+	ListingChanged(listing *models.Listing)
+}
+
+// Listings creates and updates listings, screening their text and prices
+type Listings struct {
+	store     ListingStore
+	moderator moderation.Checker
+	observer  ListingObserver
+	publisher ListingPublisher
+	// maxListings bounds the listings of sellers without a quota of their own
+	maxListings int
+}
+
+// NewListings creates the listing service screening listing text with the
+// given checker and announcing created listings to the publisher
+func NewListings(store ListingStore, moderator moderation.Checker, publisher ListingPublisher) *Listings {
+	return &Listings{store: store, moderator: moderator, publisher: publisher}
+}
+
+// SetModerationChecker sets the checker screening listing titles and descriptions
+func (s *Listings) SetModerationChecker(checker moderation.Checker) {
+	s.moderator = checker
+}
+
+// SetMaxListings sets the number of listings a seller may have unless an admin
+// set a quota for them; zero means unlimited. The limit is soft: concurrent
+// creations may exceed it slightly
+func (s *Listings) SetMaxListings(max int) {
+	s.maxListings = max
+}
+
+// SetObserver sets the observer told about created and updated listings
+func (s *Listings) SetObserver(observer ListingObserver) {
+	s.observer = observer
+}
+
+// DryRun returns a copy of the service storing listings in store, e.g. a
+// repository whose changes are rolled back. The copy neither notifies the
+// observer nor publishes created listings
+func (s *Listings) DryRun(store ListingStore) *Listings {
+	return &Listings{store: store, moderator: s.moderator, publisher: discard{}, maxListings: s.maxListings}
+}
+
+// Create validates, moderates and stores a new listing of an existing seller
+func (s *Listings) Create(ctx context.Context, sellerID int, title, description string, price models.Money) (*models.Listing, error) {
+	if err := validateTitle(title); err != nil {
+		return nil, err
+	}
+	if err := validatePrice(price); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.store.GetSeller(sellerID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, notFound("sellerId", "seller not found: %s", ids.FormatSellerID(sellerID))
+		}
+		return nil, err
+	}
+
+	remaining, err := s.remainingListings(sellerID)
+	if err != nil {
+		return nil, err
+	}
+	if remaining == 0 {
+		return nil, listingQuotaExceeded(sellerID)
+	}
+
+	// Screen the listing text before storing it
+	content := title + "\n" + description
+	decision, err := s.moderate(ctx, nil, content)
+	if err != nil {
+		return nil, err
+	}
+
+	listing, err := s.store.CreateListing(sellerID, title, description, price)
+	if err != nil {
+		return nil, err
+	}
+
+	s.stored(listing, content, decision)
+	s.publisher.PublishListingCreated(listing)
+	return listing, nil
+}
+
+// ListingResult is the outcome of one listing of a batch: the stored listing,
+// or the error that kept it from being stored
+type ListingResult struct {
+	Listing *models.Listing
+	Err     error
+}
+
+// CreateBatch validates and moderates each listing like Create, then stores all
+// listings that passed at once. Results are returned in input order; an error
+// is only returned if storing the batch fails, in which case nothing is stored
+func (s *Listings) CreateBatch(ctx context.Context, inputs []models.NewListing) ([]ListingResult, error) {
+	results := make([]ListingResult, len(inputs))
+	decisions := make([]moderation.Decision, len(inputs))
+	var accepted []int
+	var valid []models.NewListing
+	sellers := map[int]error{}
+	// remaining holds the listings each seller may still create, -1 for no limit
+	remaining := map[int]int{}
+
+	for i, input := range inputs {
+		if err := validateTitle(input.Title); err != nil {
+			results[i].Err = err
+			continue
+		}
+		if err := validatePrice(input.Price); err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		sellerErr, checked := sellers[input.SellerID]
+		if !checked {
+			_, sellerErr = s.store.GetSeller(input.SellerID)
+			sellers[input.SellerID] = sellerErr
+		}
+		if errors.Is(sellerErr, sql.ErrNoRows) {
+			results[i].Err = notFound("sellerId", "seller not found: %s", ids.FormatSellerID(input.SellerID))
+			continue
+		}
+		if sellerErr != nil {
+			results[i].Err = sellerErr
+			continue
+		}
+
+		decision, err := s.moderate(ctx, nil, input.Title+"\n"+input.Description)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		left, checked := remaining[input.SellerID]
+		if !checked {
+			if left, err = s.remainingListings(input.SellerID); err != nil {
+				results[i].Err = err
+				continue
+			}
+		}
+		if left == 0 {
+			results[i].Err = listingQuotaExceeded(input.SellerID)
+			continue
+		}
+		if left > 0 {
+			left--
+		}
+		remaining[input.SellerID] = left
+
+		decisions[i] = decision
+		accepted = append(accepted, i)
+		valid = append(valid, input)
+	}
+
+	if len(valid) == 0 {
+		return results, nil
+	}
+
+	listings, err := s.store.CreateListings(valid)
+	if err != nil {
+		return nil, err
+	}
+
+	for j, listing := range listings {
+		i := accepted[j]
+		results[i].Listing = listing
+		s.stored(listing, inputs[i].Title+"\n"+inputs[i].Description, decisions[i])
+		s.publisher.PublishListingCreated(listing)
+	}
+	return results, nil
+}
+
+// remainingListings returns how many more listings a seller may create under
+// their quota or the default limit, or -1 if they may create any number
+func (s *Listings) remainingListings(sellerID int) (int, error) {
+	limit := s.maxListings
+	quota, err := s.store.GetSellerQuota(sellerID)
+	switch {
+	case err == nil:
+		limit = quota.MaxListings
+	case !errors.Is(err, sql.ErrNoRows):
+		return 0, err
+	}
+	if limit <= 0 {
+		return -1, nil
+	}
+
+	count, err := s.store.CountSellerListings(sellerID)
+	if err != nil {
+		return 0, err
+	}
+	return max(limit-count, 0), nil
+}
+
+// listingQuotaExceeded reports a seller who reached their listing limit
+func listingQuotaExceeded(sellerID int) error {
+	return quotaExceeded("sellerId", "seller %d has reached their listing quota", sellerID)
+}
+
+// Update changes the given fields of a listing, leaving nil fields unchanged
+func (s *Listings) Update(ctx context.Context, id int, title, description *string, price *models.Money) (*models.Listing, error) {
+	if title != nil {
+		if err := validateTitle(*title); err != nil {
+			return nil, err
+		}
+	}
+	if price != nil {
+		if err := validatePrice(*price); err != nil {
+			return nil, err
+		}
+	}
+
+	// Screen only the text being changed
+	var content string
+	if title != nil {
+		content = *title
+	}
+	if description != nil {
+		content += "\n" + *description
+	}
+	decision, err := s.moderate(ctx, &id, content)
+	if err != nil {
+		return nil, err
+	}
+
+	listing, err := s.store.UpdateListing(id, title, description, price)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, notFound("id", "listing not found: %s", ids.FormatListingID(id))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.stored(listing, content, decision)
+	s.publisher.PublishListingCreated(listing)
+	return listing, nil
+}
+
+// moderate checks listing text, recording and refusing rejected content.
+// Flagged content is accepted and recorded once the listing is stored
+func (s *Listings) moderate(ctx context.Context, listingID *int, content string) (moderation.Decision, error) {
+	decision, err := s.moderator.Check(ctx, content)
+	if err != nil {
+		return decision, fmt.Errorf("failed to check listing content: %v", err)
+	}
+
+	if decision.Action == moderation.Reject {
+		s.recordModeration(listingID, content, decision)
+		return decision, invalidInput("", "listing rejected by content moderation: %s", decision.Reason)
+	}
+
+	return decision, nil
+}
+
+// stored records a flagged listing and notifies the observer
+func (s *Listings) stored(listing *models.Listing, content string, decision moderation.Decision) {
+	if decision.Action == moderation.Flag {
+		s.recordModeration(&listing.ID, content, decision)
+	}
+	if s.observer != nil {
+		s.observer.ListingChanged(listing)
+	}
+}
+
+// recordModeration stores a moderation decision; failures are logged since the
+// decision itself has already been applied
+func (s *Listings) recordModeration(listingID *int, content string, decision moderation.Decision) {
+	if err := s.store.RecordModerationDecision("listing", listingID, content, string(decision.Action), decision.Reason); err != nil {
+		log.Printf("[Service] Error recording moderation decision: %v", err)
+	}
+}
@@ -0,0 +1,254 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+	"github.com/korjavin/graphqlTinyExample/pkg/moderation"
+)
+
+type fakeListingStore struct {
+	stored    []*models.Listing
+	decisions []string
+	quotas    map[int]int
+}
+
+func (s *fakeListingStore) GetSeller(id int) (*models.Seller, error) {
+	if id != 1 {
+		return nil, sql.ErrNoRows
+	}
+	return &models.Seller{ID: id}, nil
+}
+
+func (s *fakeListingStore) CreateListing(sellerID int, title, description string, price models.Money) (*models.Listing, error) {
+	listing := &models.Listing{ID: len(s.stored) + 1, SellerID: sellerID, Title: title, Description: description, Price: price}
+	s.stored = append(s.stored, listing)
+	return listing, nil
+}
+
+func (s *fakeListingStore) CreateListings(listings []models.NewListing) ([]*models.Listing, error) {
+	var created []*models.Listing
+	for _, listing := range listings {
+		stored, _ := s.CreateListing(listing.SellerID, listing.Title, listing.Description, listing.Price)
+		created = append(created, stored)
+	}
+	return created, nil
+}
+
+func (s *fakeListingStore) UpdateListing(id int, title, description *string, price *models.Money) (*models.Listing, error) {
+	if id != 1 {
+		return nil, sql.ErrNoRows
+	}
+	listing := &models.Listing{ID: id}
+	s.stored = append(s.stored, listing)
+	return listing, nil
+}
+
+func (s *fakeListingStore) RecordModerationDecision(contentType string, contentID *int, content, action, reason string) error {
+	s.decisions = append(s.decisions, action)
+	return nil
+}
+
+func (s *fakeListingStore) CountSellerListings(sellerID int) (int, error) {
+	count := 0
+	for _, listing := range s.stored {
+		if listing.SellerID == sellerID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *fakeListingStore) GetSellerQuota(sellerID int) (*models.SellerQuota, error) {
+	max, ok := s.quotas[sellerID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &models.SellerQuota{SellerID: sellerID, MaxListings: max}, nil
+}
+
+type fakeObserver struct {
+	changed []int
+}
+
+func (o *fakeObserver) ListingChanged(listing *models.Listing) {
+	o.changed = append(o.changed, listing.ID)
+}
+
+type failingChecker struct{}
+
+func (failingChecker) Check(ctx context.Context, text string) (moderation.Decision, error) {
+	return moderation.Decision{}, errors.New("moderation service unavailable")
+}
+
+func TestListingsCreate(t *testing.T) {
+	store := &fakeListingStore{}
+	observer := &fakeObserver{}
+	publisher := &fakePublisher{}
+	listings := NewListings(store, moderation.NewWordlist([]string{"counterfeit"}, moderation.Reject), publisher)
+	listings.SetObserver(observer)
+	ctx := context.Background()
+
+	var inputErr *InputError
+	if _, err := listings.Create(ctx, 1, "Bike", "Red", 0); !errors.As(err, &inputErr) || inputErr.Field != "price" || inputErr.Code != CodeInvalidInput {
+		t.Errorf("Expected a zero price to be rejected as invalid input, got %v", err)
+	}
+	if _, err := listings.Create(ctx, 1, "  ", "Red", 1000); !errors.As(err, &inputErr) || inputErr.Field != "title" {
+		t.Errorf("Expected an empty title to be rejected, got %v", err)
+	}
+	if _, err := listings.Create(ctx, 2, "Bike", "Red", 1000); !errors.As(err, &inputErr) || inputErr.Field != "sellerId" || inputErr.Code != CodeNotFound {
+		t.Errorf("Expected an unknown seller to be rejected as not found, got %v", err)
+	}
+	if _, err := listings.Create(ctx, 1, "Bike", "Counterfeit frame", 1000); err == nil {
+		t.Errorf("Expected rejected content to be refused")
+	}
+	if len(store.stored) != 0 {
+		t.Fatalf("Expected no listing to be stored, got %d", len(store.stored))
+	}
+	if len(store.decisions) != 1 || store.decisions[0] != string(moderation.Reject) {
+		t.Errorf("Expected the rejection to be recorded, got %v", store.decisions)
+	}
+
+	listing, err := listings.Create(ctx, 1, "Bike", "Red", 1000)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(observer.changed) != 1 || observer.changed[0] != listing.ID {
+		t.Errorf("Expected the observer to be told about listing %d, got %v", listing.ID, observer.changed)
+	}
+	if len(publisher.listings) != 1 || publisher.listings[0] != listing {
+		t.Errorf("Expected listing %d to be published, got %v", listing.ID, publisher.listings)
+	}
+}
+
+func TestListingsCreateFlagged(t *testing.T) {
+	store := &fakeListingStore{}
+	listings := NewListings(store, moderation.NewWordlist([]string{"replica"}, moderation.Flag), &fakePublisher{})
+
+	if _, err := listings.Create(context.Background(), 1, "Replica watch", "", 5000); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(store.stored) != 1 || len(store.decisions) != 1 || store.decisions[0] != string(moderation.Flag) {
+		t.Errorf("Expected the listing to be stored and flagged, got %d listings and %v", len(store.stored), store.decisions)
+	}
+}
+
+func TestListingsUpdate(t *testing.T) {
+	store := &fakeListingStore{}
+	listings := NewListings(store, moderation.NewWordlist(moderation.DefaultWords, moderation.Reject), &fakePublisher{})
+	ctx := context.Background()
+
+	negative := models.Money(-1)
+	if _, err := listings.Update(ctx, 1, nil, nil, &negative); err == nil {
+		t.Errorf("Expected a negative price to be rejected")
+	}
+
+	_, err := listings.Update(ctx, 9, nil, nil, nil)
+	if err == nil || err.Error() != "listing not found: 9" {
+		t.Errorf("Expected listing not found, got %v", err)
+	}
+
+	listings.SetModerationChecker(failingChecker{})
+	title := "Bike"
+	if _, err := listings.Update(ctx, 1, &title, nil, nil); err == nil {
+		t.Errorf("Expected a moderation failure to be returned")
+	}
+}
+
+func TestListingsCreateBatch(t *testing.T) {
+	store := &fakeListingStore{}
+	observer := &fakeObserver{}
+	publisher := &fakePublisher{}
+	listings := NewListings(store, moderation.NewWordlist([]string{"counterfeit"}, moderation.Reject), publisher)
+	listings.SetObserver(observer)
+
+	results, err := listings.CreateBatch(context.Background(), []models.NewListing{
+		{SellerID: 1, Title: "Bike", Description: "Red", Price: 1000},
+		{SellerID: 2, Title: "Lamp", Description: "Blue", Price: 500},
+		{SellerID: 1, Title: "Watch", Description: "Counterfeit", Price: 500},
+		{SellerID: 1, Title: "Chair", Description: "Oak", Price: 0},
+		{SellerID: 1, Title: "Desk", Description: "Pine", Price: 2000},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(results) != 5 {
+		t.Fatalf("Expected a result per input, got %d", len(results))
+	}
+	for i, failed := range []bool{false, true, true, true, false} {
+		if (results[i].Err != nil) != failed {
+			t.Errorf("Result %d: expected failure %v, got %+v", i, failed, results[i])
+		}
+	}
+	if results[0].Listing.Title != "Bike" || results[4].Listing.Title != "Desk" {
+		t.Errorf("Expected stored listings in input order, got %+v and %+v", results[0].Listing, results[4].Listing)
+	}
+	if len(store.stored) != 2 || len(observer.changed) != 2 {
+		t.Errorf("Expected 2 stored and observed listings, got %d and %d", len(store.stored), len(observer.changed))
+	}
+	if len(publisher.listings) != 2 {
+		t.Errorf("Expected 2 published listings, got %d", len(publisher.listings))
+	}
+}
+
+func TestListingsDryRun(t *testing.T) {
+	observer := &fakeObserver{}
+	publisher := &fakePublisher{}
+	listings := NewListings(&fakeListingStore{}, moderation.NewWordlist([]string{"counterfeit"}, moderation.Reject), publisher)
+	listings.SetObserver(observer)
+
+	store := &fakeListingStore{}
+	dryRun := listings.DryRun(store)
+	if _, err := dryRun.Create(context.Background(), 1, "Watch", "Counterfeit", 1000); err == nil {
+		t.Errorf("Expected the dry run to moderate like the service")
+	}
+	if _, err := dryRun.Create(context.Background(), 1, "Bike", "Red", 1000); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(store.stored) != 1 {
+		t.Errorf("Expected the listing to go to the dry run store, got %d", len(store.stored))
+	}
+	if len(observer.changed) != 0 || len(publisher.listings) != 0 {
+		t.Errorf("Expected a dry run not to be observed or published, got %v and %v", observer.changed, publisher.listings)
+	}
+}
+
+func TestListingsQuota(t *testing.T) {
+	store := &fakeListingStore{}
+	listings := NewListings(store, moderation.NewWordlist(nil, moderation.Reject), &fakePublisher{})
+	listings.SetMaxListings(2)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := listings.Create(ctx, 1, "Bike", "Red", 1000); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	var inputErr *InputError
+	if _, err := listings.Create(ctx, 1, "Bike", "Red", 1000); !errors.As(err, &inputErr) || inputErr.Code != CodeQuotaExceeded || inputErr.Field != "sellerId" {
+		t.Errorf("Expected the third listing to exceed the quota, got %v", err)
+	}
+
+	// A quota set by an admin overrides the default, counting the batch as it goes
+	store.quotas = map[int]int{1: 3}
+	results, err := listings.CreateBatch(ctx, []models.NewListing{
+		{SellerID: 1, Title: "Lamp", Description: "Blue", Price: 500},
+		{SellerID: 1, Title: "Desk", Description: "Pine", Price: 2000},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if results[0].Err != nil || !errors.As(results[1].Err, &inputErr) || inputErr.Code != CodeQuotaExceeded {
+		t.Errorf("Expected only the first listing of the batch to fit the quota, got %+v", results)
+	}
+
+	// A quota of zero lifts the limit
+	store.quotas[1] = 0
+	if _, err := listings.Create(ctx, 1, "Chair", "Oak", 1000); err != nil {
+		t.Errorf("Expected an unlimited quota, got %v", err)
+	}
+}
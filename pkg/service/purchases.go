@@ -0,0 +1,222 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	ids "github.com/korjavin/graphqlTinyExample/pkg/id"
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+	"github.com/korjavin/graphqlTinyExample/pkg/repository"
+	"github.com/korjavin/graphqlTinyExample/pkg/tax"
+)
+
+// PurchaseStore persists purchases and looks up what they refer to
+type PurchaseStore interface {
+	GetListing(id int) (*models.Listing, error)
+	GetPickupPoint(id int) (*models.PickupPoint, error)
+	CreatePurchase(listingID int, price, taxAmount models.Money, bankTxID, deliveryAddress string, pickupPointID *int) (*models.Purchase, error)
+	CreatePurchaseWithDelivery(listingID int, price, taxAmount models.Money, bankTxID, deliveryAddress string, pickupPointID *int) (*models.Purchase, *models.Delivery, error)
+	CancelPurchase(id int, reason string) (*models.Purchase, *models.Delivery, error)
+	UpdateOrderStatus(id int, from, to string) (*models.Purchase, error)
+}
+
+// maxCancelReasonLength bounds the reason given for canceling a purchase
+const maxCancelReasonLength = 500
+
+// NewPurchase describes a purchase to create. Exactly one of DeliveryAddress
+// and PickupPointID must be set
+type NewPurchase struct {
+	ListingID       int
+	Price           models.Money
+	BankTxID        string
+	DeliveryAddress *string
+	PickupPointID   *int
+}
+
+// Purchases creates and cancels purchases
+type Purchases struct {
+	store      PurchaseStore
+	taxCalc    tax.Calculator
+	publisher  DeliveryPublisher
+	onCreated  func(purchase *models.Purchase)
+	onCanceled func(purchase *models.Purchase)
+}
+
+// NewPurchases creates the purchase service. onCreated is called with every
+// stored purchase, e.g. to queue it for review, and may be nil
+func NewPurchases(store PurchaseStore, taxCalc tax.Calculator, publisher DeliveryPublisher, onCreated func(purchase *models.Purchase)) *Purchases {
+	return &Purchases{store: store, taxCalc: taxCalc, publisher: publisher, onCreated: onCreated}
+}
+
+// SetTaxCalculator sets the calculator used to charge tax on new purchases
+func (s *Purchases) SetTaxCalculator(calc tax.Calculator) {
+	s.taxCalc = calc
+}
+
+// SetCanceledHandler sets the function called with every canceled purchase,
+// e.g. to notify its seller
+func (s *Purchases) SetCanceledHandler(onCanceled func(purchase *models.Purchase)) {
+	s.onCanceled = onCanceled
+}
+
+// DryRun returns a copy of the service storing purchases in store, e.g. a
+// repository whose changes are rolled back. The copy neither publishes
+// deliveries nor calls the created and canceled handlers
+func (s *Purchases) DryRun(store PurchaseStore) *Purchases {
+	return &Purchases{store: store, taxCalc: s.taxCalc, publisher: discard{}}
+}
+
+// Create validates a purchase, charges tax on top of its price and stores it
+func (s *Purchases) Create(ctx context.Context, input NewPurchase) (*models.Purchase, error) {
+	deliveryAddress, taxAmount, err := s.prepare(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	purchase, err := s.store.CreatePurchase(
+		input.ListingID,
+		input.Price,
+		taxAmount,
+		input.BankTxID,
+		deliveryAddress,
+		input.PickupPointID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	s.created(purchase)
+	return purchase, nil
+}
+
+// CreateWithDelivery creates a purchase like Create together with its initial
+// PACKED delivery, storing both atomically, and publishes the delivery
+func (s *Purchases) CreateWithDelivery(ctx context.Context, input NewPurchase) (*models.Purchase, *models.Delivery, error) {
+	deliveryAddress, taxAmount, err := s.prepare(ctx, input)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	purchase, delivery, err := s.store.CreatePurchaseWithDelivery(
+		input.ListingID,
+		input.Price,
+		taxAmount,
+		input.BankTxID,
+		deliveryAddress,
+		input.PickupPointID,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.created(purchase)
+	s.publisher.PublishDelivery(delivery)
+	return purchase, delivery, nil
+}
+
+// prepare validates a purchase and returns its delivery address and the tax
+// charged on top of its price
+func (s *Purchases) prepare(ctx context.Context, input NewPurchase) (string, models.Money, error) {
+	if err := validatePrice(input.Price); err != nil {
+		return "", 0, err
+	}
+
+	listing, err := s.store.GetListing(input.ListingID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", 0, notFound("listingId", "listing not found: %s", ids.FormatListingID(input.ListingID))
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	// Either deliver to an address or to a pickup point, never both
+	if (input.DeliveryAddress == nil) == (input.PickupPointID == nil) {
+		return "", 0, invalidInput("", "exactly one of deliveryAddress or pickupPointId must be provided")
+	}
+
+	var deliveryAddress string
+	if input.PickupPointID != nil {
+		// Validate pickup point exists; its address becomes the delivery address
+		point, err := s.store.GetPickupPoint(*input.PickupPointID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", 0, notFound("pickupPointId", "pickup point not found: %s", ids.FormatPickupPointID(*input.PickupPointID))
+		}
+		if err != nil {
+			return "", 0, err
+		}
+		deliveryAddress = point.Address
+	} else {
+		deliveryAddress = *input.DeliveryAddress
+	}
+
+	// Calculate the tax charged on top of the purchase price
+	taxAmount, err := s.taxCalc.Calculate(ctx, tax.Request{
+		ListingID:       input.ListingID,
+		SellerID:        listing.SellerID,
+		Amount:          input.Price,
+		DeliveryAddress: deliveryAddress,
+	})
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to calculate tax: %v", err)
+	}
+
+	return deliveryAddress, taxAmount, nil
+}
+
+// created hands a stored purchase to the onCreated callback
+func (s *Purchases) created(purchase *models.Purchase) {
+	if s.onCreated != nil {
+		s.onCreated(purchase)
+	}
+}
+
+// Cancel cancels a purchase that hasn't shipped, refunding it if it was paid,
+// and records the reason and a CANCELED delivery update, which is published.
+// A purchase that can no longer be canceled fails with a CONFLICT error wrapping
+// repository.ErrPurchaseNotCancelable
+func (s *Purchases) Cancel(ctx context.Context, id int, reason string) (*models.Purchase, error) {
+	reason = strings.TrimSpace(reason)
+	if len(reason) > maxCancelReasonLength {
+		return nil, invalidInput("reason", "reason must be at most %d characters", maxCancelReasonLength)
+	}
+
+	purchase, delivery, err := s.store.CancelPurchase(id, reason)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, notFound("id", "purchase not found: %s", ids.FormatPurchaseID(id))
+	}
+	if errors.Is(err, repository.ErrPurchaseNotCancelable) {
+		return nil, &InputError{Field: "id", Code: CodeConflict, Message: err.Error(), Err: err}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.publisher.PublishDelivery(delivery)
+	if s.onCanceled != nil {
+		s.onCanceled(purchase)
+	}
+	return purchase, nil
+}
+
+// Reviewed settles the order of a purchase that left fraud review: approved
+// purchases become paid and rejected ones canceled. It returns the updated
+// purchase, or the given one if its order status doesn't change
+func (s *Purchases) Reviewed(ctx context.Context, purchase *models.Purchase) (*models.Purchase, error) {
+	status, ok := models.OrderStatusAfterReview(purchase.Status)
+	if !ok {
+		return purchase, nil
+	}
+
+	updated, err := s.store.UpdateOrderStatus(purchase.ID, models.OrderStatusPending, status)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Canceled while under review, nothing left to settle
+		return purchase, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
@@ -0,0 +1,209 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+	"github.com/korjavin/graphqlTinyExample/pkg/repository"
+	"github.com/korjavin/graphqlTinyExample/pkg/tax"
+)
+
+type fakePurchaseStore struct {
+	created   *models.Purchase
+	cancelErr error
+	updated   []string
+}
+
+func (s *fakePurchaseStore) GetListing(id int) (*models.Listing, error) {
+	if id != 1 {
+		return nil, sql.ErrNoRows
+	}
+	return &models.Listing{ID: id, SellerID: 5}, nil
+}
+
+func (s *fakePurchaseStore) GetPickupPoint(id int) (*models.PickupPoint, error) {
+	if id != 2 {
+		return nil, sql.ErrNoRows
+	}
+	return &models.PickupPoint{ID: id, Address: "Station Rd 1"}, nil
+}
+
+func (s *fakePurchaseStore) CreatePurchase(listingID int, price, taxAmount models.Money, bankTxID, deliveryAddress string, pickupPointID *int) (*models.Purchase, error) {
+	s.created = &models.Purchase{
+		ID:              10,
+		ListingID:       listingID,
+		Price:           price,
+		TaxAmount:       taxAmount,
+		BankTxID:        bankTxID,
+		DeliveryAddress: deliveryAddress,
+		PickupPointID:   pickupPointID,
+	}
+	return s.created, nil
+}
+
+func (s *fakePurchaseStore) CreatePurchaseWithDelivery(listingID int, price, taxAmount models.Money, bankTxID, deliveryAddress string, pickupPointID *int) (*models.Purchase, *models.Delivery, error) {
+	purchase, _ := s.CreatePurchase(listingID, price, taxAmount, bankTxID, deliveryAddress, pickupPointID)
+	return purchase, &models.Delivery{ID: 5, PurchaseID: purchase.ID, Status: models.DeliveryStatusPacked}, nil
+}
+
+func (s *fakePurchaseStore) CancelPurchase(id int, reason string) (*models.Purchase, *models.Delivery, error) {
+	if s.cancelErr != nil {
+		return nil, nil, s.cancelErr
+	}
+	return &models.Purchase{ID: id, Status: models.PurchaseStatusCanceled,
+			Cancellation: &models.PurchaseCancellation{PurchaseID: id, Reason: reason}},
+		&models.Delivery{ID: 3, PurchaseID: id, Status: models.DeliveryStatusCanceled}, nil
+}
+
+func (s *fakePurchaseStore) UpdateOrderStatus(id int, from, to string) (*models.Purchase, error) {
+	if from != models.OrderStatusPending {
+		return nil, sql.ErrNoRows
+	}
+	s.updated = append(s.updated, to)
+	return &models.Purchase{ID: id, OrderStatus: to}, nil
+}
+
+type failingCalculator struct{}
+
+func (failingCalculator) Calculate(ctx context.Context, req tax.Request) (models.Money, error) {
+	return 0, errors.New("tax service unavailable")
+}
+
+func TestPurchasesCreate(t *testing.T) {
+	store := &fakePurchaseStore{}
+	var notified []int
+	purchases := NewPurchases(store, tax.FlatRate{Rate: 0.2}, &fakePublisher{}, func(purchase *models.Purchase) {
+		notified = append(notified, purchase.ID)
+	})
+	ctx := context.Background()
+	address := "Main St 1"
+	pickupPoint := 2
+
+	invalid := []NewPurchase{
+		{ListingID: 1, Price: 0, DeliveryAddress: &address},
+		{ListingID: 9, Price: 1000, DeliveryAddress: &address},
+		{ListingID: 1, Price: 1000},
+		{ListingID: 1, Price: 1000, DeliveryAddress: &address, PickupPointID: &pickupPoint},
+	}
+	for _, input := range invalid {
+		if _, err := purchases.Create(ctx, input); err == nil {
+			t.Errorf("Expected %+v to be rejected", input)
+		}
+	}
+	if store.created != nil || len(notified) != 0 {
+		t.Fatalf("Expected no purchase to be stored")
+	}
+
+	purchase, err := purchases.Create(ctx, NewPurchase{ListingID: 1, Price: 1000, BankTxID: "tx", PickupPointID: &pickupPoint})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if purchase.TaxAmount != 200 || purchase.DeliveryAddress != "Station Rd 1" {
+		t.Errorf("Expected tax 2.00 and the pickup point address, got %s and %q", purchase.TaxAmount, purchase.DeliveryAddress)
+	}
+	if len(notified) != 1 || notified[0] != purchase.ID {
+		t.Errorf("Expected the new purchase to be reported, got %v", notified)
+	}
+
+	purchases.SetTaxCalculator(failingCalculator{})
+	if _, err := purchases.Create(ctx, NewPurchase{ListingID: 1, Price: 1000, DeliveryAddress: &address}); err == nil {
+		t.Errorf("Expected a tax failure to be returned")
+	}
+}
+
+func TestPurchasesCreateWithDelivery(t *testing.T) {
+	store := &fakePurchaseStore{}
+	publisher := &fakePublisher{}
+	var notified []int
+	purchases := NewPurchases(store, tax.FlatRate{}, publisher, func(purchase *models.Purchase) {
+		notified = append(notified, purchase.ID)
+	})
+	ctx := context.Background()
+	address := "Main St 1"
+
+	if _, _, err := purchases.CreateWithDelivery(ctx, NewPurchase{ListingID: 1, Price: 1000}); err == nil {
+		t.Errorf("Expected a purchase without destination to be rejected")
+	}
+	if store.created != nil || len(publisher.published) != 0 {
+		t.Fatalf("Expected nothing to be stored or published")
+	}
+
+	purchase, delivery, err := purchases.CreateWithDelivery(ctx, NewPurchase{ListingID: 1, Price: 1000, DeliveryAddress: &address})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if delivery.PurchaseID != purchase.ID || delivery.Status != models.DeliveryStatusPacked {
+		t.Errorf("Expected a PACKED delivery of the purchase, got %+v", delivery)
+	}
+	if len(notified) != 1 || len(publisher.published) != 1 || publisher.published[0] != delivery {
+		t.Errorf("Expected the purchase reported and the delivery published, got %v and %v", notified, publisher.published)
+	}
+}
+
+func TestPurchasesCancel(t *testing.T) {
+	store := &fakePurchaseStore{cancelErr: repository.ErrPurchaseNotCancelable}
+	publisher := &fakePublisher{}
+	purchases := NewPurchases(store, tax.FlatRate{}, publisher, nil)
+	var canceled []*models.Purchase
+	purchases.SetCanceledHandler(func(purchase *models.Purchase) {
+		canceled = append(canceled, purchase)
+	})
+	ctx := context.Background()
+
+	if _, err := purchases.Cancel(ctx, 4, strings.Repeat("x", 501)); err == nil {
+		t.Errorf("Expected an overlong reason to be rejected")
+	}
+
+	if _, err := purchases.Cancel(ctx, 4, ""); !errors.Is(err, repository.ErrPurchaseNotCancelable) {
+		t.Errorf("Expected ErrPurchaseNotCancelable, got %v", err)
+	}
+
+	store.cancelErr = sql.ErrNoRows
+	if _, err := purchases.Cancel(ctx, 4, ""); err == nil || err.Error() != "purchase not found: 4" {
+		t.Errorf("Expected purchase not found, got %v", err)
+	}
+
+	store.cancelErr = nil
+	purchase, err := purchases.Cancel(ctx, 4, "  Found it cheaper ")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if purchase.Status != models.PurchaseStatusCanceled || purchase.Cancellation.Reason != "Found it cheaper" {
+		t.Errorf("Expected a canceled purchase with a trimmed reason, got %+v", purchase)
+	}
+	if len(canceled) != 1 || canceled[0] != purchase {
+		t.Errorf("Expected the canceled handler to be called once, got %v", canceled)
+	}
+	if len(publisher.published) != 1 || publisher.published[0].Status != models.DeliveryStatusCanceled {
+		t.Errorf("Expected the CANCELED delivery to be published, got %v", publisher.published)
+	}
+}
+
+func TestPurchasesReviewed(t *testing.T) {
+	store := &fakePurchaseStore{}
+	purchases := NewPurchases(store, tax.FlatRate{}, &fakePublisher{}, nil)
+	ctx := context.Background()
+
+	approved := &models.Purchase{ID: 1, Status: models.PurchaseStatusApproved, OrderStatus: models.OrderStatusPending}
+	purchase, err := purchases.Reviewed(ctx, approved)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if purchase.OrderStatus != models.OrderStatusPaid {
+		t.Errorf("Expected an approved purchase to be paid, got %s", purchase.OrderStatus)
+	}
+
+	rejected := &models.Purchase{ID: 2, Status: models.PurchaseStatusRejected, OrderStatus: models.OrderStatusPending}
+	if purchase, _ := purchases.Reviewed(ctx, rejected); purchase.OrderStatus != models.OrderStatusCanceled {
+		t.Errorf("Expected a rejected purchase to be canceled, got %s", purchase.OrderStatus)
+	}
+
+	pending := &models.Purchase{ID: 3, Status: models.PurchaseStatusPendingReview, OrderStatus: models.OrderStatusPending}
+	if purchase, _ := purchases.Reviewed(ctx, pending); purchase != pending || len(store.updated) != 2 {
+		t.Errorf("Expected a purchase still under review to be left alone, got %+v", purchase)
+	}
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	ids "github.com/korjavin/graphqlTinyExample/pkg/id"
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+	"github.com/korjavin/graphqlTinyExample/pkg/repository"
+)
+
+// SellerStore persists sellers
+type SellerStore interface {
+	CreateSeller(name, address string, digestOptIn bool) (*models.Seller, error)
+	UpdateSeller(id int, name, address *string, digestOptIn *bool) (*models.Seller, error)
+	DeleteSeller(id int) error
+}
+
+// Sellers manages sellers
+type Sellers struct {
+	store SellerStore
+}
+
+// NewSellers creates the seller service
+func NewSellers(store SellerStore) *Sellers {
+	return &Sellers{store: store}
+}
+
+// DryRun returns a copy of the service storing sellers in store, e.g. a
+// repository whose changes are rolled back
+func (s *Sellers) DryRun(store SellerStore) *Sellers {
+	return &Sellers{store: store}
+}
+
+// Create creates a seller; the name must not be blank
+func (s *Sellers) Create(ctx context.Context, name, address string, digestOptIn bool) (*models.Seller, error) {
+	if strings.TrimSpace(name) == "" {
+		return nil, invalidInput("name", "seller name must not be empty")
+	}
+	return s.store.CreateSeller(name, address, digestOptIn)
+}
+
+// Update changes the given fields of a seller, leaving nil fields unchanged
+func (s *Sellers) Update(ctx context.Context, id int, name, address *string, digestOptIn *bool) (*models.Seller, error) {
+	if name != nil && strings.TrimSpace(*name) == "" {
+		return nil, invalidInput("name", "seller name must not be empty")
+	}
+
+	seller, err := s.store.UpdateSeller(id, name, address, digestOptIn)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, notFound("id", "seller not found: %s", ids.FormatSellerID(id))
+	}
+	return seller, err
+}
+
+// Delete removes a seller without listings
+func (s *Sellers) Delete(ctx context.Context, id int) error {
+	err := s.store.DeleteSeller(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return notFound("id", "seller not found: %s", ids.FormatSellerID(id))
+	}
+	if errors.Is(err, repository.ErrSellerHasListings) {
+		return conflict("id", "seller %d still has listings and cannot be deleted", id)
+	}
+	return err
+}
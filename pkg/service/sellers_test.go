@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+	"github.com/korjavin/graphqlTinyExample/pkg/repository"
+)
+
+type fakeSellerStore struct {
+	created   int
+	updateErr error
+	deleteErr error
+}
+
+func (s *fakeSellerStore) CreateSeller(name, address string, digestOptIn bool) (*models.Seller, error) {
+	s.created++
+	return &models.Seller{ID: 1, Name: name, Address: address, DigestOptIn: digestOptIn}, nil
+}
+
+func (s *fakeSellerStore) UpdateSeller(id int, name, address *string, digestOptIn *bool) (*models.Seller, error) {
+	if s.updateErr != nil {
+		return nil, s.updateErr
+	}
+	return &models.Seller{ID: id}, nil
+}
+
+func (s *fakeSellerStore) DeleteSeller(id int) error {
+	return s.deleteErr
+}
+
+func TestSellersCreate(t *testing.T) {
+	store := &fakeSellerStore{}
+	sellers := NewSellers(store)
+
+	if _, err := sellers.Create(context.Background(), "  ", "Main St", false); err == nil {
+		t.Errorf("Expected a blank name to be rejected")
+	}
+
+	seller, err := sellers.Create(context.Background(), "Alice", "Main St", true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if store.created != 1 || !seller.DigestOptIn {
+		t.Errorf("Expected the seller to be stored once, got %d stores and %+v", store.created, seller)
+	}
+}
+
+func TestSellersUpdate(t *testing.T) {
+	store := &fakeSellerStore{updateErr: sql.ErrNoRows}
+	sellers := NewSellers(store)
+
+	blank := ""
+	if _, err := sellers.Update(context.Background(), 1, &blank, nil, nil); err == nil {
+		t.Errorf("Expected a blank name to be rejected")
+	}
+
+	_, err := sellers.Update(context.Background(), 7, nil, nil, nil)
+	if err == nil || err.Error() != "seller not found: 7" {
+		t.Errorf("Expected seller not found, got %v", err)
+	}
+}
+
+func TestSellersDelete(t *testing.T) {
+	store := &fakeSellerStore{deleteErr: repository.ErrSellerHasListings}
+	sellers := NewSellers(store)
+
+	err := sellers.Delete(context.Background(), 3)
+	if err == nil || !strings.Contains(err.Error(), "still has listings") {
+		t.Errorf("Expected a seller with listings to be kept, got %v", err)
+	}
+
+	store.deleteErr = nil
+	if err := sellers.Delete(context.Background(), 3); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
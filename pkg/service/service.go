@@ -0,0 +1,93 @@
+// Package service holds the business rules of the marketplace: validation,
+// status transitions, moderation and the side effects of changes such as
+// publishing events. Transports like the GraphQL resolvers parse their input,
+// call a service and render its result, so every transport applies the same rules
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+)
+
+// DeliveryPublisher notifies subscribers of new delivery updates
+type DeliveryPublisher interface {
+	PublishDelivery(delivery *models.Delivery)
+}
+
+// ListingPublisher notifies subscribers of newly created listings
+type ListingPublisher interface {
+	PublishListingCreated(listing *models.Listing)
+}
+
+// discard drops the events of dry runs
+type discard struct{}
+
+func (discard) PublishDelivery(delivery *models.Delivery) {}
+
+func (discard) PublishListingCreated(listing *models.Listing) {}
+
+// Codes of input errors
+const (
+	CodeInvalidInput = "INVALID_INPUT"
+	CodeNotFound     = "NOT_FOUND"
+	CodeConflict     = "CONFLICT"
+	// CodeQuotaExceeded reports a seller at one of their limits
+	CodeQuotaExceeded = "QUOTA_EXCEEDED"
+)
+
+// InputError is a failure caused by the caller's input rather than by the
+// server, naming the input field at fault if there is a single one. Transports
+// can report it back to the caller as data instead of as an internal failure
+type InputError struct {
+	Field   string
+	Code    string
+	Message string
+	// Err is the underlying error, if any
+	Err error
+}
+
+func (e *InputError) Error() string {
+	return e.Message
+}
+
+func (e *InputError) Unwrap() error {
+	return e.Err
+}
+
+// invalidInput returns an INVALID_INPUT error for a field
+func invalidInput(field, format string, args ...interface{}) error {
+	return &InputError{Field: field, Code: CodeInvalidInput, Message: fmt.Sprintf(format, args...)}
+}
+
+// notFound returns a NOT_FOUND error for the field referencing a missing record
+func notFound(field, format string, args ...interface{}) error {
+	return &InputError{Field: field, Code: CodeNotFound, Message: fmt.Sprintf(format, args...)}
+}
+
+// conflict returns a CONFLICT error for input clashing with the current state
+func conflict(field, format string, args ...interface{}) error {
+	return &InputError{Field: field, Code: CodeConflict, Message: fmt.Sprintf(format, args...)}
+}
+
+// quotaExceeded returns a QUOTA_EXCEEDED error for the field
+func quotaExceeded(field, format string, args ...interface{}) error {
+	return &InputError{Field: field, Code: CodeQuotaExceeded, Message: fmt.Sprintf(format, args...)}
+}
+
+// validatePrice rejects prices that aren't positive
+func validatePrice(price models.Money) error {
+	if price <= 0 {
+		return invalidInput("price", "price must be positive, got %s", price)
+	}
+	return nil
+}
+
+// validateTitle rejects blank listing titles
+func validateTitle(title string) error {
+	if strings.TrimSpace(title) == "" {
+		return invalidInput("title", "title must not be empty")
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+)
+
+type fakePublisher struct {
+	published []*models.Delivery
+	listings  []*models.Listing
+}
+
+func (p *fakePublisher) PublishDelivery(delivery *models.Delivery) {
+	p.published = append(p.published, delivery)
+}
+
+func (p *fakePublisher) PublishListingCreated(listing *models.Listing) {
+	p.listings = append(p.listings, listing)
+}
+
+func TestValidatePrice(t *testing.T) {
+	if err := validatePrice(1); err != nil {
+		t.Errorf("Unexpected error for a positive price: %v", err)
+	}
+	for _, price := range []models.Money{0, -100} {
+		if err := validatePrice(price); err == nil {
+			t.Errorf("Expected an error for price %s", price)
+		}
+	}
+}
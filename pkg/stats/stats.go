@@ -0,0 +1,125 @@
+package stats
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+)
+
+// ErrStatsUnavailable is returned when the statistics haven't been computed yet
+var ErrStatsUnavailable = errors.New("statistics are not available yet")
+
+// Store computes the aggregates held by the cache
+type Store interface {
+	GetAllSellerSales() ([]*models.SalesSummary, error)
+}
+
+// Snapshot is a consistent set of aggregates computed at one point in time
+type Snapshot struct {
+	// RefreshedAt is when the aggregates were computed
+	RefreshedAt time.Time
+
+	sellers map[int]*models.SalesSummary
+	// ranked holds the sellers by revenue, highest first
+	ranked []*models.SalesSummary
+}
+
+// Seller returns the sales of a seller; sellers created after the snapshot
+// have none
+func (s *Snapshot) Seller(id int) *models.SalesSummary {
+	if summary, ok := s.sellers[id]; ok {
+		return summary
+	}
+	return &models.SalesSummary{SellerID: id}
+}
+
+// TopSellers returns up to limit sellers with the highest revenue
+func (s *Snapshot) TopSellers(limit int) []*models.SalesSummary {
+	if limit > len(s.ranked) {
+		limit = len(s.ranked)
+	}
+	return s.ranked[:limit]
+}
+
+// Staleness returns the age of the aggregates
+func (s *Snapshot) Staleness() time.Duration {
+	return time.Since(s.RefreshedAt)
+}
+
+// Cache keeps expensive aggregates in memory, recomputed in the background, so
+// statistics queries never run them against the database directly
+type Cache struct {
+	mu       sync.RWMutex
+	store    Store
+	snapshot *Snapshot
+}
+
+// NewCache creates an empty statistics cache computed from the given store
+func NewCache(store Store) *Cache {
+	return &Cache{store: store}
+}
+
+// Refresh recomputes the aggregates; on failure the previous ones are kept
+func (c *Cache) Refresh() error {
+	summaries, err := c.store.GetAllSellerSales()
+	if err != nil {
+		return err
+	}
+
+	snapshot := &Snapshot{
+		RefreshedAt: time.Now(),
+		sellers:     make(map[int]*models.SalesSummary, len(summaries)),
+		ranked:      summaries,
+	}
+	for _, summary := range summaries {
+		snapshot.sellers[summary.SellerID] = summary
+	}
+	// Break ties by seller ID so the ranking is stable between refreshes
+	sort.SliceStable(snapshot.ranked, func(i, j int) bool {
+		a, b := snapshot.ranked[i], snapshot.ranked[j]
+		if a.Revenue != b.Revenue {
+			return a.Revenue > b.Revenue
+		}
+		return a.SellerID < b.SellerID
+	})
+
+	c.mu.Lock()
+	c.snapshot = snapshot
+	c.mu.Unlock()
+
+	log.Printf("[Stats] Refreshed statistics of %d sellers", len(summaries))
+	return nil
+}
+
+// Run refreshes the aggregates immediately and then every interval until stop is closed
+func (c *Cache) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.Refresh(); err != nil {
+			log.Printf("[Stats] Error refreshing statistics: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Snapshot returns the latest aggregates
+func (c *Cache) Snapshot() (*Snapshot, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.snapshot == nil {
+		return nil, ErrStatsUnavailable
+	}
+	return c.snapshot, nil
+}
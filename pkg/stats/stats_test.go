@@ -0,0 +1,61 @@
+package stats
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+)
+
+type fakeStore struct {
+	summaries []*models.SalesSummary
+	err       error
+}
+
+func (s *fakeStore) GetAllSellerSales() ([]*models.SalesSummary, error) {
+	return s.summaries, s.err
+}
+
+func TestCache(t *testing.T) {
+	store := &fakeStore{summaries: []*models.SalesSummary{
+		{SellerID: 1, Sales: 1, Revenue: 500},
+		{SellerID: 2, Sales: 4, Revenue: 2000},
+		{SellerID: 3, Sales: 2, Revenue: 500},
+	}}
+	cache := NewCache(store)
+
+	if _, err := cache.Snapshot(); !errors.Is(err, ErrStatsUnavailable) {
+		t.Errorf("Expected ErrStatsUnavailable before the first refresh, got %v", err)
+	}
+
+	if err := cache.Refresh(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	snapshot, err := cache.Snapshot()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	top := snapshot.TopSellers(5)
+	if len(top) != 3 || top[0].SellerID != 2 || top[1].SellerID != 1 || top[2].SellerID != 3 {
+		t.Errorf("Expected sellers ranked by revenue then ID, got %+v", top)
+	}
+	if len(snapshot.TopSellers(1)) != 1 {
+		t.Errorf("Expected the ranking to be limited")
+	}
+	if summary := snapshot.Seller(2); summary.Sales != 4 {
+		t.Errorf("Expected 4 sales of seller 2, got %+v", summary)
+	}
+	if summary := snapshot.Seller(9); summary.Sales != 0 || summary.SellerID != 9 {
+		t.Errorf("Expected no sales of an unknown seller, got %+v", summary)
+	}
+
+	// A failed refresh keeps the previous aggregates
+	store.err = errors.New("database unavailable")
+	if err := cache.Refresh(); err == nil {
+		t.Error("Expected the refresh to fail")
+	}
+	if current, _ := cache.Snapshot(); current != snapshot {
+		t.Error("Expected the previous snapshot to be kept")
+	}
+}
@@ -0,0 +1,98 @@
+package tax
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+)
+
+// Request describes a sale to calculate tax for
+type Request struct {
+	ListingID       int          `json:"listingId"`
+	SellerID        int          `json:"sellerId"`
+	Amount          models.Money `json:"amount"`
+	DeliveryAddress string       `json:"deliveryAddress"`
+}
+
+// Calculator computes the tax owed on a sale
+type Calculator interface {
+	Calculate(ctx context.Context, req Request) (models.Money, error)
+}
+
+// FlatRate applies the same rate to every sale, e.g. 0.2 for 20%
+type FlatRate struct {
+	Rate float64
+}
+
+// Calculate returns the amount multiplied by the flat rate, rounded to cents
+func (f FlatRate) Calculate(ctx context.Context, req Request) (models.Money, error) {
+	return req.Amount.Mul(f.Rate), nil
+}
+
+// HTTPCalculator delegates tax calculation to an external service. The request
+// is POSTed as JSON and the service must respond with {"taxAmount": <number>}
+type HTTPCalculator struct {
+	Endpoint string
+	APIKey   string
+	Client   *http.Client
+}
+
+// NewHTTPCalculator creates a calculator calling the tax service at endpoint
+func NewHTTPCalculator(endpoint, apiKey string) *HTTPCalculator {
+	return &HTTPCalculator{
+		Endpoint: endpoint,
+		APIKey:   apiKey,
+		Client:   &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Calculate asks the external service for the tax owed on the sale
+func (c *HTTPCalculator) Calculate(ctx context.Context, req Request) (models.Money, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal tax request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.Endpoint, bytes.NewBuffer(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create tax request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if c.APIKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+
+	resp, err := c.Client.Do(httpReq)
+	if err != nil {
+		return 0, fmt.Errorf("failed to call tax service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read tax service response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("tax service responded with %s: %s", resp.Status, string(respBody))
+	}
+
+	var result struct {
+		TaxAmount *float64 `json:"taxAmount"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal tax service response: %w", err)
+	}
+	if result.TaxAmount == nil || *result.TaxAmount < 0 {
+		return 0, fmt.Errorf("tax service returned no valid taxAmount")
+	}
+
+	// Services may return unrounded amounts; tax is charged in whole cents
+	return models.MoneyFromFloat(*result.TaxAmount), nil
+}
@@ -0,0 +1,59 @@
+package tax
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFlatRate(t *testing.T) {
+	amount, err := FlatRate{Rate: 0.0825}.Calculate(context.Background(), Request{Amount: 4999})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if amount != 412 {
+		t.Errorf("Expected tax %s, got %s", "4.12", amount)
+	}
+}
+
+func TestHTTPCalculator(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("Expected bearer token, got %q", r.Header.Get("Authorization"))
+		}
+
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("Failed to decode request: %v", err)
+		}
+		if req.Amount != 10000 {
+			t.Errorf("Expected amount %s, got %s", "100.00", req.Amount)
+		}
+
+		w.Write([]byte(`{"taxAmount": 7.5}`))
+	}))
+	defer server.Close()
+
+	calc := NewHTTPCalculator(server.URL, "secret")
+	amount, err := calc.Calculate(context.Background(), Request{ListingID: 1, Amount: 10000})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if amount != 750 {
+		t.Errorf("Expected tax %s, got %s", "7.50", amount)
+	}
+}
+
+func TestHTTPCalculatorError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	_, err := NewHTTPCalculator(server.URL, "").Calculate(context.Background(), Request{Amount: 10000})
+	if err == nil {
+		t.Errorf("Expected error from failing tax service")
+	}
+}
@@ -0,0 +1,79 @@
+package views
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Store persists accumulated view counts
+type Store interface {
+	IncrementListingViews(counts map[int]int64) error
+}
+
+// Counter buffers listing views in memory and flushes them to the store in batches
+type Counter struct {
+	mu      sync.Mutex
+	pending map[int]int64
+	store   Store
+}
+
+// NewCounter creates a view counter flushing to the given store
+func NewCounter(store Store) *Counter {
+	return &Counter{
+		pending: make(map[int]int64),
+		store:   store,
+	}
+}
+
+// Record registers a single view of a listing
+func (c *Counter) Record(listingID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending[listingID]++
+}
+
+// Flush writes all buffered views to the store; on failure they are kept for the next flush
+func (c *Counter) Flush() error {
+	c.mu.Lock()
+	if len(c.pending) == 0 {
+		c.mu.Unlock()
+		return nil
+	}
+	batch := c.pending
+	c.pending = make(map[int]int64)
+	c.mu.Unlock()
+
+	if err := c.store.IncrementListingViews(batch); err != nil {
+		// Merge the batch back so no views are lost
+		c.mu.Lock()
+		for id, count := range batch {
+			c.pending[id] += count
+		}
+		c.mu.Unlock()
+		return err
+	}
+
+	log.Printf("[Views] Flushed views for %d listings", len(batch))
+	return nil
+}
+
+// Run flushes buffered views every interval until stop is closed, then flushes once more
+func (c *Counter) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.Flush(); err != nil {
+				log.Printf("[Views] Error flushing views: %v", err)
+			}
+		case <-stop:
+			if err := c.Flush(); err != nil {
+				log.Printf("[Views] Error flushing views: %v", err)
+			}
+			return
+		}
+	}
+}
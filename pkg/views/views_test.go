@@ -0,0 +1,65 @@
+package views
+
+import (
+	"errors"
+	"testing"
+)
+
+type fakeStore struct {
+	counts map[int]int64
+	err    error
+}
+
+func (s *fakeStore) IncrementListingViews(counts map[int]int64) error {
+	if s.err != nil {
+		return s.err
+	}
+	for id, count := range counts {
+		s.counts[id] += count
+	}
+	return nil
+}
+
+func TestCounterFlush(t *testing.T) {
+	store := &fakeStore{counts: make(map[int]int64)}
+	counter := NewCounter(store)
+
+	counter.Record(1)
+	counter.Record(1)
+	counter.Record(2)
+
+	if err := counter.Flush(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if store.counts[1] != 2 || store.counts[2] != 1 {
+		t.Errorf("Unexpected flushed counts: %v", store.counts)
+	}
+
+	// A second flush has nothing left to write
+	store.counts = make(map[int]int64)
+	if err := counter.Flush(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(store.counts) != 0 {
+		t.Errorf("Expected nothing to be flushed twice, got %v", store.counts)
+	}
+}
+
+func TestCounterFlushFailureKeepsViews(t *testing.T) {
+	store := &fakeStore{counts: make(map[int]int64), err: errors.New("db down")}
+	counter := NewCounter(store)
+
+	counter.Record(1)
+	if err := counter.Flush(); err == nil {
+		t.Fatalf("Expected flush error")
+	}
+
+	counter.Record(1)
+	store.err = nil
+	if err := counter.Flush(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if store.counts[1] != 2 {
+		t.Errorf("Expected 2 views after retried flush, got %d", store.counts[1])
+	}
+}
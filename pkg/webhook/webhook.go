@@ -0,0 +1,265 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/events"
+	"github.com/korjavin/graphqlTinyExample/pkg/id"
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+)
+
+const (
+	// queueSize bounds the number of notifications waiting in memory; overflow is dropped
+	queueSize = 100
+	// maxAttempts is how often a notification is sent before giving up
+	maxAttempts = 3
+	// SignatureHeader carries the HMAC-SHA256 of the request body, keyed with the seller's secret
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+// Store resolves the webhook of the seller owning a listing
+type Store interface {
+	GetListing(id int) (*models.Listing, error)
+	GetSellerWebhook(sellerID int) (*models.SellerWebhook, error)
+}
+
+// Dispatcher notifies sellers of purchases of their listings and their
+// cancellations in the background, and delivers their daily digests
+type Dispatcher struct {
+	store   Store
+	client  *http.Client
+	queue   chan *models.Purchase
+	source  string
+	backoff time.Duration
+}
+
+// NewDispatcher creates a dispatcher sending CloudEvents with the default source
+func NewDispatcher(store Store) *Dispatcher {
+	return &Dispatcher{
+		store:   store,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		queue:   make(chan *models.Purchase, queueSize),
+		source:  events.DefaultSource,
+		backoff: time.Second,
+	}
+}
+
+// SetSource sets the CloudEvents source attribute of sent events
+func (d *Dispatcher) SetSource(source string) {
+	d.source = source
+}
+
+// PurchaseCreated queues a notification for the seller of the purchased listing
+// without blocking the caller
+func (d *Dispatcher) PurchaseCreated(purchase *models.Purchase) {
+	d.enqueue(purchase)
+}
+
+// PurchaseCanceled queues a notification for the seller of the listing of a
+// canceled purchase without blocking the caller
+func (d *Dispatcher) PurchaseCanceled(purchase *models.Purchase) {
+	d.enqueue(purchase)
+}
+
+// enqueue queues a purchase notification, dropping it if the queue is full
+func (d *Dispatcher) enqueue(purchase *models.Purchase) {
+	select {
+	case d.queue <- purchase:
+	default:
+		log.Printf("[Webhook] Queue is full, dropping notification for purchase ID %d", purchase.ID)
+	}
+}
+
+// Run sends queued notifications until stop is closed
+func (d *Dispatcher) Run(stop <-chan struct{}) {
+	ctx := context.Background()
+	for {
+		select {
+		case purchase := <-d.queue:
+			if err := d.Notify(ctx, purchase); err != nil {
+				log.Printf("[Webhook] %v", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// purchasePayload is the data of purchase events: the fields of a purchase its
+// seller may see, which leave out the bank transaction ID. IDs are the ones
+// exposed by the API, so sellers can pass them back to it
+type purchasePayload struct {
+	ID              string               `json:"id"`
+	ListingID       string               `json:"listingId"`
+	SellerID        string               `json:"sellerId"`
+	Price           models.Money         `json:"price"`
+	TaxAmount       models.Money         `json:"taxAmount"`
+	DeliveryAddress string               `json:"deliveryAddress"`
+	PickupPointID   *string              `json:"pickupPointId,omitempty"`
+	Status          string               `json:"status"`
+	OrderStatus     string               `json:"orderStatus"`
+	CreatedAt       time.Time            `json:"createdAt"`
+	Cancellation    *cancellationPayload `json:"cancellation,omitempty"`
+}
+
+// cancellationPayload tells why and when a purchase was canceled
+type cancellationPayload struct {
+	Reason     string    `json:"reason"`
+	CanceledAt time.Time `json:"canceledAt"`
+}
+
+// newPurchasePayload builds the event data of a purchase of the listing
+func newPurchasePayload(purchase *models.Purchase, listing *models.Listing) purchasePayload {
+	payload := purchasePayload{
+		ID:              id.FormatPurchaseID(purchase.ID),
+		ListingID:       id.FormatListingID(purchase.ListingID),
+		SellerID:        id.FormatSellerID(listing.SellerID),
+		Price:           purchase.Price,
+		TaxAmount:       purchase.TaxAmount,
+		DeliveryAddress: purchase.DeliveryAddress,
+		Status:          purchase.Status,
+		OrderStatus:     purchase.OrderStatus,
+		CreatedAt:       purchase.CreatedAt,
+	}
+	if purchase.PickupPointID != nil {
+		pickupPointID := id.FormatPickupPointID(*purchase.PickupPointID)
+		payload.PickupPointID = &pickupPointID
+	}
+	if purchase.Cancellation != nil {
+		payload.Cancellation = &cancellationPayload{Reason: purchase.Cancellation.Reason, CanceledAt: purchase.Cancellation.CanceledAt}
+	}
+	return payload
+}
+
+// Notify sends a purchase to the webhook of the listing's seller, retrying failed
+// requests; sellers without a webhook are skipped. Purchases carrying a
+// cancellation are sent as cancellation events, all others as new purchases
+func (d *Dispatcher) Notify(ctx context.Context, purchase *models.Purchase) error {
+	listing, err := d.store.GetListing(purchase.ListingID)
+	if err != nil {
+		return fmt.Errorf("failed to load listing of purchase %d: %w", purchase.ID, err)
+	}
+
+	data, err := json.Marshal(newPurchasePayload(purchase, listing))
+	if err != nil {
+		return err
+	}
+	eventType, eventID, eventTime := events.PurchaseCreatedType, "purchase-"+id.FormatPurchaseID(purchase.ID), purchase.CreatedAt
+	if purchase.Cancellation != nil {
+		eventType, eventID, eventTime = events.PurchaseCanceledType, eventID+"-canceled", purchase.Cancellation.CanceledAt
+	}
+	event := events.NewCloudEvent(eventType, d.source, eventID, "sellers/"+id.FormatSellerID(listing.SellerID), eventTime, data)
+
+	return d.send(ctx, listing.SellerID, event)
+}
+
+// digestPayload is the data of digest events, identifying the seller by the ID
+// exposed by the API
+type digestPayload struct {
+	SellerID             string                       `json:"sellerId"`
+	From                 time.Time                    `json:"from"`
+	To                   time.Time                    `json:"to"`
+	Purchases            int                          `json:"purchases"`
+	Revenue              models.Money                 `json:"revenue"`
+	DeliveryStatusCounts []models.DeliveryStatusCount `json:"deliveryStatusCounts"`
+}
+
+// SendDigest sends a daily digest to the seller's webhook; sellers without a
+// webhook are skipped. The event ID is stable per seller and day so repeated
+// digests can be deduplicated
+func (d *Dispatcher) SendDigest(ctx context.Context, digest *models.SellerDigest) error {
+	sellerID := id.FormatSellerID(digest.SellerID)
+	data, err := json.Marshal(digestPayload{
+		SellerID:             sellerID,
+		From:                 digest.From,
+		To:                   digest.To,
+		Purchases:            digest.Purchases,
+		Revenue:              digest.Revenue,
+		DeliveryStatusCounts: digest.DeliveryStatusCounts,
+	})
+	if err != nil {
+		return err
+	}
+	event := events.NewCloudEvent(events.SellerDigestType, d.source,
+		fmt.Sprintf("digest-%s-%s", sellerID, digest.From.Format("2006-01-02")),
+		"sellers/"+sellerID, digest.To, data)
+
+	return d.send(ctx, digest.SellerID, event)
+}
+
+// send posts an event to the seller's webhook, retrying failed requests
+func (d *Dispatcher) send(ctx context.Context, sellerID int, event events.CloudEvent) error {
+	hook, err := d.store.GetSellerWebhook(sellerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load webhook of seller %d: %w", sellerID, err)
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = d.post(ctx, hook, body)
+		if err == nil {
+			log.Printf("[Webhook] Sent %s to seller %d", event.ID, sellerID)
+			return nil
+		}
+		if attempt == maxAttempts {
+			return fmt.Errorf("failed to send %s to seller %d after %d attempts: %w", event.ID, sellerID, attempt, err)
+		}
+		time.Sleep(d.backoff * time.Duration(attempt))
+	}
+}
+
+// post sends a signed event to a webhook, treating non-2xx responses as failures
+func (d *Dispatcher) post(ctx context.Context, hook *models.SellerWebhook, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/cloudevents+json")
+	req.Header.Set(SignatureHeader, Sign(hook.Secret, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the signature header value of a request body, "sha256=" followed
+// by the hex encoded HMAC-SHA256 keyed with the secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewSecret generates a random signing secret for a seller's webhook
+func NewSecret() string {
+	b := make([]byte, 32)
+	// crypto/rand never fails since Go 1.24
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,180 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/events"
+	"github.com/korjavin/graphqlTinyExample/pkg/id"
+	"github.com/korjavin/graphqlTinyExample/pkg/models"
+)
+
+type fakeStore struct {
+	hooks map[int]*models.SellerWebhook
+}
+
+func (s fakeStore) GetListing(id int) (*models.Listing, error) {
+	return &models.Listing{ID: id, SellerID: id * 10}, nil
+}
+
+func (s fakeStore) GetSellerWebhook(sellerID int) (*models.SellerWebhook, error) {
+	if hook, ok := s.hooks[sellerID]; ok {
+		return hook, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func TestNotify(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		if got := r.Header.Get(SignatureHeader); got != Sign("s3cret", body) {
+			t.Errorf("Unexpected signature %s", got)
+		}
+
+		var event events.CloudEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Fatalf("Failed to decode event: %v", err)
+		}
+		if event.Type != events.PurchaseCreatedType || event.ID != "purchase-7" || event.Subject != "sellers/10" {
+			t.Errorf("Unexpected event: %+v", event)
+		}
+		var data map[string]interface{}
+		if err := json.Unmarshal(event.Data, &data); err != nil {
+			t.Fatalf("Failed to decode event data: %v", err)
+		}
+		if _, ok := data["bankTxId"]; ok {
+			t.Errorf("Expected the bank transaction ID to be left out, got %v", data)
+		}
+	}))
+	defer server.Close()
+
+	d := NewDispatcher(fakeStore{hooks: map[int]*models.SellerWebhook{
+		10: {SellerID: 10, URL: server.URL, Secret: "s3cret"},
+	}})
+	d.backoff = time.Millisecond
+
+	err := d.Notify(context.Background(), &models.Purchase{ID: 7, ListingID: 1, BankTxID: "TX-7", CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("Expected 2 calls, got %d", calls)
+	}
+}
+
+func TestNotifyWithoutWebhook(t *testing.T) {
+	d := NewDispatcher(fakeStore{})
+
+	if err := d.Notify(context.Background(), &models.Purchase{ID: 7, ListingID: 1}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestNotifyCanceled(t *testing.T) {
+	var event events.CloudEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Fatalf("Failed to decode event: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	d := NewDispatcher(fakeStore{hooks: map[int]*models.SellerWebhook{
+		10: {SellerID: 10, URL: server.URL, Secret: "s3cret"},
+	}})
+
+	canceledAt := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+	purchase := &models.Purchase{ID: 7, ListingID: 1, CreatedAt: canceledAt.Add(-time.Hour),
+		Cancellation: &models.PurchaseCancellation{PurchaseID: 7, Reason: "Changed my mind", CanceledAt: canceledAt}}
+	if err := d.Notify(context.Background(), purchase); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if event.Type != events.PurchaseCanceledType || event.ID != "purchase-7-canceled" || !event.Time.Equal(canceledAt) {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+}
+
+func TestSendDigest(t *testing.T) {
+	var event events.CloudEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Fatalf("Failed to decode event: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	d := NewDispatcher(fakeStore{hooks: map[int]*models.SellerWebhook{
+		10: {SellerID: 10, URL: server.URL, Secret: "s3cret"},
+	}})
+
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	digest := &models.SellerDigest{SellerID: 10, From: from, To: from.AddDate(0, 0, 1), Purchases: 2}
+	if err := d.SendDigest(context.Background(), digest); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if event.Type != events.SellerDigestType || event.ID != "digest-10-2024-05-01" {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+}
+
+func TestNotifyUsesPublicIDs(t *testing.T) {
+	id.SetCodec(id.NewObfuscator([]byte("secret")))
+	defer id.SetCodec(id.Decimal{})
+
+	var event events.CloudEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Fatalf("Failed to decode event: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	d := NewDispatcher(fakeStore{hooks: map[int]*models.SellerWebhook{
+		10: {SellerID: 10, URL: server.URL, Secret: "s3cret"},
+	}})
+
+	pickupPointID := 4
+	purchase := &models.Purchase{ID: 7, ListingID: 1, PickupPointID: &pickupPointID, CreatedAt: time.Now()}
+	if err := d.Notify(context.Background(), purchase); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Sellers get the IDs the API accepts rather than sequential database IDs
+	if event.ID != "purchase-"+id.FormatPurchaseID(7) || event.Subject != "sellers/"+id.FormatSellerID(10) {
+		t.Errorf("Expected public IDs in the event attributes, got %+v", event)
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(event.Data, &data); err != nil {
+		t.Fatalf("Failed to decode event data: %v", err)
+	}
+	expected := map[string]string{
+		"id":            id.FormatPurchaseID(7),
+		"listingId":     id.FormatListingID(1),
+		"sellerId":      id.FormatSellerID(10),
+		"pickupPointId": id.FormatPickupPointID(4),
+	}
+	for field, value := range expected {
+		if data[field] != value {
+			t.Errorf("Expected %s %s, got %v", field, value, data[field])
+		}
+	}
+	if sellerID, err := id.ParseSellerID(data["sellerId"].(string)); err != nil || sellerID != 10 {
+		t.Errorf("Expected sellerId to parse back to 10, got %d, %v", sellerID, err)
+	}
+}
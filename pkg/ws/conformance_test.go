@@ -0,0 +1,450 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	graphqlgo "github.com/graph-gophers/graphql-go"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/graphql"
+)
+
+const testSchema = `
+schema {
+  query: Query
+  subscription: Subscription
+}
+
+type Query {
+  hello: String!
+}
+
+type Subscription {
+  counter(to: Int!): Int!
+  idle: Int!
+}
+`
+
+type testResolver struct{}
+
+func (testResolver) Hello() string { return "hello" }
+
+// Counter emits 1 to the given number and ends
+func (testResolver) Counter(ctx context.Context, args struct{ To int32 }) <-chan int32 {
+	ch := make(chan int32)
+	go func() {
+		defer close(ch)
+		for i := int32(1); i <= args.To; i++ {
+			select {
+			case ch <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// Idle never emits and ends when stopped
+func (testResolver) Idle(ctx context.Context) <-chan int32 {
+	ch := make(chan int32)
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch
+}
+
+// step is one turn of a conversation: the client sends a frame, or expects
+// a frame or the connection to be closed with a code
+type step struct {
+	send      string
+	expect    string
+	closeCode int
+	// closeFrame sends send as a close frame instead of a text frame
+	closeFrame bool
+}
+
+func send(frame string) step      { return step{send: frame} }
+func expect(frame string) step    { return step{expect: frame} }
+func expectClose(code int) step   { return step{closeCode: code} }
+func start(id, query string) step { return send(operationFrame("start", id, query)) }
+
+func subscribe(id, query string) step { return send(operationFrame("subscribe", id, query)) }
+
+func operationFrame(messageType, id, query string) string {
+	frame, _ := json.Marshal(map[string]interface{}{
+		"type":    messageType,
+		"id":      id,
+		"payload": map[string]string{"query": query},
+	})
+	return string(frame)
+}
+
+const (
+	initFrame = `{"type":"connection_init"}`
+	ackFrame  = `{"type":"connection_ack"}`
+)
+
+func newTestServer(t *testing.T, whitelist graphql.RoleWhitelist) *httptest.Server {
+	t.Helper()
+	return serveTestHandler(t, newTestHandler(t, whitelist))
+}
+
+func newTestHandler(t *testing.T, whitelist graphql.RoleWhitelist) *Handler {
+	t.Helper()
+	schema, err := graphqlgo.ParseSchema(testSchema, &testResolver{})
+	if err != nil {
+		t.Fatalf("Failed to parse schema: %v", err)
+	}
+	return &Handler{
+		Schema:    schema,
+		Whitelist: whitelist,
+		Context: func(r *http.Request) context.Context {
+			return graphql.WithRole(context.Background(), r.Header.Get("X-User-Role"))
+		},
+	}
+}
+
+func serveTestHandler(t *testing.T, handler *Handler) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// converse dials the server with the subprotocol and plays the conversation
+func converse(t *testing.T, server *httptest.Server, subprotocol string, header http.Header, steps []step) {
+	t.Helper()
+
+	dialer := websocket.Dialer{}
+	if subprotocol != "" {
+		dialer.Subprotocols = []string{subprotocol}
+	}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), header)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	if subprotocol != "" && conn.Subprotocol() != subprotocol {
+		t.Fatalf("Expected subprotocol %s, got %q", subprotocol, conn.Subprotocol())
+	}
+
+	for i, s := range steps {
+		if s.closeFrame {
+			if err := conn.WriteControl(websocket.CloseMessage, []byte(s.send), time.Now().Add(time.Second)); err != nil {
+				t.Fatalf("step %d: failed to close: %v", i, err)
+			}
+			continue
+		}
+		if s.send != "" {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(s.send)); err != nil {
+				t.Fatalf("step %d: failed to send %s: %v", i, s.send, err)
+			}
+			continue
+		}
+
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, frame, err := conn.ReadMessage()
+
+		if s.closeCode != 0 {
+			if !websocket.IsCloseError(err, s.closeCode) {
+				t.Fatalf("step %d: expected close %d, got frame %s, error %v", i, s.closeCode, frame, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("step %d: expected %s, got error %v", i, s.expect, err)
+		}
+
+		var got, want interface{}
+		if err := json.Unmarshal(frame, &got); err != nil {
+			t.Fatalf("step %d: invalid frame %s: %v", i, frame, err)
+		}
+		if err := json.Unmarshal([]byte(s.expect), &want); err != nil {
+			t.Fatalf("step %d: invalid expectation %s: %v", i, s.expect, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("step %d: expected %s, got %s", i, s.expect, frame)
+		}
+	}
+}
+
+func TestLegacyProtocol(t *testing.T) {
+	server := newTestServer(t, nil)
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"init", []step{
+			send(initFrame),
+			expect(ackFrame),
+		}},
+		{"events and server completion", []step{
+			send(initFrame),
+			expect(ackFrame),
+			start("1", "subscription { counter(to: 2) }"),
+			expect(`{"type":"data","id":"1","payload":{"data":{"counter":1}}}`),
+			expect(`{"type":"data","id":"1","payload":{"data":{"counter":2}}}`),
+			expect(`{"type":"complete","id":"1"}`),
+		}},
+		{"stop", []step{
+			send(initFrame),
+			expect(ackFrame),
+			start("1", "subscription { idle }"),
+			send(`{"type":"stop","id":"1"}`),
+			expect(`{"type":"complete","id":"1"}`),
+		}},
+		{"stop unknown subscription", []step{
+			send(`{"type":"stop","id":"9"}`),
+			expect(`{"type":"complete","id":"9"}`),
+		}},
+		{"restart with the same id", []step{
+			start("1", "subscription { idle }"),
+			start("1", "subscription { counter(to: 1) }"),
+			expect(`{"type":"data","id":"1","payload":{"data":{"counter":1}}}`),
+			expect(`{"type":"complete","id":"1"}`),
+		}},
+		{"invalid query", []step{
+			start("1", "subscription { missing }"),
+			expect(`{"type":"error","id":"1","payload":{"message":"graphql: Cannot query field \"missing\" on type \"Subscription\". (line 1, column 16)"}}`),
+		}},
+		{"invalid payload", []step{
+			send(`{"type":"start","id":"1","payload":"subscription { idle }"}`),
+			expect(`{"type":"error","id":"1","payload":{"message":"Invalid subscription payload"}}`),
+		}},
+		{"malformed frame keeps the connection open", []step{
+			send(`not json`),
+			expect(`{"type":"error","payload":{"message":"Invalid message format"}}`),
+			send(initFrame),
+			expect(ackFrame),
+		}},
+		{"unknown message type is ignored", []step{
+			send(`{"type":"bogus"}`),
+			send(initFrame),
+			expect(ackFrame),
+		}},
+		{"terminate", []step{
+			send(initFrame),
+			expect(ackFrame),
+			send(`{"type":"connection_terminate"}`),
+			expectClose(websocket.CloseNormalClosure),
+		}},
+	}
+
+	for _, subprotocol := range []string{"", ProtocolGraphQLWS} {
+		for _, tt := range tests {
+			t.Run(tt.name+"/"+subprotocol, func(t *testing.T) {
+				converse(t, server, subprotocol, nil, tt.steps)
+			})
+		}
+	}
+}
+
+func TestTransportWSProtocol(t *testing.T) {
+	server := newTestServer(t, nil)
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"init", []step{
+			send(initFrame),
+			expect(ackFrame),
+		}},
+		{"ping", []step{
+			send(`{"type":"ping"}`),
+			expect(`{"type":"pong"}`),
+		}},
+		{"events and server completion", []step{
+			send(initFrame),
+			expect(ackFrame),
+			subscribe("1", "subscription { counter(to: 2) }"),
+			expect(`{"type":"next","id":"1","payload":{"data":{"counter":1}}}`),
+			expect(`{"type":"next","id":"1","payload":{"data":{"counter":2}}}`),
+			expect(`{"type":"complete","id":"1"}`),
+		}},
+		{"client completion is not acknowledged", []step{
+			send(initFrame),
+			expect(ackFrame),
+			subscribe("1", "subscription { idle }"),
+			send(`{"type":"complete","id":"1"}`),
+			send(`{"type":"ping"}`),
+			expect(`{"type":"pong"}`),
+		}},
+		{"id can be reused after completion", []step{
+			send(initFrame),
+			expect(ackFrame),
+			subscribe("1", "subscription { counter(to: 1) }"),
+			expect(`{"type":"next","id":"1","payload":{"data":{"counter":1}}}`),
+			expect(`{"type":"complete","id":"1"}`),
+			subscribe("1", "subscription { counter(to: 1) }"),
+			expect(`{"type":"next","id":"1","payload":{"data":{"counter":1}}}`),
+			expect(`{"type":"complete","id":"1"}`),
+		}},
+		{"invalid query", []step{
+			send(initFrame),
+			expect(ackFrame),
+			subscribe("1", "subscription { missing }"),
+			expect(`{"type":"error","id":"1","payload":[{"message":"Cannot query field \"missing\" on type \"Subscription\".","locations":[{"line":1,"column":16}]}]}`),
+			send(`{"type":"ping"}`),
+			expect(`{"type":"pong"}`),
+		}},
+		{"subscribe before init", []step{
+			subscribe("1", "subscription { idle }"),
+			expectClose(CloseUnauthorized),
+		}},
+		{"second init", []step{
+			send(initFrame),
+			expect(ackFrame),
+			send(initFrame),
+			expectClose(CloseTooManyInitRequests),
+		}},
+		{"duplicate id", []step{
+			send(initFrame),
+			expect(ackFrame),
+			subscribe("1", "subscription { idle }"),
+			subscribe("1", "subscription { idle }"),
+			expectClose(CloseSubscriberExists),
+		}},
+		{"missing id", []step{
+			send(initFrame),
+			expect(ackFrame),
+			subscribe("", "subscription { idle }"),
+			expectClose(CloseBadRequest),
+		}},
+		{"invalid payload", []step{
+			send(initFrame),
+			expect(ackFrame),
+			send(`{"type":"subscribe","id":"1","payload":"subscription { idle }"}`),
+			expectClose(CloseBadRequest),
+		}},
+		{"malformed frame", []step{
+			send(`not json`),
+			expectClose(CloseBadRequest),
+		}},
+		{"unknown message type", []step{
+			send(`{"type":"start","id":"1"}`),
+			expectClose(CloseBadRequest),
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			converse(t, server, ProtocolTransportWS, nil, tt.steps)
+		})
+	}
+}
+
+func TestRoleWhitelist(t *testing.T) {
+	server := newTestServer(t, graphql.RoleWhitelist{"courier": {"counter"}})
+	header := http.Header{"X-User-Role": {"courier"}}
+
+	converse(t, server, ProtocolGraphQLWS, header, []step{
+		start("1", "subscription { idle }"),
+		expect(`{"type":"error","id":"1","payload":{"message":"role \"courier\" is not permitted to use idle"}}`),
+		start("2", "subscription { counter(to: 1) }"),
+		expect(`{"type":"data","id":"2","payload":{"data":{"counter":1}}}`),
+	})
+
+	converse(t, server, ProtocolTransportWS, header, []step{
+		send(initFrame),
+		expect(ackFrame),
+		subscribe("1", "subscription { idle }"),
+		expect(`{"type":"error","id":"1","payload":[{"message":"role \"courier\" is not permitted to use idle"}]}`),
+	})
+}
+
+func TestAuthentication(t *testing.T) {
+	handler := newTestHandler(t, graphql.RoleWhitelist{"courier": {"counter"}})
+	handler.Authenticate = func(ctx context.Context, token string) (context.Context, error) {
+		switch token {
+		case "":
+			return ctx, nil
+		case "courier-token":
+			return graphql.WithPrincipal(ctx, &graphql.Principal{Subject: "courier-7", Role: "courier"}), nil
+		}
+		return nil, errors.New("invalid token")
+	}
+	server := serveTestHandler(t, handler)
+
+	converse(t, server, ProtocolGraphQLWS, nil, []step{
+		send(`{"type":"connection_init","payload":{"authToken":"courier-token"}}`),
+		expect(ackFrame),
+		start("1", "subscription { counter(to: 1) }"),
+		expect(`{"type":"data","id":"1","payload":{"data":{"counter":1}}}`),
+	})
+	converse(t, server, ProtocolGraphQLWS, nil, []step{
+		send(`{"type":"connection_init","payload":{"authToken":"forged"}}`),
+		expect(`{"type":"connection_error","payload":{"message":"invalid token"}}`),
+		expectClose(websocket.ClosePolicyViolation),
+	})
+
+	converse(t, server, ProtocolTransportWS, nil, []step{
+		send(`{"type":"connection_init","payload":{"Authorization":"Bearer courier-token"}}`),
+		expect(ackFrame),
+		subscribe("1", "subscription { counter(to: 1) }"),
+		expect(`{"type":"next","id":"1","payload":{"data":{"counter":1}}}`),
+	})
+	converse(t, server, ProtocolTransportWS, nil, []step{
+		send(`{"type":"connection_init","payload":{"authToken":"forged"}}`),
+		expectClose(CloseForbidden),
+	})
+
+	// Without a token the connection keeps the role of the request
+	converse(t, server, ProtocolTransportWS, nil, []step{
+		send(initFrame),
+		expect(ackFrame),
+		subscribe("1", "subscription { counter(to: 1) }"),
+		expect(`{"type":"error","id":"1","payload":[{"message":"role \"anonymous\" is not permitted to run operations"}]}`),
+	})
+}
+
+func TestLimits(t *testing.T) {
+	handler := newTestHandler(t, nil)
+	handler.MaxSubscriptions = 1
+	handler.MaxMessageSize = 128
+	server := serveTestHandler(t, handler)
+
+	converse(t, server, ProtocolTransportWS, nil, []step{
+		send(initFrame),
+		expect(ackFrame),
+		subscribe("1", "subscription { idle }"),
+		subscribe("2", "subscription { idle }"),
+		expect(`{"type":"error","id":"2","payload":[{"message":"too many subscriptions on this connection, at most 1 may run at once"}]}`),
+		send(`{"type":"complete","id":"1"}`),
+		subscribe("3", "subscription { counter(to: 1) }"),
+		expect(`{"type":"next","id":"3","payload":{"data":{"counter":1}}}`),
+	})
+
+	converse(t, server, ProtocolGraphQLWS, nil, []step{
+		start("1", "subscription { idle } # "+strings.Repeat("x", 128)),
+		expectClose(websocket.CloseMessageTooBig),
+	})
+
+	// Connections are counted until they close
+	handler = newTestHandler(t, nil)
+	handler.MaxConnectionsPerIP = 2
+	url := "ws" + strings.TrimPrefix(serveTestHandler(t, handler).URL, "http")
+	for i := 0; i < 2; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer conn.Close()
+	}
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected a third connection from the IP to be refused with 429, got %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package ws
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/metrics"
+)
+
+// Connection lifecycle events, logged as key=value lines and counted in
+// metrics.WebSocketEvents
+const (
+	eventConnect               = "connect"
+	eventInit                  = "init"
+	eventAuthFailed            = "auth_failed"
+	eventSubscriptionStarted   = "subscription_started"
+	eventSubscriptionStopped   = "subscription_stopped"
+	eventSubscriptionCompleted = "subscription_completed"
+	eventError                 = "error"
+	eventClose                 = "close"
+)
+
+// Sides that may end a connection. Connections dropped without a close
+// frame, as mobile clients losing their network do, count as network
+const (
+	closedByClient  = "client"
+	closedByServer  = "server"
+	closedByNetwork = "network"
+)
+
+// connectionIDs numbers connections so the events of one can be told apart
+var connectionIDs atomic.Uint64
+
+// closeInfo records how a connection ended
+type closeInfo struct {
+	by     string
+	code   int
+	reason string
+}
+
+// event logs a lifecycle event of the connection with key/value fields and
+// counts it
+func (c *connection) event(name string, fields ...interface{}) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[WS] conn=%d event=%s", c.id, name)
+	for i := 0; i+1 < len(fields); i += 2 {
+		fmt.Fprintf(&b, " %v=%s", fields[i], formatValue(fields[i+1]))
+	}
+	log.Print(b.String())
+
+	metrics.WebSocketEvents.WithLabelValues(c.protocol(), name).Inc()
+}
+
+// formatValue quotes values that would otherwise be ambiguous in a key=value line
+func formatValue(value interface{}) string {
+	s := fmt.Sprint(value)
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// readClosed works out how a connection ended from the error its read loop
+// stopped with, unless the server closed it first
+func (c *connection) readClosed(err error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed != nil {
+		return
+	}
+
+	// The read limit was hit, and the connection closed with 1009 already
+	if errors.Is(err, websocket.ErrReadLimit) {
+		c.closed = &closeInfo{by: closedByServer, code: websocket.CloseMessageTooBig, reason: "Message too big"}
+		return
+	}
+
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) && closeErr.Code != websocket.CloseAbnormalClosure {
+		c.closed = &closeInfo{by: closedByClient, code: closeErr.Code, reason: closeErr.Text}
+		return
+	}
+	c.closed = &closeInfo{by: closedByNetwork, code: websocket.CloseAbnormalClosure, reason: err.Error()}
+}
+
+// finish records the end of the connection with its lifetime
+func (c *connection) finish(started time.Time, subscriptions int) {
+	duration := time.Since(started)
+	closed := c.closed
+	if closed == nil {
+		closed = &closeInfo{by: closedByServer, code: websocket.CloseNormalClosure}
+	}
+
+	c.event(eventClose,
+		"closed_by", closed.by,
+		"code", closed.code,
+		"reason", closed.reason,
+		"duration", duration.Round(time.Millisecond),
+		"subscriptions", subscriptions,
+	)
+	metrics.WebSocketConnections.WithLabelValues(c.protocol()).Dec()
+	metrics.WebSocketConnectionDuration.WithLabelValues(c.protocol(), closed.by, strconv.Itoa(closed.code)).Observe(duration.Seconds())
+}
@@ -0,0 +1,158 @@
+package ws
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/metrics"
+)
+
+// syncBuffer collects log output written from several goroutines
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLog redirects the standard logger for the rest of the test
+func captureLog(t *testing.T) *syncBuffer {
+	buf := &syncBuffer{}
+	log.SetOutput(buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return buf
+}
+
+// connectionLog waits until the connection made with the user agent is closed
+// and returns its events
+func connectionLog(t *testing.T, logs *syncBuffer, userAgent string) string {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var conn string
+		var events []string
+		for _, line := range strings.Split(logs.String(), "\n") {
+			if conn == "" && strings.Contains(line, "event=connect") && strings.HasSuffix(line, "user_agent="+userAgent) {
+				conn = line[strings.Index(line, "conn="):strings.Index(line, " event=")]
+			}
+			if conn != "" && strings.Contains(line, conn+" ") {
+				events = append(events, line)
+			}
+		}
+		if len(events) > 0 && strings.Contains(events[len(events)-1], "event=close") {
+			return strings.Join(events, "\n")
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Connection of %s was not closed, events:\n%s", userAgent, strings.Join(events, "\n"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func closedConnections(t *testing.T, protocol, closedBy, code string) uint64 {
+	var m dto.Metric
+	observer := metrics.WebSocketConnectionDuration.WithLabelValues(protocol, closedBy, code)
+	if err := observer.(prometheus.Metric).Write(&m); err != nil {
+		t.Fatalf("Failed to read connection metrics: %v", err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestConnectionEvents(t *testing.T) {
+	server := newTestServer(t, nil)
+	logs := captureLog(t)
+	before := closedConnections(t, ProtocolTransportWS, closedByClient, "1000")
+
+	converse(t, server, ProtocolTransportWS, http.Header{"User-Agent": {"events-test"}}, []step{
+		send(initFrame),
+		expect(ackFrame),
+		subscribe("1", "subscription { counter(to: 1) }"),
+		expect(`{"type":"next","id":"1","payload":{"data":{"counter":1}}}`),
+		expect(`{"type":"complete","id":"1"}`),
+		subscribe("2", "subscription { missing }"),
+		expect(`{"type":"error","id":"2","payload":[{"message":"Cannot query field \"missing\" on type \"Subscription\".","locations":[{"line":1,"column":16}]}]}`),
+		subscribe("3", "subscription { idle }"),
+		send(`{"type":"complete","id":"3"}`),
+		send(`{"type":"ping"}`),
+		expect(`{"type":"pong"}`),
+		closeNormally(),
+	})
+
+	// The server notices the close after the conversation ends
+	output := connectionLog(t, logs, "events-test")
+	want := []string{
+		"event=connect remote=",
+		"protocol=graphql-transport-ws client=unknown",
+		"event=init",
+		"event=subscription_started id=1 operation=anonymous",
+		"event=subscription_completed id=1",
+		`event=error id=2 message="Cannot query field`,
+		"event=subscription_stopped id=3 cause=client",
+		"event=close closed_by=client code=1000",
+		"subscriptions=3",
+	}
+	for _, fragment := range want {
+		if !strings.Contains(output, fragment) {
+			t.Errorf("Expected the event log to contain %q, got:\n%s", fragment, output)
+		}
+	}
+
+	if got := closedConnections(t, ProtocolTransportWS, closedByClient, "1000") - before; got != 1 {
+		t.Errorf("Expected 1 connection closed by the client, got %d", got)
+	}
+}
+
+func TestConnectionCloseCause(t *testing.T) {
+	server := newTestServer(t, nil)
+	logs := captureLog(t)
+
+	// Protocol violations are closed by the server with the violation's code
+	converse(t, server, ProtocolTransportWS, http.Header{"User-Agent": {"violation-test"}}, []step{
+		subscribe("1", "subscription { idle }"),
+		expectClose(CloseUnauthorized),
+	})
+	if output := connectionLog(t, logs, "violation-test"); !strings.Contains(output, "event=close closed_by=server code=4401 reason=Unauthorized") {
+		t.Errorf("Expected the server to close the connection, got:\n%s", output)
+	}
+
+	// Dropping the connection without a close frame is put down to the network
+	converse(t, server, ProtocolGraphQLWS, http.Header{"User-Agent": {"drop-test"}}, []step{
+		start("1", "subscription { idle }"),
+		send(initFrame),
+		expect(ackFrame),
+	})
+	output := connectionLog(t, logs, "drop-test")
+	for _, fragment := range []string{
+		"event=subscription_stopped id=1 cause=connection_closed",
+		"event=close closed_by=network code=1006",
+	} {
+		if !strings.Contains(output, fragment) {
+			t.Errorf("Expected the event log to contain %q, got:\n%s", fragment, output)
+		}
+	}
+}
+
+// closeNormally is a step sending a normal close frame
+func closeNormally() step {
+	return step{send: string(websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye")), closeFrame: true}
+}
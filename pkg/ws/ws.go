@@ -0,0 +1,591 @@
+// Package ws serves GraphQL subscriptions over WebSocket. It speaks both the
+// legacy subscriptions-transport-ws protocol and graphql-transport-ws, chosen
+// by the subprotocol the client asks for
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	graphqlgo "github.com/graph-gophers/graphql-go"
+
+	"github.com/korjavin/graphqlTinyExample/pkg/graphql"
+	"github.com/korjavin/graphqlTinyExample/pkg/metrics"
+)
+
+const (
+	// ProtocolGraphQLWS is the subprotocol of the legacy subscriptions-transport-ws
+	// protocol, which is also spoken when the client asks for no subprotocol
+	ProtocolGraphQLWS = "graphql-ws"
+	// ProtocolTransportWS is the subprotocol of the graphql-transport-ws protocol
+	ProtocolTransportWS = "graphql-transport-ws"
+)
+
+// Close codes of graphql-transport-ws
+const (
+	CloseBadRequest          = 4400
+	CloseUnauthorized        = 4401
+	CloseForbidden           = 4403
+	CloseSubscriberExists    = 4409
+	CloseTooManyInitRequests = 4429
+)
+
+// writeTimeout bounds how long a close frame may take to be written
+const writeTimeout = time.Second
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+	Subprotocols: []string{ProtocolTransportWS, ProtocolGraphQLWS},
+}
+
+// Handler upgrades requests to WebSocket connections and runs the
+// subscriptions they start against the schema
+type Handler struct {
+	Schema *graphqlgo.Schema
+	// Whitelist restricts the root fields each role may subscribe to; nil allows all
+	Whitelist graphql.RoleWhitelist
+	// Context returns the context the connection's subscriptions run in, e.g.
+	// carrying the caller's role; nil uses context.Background
+	Context func(r *http.Request) context.Context
+	// Authenticate validates the auth token of the connection_init payload and
+	// returns the context the connection's subscriptions run in from then on,
+	// e.g. carrying the principal. The token is empty if the client sent none.
+	// An error rejects the connection; nil accepts every connection
+	Authenticate func(ctx context.Context, token string) (context.Context, error)
+
+	// MaxSubscriptions limits the subscriptions running on one connection; zero means unlimited
+	MaxSubscriptions int
+	// MaxMessageSize limits the size of client messages in bytes. Larger
+	// messages close the connection with 1009; zero means unlimited
+	MaxMessageSize int64
+	// MaxConnectionsPerIP limits the open connections from one remote IP.
+	// Further upgrades are refused with HTTP 429; zero means unlimited
+	MaxConnectionsPerIP int
+
+	mu          sync.Mutex
+	connections map[string]int
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ip := remoteIP(r)
+	if !h.acquire(ip) {
+		log.Printf("[WS] Refused connection from %s: too many connections", ip)
+		http.Error(w, "Too many connections", http.StatusTooManyRequests)
+		return
+	}
+	defer h.release(ip)
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("[WS] Failed to upgrade connection to WebSocket: %v", err)
+		return
+	}
+	defer conn.Close()
+	if h.MaxMessageSize > 0 {
+		conn.SetReadLimit(h.MaxMessageSize)
+	}
+
+	ctx := context.Background()
+	if h.Context != nil {
+		ctx = h.Context(r)
+	}
+
+	c := &connection{
+		id:            connectionIDs.Add(1),
+		handler:       h,
+		conn:          conn,
+		ctx:           ctx,
+		transportWS:   conn.Subprotocol() == ProtocolTransportWS,
+		subscriptions: make(map[string]*subscription),
+	}
+
+	started := time.Now()
+	client := graphql.ClientInfoFromContext(ctx)
+	c.event(eventConnect,
+		"remote", r.RemoteAddr,
+		"protocol", c.protocol(),
+		"client", client.Name,
+		"client_version", client.Version,
+		"user_agent", r.UserAgent(),
+	)
+	metrics.WebSocketConnections.WithLabelValues(c.protocol()).Inc()
+
+	c.serve()
+	c.finish(started, c.subscriptionsStarted())
+}
+
+// acquire counts a new connection from the IP, reporting false if the IP
+// already has MaxConnectionsPerIP open
+func (h *Handler) acquire(ip string) bool {
+	if h.MaxConnectionsPerIP <= 0 {
+		return true
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.connections[ip] >= h.MaxConnectionsPerIP {
+		return false
+	}
+	if h.connections == nil {
+		h.connections = make(map[string]int)
+	}
+	h.connections[ip]++
+	return true
+}
+
+// release forgets a closed connection from the IP
+func (h *Handler) release(ip string) {
+	if h.MaxConnectionsPerIP <= 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.connections[ip]--; h.connections[ip] <= 0 {
+		delete(h.connections, ip)
+	}
+}
+
+// remoteIP returns the IP of the request's peer. Behind a proxy that is the
+// proxy's IP, so the per-IP limit should then be left to the proxy
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// message is a frame of either protocol
+type message struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// initPayload is the payload of connection_init. The token is given as
+// authToken or, as some clients send it, as a bearer Authorization
+type initPayload struct {
+	AuthToken     string `json:"authToken"`
+	Authorization string `json:"Authorization"`
+}
+
+// operation is the payload starting a subscription
+type operation struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+	OperationName string                 `json:"operationName,omitempty"`
+}
+
+// subscription is a running operation of a connection
+type subscription struct {
+	cancel context.CancelFunc
+}
+
+// connection is the state of a single WebSocket connection
+type connection struct {
+	id          uint64
+	handler     *Handler
+	conn        *websocket.Conn
+	ctx         context.Context
+	transportWS bool
+	initialized bool
+
+	// writeMu serializes writes, which come from the read loop and from every
+	// subscription, and guards closed
+	writeMu sync.Mutex
+	closed  *closeInfo
+
+	mu            sync.Mutex
+	subscriptions map[string]*subscription
+	started       int
+}
+
+func (c *connection) protocol() string {
+	if c.transportWS {
+		return ProtocolTransportWS
+	}
+	return ProtocolGraphQLWS
+}
+
+// serve processes the client's messages until the connection is closed
+func (c *connection) serve() {
+	defer c.stopAll()
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			c.readClosed(err)
+			return
+		}
+
+		var msg message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			log.Printf("[WS] Error parsing message: %v", err)
+			if c.transportWS {
+				c.close(closedByServer, CloseBadRequest, "Invalid message format")
+				return
+			}
+			c.sendError("", "Invalid message format")
+			continue
+		}
+
+		var open bool
+		if c.transportWS {
+			open = c.handleTransportWS(msg)
+		} else {
+			open = c.handleLegacy(msg)
+		}
+		if !open {
+			return
+		}
+	}
+}
+
+// handleLegacy handles a subscriptions-transport-ws message, returning false
+// once the connection should be closed
+func (c *connection) handleLegacy(msg message) bool {
+	switch msg.Type {
+	case "connection_init":
+		if err := c.authenticate(msg.Payload); err != nil {
+			c.send("connection_error", "", map[string]interface{}{"message": err.Error()})
+			c.close(closedByServer, websocket.ClosePolicyViolation, "Forbidden")
+			return false
+		}
+		c.event(eventInit)
+		c.send("connection_ack", "", nil)
+
+	case "start":
+		var op operation
+		if err := json.Unmarshal(msg.Payload, &op); err != nil {
+			log.Printf("[WS] Error parsing subscription payload: %v", err)
+			c.sendError(msg.ID, "Invalid subscription payload")
+			return true
+		}
+
+		// A start reusing the ID of a running subscription replaces it
+		if c.stop(msg.ID) {
+			c.event(eventSubscriptionStopped, "id", msg.ID, "cause", "replaced")
+		}
+		if err := c.check(op); err != nil {
+			c.sendError(msg.ID, err.Error())
+			return true
+		}
+		if err := c.checkLimit(); err != nil {
+			c.sendError(msg.ID, err.Error())
+			return true
+		}
+		c.start(msg.ID, op)
+
+	case "stop":
+		if c.stop(msg.ID) {
+			c.event(eventSubscriptionStopped, "id", msg.ID, "cause", "client")
+		}
+		c.send("complete", msg.ID, nil)
+
+	case "connection_terminate":
+		c.close(closedByClient, websocket.CloseNormalClosure, "connection_terminate")
+		return false
+
+	default:
+		log.Printf("[WS] Unknown message type: %s", msg.Type)
+	}
+	return true
+}
+
+// handleTransportWS handles a graphql-transport-ws message, returning false
+// once the connection should be closed. Protocol violations close the
+// connection with the code the protocol prescribes
+func (c *connection) handleTransportWS(msg message) bool {
+	switch msg.Type {
+	case "connection_init":
+		if c.initialized {
+			c.close(closedByServer, CloseTooManyInitRequests, "Too many initialisation requests")
+			return false
+		}
+		c.initialized = true
+		if err := c.authenticate(msg.Payload); err != nil {
+			c.close(closedByServer, CloseForbidden, "Forbidden")
+			return false
+		}
+		c.event(eventInit)
+		c.send("connection_ack", "", nil)
+
+	case "ping":
+		c.send("pong", "", nil)
+
+	case "pong":
+
+	case "subscribe":
+		if !c.initialized {
+			c.close(closedByServer, CloseUnauthorized, "Unauthorized")
+			return false
+		}
+		if msg.ID == "" {
+			c.close(closedByServer, CloseBadRequest, "Subscribe message requires an id")
+			return false
+		}
+
+		var op operation
+		if err := json.Unmarshal(msg.Payload, &op); err != nil {
+			log.Printf("[WS] Error parsing subscription payload: %v", err)
+			c.close(closedByServer, CloseBadRequest, "Invalid subscription payload")
+			return false
+		}
+
+		if c.running(msg.ID) {
+			c.close(closedByServer, CloseSubscriberExists, "Subscriber for "+msg.ID+" already exists")
+			return false
+		}
+		if err := c.check(op); err != nil {
+			c.sendError(msg.ID, err.Error())
+			return true
+		}
+		if err := c.checkLimit(); err != nil {
+			c.sendError(msg.ID, err.Error())
+			return true
+		}
+		c.start(msg.ID, op)
+
+	case "complete":
+		if c.stop(msg.ID) {
+			c.event(eventSubscriptionStopped, "id", msg.ID, "cause", "client")
+		}
+
+	default:
+		log.Printf("[WS] Unknown message type: %s", msg.Type)
+		c.close(closedByServer, CloseBadRequest, "Unknown message type "+msg.Type)
+		return false
+	}
+	return true
+}
+
+// authenticate passes the auth token of a connection_init payload to the
+// handler's Authenticate, switching the connection to the context it returns
+func (c *connection) authenticate(payload json.RawMessage) error {
+	if c.handler.Authenticate == nil {
+		return nil
+	}
+
+	var init initPayload
+	if len(payload) > 0 && string(payload) != "null" {
+		if err := json.Unmarshal(payload, &init); err != nil {
+			c.event(eventAuthFailed, "error", "invalid payload")
+			return errors.New("invalid connection_init payload")
+		}
+	}
+	token := init.AuthToken
+	if token == "" {
+		token = strings.TrimPrefix(init.Authorization, "Bearer ")
+	}
+
+	ctx, err := c.handler.Authenticate(c.ctx, token)
+	if err != nil {
+		c.event(eventAuthFailed, "error", err.Error())
+		return err
+	}
+	c.ctx = ctx
+	return nil
+}
+
+// check returns an error if the caller's role may not run the operation or
+// see the fields it selects
+func (c *connection) check(op operation) error {
+	role := graphql.RoleFromContext(c.ctx)
+	if c.handler.Whitelist != nil {
+		if err := c.handler.Whitelist.Check(role, op.Query, op.OperationName); err != nil {
+			return err
+		}
+	}
+	return graphql.CheckFieldRoles(c.handler.Schema, role, op.Query, op.OperationName, op.Variables)
+}
+
+// checkLimit returns an error if the connection already runs MaxSubscriptions subscriptions
+func (c *connection) checkLimit() error {
+	max := c.handler.MaxSubscriptions
+	if max <= 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.subscriptions) >= max {
+		return fmt.Errorf("too many subscriptions on this connection, at most %d may run at once", max)
+	}
+	return nil
+}
+
+// start runs an operation in the background, forwarding its results to the client
+func (c *connection) start(id string, op operation) {
+	operationName := op.OperationName
+	if operationName == "" {
+		operationName = "anonymous"
+	}
+	c.event(eventSubscriptionStarted, "id", id, "operation", operationName, "query", op.Query)
+
+	ctx, cancel := context.WithCancel(c.ctx)
+	sub := &subscription{cancel: cancel}
+	c.mu.Lock()
+	c.subscriptions[id] = sub
+	c.started++
+	c.mu.Unlock()
+
+	go c.run(ctx, id, sub, op)
+}
+
+// run forwards the results of a subscription until it ends or is stopped.
+// Subscriptions ended by the server are completed towards the client
+func (c *connection) run(ctx context.Context, id string, sub *subscription, op operation) {
+	defer c.remove(id, sub)
+
+	responses, err := c.handler.Schema.Subscribe(ctx, op.Query, op.OperationName, op.Variables)
+	if err != nil {
+		c.sendError(id, err.Error())
+		return
+	}
+
+	for response := range responses {
+		// Nothing is sent for a subscription once the client stopped it
+		if ctx.Err() != nil {
+			return
+		}
+		resp, ok := response.(*graphqlgo.Response)
+		if !ok {
+			continue
+		}
+
+		if c.transportWS {
+			// Without data the operation failed as a whole, which ends it
+			if len(resp.Data) == 0 && len(resp.Errors) > 0 {
+				c.event(eventError, "id", id, "message", resp.Errors[0].Message)
+				c.send("error", id, resp.Errors)
+				return
+			}
+			c.send("next", id, resp)
+			continue
+		}
+
+		if len(resp.Errors) > 0 {
+			c.sendError(id, resp.Errors[0].Error())
+			continue
+		}
+		c.send("data", id, map[string]interface{}{
+			"data": resp.Data,
+		})
+	}
+
+	if ctx.Err() == nil {
+		// Forget the subscription first so the client may reuse its ID right away
+		c.remove(id, sub)
+		c.event(eventSubscriptionCompleted, "id", id)
+		c.send("complete", id, nil)
+	}
+}
+
+// running reports whether a subscription with the ID is running
+func (c *connection) running(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.subscriptions[id]
+	return ok
+}
+
+// stop cancels the subscription with the ID, reporting whether it was running
+func (c *connection) stop(id string) bool {
+	c.mu.Lock()
+	sub, ok := c.subscriptions[id]
+	delete(c.subscriptions, id)
+	c.mu.Unlock()
+
+	if ok {
+		sub.cancel()
+	}
+	return ok
+}
+
+// remove forgets a finished subscription unless its ID was reused since
+func (c *connection) remove(id string, sub *subscription) {
+	c.mu.Lock()
+	if c.subscriptions[id] == sub {
+		delete(c.subscriptions, id)
+	}
+	c.mu.Unlock()
+	sub.cancel()
+}
+
+// stopAll cancels every running subscription when the connection closes
+func (c *connection) stopAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, sub := range c.subscriptions {
+		sub.cancel()
+		c.event(eventSubscriptionStopped, "id", id, "cause", "connection_closed")
+	}
+	c.subscriptions = make(map[string]*subscription)
+}
+
+// subscriptionsStarted returns how many subscriptions the connection started
+func (c *connection) subscriptionsStarted() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.started
+}
+
+// send sends a message to the client
+func (c *connection) send(messageType, id string, payload interface{}) {
+	msg := map[string]interface{}{
+		"type": messageType,
+	}
+
+	if id != "" {
+		msg["id"] = id
+	}
+
+	if payload != nil {
+		msg["payload"] = payload
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.conn.WriteJSON(msg); err != nil {
+		log.Printf("[WS] Error sending message: %v", err)
+	}
+}
+
+// sendError sends an error message to the client. graphql-transport-ws
+// carries a list of GraphQL errors, the legacy protocol a single one
+func (c *connection) sendError(id string, errorMessage string) {
+	c.event(eventError, "id", id, "message", errorMessage)
+	payload := map[string]interface{}{
+		"message": errorMessage,
+	}
+	if c.transportWS {
+		c.send("error", id, []interface{}{payload})
+		return
+	}
+	c.send("error", id, payload)
+}
+
+// close closes the connection with a close code and reason, on behalf of the
+// side that asked for it
+func (c *connection) close(by string, code int, reason string) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed == nil {
+		c.closed = &closeInfo{by: by, code: code, reason: reason}
+	}
+	err := c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(writeTimeout))
+	if err != nil {
+		log.Printf("[WS] Error sending close message: %v", err)
+	}
+}